@@ -0,0 +1,227 @@
+// Package api contains the API server.
+package api
+
+import (
+	"XMedia/internal/conf"
+	"XMedia/internal/defs"
+	"XMedia/internal/logger"
+	"XMedia/internal/recordstore"
+	"XMedia/internal/stream"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type apiParent interface {
+	logger.Writer
+}
+
+// PathManager is the path manager as seen by the API.
+type PathManager interface {
+	APISEIUserDataGet(name string) (*defs.APISEIUserDataList, error)
+	APISEIUserDataInject(name string, uuid [16]byte, payload []byte) error
+	APIKLVTelemetryGet(name string) (*defs.APIKLVTelemetry, error)
+	APITracksGet(name string) (*defs.APIPathTrackList, error)
+	APIRecordingGet(name string) (*defs.APIPathRecording, error)
+	APIRecordEventStart(name string) error
+	APIRecordEventStop(name string) error
+}
+
+// API is the API server.
+type API struct {
+	Address      string
+	ReadTimeout  conf.Duration
+	WriteTimeout conf.Duration
+	PathConfs    map[string]*conf.Path
+	PathDefaults *conf.Path
+	PathManager  PathManager
+	Parent       apiParent
+
+	ln         net.Listener
+	httpServer *http.Server
+}
+
+// Initialize initializes API.
+func (a *API) Initialize() error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/paths/sei/{name...}", a.onSEIUserDataGet)
+	mux.HandleFunc("POST /v1/paths/sei/{name...}", a.onSEIUserDataInject)
+	mux.HandleFunc("GET /v1/paths/klv/{name...}", a.onKLVTelemetryGet)
+	mux.HandleFunc("GET /v1/paths/tracks/{name...}", a.onTracksGet)
+	mux.HandleFunc("GET /v1/paths/recording/{name...}", a.onRecordingGet)
+	mux.HandleFunc("GET /v1/recordings/list/{name...}", a.onRecordingsList)
+	mux.HandleFunc("POST /v1/recordings/lock/{name...}", a.onRecordingsLock)
+	mux.HandleFunc("POST /v1/recordings/unlock/{name...}", a.onRecordingsUnlock)
+	mux.HandleFunc("GET /v1/recordings/timeline/{name...}", a.onRecordingsTimeline)
+	// GET is accepted too, since it's the only method supported by alarm outputs of many devices.
+	mux.HandleFunc("POST /v1/paths/record/start/{name...}", a.onRecordEventStart)
+	mux.HandleFunc("GET /v1/paths/record/start/{name...}", a.onRecordEventStart)
+	mux.HandleFunc("POST /v1/paths/record/stop/{name...}", a.onRecordEventStop)
+	mux.HandleFunc("GET /v1/paths/record/stop/{name...}", a.onRecordEventStop)
+
+	var err error
+	a.ln, err = net.Listen("tcp", a.Address)
+	if err != nil {
+		return err
+	}
+
+	a.httpServer = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: time.Duration(a.ReadTimeout),
+		WriteTimeout:      time.Duration(a.WriteTimeout),
+	}
+
+	go a.httpServer.Serve(a.ln) //nolint:errcheck
+
+	a.Log(logger.Info, "listener opened on %s", a.Address)
+
+	return nil
+}
+
+// Close closes the API.
+func (a *API) Close() {
+	a.Log(logger.Info, "listener is closing")
+	a.httpServer.Close()
+	a.ln.Close() //nolint:errcheck
+}
+
+// Log implements logger.Writer.
+func (a *API) Log(level logger.Level, format string, args ...interface{}) {
+	a.Parent.Log(level, "[API] "+format, args...)
+}
+
+func (a *API) writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v) //nolint:errcheck
+}
+
+func (a *API) writeError(w http.ResponseWriter, status int, err error) {
+	// show error in logs
+	a.Log(logger.Error, err.Error())
+
+	a.writeJSON(w, status, &defs.APIError{Error: err.Error()})
+}
+
+func (a *API) writePathError(w http.ResponseWriter, err error) {
+	if errors.Is(err, defs.ErrPathNotFound) || errors.Is(err, defs.ErrPathNoStream) ||
+		errors.Is(err, stream.ErrNoTelemetry) || errors.Is(err, recordstore.ErrNoSegmentsFound) ||
+		errors.Is(err, recordstore.ErrSegmentNotFound) {
+		a.writeError(w, http.StatusNotFound, err)
+	} else if errors.Is(err, stream.ErrSEIInjectionNotSupported) || errors.Is(err, defs.ErrPathNoEventRecording) {
+		a.writeError(w, http.StatusBadRequest, err)
+	} else {
+		a.writeError(w, http.StatusInternalServerError, err)
+	}
+}
+
+func (a *API) onSEIUserDataGet(w http.ResponseWriter, r *http.Request) {
+	data, err := a.PathManager.APISEIUserDataGet(r.PathValue("name"))
+	if err != nil {
+		a.writePathError(w, err)
+		return
+	}
+
+	a.writeJSON(w, http.StatusOK, data)
+}
+
+func (a *API) onSEIUserDataInject(w http.ResponseWriter, r *http.Request) {
+	var req defs.APISEIUserDataInject
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		a.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	id, err := uuid.Parse(req.UUID)
+	if err != nil {
+		a.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid UUID: %w", err))
+		return
+	}
+
+	payload := req.Payload
+
+	if req.JSON != nil {
+		if payload != nil {
+			a.writeError(w, http.StatusBadRequest, fmt.Errorf("payload and json cannot be used together"))
+			return
+		}
+
+		var buf bytes.Buffer
+		err = json.Compact(&buf, req.JSON)
+		if err != nil {
+			a.writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		payload = buf.Bytes()
+	}
+
+	if len(payload) == 0 {
+		a.writeError(w, http.StatusBadRequest, fmt.Errorf("payload is empty"))
+		return
+	}
+
+	err = a.PathManager.APISEIUserDataInject(r.PathValue("name"), id, payload)
+	if err != nil {
+		a.writePathError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (a *API) onKLVTelemetryGet(w http.ResponseWriter, r *http.Request) {
+	data, err := a.PathManager.APIKLVTelemetryGet(r.PathValue("name"))
+	if err != nil {
+		a.writePathError(w, err)
+		return
+	}
+
+	a.writeJSON(w, http.StatusOK, data)
+}
+
+func (a *API) onTracksGet(w http.ResponseWriter, r *http.Request) {
+	data, err := a.PathManager.APITracksGet(r.PathValue("name"))
+	if err != nil {
+		a.writePathError(w, err)
+		return
+	}
+
+	a.writeJSON(w, http.StatusOK, data)
+}
+
+func (a *API) onRecordingGet(w http.ResponseWriter, r *http.Request) {
+	data, err := a.PathManager.APIRecordingGet(r.PathValue("name"))
+	if err != nil {
+		a.writePathError(w, err)
+		return
+	}
+
+	a.writeJSON(w, http.StatusOK, data)
+}
+
+func (a *API) onRecordEventStart(w http.ResponseWriter, r *http.Request) {
+	err := a.PathManager.APIRecordEventStart(r.PathValue("name"))
+	if err != nil {
+		a.writePathError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (a *API) onRecordEventStop(w http.ResponseWriter, r *http.Request) {
+	err := a.PathManager.APIRecordEventStop(r.PathValue("name"))
+	if err != nil {
+		a.writePathError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
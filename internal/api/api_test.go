@@ -0,0 +1,93 @@
+package api
+
+import (
+	"XMedia/internal/logger"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type nilLogger struct{}
+
+func (nilLogger) Log(logger.Level, string, ...interface{}) {}
+
+type testPathManager struct {
+	PathManager
+	injected [][]byte
+}
+
+func (pm *testPathManager) APISEIUserDataInject(_ string, _ [16]byte, payload []byte) error {
+	pm.injected = append(pm.injected, payload)
+	return nil
+}
+
+func TestSEIUserDataInject(t *testing.T) {
+	for _, ca := range []struct {
+		name    string
+		body    string
+		status  int
+		payload []byte
+	}{
+		{
+			"payload",
+			`{"uuid":"11223344-5566-7788-99aa-bbccddeeff10","payload":"AQID"}`,
+			http.StatusOK,
+			[]byte{1, 2, 3},
+		},
+		{
+			"json",
+			`{"uuid":"11223344-5566-7788-99aa-bbccddeeff10","json":{ "a": 1 }}`,
+			http.StatusOK,
+			[]byte(`{"a":1}`),
+		},
+		{
+			"missing payload",
+			`{"uuid":"11223344-5566-7788-99aa-bbccddeeff10"}`,
+			http.StatusBadRequest,
+			nil,
+		},
+		{
+			"empty payload",
+			`{"uuid":"11223344-5566-7788-99aa-bbccddeeff10","payload":""}`,
+			http.StatusBadRequest,
+			nil,
+		},
+		{
+			"payload and json",
+			`{"uuid":"11223344-5566-7788-99aa-bbccddeeff10","payload":"AQID","json":1}`,
+			http.StatusBadRequest,
+			nil,
+		},
+		{
+			"invalid uuid",
+			`{"uuid":"1122","payload":"AQID"}`,
+			http.StatusBadRequest,
+			nil,
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			pm := &testPathManager{}
+			a := &API{
+				PathManager: pm,
+				Parent:      nilLogger{},
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/paths/sei/mypath", strings.NewReader(ca.body))
+			req.SetPathValue("name", "mypath")
+			w := httptest.NewRecorder()
+
+			a.onSEIUserDataInject(w, req)
+
+			require.Equal(t, ca.status, w.Code)
+
+			if ca.status == http.StatusOK {
+				require.Equal(t, [][]byte{ca.payload}, pm.injected)
+			} else {
+				require.Empty(t, pm.injected)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"XMedia/internal/conf"
+	"XMedia/internal/defs"
+	"XMedia/internal/logger"
+	"XMedia/internal/recordstore"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// findRecordedPathConf returns the configuration of a path whose recordings are accessed.
+func (a *API) findRecordedPathConf(pathName string) (*conf.Path, error) {
+	err := conf.IsValidPathName(pathName)
+	if err != nil {
+		return nil, fmt.Errorf("invalid path name: %w", err)
+	}
+
+	return conf.FindPathConf(a.PathConfs, a.PathDefaults, pathName), nil
+}
+
+// recordingsExt returns the extension of the segments of a path.
+func recordingsExt(pconf *conf.Path) string {
+	if pconf.RecordFormat == "mpegts" {
+		return ".ts"
+	}
+	return ".mp4"
+}
+
+func (a *API) onRecordingsList(w http.ResponseWriter, r *http.Request) {
+	pathName := r.PathValue("name")
+	pconf, err := a.findRecordedPathConf(pathName)
+	if err != nil {
+		a.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	segments, err := recordstore.FindSegments(pconf.RecordPath, pathName, recordingsExt(pconf))
+	if err != nil {
+		a.writePathError(w, err)
+		return
+	}
+
+	data := &defs.APIRecordingSegmentList{
+		Items: []defs.APIRecordingSegment{},
+	}
+
+	for _, seg := range segments {
+		st, err := os.Stat(seg.Fpath)
+		if err != nil {
+			continue
+		}
+
+		data.Items = append(data.Items, defs.APIRecordingSegment{
+			Start:  seg.Start,
+			Size:   st.Size(),
+			Locked: recordstore.IsLocked(seg.Fpath),
+		})
+	}
+
+	a.writeJSON(w, http.StatusOK, data)
+}
+
+func (a *API) onRecordingsLock(w http.ResponseWriter, r *http.Request) {
+	a.setRecordingLocked(w, r, true)
+}
+
+func (a *API) onRecordingsUnlock(w http.ResponseWriter, r *http.Request) {
+	a.setRecordingLocked(w, r, false)
+}
+
+// setRecordingLocked locks or unlocks the segment of a path that starts at the time in the start parameter.
+func (a *API) setRecordingLocked(w http.ResponseWriter, r *http.Request, locked bool) {
+	pathName := r.PathValue("name")
+	pconf, err := a.findRecordedPathConf(pathName)
+	if err != nil {
+		a.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	start, err := time.Parse(time.RFC3339Nano, r.URL.Query().Get("start"))
+	if err != nil {
+		a.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid 'start' parameter: %w", err))
+		return
+	}
+
+	seg, err := recordstore.FindSegment(pconf.RecordPath, pathName, recordingsExt(pconf), start)
+	if err != nil {
+		a.writePathError(w, err)
+		return
+	}
+
+	err = recordstore.SetLocked(seg.Fpath, locked)
+	if err != nil {
+		a.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if locked {
+		a.Log(logger.Info, "segment %s locked", seg.Fpath)
+	} else {
+		a.Log(logger.Info, "segment %s unlocked", seg.Fpath)
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
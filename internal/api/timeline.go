@@ -0,0 +1,187 @@
+package api
+
+import (
+	"XMedia/internal/defs"
+	"XMedia/internal/recordstore"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+)
+
+// maximum distance between two segments that are considered contiguous.
+const timelineGapTolerance = 1 * time.Second
+
+// maximum span of a timeline, that bounds the number of days it contains.
+const timelineMaxSpan = 366 * 24 * time.Hour
+
+// mergeTimeline merges contiguous segments into ranges, and clips them into [start, end).
+func mergeTimeline(entries []*recordstore.TimelineEntry, start time.Time, end time.Time) []defs.APIRecordingRange {
+	ranges := []defs.APIRecordingRange{}
+
+	for _, entry := range entries {
+		if !entry.End.After(start) || !entry.Start.Before(end) {
+			continue
+		}
+
+		r := defs.APIRecordingRange{
+			Start: entry.Start,
+			End:   entry.End,
+		}
+		if r.Start.Before(start) {
+			r.Start = start
+		}
+		if r.End.After(end) {
+			r.End = end
+		}
+
+		if len(ranges) != 0 {
+			prev := &ranges[len(ranges)-1]
+			if r.Start.Sub(prev.End) <= timelineGapTolerance {
+				if r.End.After(prev.End) {
+					prev.End = r.End
+				}
+				continue
+			}
+		}
+
+		ranges = append(ranges, r)
+	}
+
+	return ranges
+}
+
+// timelineGaps returns the intervals between ranges.
+func timelineGaps(ranges []defs.APIRecordingRange) []defs.APIRecordingRange {
+	gaps := []defs.APIRecordingRange{}
+
+	for i := 1; i < len(ranges); i++ {
+		gaps = append(gaps, defs.APIRecordingRange{
+			Start: ranges[i-1].End,
+			End:   ranges[i].Start,
+		})
+	}
+
+	return gaps
+}
+
+// timelineDays returns the recording coverage of every day between start and end.
+func timelineDays(ranges []defs.APIRecordingRange, start time.Time, end time.Time, loc *time.Location) []defs.APIRecordingDay {
+	days := []defs.APIRecordingDay{}
+
+	y, m, d := start.In(loc).Date()
+	dayStart := time.Date(y, m, d, 0, 0, 0, 0, loc)
+
+	for dayStart.Before(end) {
+		// days can last 23 or 25 hours because of daylight saving time
+		dayEnd := time.Date(y, m, d+1, 0, 0, 0, 0, loc)
+
+		from := maxTime(dayStart, start)
+		to := minTime(dayEnd, end)
+
+		recorded := time.Duration(0)
+		for _, r := range ranges {
+			if s, e := maxTime(r.Start, from), minTime(r.End, to); e.After(s) {
+				recorded += e.Sub(s)
+			}
+		}
+
+		coverage := float64(0)
+		if to.After(from) {
+			coverage = math.Round(float64(recorded)/float64(to.Sub(from))*10000) / 100
+		}
+
+		days = append(days, defs.APIRecordingDay{
+			Date:     dayStart.Format(time.DateOnly),
+			Recorded: recorded.Seconds(),
+			Coverage: coverage,
+		})
+
+		y, m, d = dayEnd.Date()
+		dayStart = dayEnd
+	}
+
+	return days
+}
+
+func minTime(a time.Time, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+func maxTime(a time.Time, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+func (a *API) onRecordingsTimeline(w http.ResponseWriter, r *http.Request) {
+	pathName := r.PathValue("name")
+	pconf, err := a.findRecordedPathConf(pathName)
+	if err != nil {
+		a.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	q := r.URL.Query()
+
+	loc := time.Local
+	if v := q.Get("timezone"); v != "" {
+		loc, err = time.LoadLocation(v)
+		if err != nil {
+			a.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid 'timezone' parameter: %w", err))
+			return
+		}
+	}
+
+	end := time.Now()
+	if v := q.Get("end"); v != "" {
+		end, err = time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			a.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid 'end' parameter: %w", err))
+			return
+		}
+	}
+
+	entries, err := recordstore.ReadTimeline(pconf.RecordPath, pathName)
+	if err != nil {
+		a.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// by default, the timeline starts with the first segment, within the maximum span
+	start := end
+	if len(entries) != 0 && entries[0].Start.Before(end) {
+		start = maxTime(entries[0].Start, end.Add(-timelineMaxSpan))
+	}
+	if v := q.Get("start"); v != "" {
+		start, err = time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			a.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid 'start' parameter: %w", err))
+			return
+		}
+	}
+
+	if end.Before(start) {
+		a.writeError(w, http.StatusBadRequest, fmt.Errorf("'end' is before 'start'"))
+		return
+	}
+
+	if end.Sub(start) > timelineMaxSpan {
+		a.writeError(w, http.StatusBadRequest, fmt.Errorf("timeline can't span more than %d days",
+			timelineMaxSpan/(24*time.Hour)))
+		return
+	}
+
+	ranges := mergeTimeline(entries, start, end)
+
+	a.writeJSON(w, http.StatusOK, &defs.APIRecordingTimeline{
+		Start:  start.In(loc),
+		End:    end.In(loc),
+		Ranges: ranges,
+		Gaps:   timelineGaps(ranges),
+		Days:   timelineDays(ranges, start, end, loc),
+	})
+}
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"XMedia/internal/conf"
+	"XMedia/internal/defs"
+	"XMedia/internal/recordstore"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordingsTimelineSpan(t *testing.T) {
+	recordPath := filepath.Join(t.TempDir(), "%path/%Y-%m-%d_%H-%M-%S-%f")
+
+	end := time.Date(2010, 5, 20, 12, 0, 0, 0, time.UTC)
+	first := end.Add(-2 * 366 * 24 * time.Hour)
+
+	err := recordstore.UpdateTimeline(recordPath, "mypath", first, first.Add(time.Hour))
+	require.NoError(t, err)
+
+	for _, ca := range []struct {
+		name   string
+		query  url.Values
+		status int
+		start  time.Time
+	}{
+		{
+			"default start is clamped",
+			url.Values{"end": {end.Format(time.RFC3339)}, "timezone": {"UTC"}},
+			http.StatusOK,
+			end.Add(-timelineMaxSpan),
+		},
+		{
+			"maximum span",
+			url.Values{
+				"start":    {end.Add(-timelineMaxSpan).Format(time.RFC3339)},
+				"end":      {end.Format(time.RFC3339)},
+				"timezone": {"UTC"},
+			},
+			http.StatusOK,
+			end.Add(-timelineMaxSpan),
+		},
+		{
+			"span too large",
+			url.Values{"start": {first.Format(time.RFC3339)}, "end": {end.Format(time.RFC3339)}},
+			http.StatusBadRequest,
+			time.Time{},
+		},
+		{
+			"extreme dates",
+			url.Values{"start": {"0001-01-01T00:00:00Z"}, "end": {"9999-12-31T00:00:00Z"}},
+			http.StatusBadRequest,
+			time.Time{},
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			a := &API{
+				PathDefaults: &conf.Path{RecordPath: recordPath},
+				Parent:       nilLogger{},
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/recordings/timeline/mypath?"+ca.query.Encode(), nil)
+			req.SetPathValue("name", "mypath")
+			w := httptest.NewRecorder()
+
+			a.onRecordingsTimeline(w, req)
+
+			require.Equal(t, ca.status, w.Code)
+
+			if ca.status != http.StatusOK {
+				var res defs.APIError
+				err := json.Unmarshal(w.Body.Bytes(), &res)
+				require.NoError(t, err)
+				require.Equal(t, "timeline can't span more than 366 days", res.Error)
+				return
+			}
+
+			var res defs.APIRecordingTimeline
+			err := json.Unmarshal(w.Body.Bytes(), &res)
+			require.NoError(t, err)
+			require.True(t, ca.start.Equal(res.Start))
+			require.Len(t, res.Days, 367)
+		})
+	}
+}
@@ -0,0 +1,112 @@
+// Package asyncwriter contains an asynchronous writer.
+package asyncwriter
+
+import (
+	"XMedia/internal/counterdumper"
+	"XMedia/internal/logger"
+)
+
+// Writer is an asynchronous writer.
+// Data is pushed into a queue and written by a dedicated routine,
+// in order not to block the publisher when a reader is slow.
+type Writer struct {
+	QueueSize int
+	Parent    logger.Writer
+
+	buffer    chan func() error
+	discarded *counterdumper.CounterDumper
+	terminate chan struct{}
+	done      chan struct{}
+
+	// out
+	err chan error
+}
+
+// Initialize initializes Writer.
+func (w *Writer) Initialize() {
+	w.buffer = make(chan func() error, w.QueueSize)
+	w.err = make(chan error, 1)
+	w.terminate = make(chan struct{})
+	w.done = make(chan struct{})
+
+	w.discarded = &counterdumper.CounterDumper{
+		OnReport: func(val uint64) {
+			w.Parent.Log(logger.Warn, "reader is too slow, discarding %d %s",
+				val,
+				func() string {
+					if val == 1 {
+						return "frame"
+					}
+					return "frames"
+				}())
+		},
+	}
+}
+
+// Start starts the writer routine.
+func (w *Writer) Start() {
+	w.discarded.Start()
+	go w.run()
+}
+
+// Stop stops the writer routine, after writing the elements that are still in the queue.
+func (w *Writer) Stop() {
+	close(w.terminate)
+	<-w.done
+	w.discarded.Stop()
+}
+
+// Error returns whenever there's an error.
+func (w *Writer) Error() chan error {
+	return w.err
+}
+
+func (w *Writer) run() {
+	defer close(w.done)
+
+	err := w.runInner()
+	if err != nil {
+		w.err <- err
+	}
+}
+
+func (w *Writer) runInner() error {
+	for {
+		select {
+		case cb := <-w.buffer:
+			err := cb()
+			if err != nil {
+				return err
+			}
+
+		case <-w.terminate:
+			return w.drain()
+		}
+	}
+}
+
+// drain writes the elements that are still in the queue.
+func (w *Writer) drain() error {
+	for {
+		select {
+		case cb := <-w.buffer:
+			err := cb()
+			if err != nil {
+				return err
+			}
+
+		default:
+			return nil
+		}
+	}
+}
+
+// Push appends an element to the queue.
+// The element is discarded when the queue is full.
+func (w *Writer) Push(cb func() error) {
+	select {
+	case w.buffer <- cb:
+	default:
+		w.discarded.Increase()
+	}
+}
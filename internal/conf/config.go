@@ -3,6 +3,7 @@ package conf
 import (
 	"XMedia/internal/utils"
 	"fmt"
+	"strings"
 
 	"gopkg.in/ini.v1"
 )
@@ -37,6 +38,32 @@ type RtspConf struct {
 	RtspTransportsRaw string `ini:"rtspTransports"`
 }
 
+// Api
+type ApiConf struct {
+	Api        bool   `ini:"api"`
+	ApiAddress string `ini:"apiAddress"`
+}
+
+// default address of the playback server, that doesn't authenticate clients.
+const defaultPlaybackAddress = "127.0.0.1:9996"
+
+// Playback
+type PlaybackConf struct {
+	Playback bool `ini:"playback"`
+	// Address of the listener. Empty means localhost only.
+	PlaybackAddress string `ini:"playbackAddress"`
+	// Directory of exported clips. Empty disables exports.
+	PlaybackExportPath string `ini:"playbackExportPath"`
+}
+
+// RecordStorage
+type RecordStorageConf struct {
+	// Maximum size in bytes of the recordings of all paths. 0 means unlimited.
+	RecordStorageMaxSize uint64 `ini:"recordStorageMaxSize"`
+	// Minimum free space in bytes of the disks that contain recordings. 0 disables the check.
+	RecordStorageMinFreeSpace uint64 `ini:"recordStorageMinFreeSpace"`
+}
+
 type Config struct {
 	Ini *ini.File `ini:"-" json:"-"`
 
@@ -49,8 +76,80 @@ type Config struct {
 	// Rtsp
 	Rtsp RtspConf `ini:"rtsp"`
 
+	// Api
+	Api ApiConf `ini:"api"`
+
+	// Playback
+	Playback PlaybackConf `ini:"playback"`
+
+	// RecordStorage
+	RecordStorage RecordStorageConf `ini:"recordStorage"`
+
 	// Path
-	Paths RtspConf `ini:"rtsp"`
+	PathDefaults Path             `ini:"-" json:"-"` // filled by loadPaths()
+	Paths        map[string]*Path `ini:"-" json:"-"` // filled by loadPaths()
+}
+
+// pathSectionPrefix is the prefix of sections of single paths, like [path.NAME].
+const pathSectionPrefix = "path."
+
+// sections that don't contain paths.
+var globalSections = map[string]struct{}{
+	ini.DefaultSection: {},
+	"general":          {},
+	"log":              {},
+	"rtsp":             {},
+	"api":              {},
+	"playback":         {},
+	"recordStorage":    {},
+	"paths":            {},
+}
+
+// loadPaths reads the [paths] section, that contains defaults of all paths,
+// and the [path.NAME] sections, that override them for a single path.
+func (c *Config) loadPaths() error {
+	c.Paths = make(map[string]*Path)
+
+	err := c.Ini.Section("paths").MapTo(&c.PathDefaults)
+	if err != nil {
+		return err
+	}
+
+	err = c.PathDefaults.Check("")
+	if err != nil {
+		return err
+	}
+
+	for _, sec := range c.Ini.Sections() {
+		if _, ok := globalSections[sec.Name()]; ok {
+			continue
+		}
+
+		name, ok := strings.CutPrefix(sec.Name(), pathSectionPrefix)
+		if !ok {
+			return fmt.Errorf("unknown section [%s], sections of paths must be named [%sNAME]",
+				sec.Name(), pathSectionPrefix)
+		}
+
+		if name == "" {
+			return fmt.Errorf("section [%s] doesn't contain a path name", sec.Name())
+		}
+
+		pconf := c.PathDefaults
+		err = sec.MapTo(&pconf)
+		if err != nil {
+			return err
+		}
+
+		err = pconf.Check(name)
+		if err != nil {
+			return err
+		}
+
+		c.Paths[name] = &pconf
+	}
+
+	return nil
 }
 
 func (c *Config) Check() error {
@@ -70,6 +169,10 @@ func (c *Config) Check() error {
 		return err
 	}
 
+	if c.Playback.PlaybackAddress == "" {
+		c.Playback.PlaybackAddress = defaultPlaybackAddress
+	}
+
 	return nil
 }
 
@@ -92,6 +195,10 @@ func Load(file string) (cfg *Config, err error) {
 	if err != nil {
 		return nil, fmt.Errorf("Config.Load %s error:%v", file, err)
 	}
+	err = cfg.loadPaths()
+	if err != nil {
+		return nil, fmt.Errorf("Config.Load %s error:%v", file, err)
+	}
 
 	return cfg, nil
 }
@@ -0,0 +1,103 @@
+package conf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/ini.v1"
+)
+
+func TestLoadPaths(t *testing.T) {
+	for _, ca := range []struct {
+		name   string
+		ini    string
+		paths  map[string]bool
+		errStr string
+	}{
+		{
+			"paths",
+			"[general]\n" +
+				"[paths]\nuseAbsoluteTimestamp: true\n" +
+				"[path.cam1]\n" +
+				"[path.cam2]\nuseAbsoluteTimestamp: false\n" +
+				"[recordStorage]\n",
+			map[string]bool{"cam1": true, "cam2": false},
+			"",
+		},
+		{
+			"path before defaults",
+			"[path.cam1]\n" +
+				"[paths]\nuseAbsoluteTimestamp: true\n",
+			map[string]bool{"cam1": true},
+			"",
+		},
+		{
+			"global sections after paths",
+			"[paths]\n[playback]\n[api]\n",
+			map[string]bool{},
+			"",
+		},
+		{
+			"invalid path name",
+			"[paths]\n[path.cam 1]\n",
+			nil,
+			"invalid path name 'cam 1': can contain only alphanumeric characters, underscore, dot, tilde, minus or slash",
+		},
+		{
+			"section without prefix",
+			"[paths]\n[cam1]\n",
+			nil,
+			"unknown section [cam1], sections of paths must be named [path.NAME]",
+		},
+		{
+			"empty path name",
+			"[paths]\n[path.]\n",
+			nil,
+			"section [path.] doesn't contain a path name",
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			f, err := ini.Load([]byte(ca.ini))
+			require.NoError(t, err)
+			f.NameMapper = nil
+
+			c := &Config{Ini: f}
+			err = c.loadPaths()
+
+			if ca.errStr != "" {
+				require.EqualError(t, err, ca.errStr)
+				return
+			}
+			require.NoError(t, err)
+
+			paths := make(map[string]bool)
+			for name, pconf := range c.Paths {
+				paths[name] = pconf.UseAbsoluteTimestamp
+			}
+			require.Equal(t, ca.paths, paths)
+		})
+	}
+}
+
+func TestCheckPlaybackAddress(t *testing.T) {
+	for _, ca := range []struct {
+		name    string
+		address string
+		out     string
+	}{
+		{"default", "", "127.0.0.1:9996"},
+		{"custom", ":9996", ":9996"},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			c := &Config{}
+			c.General.ReadTimeoutRaw = "10s"
+			c.General.WriteTimeoutRaw = "10s"
+			c.Rtsp.RtspTransportsRaw = "tcp"
+			c.Playback.PlaybackAddress = ca.address
+
+			err := c.Check()
+			require.NoError(t, err)
+			require.Equal(t, ca.out, c.Playback.PlaybackAddress)
+		})
+	}
+}
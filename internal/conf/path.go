@@ -1,5 +1,347 @@
 package conf
 
+import (
+	"XMedia/internal/recordcrypto"
+	"XMedia/internal/recordstore"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Path is the configuration of a path.
 type Path struct {
-	UseAbsoluteTimestamp bool
+	Name string `ini:"-" json:"-"` // filled by Check()
+
+	// Route original absolute timestamps of RTSP frames, instead of replacing them.
+	UseAbsoluteTimestamp bool `ini:"useAbsoluteTimestamp"`
+
+	// Number of RTP packets kept for reordering. 0 disables the reorder buffer.
+	RtpReorderBufferSize int `ini:"rtpReorderBufferSize"`
+	// Maximum time a RTP packet waits for a missing predecessor.
+	RtpReorderLatencyRaw string `ini:"rtpReorderLatency"`
+
+	// Parameter sets of H264 and H265 tracks, base64-encoded.
+	// They are used when the publisher doesn't announce them.
+	H264SPSRaw string `ini:"h264SPS"`
+	H264PPSRaw string `ini:"h264PPS"`
+	H265VPSRaw string `ini:"h265VPS"`
+	H265SPSRaw string `ini:"h265SPS"`
+	H265PPSRaw string `ini:"h265PPS"`
+	// Keep the path not ready until parameters of all tracks are known.
+	WaitForParameters bool `ini:"waitForParameters"`
+	// Keep the path not ready until every video track has produced a keyframe
+	// and every other track has produced a frame.
+	WaitForKeyframe bool `ini:"waitForKeyframe"`
+	// Time after which a publisher that didn't make the path ready is closed.
+	WaitForKeyframeTimeoutRaw string `ini:"waitForKeyframeTimeout"`
+
+	// H264 bitstream normalization.
+	H264InsertAUD          bool `ini:"h264InsertAUD"`
+	H264StripSEI           bool `ini:"h264StripSEI"`
+	H264RepeatParameters   bool `ini:"h264RepeatParameters"`
+	H264PacketizationMode1 bool `ini:"h264PacketizationMode1"`
+	H264DropFiller         bool `ini:"h264DropFiller"`
+
+	// Extract CEA-608/708 closed captions from H264 SEI NALUs.
+	H264ExtractCaptions bool `ini:"h264ExtractCaptions"`
+
+	// Extract user_data_unregistered payloads from H264 SEI NALUs.
+	H264ExtractUserData bool `ini:"h264ExtractUserData"`
+	// Comma-separated UUIDs of the user data payloads to extract. Empty means all.
+	SEIUUIDFilterRaw string `ini:"seiUUIDFilter"`
+	// Extract timecodes from H264 pic_timing SEI NALUs.
+	H264ExtractTimecodes bool `ini:"h264ExtractTimecodes"`
+
+	// Keep the units received since the last keyframe and send them to new readers.
+	GOPCache bool `ini:"gopCache"`
+	// Maximum size in bytes of the GOP cache. 0 means unlimited.
+	GOPCacheMaxSize uint64 `ini:"gopCacheMaxSize"`
+	// Maximum duration of the GOP cache. Empty means unlimited.
+	GOPCacheMaxDurationRaw string `ini:"gopCacheMaxDuration"`
+
+	// Forward keyframe requests of readers to the publisher.
+	KeyframeRequests bool `ini:"keyframeRequests"`
+	// Minimum interval between two keyframe requests sent to the publisher.
+	KeyframeRequestIntervalRaw string `ini:"keyframeRequestInterval"`
+
+	// Record the stream to disk.
+	Record bool `ini:"record"`
+	// When recording happens: always, or event (between a start and a stop request of the API).
+	RecordMode string `ini:"recordMode"`
+	// Duration of the stream that is kept in memory and recorded before the start of an event.
+	RecordPreRollRaw string `ini:"recordPreRoll"`
+	// Duration of the stream that is recorded after the end of an event.
+	RecordPostRollRaw string `ini:"recordPostRoll"`
+	// Weekly windows in which the stream is recorded, like "Mon-Fri 08:00-18:00, Sat 09:00-12:00".
+	RecordScheduleRaw string `ini:"recordSchedule"`
+	// Time zone of the schedule, like "Europe/Rome". Empty means local time.
+	RecordScheduleTimezone string `ini:"recordScheduleTimezone"`
+	// Path of recording segments, without extension.
+	RecordPath string `ini:"recordPath"`
+	// Format of recording segments.
+	RecordFormat string `ini:"recordFormat"`
+	// Minimum duration of every part of a segment.
+	RecordPartDurationRaw string `ini:"recordPartDuration"`
+	// Minimum duration of every segment.
+	RecordSegmentDurationRaw string `ini:"recordSegmentDuration"`
+	// When segments are synchronized to disk (never, segment or part).
+	RecordFsync string `ini:"recordFsync"`
+	// File with the keys used to encrypt segments. Empty disables encryption.
+	RecordEncryptionKeyFile string `ini:"recordEncryptionKeyFile"`
+	// Time after which segments are deleted. 0 disables deletion.
+	// Locked segments are never deleted.
+	RecordDeleteAfterRaw string `ini:"recordDeleteAfter"`
+	// Maximum size in bytes of the recordings of the path. 0 means unlimited.
+	// When exceeded, the oldest segments are deleted.
+	RecordMaxSize uint64 `ini:"recordMaxSize"`
+
+	RtpReorderLatency       Duration           `ini:"-" json:"-"` // filled by Check()
+	GOPCacheMaxDuration     Duration           `ini:"-" json:"-"` // filled by Check()
+	WaitForKeyframeTimeout  Duration           `ini:"-" json:"-"` // filled by Check()
+	KeyframeRequestInterval Duration           `ini:"-" json:"-"` // filled by Check()
+	RecordPreRoll           Duration           `ini:"-" json:"-"` // filled by Check()
+	RecordSchedule          Schedule           `ini:"-" json:"-"` // filled by Check()
+	RecordPostRoll          Duration           `ini:"-" json:"-"` // filled by Check()
+	RecordPartDuration      Duration           `ini:"-" json:"-"` // filled by Check()
+	RecordSegmentDuration   Duration           `ini:"-" json:"-"` // filled by Check()
+	RecordDeleteAfter       Duration           `ini:"-" json:"-"` // filled by Check()
+	H264SPS                 []byte             `ini:"-" json:"-"` // filled by Check()
+	H264PPS                 []byte             `ini:"-" json:"-"` // filled by Check()
+	H265VPS                 []byte             `ini:"-" json:"-"` // filled by Check()
+	H265SPS                 []byte             `ini:"-" json:"-"` // filled by Check()
+	H265PPS                 []byte             `ini:"-" json:"-"` // filled by Check()
+	SEIUUIDFilter           [][16]byte         `ini:"-" json:"-"` // filled by Check()
+	RecordEncryptionKeys    *recordcrypto.Keys `ini:"-" json:"-"` // filled by Check()
+}
+
+func decodeParameter(name string, key string, raw string) ([]byte, error) {
+	if raw == "" {
+		return nil, nil
+	}
+
+	byts, err := base64.StdEncoding.DecodeString(raw)
+	if err != nil || len(byts) == 0 {
+		return nil, fmt.Errorf("path %s: invalid %s", name, key)
+	}
+
+	return byts, nil
+}
+
+// IsValidPathName checks whether a path name is valid.
+// Path names are used to build the paths of recordings, therefore they can't
+// contain empty or relative segments.
+func IsValidPathName(name string) error {
+	if name == "" {
+		return fmt.Errorf("cannot be empty")
+	}
+
+	if name[0] == '/' {
+		return fmt.Errorf("can't begin with a slash")
+	}
+
+	if name[len(name)-1] == '/' {
+		return fmt.Errorf("can't end with a slash")
+	}
+
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z') && !(r >= 'A' && r <= 'Z') && !(r >= '0' && r <= '9') &&
+			!strings.ContainsRune("_-./~", r) {
+			return fmt.Errorf("can contain only alphanumeric characters, underscore, dot, tilde, minus or slash")
+		}
+	}
+
+	for _, seg := range strings.Split(name, "/") {
+		switch seg {
+		case "":
+			return fmt.Errorf("can't contain empty segments")
+
+		case ".", "..":
+			return fmt.Errorf("can't contain relative segments")
+		}
+	}
+
+	return nil
+}
+
+// Check checks the configuration of a path.
+func (pconf *Path) Check(name string) error {
+	pconf.Name = name
+
+	if name != "" {
+		err := IsValidPathName(name)
+		if err != nil {
+			return fmt.Errorf("invalid path name '%s': %w", name, err)
+		}
+	}
+
+	if pconf.RtpReorderBufferSize < 0 || pconf.RtpReorderBufferSize > 1024 {
+		return fmt.Errorf("path %s: rtpReorderBufferSize must be between 0 and 1024", name)
+	}
+
+	if pconf.RtpReorderLatencyRaw != "" {
+		err := pconf.RtpReorderLatency.Marshal(pconf.RtpReorderLatencyRaw)
+		if err != nil {
+			return fmt.Errorf("path %s: %v", name, err)
+		}
+	}
+
+	if pconf.GOPCacheMaxDurationRaw != "" {
+		err := pconf.GOPCacheMaxDuration.Marshal(pconf.GOPCacheMaxDurationRaw)
+		if err != nil {
+			return fmt.Errorf("path %s: %v", name, err)
+		}
+	}
+
+	if pconf.WaitForKeyframeTimeoutRaw != "" {
+		err := pconf.WaitForKeyframeTimeout.Marshal(pconf.WaitForKeyframeTimeoutRaw)
+		if err != nil {
+			return fmt.Errorf("path %s: %v", name, err)
+		}
+	}
+
+	if pconf.KeyframeRequestIntervalRaw != "" {
+		err := pconf.KeyframeRequestInterval.Marshal(pconf.KeyframeRequestIntervalRaw)
+		if err != nil {
+			return fmt.Errorf("path %s: %v", name, err)
+		}
+	}
+
+	if pconf.RecordPreRollRaw != "" {
+		err := pconf.RecordPreRoll.Marshal(pconf.RecordPreRollRaw)
+		if err != nil {
+			return fmt.Errorf("path %s: %v", name, err)
+		}
+	}
+
+	if pconf.RecordPostRollRaw != "" {
+		err := pconf.RecordPostRoll.Marshal(pconf.RecordPostRollRaw)
+		if err != nil {
+			return fmt.Errorf("path %s: %v", name, err)
+		}
+	}
+
+	location := time.Local
+	if pconf.RecordScheduleTimezone != "" {
+		var err error
+		location, err = time.LoadLocation(pconf.RecordScheduleTimezone)
+		if err != nil {
+			return fmt.Errorf("path %s: invalid recordScheduleTimezone: %v", name, err)
+		}
+	}
+
+	err := pconf.RecordSchedule.Marshal(pconf.RecordScheduleRaw, location)
+	if err != nil {
+		return fmt.Errorf("path %s: invalid recordSchedule: %v", name, err)
+	}
+
+	if pconf.RecordPartDurationRaw != "" {
+		err := pconf.RecordPartDuration.Marshal(pconf.RecordPartDurationRaw)
+		if err != nil {
+			return fmt.Errorf("path %s: %v", name, err)
+		}
+	}
+
+	if pconf.RecordSegmentDurationRaw != "" {
+		err := pconf.RecordSegmentDuration.Marshal(pconf.RecordSegmentDurationRaw)
+		if err != nil {
+			return fmt.Errorf("path %s: %v", name, err)
+		}
+	}
+
+	if pconf.RecordDeleteAfterRaw != "" {
+		err := pconf.RecordDeleteAfter.Marshal(pconf.RecordDeleteAfterRaw)
+		if err != nil {
+			return fmt.Errorf("path %s: %v", name, err)
+		}
+	}
+
+	pconf.RecordEncryptionKeys = nil
+	if pconf.RecordEncryptionKeyFile != "" {
+		pconf.RecordEncryptionKeys, err = recordcrypto.LoadKeys(pconf.RecordEncryptionKeyFile)
+		if err != nil {
+			return fmt.Errorf("path %s: invalid recordEncryptionKeyFile: %v", name, err)
+		}
+	}
+
+	if pconf.Record {
+		if !strings.Contains(pconf.RecordPath, "%path") {
+			return fmt.Errorf("path %s: recordPath must contain %%path", name)
+		}
+
+		if !recordstore.HasTimeTokens(pconf.RecordPath) {
+			return fmt.Errorf("path %s: recordPath must contain either %%s or %%Y %%m %%d %%H %%M %%S", name)
+		}
+
+		if pconf.RecordFormat != "fmp4" && pconf.RecordFormat != "mpegts" {
+			return fmt.Errorf("path %s: unsupported recordFormat '%s'", name, pconf.RecordFormat)
+		}
+
+		if pconf.RecordMode != "always" && pconf.RecordMode != "event" {
+			return fmt.Errorf("path %s: unsupported recordMode '%s'", name, pconf.RecordMode)
+		}
+
+		if pconf.RecordFsync != "never" && pconf.RecordFsync != "segment" && pconf.RecordFsync != "part" {
+			return fmt.Errorf("path %s: unsupported recordFsync '%s'", name, pconf.RecordFsync)
+		}
+
+		if pconf.RecordPartDuration <= 0 || pconf.RecordSegmentDuration <= 0 {
+			return fmt.Errorf("path %s: recordPartDuration and recordSegmentDuration must be greater than 0", name)
+		}
+	}
+
+	if pconf.GOPCache && pconf.GOPCacheMaxSize == 0 && pconf.GOPCacheMaxDuration == 0 {
+		return fmt.Errorf("path %s: gopCacheMaxSize or gopCacheMaxDuration must be set when gopCache is enabled", name)
+	}
+
+	pconf.H264SPS, err = decodeParameter(name, "h264SPS", pconf.H264SPSRaw)
+	if err != nil {
+		return err
+	}
+	pconf.H264PPS, err = decodeParameter(name, "h264PPS", pconf.H264PPSRaw)
+	if err != nil {
+		return err
+	}
+	pconf.H265VPS, err = decodeParameter(name, "h265VPS", pconf.H265VPSRaw)
+	if err != nil {
+		return err
+	}
+	pconf.H265SPS, err = decodeParameter(name, "h265SPS", pconf.H265SPSRaw)
+	if err != nil {
+		return err
+	}
+	pconf.H265PPS, err = decodeParameter(name, "h265PPS", pconf.H265PPSRaw)
+	if err != nil {
+		return err
+	}
+
+	pconf.SEIUUIDFilter = nil
+	for _, raw := range strings.Split(pconf.SEIUUIDFilterRaw, ",") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+
+		id, err := uuid.Parse(raw)
+		if err != nil {
+			return fmt.Errorf("path %s: invalid seiUUIDFilter entry '%s'", name, raw)
+		}
+		pconf.SEIUUIDFilter = append(pconf.SEIUUIDFilter, id)
+	}
+
+	return nil
+}
+
+// FindPathConf returns the configuration of a path.
+// Paths that are not listed in the configuration use the defaults of the [paths] section.
+func FindPathConf(pathConfs map[string]*Path, pathDefaults *Path, name string) *Path {
+	if pconf, ok := pathConfs[name]; ok {
+		return pconf
+	}
+
+	pconf := *pathDefaults
+	pconf.Name = name
+	return &pconf
 }
@@ -0,0 +1,38 @@
+package conf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsValidPathName(t *testing.T) {
+	for _, ca := range []struct {
+		name  string
+		valid bool
+	}{
+		{"mystream", true},
+		{"cam/1", true},
+		{"site.a/cam_1~hd-2", true},
+		{"", false},
+		{"/mystream", false},
+		{"mystream/", false},
+		{"cam//1", false},
+		{"..", false},
+		{"../etc", false},
+		{"cam/../../etc", false},
+		{"cam/./1", false},
+		{"cam 1", false},
+		{"cam%path", false},
+		{`cam\1`, false},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			err := IsValidPathName(ca.name)
+			if ca.valid {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+			}
+		})
+	}
+}
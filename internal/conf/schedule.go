@@ -0,0 +1,193 @@
+package conf
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	// embed the timezone database, since it's often missing from embedded systems.
+	_ "time/tzdata"
+)
+
+var reScheduleWindow = regexp.MustCompile(`^([A-Za-z]+)(?:-([A-Za-z]+))?\s+([0-9]{2}):([0-9]{2})-([0-9]{2}):([0-9]{2})$`)
+
+var scheduleDays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// scheduleWindow is a daily time window, that is active in some days of the week.
+// Windows whose end precedes their start cross midnight.
+type scheduleWindow struct {
+	days  [7]bool
+	start time.Duration // since midnight
+	end   time.Duration // since midnight
+}
+
+// Schedule is a weekly schedule, made of time windows in a time zone.
+type Schedule struct {
+	windows  []scheduleWindow
+	location *time.Location
+}
+
+func parseScheduleDay(v string) (time.Weekday, error) {
+	d, ok := scheduleDays[strings.ToLower(v)]
+	if !ok {
+		return 0, fmt.Errorf("invalid day '%s'", v)
+	}
+	return d, nil
+}
+
+func parseScheduleTime(hours string, minutes string) (time.Duration, error) {
+	h, _ := strconv.Atoi(hours)
+	m, _ := strconv.Atoi(minutes)
+
+	if m > 59 || h > 24 || (h == 24 && m != 0) {
+		return 0, fmt.Errorf("invalid time '%s:%s'", hours, minutes)
+	}
+
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute, nil
+}
+
+// Marshal parses a schedule, that is a comma-separated list of windows like
+// "Mon-Fri 08:00-18:00, Sat 09:00-12:00", in the given time zone.
+// "daily" can be used in place of days.
+func (s *Schedule) Marshal(raw string, location *time.Location) error {
+	s.windows = nil
+	s.location = location
+
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if strings.HasPrefix(strings.ToLower(entry), "daily ") {
+			entry = "Sun-Sat " + strings.TrimSpace(entry[len("daily "):])
+		}
+
+		m := reScheduleWindow.FindStringSubmatch(entry)
+		if m == nil {
+			return fmt.Errorf("invalid schedule window '%s'", entry)
+		}
+
+		first, err := parseScheduleDay(m[1])
+		if err != nil {
+			return err
+		}
+
+		last := first
+		if m[2] != "" {
+			last, err = parseScheduleDay(m[2])
+			if err != nil {
+				return err
+			}
+		}
+
+		var w scheduleWindow
+
+		for d := first; ; d = (d + 1) % 7 {
+			w.days[d] = true
+			if d == last {
+				break
+			}
+		}
+
+		w.start, err = parseScheduleTime(m[3], m[4])
+		if err != nil {
+			return err
+		}
+
+		w.end, err = parseScheduleTime(m[5], m[6])
+		if err != nil {
+			return err
+		}
+
+		if w.start == w.end {
+			return fmt.Errorf("schedule window '%s' is empty", entry)
+		}
+
+		s.windows = append(s.windows, w)
+	}
+
+	return nil
+}
+
+// IsEmpty returns whether the schedule has no windows.
+func (s Schedule) IsEmpty() bool {
+	return len(s.windows) == 0
+}
+
+// at returns the time of a given day at a given duration since midnight, in the time zone of the schedule.
+func (s Schedule) at(day time.Time, d time.Duration) time.Time {
+	return time.Date(day.Year(), day.Month(), day.Day(),
+		int(d/time.Hour), int((d%time.Hour)/time.Minute), 0, 0, s.location)
+}
+
+// Active returns whether a time is inside a window of the schedule.
+func (s Schedule) Active(t time.Time) bool {
+	t = t.In(s.location)
+
+	// windows that started today or yesterday can contain t
+	for _, day := range []time.Time{t, t.AddDate(0, 0, -1)} {
+		for _, w := range s.windows {
+			if !w.days[day.Weekday()] {
+				continue
+			}
+
+			start := s.at(day, w.start)
+			end := s.at(day, w.end)
+			if w.end < w.start {
+				end = s.at(day.AddDate(0, 0, 1), w.end)
+			}
+
+			if !t.Before(start) && t.Before(end) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// NextChange returns the first time after t in which the schedule switches from active to inactive or vice versa.
+// It returns the zero time when the schedule never changes.
+func (s Schedule) NextChange(t time.Time) time.Time {
+	cur := s.Active(t)
+	t = t.In(s.location)
+
+	var ret time.Time
+
+	// borders of windows in the following week
+	for i := -1; i <= 8; i++ {
+		day := t.AddDate(0, 0, i)
+
+		for _, w := range s.windows {
+			if !w.days[day.Weekday()] {
+				continue
+			}
+
+			borders := []time.Time{s.at(day, w.start)}
+			if w.end < w.start {
+				borders = append(borders, s.at(day.AddDate(0, 0, 1), w.end))
+			} else {
+				borders = append(borders, s.at(day, w.end))
+			}
+
+			for _, b := range borders {
+				if b.After(t) && (ret.IsZero() || b.Before(ret)) && s.Active(b) != cur {
+					ret = b
+				}
+			}
+		}
+	}
+
+	return ret
+}
@@ -0,0 +1,254 @@
+package conf
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func mustParseSchedule(t *testing.T, raw string, location *time.Location) Schedule {
+	var s Schedule
+	err := s.Marshal(raw, location)
+	require.NoError(t, err)
+	return s
+}
+
+func TestScheduleMarshalErrors(t *testing.T) {
+	for _, raw := range []string{
+		"Mon 08:00",
+		"Foo 08:00-18:00",
+		"Mon-Foo 08:00-18:00",
+		"Mon 25:00-26:00",
+		"Mon 08:60-09:00",
+		"Mon 08:00-24:30",
+		"Mon 08:00-08:00",
+	} {
+		t.Run(raw, func(t *testing.T) {
+			var s Schedule
+			err := s.Marshal(raw, time.UTC)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestScheduleActive(t *testing.T) {
+	rome, err := time.LoadLocation("Europe/Rome")
+	require.NoError(t, err)
+
+	for _, ca := range []struct {
+		name     string
+		schedule string
+		location *time.Location
+		t        time.Time
+		active   bool
+	}{
+		{
+			"inside window",
+			"Mon-Fri 08:00-18:00",
+			time.UTC,
+			time.Date(2024, 6, 3, 8, 0, 0, 0, time.UTC), // Monday
+			true,
+		},
+		{
+			"end is excluded",
+			"Mon-Fri 08:00-18:00",
+			time.UTC,
+			time.Date(2024, 6, 3, 18, 0, 0, 0, time.UTC),
+			false,
+		},
+		{
+			"day outside range",
+			"Mon-Fri 08:00-18:00",
+			time.UTC,
+			time.Date(2024, 6, 8, 10, 0, 0, 0, time.UTC), // Saturday
+			false,
+		},
+		{
+			"range crossing the end of the week",
+			"Fri-Mon 08:00-18:00",
+			time.UTC,
+			time.Date(2024, 6, 9, 10, 0, 0, 0, time.UTC), // Sunday
+			true,
+		},
+		{
+			"range crossing the end of the week, excluded day",
+			"Fri-Mon 08:00-18:00",
+			time.UTC,
+			time.Date(2024, 6, 5, 10, 0, 0, 0, time.UTC), // Wednesday
+			false,
+		},
+		{
+			"midnight crossing, before midnight",
+			"Fri 22:00-02:00",
+			time.UTC,
+			time.Date(2024, 6, 7, 23, 0, 0, 0, time.UTC), // Friday
+			true,
+		},
+		{
+			"midnight crossing, after midnight",
+			"Fri 22:00-02:00",
+			time.UTC,
+			time.Date(2024, 6, 8, 1, 59, 0, 0, time.UTC), // Saturday
+			true,
+		},
+		{
+			"midnight crossing, window of the following day",
+			"Fri 22:00-02:00",
+			time.UTC,
+			time.Date(2024, 6, 8, 23, 0, 0, 0, time.UTC), // Saturday
+			false,
+		},
+		{
+			"midnight crossing, after the end",
+			"Fri 22:00-02:00",
+			time.UTC,
+			time.Date(2024, 6, 8, 2, 0, 0, 0, time.UTC),
+			false,
+		},
+		{
+			"end at 24:00",
+			"Sat 20:00-24:00",
+			time.UTC,
+			time.Date(2024, 6, 8, 23, 59, 59, 0, time.UTC),
+			true,
+		},
+		{
+			"end at 24:00, next day",
+			"Sat 20:00-24:00",
+			time.UTC,
+			time.Date(2024, 6, 9, 0, 0, 0, 0, time.UTC),
+			false,
+		},
+		{
+			"daily",
+			"daily 00:00-01:00",
+			time.UTC,
+			time.Date(2024, 6, 5, 0, 30, 0, 0, time.UTC),
+			true,
+		},
+		{
+			"multiple windows",
+			"Mon-Fri 08:00-12:00, Sat 09:00-10:00",
+			time.UTC,
+			time.Date(2024, 6, 8, 9, 30, 0, 0, time.UTC),
+			true,
+		},
+		{
+			"time zone",
+			"Mon 08:00-09:00",
+			rome,
+			time.Date(2024, 6, 3, 6, 30, 0, 0, time.UTC), // 08:30 CEST
+			true,
+		},
+		{
+			"time zone, same time in UTC",
+			"Mon 08:00-09:00",
+			rome,
+			time.Date(2024, 6, 3, 8, 30, 0, 0, time.UTC), // 10:30 CEST
+			false,
+		},
+		{
+			"daylight saving time start",
+			"Sun 01:00-05:00",
+			rome,
+			time.Date(2024, 3, 31, 2, 30, 0, 0, time.UTC), // 04:30 CEST
+			true,
+		},
+		{
+			"daylight saving time end",
+			"Sun 01:00-05:00",
+			rome,
+			time.Date(2024, 10, 27, 3, 30, 0, 0, time.UTC), // 04:30 CET
+			true,
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			s := mustParseSchedule(t, ca.schedule, ca.location)
+			require.Equal(t, ca.active, s.Active(ca.t))
+		})
+	}
+}
+
+func TestScheduleNextChange(t *testing.T) {
+	rome, err := time.LoadLocation("Europe/Rome")
+	require.NoError(t, err)
+
+	for _, ca := range []struct {
+		name     string
+		schedule string
+		location *time.Location
+		t        time.Time
+		next     time.Time
+	}{
+		{
+			"start",
+			"Mon-Fri 08:00-18:00",
+			time.UTC,
+			time.Date(2024, 6, 3, 7, 0, 0, 0, time.UTC),
+			time.Date(2024, 6, 3, 8, 0, 0, 0, time.UTC),
+		},
+		{
+			"end",
+			"Mon-Fri 08:00-18:00",
+			time.UTC,
+			time.Date(2024, 6, 3, 8, 0, 0, 0, time.UTC),
+			time.Date(2024, 6, 3, 18, 0, 0, 0, time.UTC),
+		},
+		{
+			"start after the weekend",
+			"Mon-Fri 08:00-18:00",
+			time.UTC,
+			time.Date(2024, 6, 7, 18, 0, 0, 0, time.UTC), // Friday
+			time.Date(2024, 6, 10, 8, 0, 0, 0, time.UTC), // Monday
+		},
+		{
+			"midnight crossing",
+			"Fri 22:00-02:00",
+			time.UTC,
+			time.Date(2024, 6, 7, 23, 0, 0, 0, time.UTC),
+			time.Date(2024, 6, 8, 2, 0, 0, 0, time.UTC),
+		},
+		{
+			"adjacent windows are merged",
+			"Mon 00:00-24:00, Tue 00:00-24:00",
+			time.UTC,
+			time.Date(2024, 6, 3, 12, 0, 0, 0, time.UTC),
+			time.Date(2024, 6, 5, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			"window crossing midnight adjacent to the next one",
+			"Mon 20:00-02:00, Tue 02:00-04:00",
+			time.UTC,
+			time.Date(2024, 6, 3, 21, 0, 0, 0, time.UTC),
+			time.Date(2024, 6, 4, 4, 0, 0, 0, time.UTC),
+		},
+		{
+			"always active",
+			"daily 00:00-24:00",
+			time.UTC,
+			time.Date(2024, 6, 3, 12, 0, 0, 0, time.UTC),
+			time.Time{},
+		},
+		{
+			"daylight saving time start",
+			"Sun 01:00-05:00",
+			rome,
+			time.Date(2024, 3, 31, 0, 30, 0, 0, time.UTC), // 01:30 CET
+			time.Date(2024, 3, 31, 3, 0, 0, 0, time.UTC),  // 05:00 CEST
+		},
+		{
+			"daylight saving time end",
+			"Sun 01:00-05:00",
+			rome,
+			time.Date(2024, 10, 26, 23, 30, 0, 0, time.UTC), // 01:30 CEST
+			time.Date(2024, 10, 27, 4, 0, 0, 0, time.UTC),   // 05:00 CET
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			s := mustParseSchedule(t, ca.schedule, ca.location)
+			next := s.NextChange(ca.t)
+			require.True(t, ca.next.Equal(next), "expected %v, got %v", ca.next, next)
+		})
+	}
+}
@@ -1,8 +1,12 @@
 package core
 
 import (
+	"XMedia/internal/api"
 	"XMedia/internal/conf"
 	"XMedia/internal/logger"
+	"XMedia/internal/playback"
+	"XMedia/internal/recordcleaner"
+	"XMedia/internal/recordrecovery"
 	"XMedia/internal/servers/rtsp"
 	"context"
 	"fmt"
@@ -10,14 +14,17 @@ import (
 )
 
 type Core struct {
-	product     string
-	ctx         context.Context
-	ctxCancel   func()
-	confPath    string
-	conf        *conf.Config
-	logger      *logger.AsyncLogQueue
-	pathManager *pathManager
-	rtspServer  *rtsp.Server
+	product        string
+	ctx            context.Context
+	ctxCancel      func()
+	confPath       string
+	conf           *conf.Config
+	logger         *logger.AsyncLogQueue
+	recordCleaner  *recordcleaner.Cleaner
+	pathManager    *pathManager
+	rtspServer     *rtsp.Server
+	api            *api.API
+	playbackServer *playback.Server
 
 	// out
 	done chan struct{}
@@ -96,6 +103,8 @@ outer:
 		}
 	}
 	p.ctxCancel()
+
+	p.closeResources()
 }
 
 func (p *Core) createResources(initial bool) error {
@@ -112,6 +121,27 @@ func (p *Core) createResources(initial bool) error {
 		}
 	}
 
+	// segments must be repaired before recorders append new ones
+	if initial {
+		rr := &recordrecovery.Recovery{
+			PathConfs:    p.conf.Paths,
+			PathDefaults: &p.conf.PathDefaults,
+			Parent:       p,
+		}
+		rr.Run()
+	}
+
+	if p.recordCleaner == nil {
+		p.recordCleaner = &recordcleaner.Cleaner{
+			PathConfs:    p.conf.Paths,
+			PathDefaults: &p.conf.PathDefaults,
+			MaxSize:      p.conf.RecordStorage.RecordStorageMaxSize,
+			MinFreeSpace: p.conf.RecordStorage.RecordStorageMinFreeSpace,
+			Parent:       p,
+		}
+		p.recordCleaner.Initialize()
+	}
+
 	if p.pathManager == nil {
 		p.pathManager = &pathManager{
 			rtspAddress:       p.conf.Rtsp.RtspAddress,
@@ -119,6 +149,8 @@ func (p *Core) createResources(initial bool) error {
 			writeTimeout:      p.conf.General.WriteTimeout,
 			writeQueueSize:    p.conf.General.WriteQueueSize,
 			udpMaxPayloadSize: p.conf.General.UdpMaxPayloadSize,
+			pathConfs:         p.conf.Paths,
+			pathDefaults:      &p.conf.PathDefaults,
 			parent:            p,
 		}
 		p.pathManager.initialize()
@@ -143,11 +175,69 @@ func (p *Core) createResources(initial bool) error {
 		p.rtspServer = i
 	}
 
+	if p.conf.Api.Api {
+		i := &api.API{
+			Address:      p.conf.Api.ApiAddress,
+			ReadTimeout:  p.conf.General.ReadTimeout,
+			WriteTimeout: p.conf.General.WriteTimeout,
+			PathConfs:    p.conf.Paths,
+			PathDefaults: &p.conf.PathDefaults,
+			PathManager:  p.pathManager,
+			Parent:       p,
+		}
+		err = i.Initialize()
+		if err != nil {
+			return err
+		}
+		p.api = i
+	}
+
+	if p.conf.Playback.Playback {
+		i := &playback.Server{
+			Address:      p.conf.Playback.PlaybackAddress,
+			ReadTimeout:  p.conf.General.ReadTimeout,
+			WriteTimeout: p.conf.General.WriteTimeout,
+			ExportPath:   p.conf.Playback.PlaybackExportPath,
+			PathConfs:    p.conf.Paths,
+			PathDefaults: &p.conf.PathDefaults,
+			Parent:       p,
+		}
+		err = i.Initialize()
+		if err != nil {
+			return err
+		}
+		p.playbackServer = i
+	}
+
 	return err
 }
 
 func (p *Core) closeResources() {
-	return
+	if p.playbackServer != nil {
+		p.playbackServer.Close()
+		p.playbackServer = nil
+	}
+
+	if p.api != nil {
+		p.api.Close()
+		p.api = nil
+	}
+
+	if p.rtspServer != nil {
+		p.rtspServer.Close()
+		p.rtspServer = nil
+	}
+
+	// paths flush and close the segments of their recorders
+	if p.pathManager != nil {
+		p.pathManager.close()
+		p.pathManager = nil
+	}
+
+	if p.recordCleaner != nil {
+		p.recordCleaner.Close()
+		p.recordCleaner = nil
+	}
 }
 
 // Log implements log.Writer.
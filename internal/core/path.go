@@ -3,7 +3,9 @@ package core
 import (
 	"XMedia/internal/conf"
 	"XMedia/internal/defs"
+	"XMedia/internal/formatprocessor"
 	"XMedia/internal/logger"
+	"XMedia/internal/recorder"
 	"XMedia/internal/stream"
 	"context"
 	"fmt"
@@ -11,8 +13,67 @@ import (
 	"time"
 
 	"github.com/bluenviron/gortsplib/v4/pkg/description"
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/google/uuid"
 )
 
+type pathAPISEIUserDataGetRes struct {
+	data *defs.APISEIUserDataList
+	err  error
+}
+
+type pathAPISEIUserDataGetReq struct {
+	res chan pathAPISEIUserDataGetRes
+}
+
+type pathAPISEIUserDataInjectReq struct {
+	uuid    [16]byte
+	payload []byte
+	res     chan error
+}
+
+type pathAPIKLVTelemetryGetRes struct {
+	data *defs.APIKLVTelemetry
+	err  error
+}
+
+type pathAPIKLVTelemetryGetReq struct {
+	res chan pathAPIKLVTelemetryGetRes
+}
+
+type pathAPITracksGetRes struct {
+	data *defs.APIPathTrackList
+	err  error
+}
+
+type pathAPITracksGetReq struct {
+	res chan pathAPITracksGetRes
+}
+
+type pathAPIRecordingGetRes struct {
+	data *defs.APIPathRecording
+	err  error
+}
+
+type pathAPIRecordingGetReq struct {
+	res chan pathAPIRecordingGetRes
+}
+
+type pathAPIRecordEventReq struct {
+	start bool
+	res   chan error
+}
+
+// maximum interval between two checks of the recording schedule,
+// in order to follow changes of the system clock.
+const recordScheduleCheckPeriod = 1 * time.Minute
+
+func emptyTimer() *time.Timer {
+	t := time.NewTimer(0)
+	<-t.C
+	return t
+}
+
 type pathParent interface {
 	logger.Writer
 	pathReady(*path)
@@ -26,6 +87,7 @@ type path struct {
 	writeTimeout      conf.Duration
 	writeQueueSize    int
 	udpMaxPayloadSize int
+	conf              *conf.Path
 	name              string
 	matches           []string
 	wg                *sync.WaitGroup
@@ -36,10 +98,31 @@ type path struct {
 	source         defs.Source
 	publisherQuery string
 	stream         *stream.Stream
+	streamReady    <-chan struct{}
+	readyTimer     *time.Timer
 	readyTime      time.Time
-
-	chAddPublisher   chan defs.PathAddPublisherReq
-	chStartPublisher chan defs.PathStartPublisherReq
+	recorder       *recorder.Recorder
+	// NTP of the last unit recorded from the current stream,
+	// in order not to record units of the pre-roll buffer twice.
+	lastRecordedNTP time.Time
+	// whether an event is being recorded.
+	recordEvent bool
+	// whether the post-roll of an event is being recorded.
+	recordPostRoll bool
+	postRollTimer  *time.Timer
+	// whether the recording schedule is active.
+	recordScheduled bool
+	scheduleTimer   *time.Timer
+
+	chAddPublisher    chan defs.PathAddPublisherReq
+	chStartPublisher  chan defs.PathStartPublisherReq
+	chRemovePublisher chan defs.PathRemovePublisherReq
+	chAPISEIUserData  chan pathAPISEIUserDataGetReq
+	chAPISEIInject    chan pathAPISEIUserDataInjectReq
+	chAPIKLV          chan pathAPIKLVTelemetryGetReq
+	chAPITracks       chan pathAPITracksGetReq
+	chAPIRecordEvent  chan pathAPIRecordEventReq
+	chAPIRecording    chan pathAPIRecordingGetReq
 
 	// out
 	done chan struct{}
@@ -52,8 +135,23 @@ func (pa *path) initialize() {
 	pa.ctxCancel = ctxCancel
 	pa.chAddPublisher = make(chan defs.PathAddPublisherReq)
 	pa.chStartPublisher = make(chan defs.PathStartPublisherReq)
+	pa.chRemovePublisher = make(chan defs.PathRemovePublisherReq)
+	pa.chAPISEIUserData = make(chan pathAPISEIUserDataGetReq)
+	pa.chAPISEIInject = make(chan pathAPISEIUserDataInjectReq)
+	pa.chAPIKLV = make(chan pathAPIKLVTelemetryGetReq)
+	pa.chAPITracks = make(chan pathAPITracksGetReq)
+	pa.chAPIRecordEvent = make(chan pathAPIRecordEventReq)
+	pa.chAPIRecording = make(chan pathAPIRecordingGetReq)
 
 	pa.done = make(chan struct{})
+	pa.readyTimer = emptyTimer()
+	pa.postRollTimer = emptyTimer()
+	pa.scheduleTimer = emptyTimer()
+
+	if pa.conf.Record && !pa.conf.RecordSchedule.IsEmpty() {
+		pa.recordScheduled = pa.conf.RecordSchedule.Active(time.Now())
+		pa.scheduleNextCheck()
+	}
 
 	pa.Log(logger.Info, "created")
 
@@ -78,6 +176,11 @@ func (pa *path) Name() string {
 	return pa.name
 }
 
+// SafeConf returns the path configuration.
+func (pa *path) SafeConf() *conf.Path {
+	return pa.conf
+}
+
 // addPublisher is called by a publisher through pathManager.
 func (pa *path) addPublisher(req defs.PathAddPublisherReq) (defs.Path, error) {
 	select {
@@ -100,9 +203,12 @@ func (pa *path) run() {
 
 	pa.ctxCancel()
 
-	// if pa.stream != nil {
-	// 	pa.setNotReady()
-	// }
+	pa.stopRecording()
+
+	if pa.stream != nil {
+		pa.stream.Close()
+		pa.stream = nil
+	}
 
 	// if pa.source != nil {
 	// 	if source, ok := pa.source.(*staticsources.Handler); ok {
@@ -128,6 +234,30 @@ func (pa *path) runInner() error {
 			pa.doAddPublisher(req)
 		case req := <-pa.chStartPublisher:
 			pa.doStartPublisher(req)
+		case req := <-pa.chRemovePublisher:
+			pa.doRemovePublisher(req)
+		case <-pa.streamReady:
+			pa.doStreamReady()
+		case <-pa.readyTimer.C:
+			pa.doReadyTimeout()
+		case req := <-pa.chAPISEIUserData:
+			pa.doAPISEIUserDataGet(req)
+		case req := <-pa.chAPISEIInject:
+			pa.doAPISEIUserDataInject(req)
+		case req := <-pa.chAPIKLV:
+			pa.doAPIKLVTelemetryGet(req)
+		case req := <-pa.chAPITracks:
+			pa.doAPITracksGet(req)
+		case req := <-pa.chAPIRecordEvent:
+			pa.doAPIRecordEvent(req)
+		case <-pa.postRollTimer.C:
+			pa.doPostRollTimeout()
+		case <-pa.scheduleTimer.C:
+			pa.doScheduleCheck()
+		case req := <-pa.chAPIRecording:
+			pa.doAPIRecordingGet(req)
+		case <-pa.ctx.Done():
+			return fmt.Errorf("terminated")
 		}
 	}
 }
@@ -166,6 +296,42 @@ func (pa *path) doStartPublisher(req defs.PathStartPublisherReq) {
 	req.Res <- defs.PathStartPublisherRes{Stream: pa.stream}
 }
 
+func (pa *path) doRemovePublisher(req defs.PathRemovePublisherReq) {
+	if pa.source == req.Author {
+		pa.setNotReady()
+		pa.source = nil
+	}
+	close(req.Res)
+}
+
+// setNotReady stops the recorder and closes the stream of the publisher.
+func (pa *path) setNotReady() {
+	pa.stopRecording()
+	pa.recordEvent = false
+	pa.recordPostRoll = false
+	pa.postRollTimer.Stop()
+
+	pa.streamReady = nil
+	pa.readyTimer.Stop()
+
+	if pa.stream != nil {
+		pa.stream.Close()
+		pa.stream = nil
+	}
+
+	pa.lastRecordedNTP = time.Time{}
+}
+
+// RemovePublisher is called by a publisher when it stops publishing.
+func (pa *path) RemovePublisher(req defs.PathRemovePublisherReq) {
+	req.Res = make(chan struct{})
+	select {
+	case pa.chRemovePublisher <- req:
+		<-req.Res
+	case <-pa.ctx.Done():
+	}
+}
+
 func (pa *path) StartPublisher(req defs.PathStartPublisherReq) (*stream.Stream, error) {
 	req.Res = make(chan defs.PathStartPublisherRes)
 	select {
@@ -178,21 +344,506 @@ func (pa *path) StartPublisher(req defs.PathStartPublisherReq) (*stream.Stream,
 }
 
 func (pa *path) setReady(desc *description.Session, allocateEncoder bool) error {
-	pa.stream = &stream.Stream{
+	// stream of a previous publisher
+	pa.setNotReady()
+
+	pa.seedParameters(desc)
+	pa.announcePacketizationMode1(desc)
+
+	strm := &stream.Stream{
 		WriteQueueSize:     pa.writeQueueSize,
 		UDPMaxPayloadSize:  pa.udpMaxPayloadSize,
 		Desc:               desc,
 		GenerateRTPPackets: allocateEncoder,
-		Parent:             pa.source,
+		WaitForParameters:  pa.conf.WaitForParameters,
+		WaitForKeyframe:    pa.conf.WaitForKeyframe,
+		ProcessorOptions: formatprocessor.Options{
+			H264: formatprocessor.H264Options{
+				InsertAUD:        pa.conf.H264InsertAUD,
+				StripSEI:         pa.conf.H264StripSEI,
+				RepeatParameters: pa.conf.H264RepeatParameters,
+				DropFiller:       pa.conf.H264DropFiller,
+				ExtractCaptions:  pa.conf.H264ExtractCaptions,
+				ExtractUserData:  pa.conf.H264ExtractUserData,
+				UserDataUUIDs:    pa.conf.SEIUUIDFilter,
+				ExtractTimecodes: pa.conf.H264ExtractTimecodes,
+			},
+		},
+		Parent: pa.source,
+	}
+	if kr, ok := pa.source.(defs.KeyframeRequester); ok && pa.conf.KeyframeRequests {
+		strm.OnKeyframeRequest = kr.RequestKeyframe
+		strm.KeyframeRequestInterval = time.Duration(pa.conf.KeyframeRequestInterval)
 	}
-	err := pa.stream.Initialize()
+	if pa.conf.Record && pa.conf.RecordMode == "event" {
+		strm.PreRollDuration = time.Duration(pa.conf.RecordPreRoll)
+	}
+	if pa.conf.GOPCache {
+		strm.GOPCacheMaxBytes = pa.conf.GOPCacheMaxSize
+		strm.GOPCacheMaxDuration = time.Duration(pa.conf.GOPCacheMaxDuration)
+	}
+	err := strm.Initialize()
 	if err != nil {
 		return err
 	}
+	pa.stream = strm
+
+	// the stream becomes ready when parameters of all tracks are known
+	pa.streamReady = strm.Ready()
+
+	if pa.conf.WaitForKeyframe {
+		pa.Log(logger.Info, "waiting for a keyframe on every track")
+
+		pa.readyTimer.Stop()
+		if pa.conf.WaitForKeyframeTimeout != 0 {
+			pa.readyTimer = time.NewTimer(time.Duration(pa.conf.WaitForKeyframeTimeout))
+		}
+	}
+
+	return nil
+}
+
+func (pa *path) doStreamReady() {
+	pa.streamReady = nil
+
+	if !pa.readyTimer.Stop() {
+		select {
+		case <-pa.readyTimer.C:
+		default:
+		}
+	}
+
+	if pa.conf.WaitForKeyframe {
+		pa.Log(logger.Info, "all tracks have produced a keyframe")
+	} else if pa.conf.WaitForParameters {
+		pa.Log(logger.Info, "parameters of all tracks are known")
+	}
 
 	pa.readyTime = time.Now()
 
+	pa.updateRecording(false)
+
 	pa.parent.pathReady(pa)
+}
 
-	return nil
+// isRecordingScheduled returns whether the stream must be recorded regardless of events.
+// Without a schedule, this happens in the always mode only.
+func (pa *path) isRecordingScheduled() bool {
+	if pa.conf.RecordSchedule.IsEmpty() {
+		return pa.conf.RecordMode == "always"
+	}
+	return pa.recordScheduled
+}
+
+// updateRecording starts or stops the recorder, depending on the schedule and on events.
+// When the recorder is started by an event, it begins with the pre-roll buffer.
+func (pa *path) updateRecording(preRoll bool) {
+	want := pa.conf.Record && pa.stream != nil && pa.streamReady == nil &&
+		(pa.isRecordingScheduled() || pa.recordEvent || pa.recordPostRoll)
+
+	switch {
+	case want && pa.recorder == nil:
+		pa.startRecording(preRoll)
+
+	case !want && pa.recorder != nil:
+		pa.stopRecording()
+	}
+}
+
+func (pa *path) scheduleNextCheck() {
+	now := time.Now()
+	d := recordScheduleCheckPeriod
+
+	if next := pa.conf.RecordSchedule.NextChange(now); !next.IsZero() && next.Sub(now) < d {
+		d = next.Sub(now)
+	}
+
+	pa.scheduleTimer = time.NewTimer(d)
+}
+
+// doScheduleCheck is called periodically when the path has a recording schedule.
+func (pa *path) doScheduleCheck() {
+	scheduled := pa.conf.RecordSchedule.Active(time.Now())
+
+	if scheduled != pa.recordScheduled {
+		pa.recordScheduled = scheduled
+		if scheduled {
+			pa.Log(logger.Info, "recording schedule started")
+		} else {
+			pa.Log(logger.Info, "recording schedule ended")
+		}
+
+		pa.updateRecording(false)
+	}
+
+	pa.scheduleNextCheck()
+}
+
+func (pa *path) startRecording(preRoll bool) {
+	pa.recorder = &recorder.Recorder{
+		Format:          pa.conf.RecordFormat,
+		PathFormat:      pa.conf.RecordPath,
+		PartDuration:    time.Duration(pa.conf.RecordPartDuration),
+		SegmentDuration: time.Duration(pa.conf.RecordSegmentDuration),
+		Fsync:           pa.conf.RecordFsync,
+		EncryptionKeys:  pa.conf.RecordEncryptionKeys,
+		PathName:        pa.name,
+		Stream:          pa.stream,
+		WriteQueueSize:  pa.writeQueueSize,
+		PreRoll:         preRoll,
+		PreRollAfter:    pa.lastRecordedNTP,
+		Parent:          pa,
+	}
+	pa.recorder.Initialize()
+}
+
+func (pa *path) stopRecording() {
+	if pa.recorder != nil {
+		pa.recorder.Close()
+		if ntp := pa.recorder.LastNTP(); ntp.After(pa.lastRecordedNTP) {
+			pa.lastRecordedNTP = ntp
+		}
+		pa.recorder = nil
+	}
+}
+
+// doReadyTimeout is called when the stream didn't become ready in time.
+func (pa *path) doReadyTimeout() {
+	if pa.streamReady == nil {
+		return
+	}
+
+	pa.Log(logger.Warn, "publisher didn't produce a keyframe on every track within %v, closing it",
+		pa.conf.WaitForKeyframeTimeout)
+
+	pa.streamReady = nil
+
+	// Close() is asynchronous and the publisher keeps writing to the stream until it is closed,
+	// therefore the stream is closed by doRemovePublisher(), once the publisher has stopped.
+	if publisher, ok := pa.source.(defs.Publisher); ok {
+		publisher.Close()
+		return
+	}
+
+	pa.setNotReady()
+	pa.source = nil
+}
+
+// seedParameters fills parameters of tracks that have not been announced by the publisher
+// with the ones of the path configuration.
+func (pa *path) seedParameters(desc *description.Session) {
+	for _, medi := range desc.Medias {
+		for _, forma := range medi.Formats {
+			switch forma := forma.(type) {
+			case *format.H264:
+				sps, pps := forma.SafeParams()
+				if sps == nil {
+					sps = pa.conf.H264SPS
+				}
+				if pps == nil {
+					pps = pa.conf.H264PPS
+				}
+				forma.SafeSetParams(sps, pps)
+
+			case *format.H265:
+				vps, sps, pps := forma.SafeParams()
+				if vps == nil {
+					vps = pa.conf.H265VPS
+				}
+				if sps == nil {
+					sps = pa.conf.H265SPS
+				}
+				if pps == nil {
+					pps = pa.conf.H265PPS
+				}
+				forma.SafeSetParams(vps, sps, pps)
+			}
+		}
+	}
+}
+
+// announcePacketizationMode1 announces H264 tracks published with packetization-mode 0
+// as packetization-mode 1, that allows the stream to fragment packets that exceed
+// the maximum payload size. Single NAL unit packets are valid in both modes and
+// are routed as they are.
+func (pa *path) announcePacketizationMode1(desc *description.Session) {
+	if !pa.conf.H264PacketizationMode1 {
+		return
+	}
+
+	for _, medi := range desc.Medias {
+		for _, forma := range medi.Formats {
+			if forma, ok := forma.(*format.H264); ok && forma.PacketizationMode == 0 {
+				forma.PacketizationMode = 1
+				pa.Log(logger.Info, "announcing H264 track with packetization-mode 1")
+			}
+		}
+	}
+}
+
+func (pa *path) doAPISEIUserDataGet(req pathAPISEIUserDataGetReq) {
+	if pa.stream == nil {
+		req.res <- pathAPISEIUserDataGetRes{err: defs.ErrPathNoStream}
+		return
+	}
+
+	data := &defs.APISEIUserDataList{
+		Items: []defs.APISEIUserData{},
+	}
+
+	for _, s := range pa.stream.LatestUserData() {
+		data.Items = append(data.Items, defs.APISEIUserData{
+			UUID:    uuid.UUID(s.UUID).String(),
+			Payload: s.Payload,
+			NTP:     s.NTP,
+		})
+	}
+
+	if tc, ok := pa.stream.LatestTimecode(); ok {
+		data.Timecode = &defs.APISEITimecode{
+			Value: tc.Timecode.String(),
+			NTP:   tc.NTP,
+		}
+	}
+
+	req.res <- pathAPISEIUserDataGetRes{data: data}
+}
+
+// apiSEIUserDataGet is called by pathManager.
+func (pa *path) apiSEIUserDataGet() (*defs.APISEIUserDataList, error) {
+	req := pathAPISEIUserDataGetReq{
+		res: make(chan pathAPISEIUserDataGetRes),
+	}
+
+	select {
+	case pa.chAPISEIUserData <- req:
+		res := <-req.res
+		return res.data, res.err
+
+	case <-pa.ctx.Done():
+		return nil, fmt.Errorf("terminated")
+	}
+}
+
+func (pa *path) doAPISEIUserDataInject(req pathAPISEIUserDataInjectReq) {
+	if pa.stream == nil {
+		req.res <- defs.ErrPathNoStream
+		return
+	}
+
+	err := pa.stream.InjectSEIUserData(req.uuid, req.payload)
+	if err == nil {
+		pa.Log(logger.Info, "SEI user data with UUID %s queued", uuid.UUID(req.uuid))
+	}
+
+	req.res <- err
+}
+
+// apiSEIUserDataInject is called by pathManager.
+func (pa *path) apiSEIUserDataInject(id [16]byte, payload []byte) error {
+	req := pathAPISEIUserDataInjectReq{
+		uuid:    id,
+		payload: payload,
+		res:     make(chan error),
+	}
+
+	select {
+	case pa.chAPISEIInject <- req:
+		return <-req.res
+
+	case <-pa.ctx.Done():
+		return fmt.Errorf("terminated")
+	}
+}
+
+func (pa *path) doAPIKLVTelemetryGet(req pathAPIKLVTelemetryGetReq) {
+	if pa.stream == nil {
+		req.res <- pathAPIKLVTelemetryGetRes{err: defs.ErrPathNoStream}
+		return
+	}
+
+	t, err := pa.stream.LatestTelemetry()
+	if err != nil {
+		req.res <- pathAPIKLVTelemetryGetRes{err: err}
+		return
+	}
+
+	req.res <- pathAPIKLVTelemetryGetRes{data: &defs.APIKLVTelemetry{
+		Timestamp: t.Timestamp,
+		Latitude:  t.Latitude,
+		Longitude: t.Longitude,
+		Altitude:  t.Altitude,
+	}}
+}
+
+// apiKLVTelemetryGet is called by pathManager.
+func (pa *path) apiKLVTelemetryGet() (*defs.APIKLVTelemetry, error) {
+	req := pathAPIKLVTelemetryGetReq{
+		res: make(chan pathAPIKLVTelemetryGetRes),
+	}
+
+	select {
+	case pa.chAPIKLV <- req:
+		res := <-req.res
+		return res.data, res.err
+
+	case <-pa.ctx.Done():
+		return nil, fmt.Errorf("terminated")
+	}
+}
+
+func (pa *path) doAPITracksGet(req pathAPITracksGetReq) {
+	if pa.stream == nil {
+		req.res <- pathAPITracksGetRes{err: defs.ErrPathNoStream}
+		return
+	}
+
+	data := &defs.APIPathTrackList{
+		Items: []defs.APIPathTrack{},
+	}
+
+	for _, medi := range pa.stream.Desc.Medias {
+		for _, forma := range medi.Formats {
+			stats := pa.stream.FormatStats(medi, forma)
+
+			track := defs.APIPathTrack{
+				Type:              string(medi.Type),
+				Codec:             forma.Codec(),
+				PacketsLost:       stats.PacketsLost,
+				PacketsDuplicated: stats.PacketsDuplicated,
+				PacketsLate:       stats.PacketsLate,
+				ProcessingErrors:  stats.ProcessingErrors,
+			}
+
+			if info, ok := pa.stream.TrackInfo(medi, forma); ok {
+				track.Info = &defs.APIPathTrackInfo{
+					Width:        info.Width,
+					Height:       info.Height,
+					Profile:      info.Profile,
+					Level:        info.Level,
+					ChromaFormat: info.ChromaFormat,
+					FPS:          info.FPS,
+				}
+			}
+
+			data.Items = append(data.Items, track)
+		}
+	}
+
+	req.res <- pathAPITracksGetRes{data: data}
+}
+
+// apiTracksGet is called by pathManager.
+func (pa *path) apiTracksGet() (*defs.APIPathTrackList, error) {
+	req := pathAPITracksGetReq{
+		res: make(chan pathAPITracksGetRes),
+	}
+
+	select {
+	case pa.chAPITracks <- req:
+		res := <-req.res
+		return res.data, res.err
+
+	case <-pa.ctx.Done():
+		return nil, fmt.Errorf("terminated")
+	}
+}
+
+func (pa *path) doAPIRecordEvent(req pathAPIRecordEventReq) {
+	if !pa.conf.Record || pa.conf.RecordMode != "event" {
+		req.res <- defs.ErrPathNoEventRecording
+		return
+	}
+
+	if pa.stream == nil || pa.streamReady != nil {
+		req.res <- defs.ErrPathNoStream
+		return
+	}
+
+	if req.start {
+		if !pa.postRollTimer.Stop() {
+			select {
+			case <-pa.postRollTimer.C:
+			default:
+			}
+		}
+
+		pa.recordPostRoll = false
+
+		if !pa.recordEvent {
+			pa.recordEvent = true
+			pa.Log(logger.Info, "event started")
+		}
+
+		pa.updateRecording(true)
+	} else if pa.recordEvent {
+		pa.recordEvent = false
+		pa.recordPostRoll = true
+		pa.Log(logger.Info, "event ended, recording it for another %v", pa.conf.RecordPostRoll)
+
+		pa.postRollTimer = time.NewTimer(time.Duration(pa.conf.RecordPostRoll))
+	}
+
+	req.res <- nil
+}
+
+// doPostRollTimeout is called when the post-roll of an event is over.
+func (pa *path) doPostRollTimeout() {
+	pa.recordPostRoll = false
+	pa.updateRecording(false)
+}
+
+func (pa *path) doAPIRecordingGet(req pathAPIRecordingGetReq) {
+	data := &defs.APIPathRecording{
+		Enabled:   pa.conf.Record,
+		Mode:      pa.conf.RecordMode,
+		Recording: pa.recorder != nil,
+		Event:     pa.recordEvent,
+		PostRoll:  pa.recordPostRoll,
+	}
+
+	if pa.conf.Record && !pa.conf.RecordSchedule.IsEmpty() {
+		data.Schedule = &defs.APIPathRecordingSchedule{
+			Schedule: pa.conf.RecordScheduleRaw,
+			Active:   pa.recordScheduled,
+		}
+
+		if next := pa.conf.RecordSchedule.NextChange(time.Now()); !next.IsZero() {
+			data.Schedule.NextChange = &next
+		}
+	}
+
+	req.res <- pathAPIRecordingGetRes{data: data}
+}
+
+// apiRecordingGet is called by pathManager.
+func (pa *path) apiRecordingGet() (*defs.APIPathRecording, error) {
+	req := pathAPIRecordingGetReq{
+		res: make(chan pathAPIRecordingGetRes),
+	}
+
+	select {
+	case pa.chAPIRecording <- req:
+		res := <-req.res
+		return res.data, res.err
+
+	case <-pa.ctx.Done():
+		return nil, fmt.Errorf("terminated")
+	}
+}
+
+// apiRecordEvent is called by pathManager.
+func (pa *path) apiRecordEvent(start bool) error {
+	req := pathAPIRecordEventReq{
+		start: start,
+		res:   make(chan error),
+	}
+
+	select {
+	case pa.chAPIRecordEvent <- req:
+		return <-req.res
+
+	case <-pa.ctx.Done():
+		return fmt.Errorf("terminated")
+	}
 }
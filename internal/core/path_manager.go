@@ -14,6 +14,16 @@ type pathData struct {
 	ready bool
 }
 
+type pathManagerAPIPathGetRes struct {
+	path *path
+	err  error
+}
+
+type pathManagerAPIPathGetReq struct {
+	name string
+	res  chan pathManagerAPIPathGetRes
+}
+
 type pathManagerParent interface {
 	logger.Writer
 }
@@ -24,6 +34,8 @@ type pathManager struct {
 	writeTimeout      conf.Duration
 	writeQueueSize    int
 	udpMaxPayloadSize int
+	pathConfs         map[string]*conf.Path
+	pathDefaults      *conf.Path
 	parent            pathManagerParent
 
 	ctx       context.Context
@@ -34,6 +46,7 @@ type pathManager struct {
 	chAddPublisher chan defs.PathAddPublisherReq
 	chClosePath    chan *path
 	chPathReady    chan *path
+	chAPIPathGet   chan pathManagerAPIPathGetReq
 }
 
 func (pm *pathManager) initialize() {
@@ -46,6 +59,7 @@ func (pm *pathManager) initialize() {
 	pm.chAddPublisher = make(chan defs.PathAddPublisherReq)
 	pm.chClosePath = make(chan *path)
 	pm.chPathReady = make(chan *path)
+	pm.chAPIPathGet = make(chan pathManagerAPIPathGetReq)
 
 	pm.Log(logger.Info, "path manager created")
 
@@ -53,6 +67,12 @@ func (pm *pathManager) initialize() {
 	go pm.run()
 }
 
+// close closes all paths, that stop their recorders, and waits for them.
+func (pm *pathManager) close() {
+	pm.ctxCancel()
+	pm.wg.Wait()
+}
+
 // Log implements logger.Writer.
 func (pm *pathManager) Log(level logger.Level, format string, args ...interface{}) {
 	pm.parent.Log(level, format, args...)
@@ -69,6 +89,8 @@ outer:
 			pm.doClosePath(pa)
 		case pa := <-pm.chPathReady:
 			pm.doPathReady(pa)
+		case req := <-pm.chAPIPathGet:
+			pm.doAPIPathGet(req)
 		case <-pm.ctx.Done():
 			break outer
 		}
@@ -120,6 +142,7 @@ func (pm *pathManager) doAddPublisher(req defs.PathAddPublisherReq) {
 func (pm *pathManager) createPath(name string) {
 	pa := &path{
 		parentCtx:         pm.ctx,
+		conf:              conf.FindPathConf(pm.pathConfs, pm.pathDefaults, name),
 		rtspAddress:       pm.rtspAddress,
 		readTimeout:       pm.readTimeout,
 		writeTimeout:      pm.writeTimeout,
@@ -169,3 +192,100 @@ func (pm *pathManager) doPathReady(pa *path) {
 	}
 	pm.paths[pa.name].ready = true
 }
+
+func (pm *pathManager) doAPIPathGet(req pathManagerAPIPathGetReq) {
+	pd, ok := pm.paths[req.name]
+	if !ok {
+		req.res <- pathManagerAPIPathGetRes{err: defs.ErrPathNotFound}
+		return
+	}
+
+	req.res <- pathManagerAPIPathGetRes{path: pd.path}
+}
+
+// apiPathGet returns a path.
+func (pm *pathManager) apiPathGet(name string) (*path, error) {
+	req := pathManagerAPIPathGetReq{
+		name: name,
+		res:  make(chan pathManagerAPIPathGetRes),
+	}
+
+	select {
+	case pm.chAPIPathGet <- req:
+		res := <-req.res
+		return res.path, res.err
+
+	case <-pm.ctx.Done():
+		return nil, fmt.Errorf("terminated")
+	}
+}
+
+// APISEIUserDataGet is called by api.API.
+func (pm *pathManager) APISEIUserDataGet(name string) (*defs.APISEIUserDataList, error) {
+	pa, err := pm.apiPathGet(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return pa.apiSEIUserDataGet()
+}
+
+// APISEIUserDataInject is called by api.API.
+func (pm *pathManager) APISEIUserDataInject(name string, uuid [16]byte, payload []byte) error {
+	pa, err := pm.apiPathGet(name)
+	if err != nil {
+		return err
+	}
+
+	return pa.apiSEIUserDataInject(uuid, payload)
+}
+
+// APIKLVTelemetryGet is called by api.API.
+func (pm *pathManager) APIKLVTelemetryGet(name string) (*defs.APIKLVTelemetry, error) {
+	pa, err := pm.apiPathGet(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return pa.apiKLVTelemetryGet()
+}
+
+// APITracksGet is called by api.API.
+func (pm *pathManager) APITracksGet(name string) (*defs.APIPathTrackList, error) {
+	pa, err := pm.apiPathGet(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return pa.apiTracksGet()
+}
+
+// APIRecordEventStart is called by api.API.
+func (pm *pathManager) APIRecordEventStart(name string) error {
+	pa, err := pm.apiPathGet(name)
+	if err != nil {
+		return err
+	}
+
+	return pa.apiRecordEvent(true)
+}
+
+// APIRecordEventStop is called by api.API.
+func (pm *pathManager) APIRecordEventStop(name string) error {
+	pa, err := pm.apiPathGet(name)
+	if err != nil {
+		return err
+	}
+
+	return pa.apiRecordEvent(false)
+}
+
+// APIRecordingGet is called by api.API.
+func (pm *pathManager) APIRecordingGet(name string) (*defs.APIPathRecording, error) {
+	pa, err := pm.apiPathGet(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return pa.apiRecordingGet()
+}
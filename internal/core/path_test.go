@@ -0,0 +1,236 @@
+package core
+
+import (
+	"XMedia/internal/conf"
+	"XMedia/internal/defs"
+	"XMedia/internal/logger"
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/description"
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPathSeedParameters(t *testing.T) {
+	pa := &path{
+		conf: &conf.Path{
+			H264SPS: []byte{0x67, 1},
+			H264PPS: []byte{0x68, 1},
+			H265VPS: []byte{0x40, 1, 1},
+			H265SPS: []byte{0x42, 1, 1},
+			H265PPS: []byte{0x44, 1, 1},
+		},
+	}
+
+	t.Run("h264 missing", func(t *testing.T) {
+		forma := &format.H264{PayloadTyp: 96, PacketizationMode: 1}
+		pa.seedParameters(&description.Session{Medias: []*description.Media{{
+			Type: description.MediaTypeVideo, Formats: []format.Format{forma},
+		}}})
+
+		sps, pps := forma.SafeParams()
+		require.Equal(t, []byte{0x67, 1}, sps)
+		require.Equal(t, []byte{0x68, 1}, pps)
+	})
+
+	t.Run("h264 partially announced", func(t *testing.T) {
+		forma := &format.H264{PayloadTyp: 96, PacketizationMode: 1, SPS: []byte{0x67, 2}}
+		pa.seedParameters(&description.Session{Medias: []*description.Media{{
+			Type: description.MediaTypeVideo, Formats: []format.Format{forma},
+		}}})
+
+		sps, pps := forma.SafeParams()
+		require.Equal(t, []byte{0x67, 2}, sps)
+		require.Equal(t, []byte{0x68, 1}, pps)
+	})
+
+	t.Run("h264 announced", func(t *testing.T) {
+		forma := &format.H264{
+			PayloadTyp:        96,
+			PacketizationMode: 1,
+			SPS:               []byte{0x67, 2},
+			PPS:               []byte{0x68, 2},
+		}
+		pa.seedParameters(&description.Session{Medias: []*description.Media{{
+			Type: description.MediaTypeVideo, Formats: []format.Format{forma},
+		}}})
+
+		sps, pps := forma.SafeParams()
+		require.Equal(t, []byte{0x67, 2}, sps)
+		require.Equal(t, []byte{0x68, 2}, pps)
+	})
+
+	t.Run("h265 missing", func(t *testing.T) {
+		forma := &format.H265{PayloadTyp: 96}
+		pa.seedParameters(&description.Session{Medias: []*description.Media{{
+			Type: description.MediaTypeVideo, Formats: []format.Format{forma},
+		}}})
+
+		vps, sps, pps := forma.SafeParams()
+		require.Equal(t, []byte{0x40, 1, 1}, vps)
+		require.Equal(t, []byte{0x42, 1, 1}, sps)
+		require.Equal(t, []byte{0x44, 1, 1}, pps)
+	})
+
+	t.Run("h265 partially announced", func(t *testing.T) {
+		forma := &format.H265{PayloadTyp: 96, VPS: []byte{0x40, 1, 2}, PPS: []byte{0x44, 1, 2}}
+		pa.seedParameters(&description.Session{Medias: []*description.Media{{
+			Type: description.MediaTypeVideo, Formats: []format.Format{forma},
+		}}})
+
+		vps, sps, pps := forma.SafeParams()
+		require.Equal(t, []byte{0x40, 1, 2}, vps)
+		require.Equal(t, []byte{0x42, 1, 1}, sps)
+		require.Equal(t, []byte{0x44, 1, 2}, pps)
+	})
+
+	t.Run("no configured parameters", func(t *testing.T) {
+		pa := &path{conf: &conf.Path{}}
+
+		forma := &format.H264{PayloadTyp: 96, PacketizationMode: 1, SPS: []byte{0x67, 2}}
+		pa.seedParameters(&description.Session{Medias: []*description.Media{{
+			Type: description.MediaTypeVideo, Formats: []format.Format{forma},
+		}}})
+
+		sps, pps := forma.SafeParams()
+		require.Equal(t, []byte{0x67, 2}, sps)
+		require.Nil(t, pps)
+	})
+}
+
+type nilLogger struct{}
+
+func (nilLogger) Log(logger.Level, string, ...interface{}) {}
+
+type nilPathParent struct {
+	nilLogger
+}
+
+func (nilPathParent) pathReady(*path) {}
+
+func (nilPathParent) closePath(*path) {}
+
+func TestPathAnnouncePacketizationMode1(t *testing.T) {
+	for _, ca := range []struct {
+		name     string
+		enabled  bool
+		mode     int
+		expected int
+	}{
+		{"disabled", false, 0, 0},
+		{"mode 0", true, 0, 1},
+		{"mode 1", true, 1, 1},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			pa := &path{
+				conf:   &conf.Path{H264PacketizationMode1: ca.enabled},
+				parent: nilPathParent{},
+			}
+
+			forma := &format.H264{PayloadTyp: 96, PacketizationMode: ca.mode}
+			pa.announcePacketizationMode1(&description.Session{Medias: []*description.Media{{
+				Type: description.MediaTypeVideo, Formats: []format.Format{forma},
+			}}})
+
+			require.Equal(t, ca.expected, forma.PacketizationMode)
+		})
+	}
+}
+
+type testPublisher struct {
+	pa *path
+
+	// whether the stream was still open when the publisher was asked to close
+	streamOpenOnClose bool
+	closed            chan struct{}
+}
+
+func (*testPublisher) Log(logger.Level, string, ...interface{}) {}
+
+func (*testPublisher) APISourceDescribe() defs.APIPathSourceOrReader {
+	return defs.APIPathSourceOrReader{Type: "test"}
+}
+
+// Close is called by the path, in its goroutine.
+func (p *testPublisher) Close() {
+	p.streamOpenOnClose = p.pa.stream != nil
+	close(p.closed)
+}
+
+func TestPathReadyTimeout(t *testing.T) {
+	pa := &path{
+		parentCtx:         context.Background(),
+		writeQueueSize:    512,
+		udpMaxPayloadSize: 1472,
+		conf: &conf.Path{
+			WaitForKeyframe:        true,
+			WaitForKeyframeTimeout: conf.Duration(50 * time.Millisecond),
+		},
+		name:   "mypath",
+		wg:     &sync.WaitGroup{},
+		parent: nilPathParent{},
+	}
+
+	pub := &testPublisher{pa: pa, closed: make(chan struct{})}
+	pa.source = pub
+
+	pa.initialize()
+	defer pa.wait()
+	defer pa.close()
+
+	forma := &format.H264{PayloadTyp: 96, PacketizationMode: 1}
+	medi := &description.Media{Type: description.MediaTypeVideo, Formats: []format.Format{forma}}
+
+	strm, err := pa.StartPublisher(defs.PathStartPublisherReq{
+		Author: pub,
+		Desc:   &description.Session{Medias: []*description.Media{medi}},
+	})
+	require.NoError(t, err)
+
+	// the publisher writes frames without keyframes, until it is closed,
+	// then it removes itself from the path, like RTSP sessions do.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; ; i++ {
+			select {
+			case <-pub.closed:
+				pa.RemovePublisher(defs.PathRemovePublisherReq{Author: pub})
+				return
+			default:
+			}
+
+			strm.WriteRTPPacket(medi, forma, &rtp.Packet{
+				Header: rtp.Header{
+					Version:        2,
+					Marker:         true,
+					PayloadType:    96,
+					SequenceNumber: uint16(i),
+					Timestamp:      uint32(i * 3000),
+				},
+				Payload: []byte{0x41, 0x9a, 0x24, 0x6c},
+			}, time.Now(), int64(i*3000))
+
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("publisher was not closed")
+	}
+
+	// the stream is closed after the publisher has stopped writing to it
+	require.True(t, pub.streamOpenOnClose)
+
+	_, err = pa.StartPublisher(defs.PathStartPublisherReq{
+		Author: pub,
+		Desc:   &description.Session{Medias: []*description.Media{medi}},
+	})
+	require.EqualError(t, err, "publisher is not assigned to this path anymore")
+}
@@ -1,7 +1,141 @@
 package defs
 
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// ErrPathNotFound is returned when a path does not exist.
+var ErrPathNotFound = errors.New("path not found")
+
+// ErrPathNoStream is returned when a path has no stream.
+var ErrPathNoStream = errors.New("path has no stream")
+
+// ErrPathNoEventRecording is returned when a path is not configured for event recording.
+var ErrPathNoEventRecording = errors.New("path is not configured for event recording")
+
+// APIError is a generic error.
+type APIError struct {
+	Error string `json:"error"`
+}
+
 // APIPathSourceOrReader is a source or a reader.
 type APIPathSourceOrReader struct {
 	Type string `json:"type"`
 	ID   string `json:"id"`
 }
+
+// APISEIUserData is the latest SEI user data payload with a given UUID.
+type APISEIUserData struct {
+	UUID    string    `json:"uuid"`
+	Payload []byte    `json:"payload"`
+	NTP     time.Time `json:"ntp"`
+}
+
+// APISEITimecode is the latest timecode of a stream.
+type APISEITimecode struct {
+	Value string    `json:"value"`
+	NTP   time.Time `json:"ntp"`
+}
+
+// APISEIUserDataList is a list of SEI user data payloads.
+type APISEIUserDataList struct {
+	Items    []APISEIUserData `json:"items"`
+	Timecode *APISEITimecode  `json:"timecode"`
+}
+
+// APISEIUserDataInject is a SEI user data payload to insert into a stream.
+// The payload is either raw bytes (base64-encoded in JSON) or a JSON value.
+type APISEIUserDataInject struct {
+	UUID    string          `json:"uuid"`
+	Payload []byte          `json:"payload"`
+	JSON    json.RawMessage `json:"json"`
+}
+
+// APIKLVTelemetry contains telemetry decoded from a MISB ST 0601 local set.
+type APIKLVTelemetry struct {
+	Timestamp *time.Time `json:"timestamp"`
+	Latitude  *float64   `json:"latitude"`
+	Longitude *float64   `json:"longitude"`
+	Altitude  *float64   `json:"altitude"`
+}
+
+// APIPathTrackInfo contains properties of a track that are read from the bitstream.
+type APIPathTrackInfo struct {
+	Width        int     `json:"width"`
+	Height       int     `json:"height"`
+	Profile      string  `json:"profile"`
+	Level        string  `json:"level"`
+	ChromaFormat string  `json:"chromaFormat"`
+	FPS          float64 `json:"fps"`
+}
+
+// APIPathTrack is a track of the stream of a path.
+type APIPathTrack struct {
+	Type              string            `json:"type"`
+	Codec             string            `json:"codec"`
+	Info              *APIPathTrackInfo `json:"info"`
+	PacketsLost       uint64            `json:"packetsLost"`
+	PacketsDuplicated uint64            `json:"packetsDuplicated"`
+	PacketsLate       uint64            `json:"packetsLate"`
+	ProcessingErrors  uint64            `json:"processingErrors"`
+}
+
+// APIPathTrackList is a list of tracks.
+type APIPathTrackList struct {
+	Items []APIPathTrack `json:"items"`
+}
+
+// APIPathRecordingSchedule is the state of the recording schedule of a path.
+type APIPathRecordingSchedule struct {
+	Schedule   string     `json:"schedule"`
+	Active     bool       `json:"active"`
+	NextChange *time.Time `json:"nextChange"`
+}
+
+// APIPathRecording is the recording state of a path.
+type APIPathRecording struct {
+	Enabled   bool                      `json:"enabled"`
+	Mode      string                    `json:"mode"`
+	Recording bool                      `json:"recording"`
+	Event     bool                      `json:"event"`
+	PostRoll  bool                      `json:"postRoll"`
+	Schedule  *APIPathRecordingSchedule `json:"schedule"`
+}
+
+// APIRecordingSegment is a recording segment.
+type APIRecordingSegment struct {
+	Start  time.Time `json:"start"`
+	Size   int64     `json:"size"`
+	Locked bool      `json:"locked"`
+}
+
+// APIRecordingSegmentList is a list of recording segments.
+type APIRecordingSegmentList struct {
+	Items []APIRecordingSegment `json:"items"`
+}
+
+// APIRecordingRange is a time range.
+type APIRecordingRange struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// APIRecordingDay is the recording coverage of a day.
+type APIRecordingDay struct {
+	Date string `json:"date"`
+	// recorded time, in seconds.
+	Recorded float64 `json:"recorded"`
+	// recorded percentage of the part of the day inside the timeline.
+	Coverage float64 `json:"coverage"`
+}
+
+// APIRecordingTimeline is the recording timeline of a path.
+type APIRecordingTimeline struct {
+	Start  time.Time           `json:"start"`
+	End    time.Time           `json:"end"`
+	Ranges []APIRecordingRange `json:"ranges"`
+	Gaps   []APIRecordingRange `json:"gaps"`
+	Days   []APIRecordingDay   `json:"days"`
+}
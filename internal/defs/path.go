@@ -1,13 +1,16 @@
 package defs
 
 import (
+	"XMedia/internal/conf"
 	"XMedia/internal/stream"
 
 	"github.com/bluenviron/gortsplib/v4/pkg/description"
 )
 
 type Path interface {
+	SafeConf() *conf.Path
 	StartPublisher(req PathStartPublisherReq) (*stream.Stream, error)
+	RemovePublisher(req PathRemovePublisherReq)
 }
 
 // PathAddPublisherRes contains the response of AddPublisher().
@@ -36,3 +39,9 @@ type PathStartPublisherReq struct {
 	GenerateRTPPackets bool
 	Res                chan PathStartPublisherRes
 }
+
+// PathRemovePublisherReq contains arguments of RemovePublisher().
+type PathRemovePublisherReq struct {
+	Author Publisher
+	Res    chan struct{}
+}
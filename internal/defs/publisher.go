@@ -1,7 +1,17 @@
 package defs
 
+import (
+	"github.com/bluenviron/gortsplib/v4/pkg/description"
+)
+
 // Publisher is an entity that can publish a stream.
 type Publisher interface {
 	Source
 	Close()
 }
+
+// KeyframeRequester is implemented by publishers that are able to
+// forward keyframe requests to the device that is producing the stream.
+type KeyframeRequester interface {
+	RequestKeyframe(medi *description.Media)
+}
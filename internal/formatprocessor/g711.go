@@ -0,0 +1,127 @@
+package formatprocessor
+
+import (
+	"XMedia/internal/logger"
+	"XMedia/internal/unit"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/bluenviron/gortsplib/v4/pkg/format/rtplpcm"
+	"github.com/pion/rtp"
+)
+
+type g711 struct {
+	UDPMaxPayloadSize  int
+	Format             *format.G711
+	GenerateRTPPackets bool
+	Parent             logger.Writer
+
+	encoder     *rtplpcm.Encoder
+	decoder     *rtplpcm.Decoder
+	randomStart uint32
+}
+
+func (t *g711) initialize() error {
+	if t.GenerateRTPPackets {
+		err := t.createEncoder(nil, nil)
+		if err != nil {
+			return err
+		}
+
+		t.randomStart, err = randUint32()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (t *g711) createEncoder(
+	ssrc *uint32,
+	initialSequenceNumber *uint16,
+) error {
+	t.encoder = &rtplpcm.Encoder{
+		PayloadMaxSize:        t.UDPMaxPayloadSize - 12,
+		PayloadType:           t.Format.PayloadType(),
+		BitDepth:              8,
+		ChannelCount:          t.Format.ChannelCount,
+		SSRC:                  ssrc,
+		InitialSequenceNumber: initialSequenceNumber,
+	}
+	return t.encoder.Init()
+}
+
+func (t *g711) ProcessUnit(unit.Unit) error {
+	return nil
+}
+
+// process a RTP packet and convert it into a unit.
+func (t *g711) ProcessRTPPacket(
+	pkt *rtp.Packet,
+	ntp time.Time,
+	pts int64,
+	hasNonRTSPReaders bool,
+) (unit.Unit, error) {
+	u := &unit.G711{
+		Base: unit.Base{
+			RTPPackets: []*rtp.Packet{pkt},
+			NTP:        ntp,
+			PTS:        pts,
+		},
+	}
+
+	if t.encoder == nil {
+		// remove padding
+		pkt.Padding = false
+		pkt.PaddingSize = 0
+
+		// RTP packets exceed maximum size: start re-encoding them
+		if pkt.MarshalSize() > t.UDPMaxPayloadSize {
+			t.Parent.Log(logger.Info, "RTP packets are too big, remuxing them into smaller ones")
+
+			v1 := pkt.SSRC
+			v2 := pkt.SequenceNumber
+			err := t.createEncoder(&v1, &v2)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// decode from RTP
+	if hasNonRTSPReaders || t.decoder != nil || t.encoder != nil {
+		if t.decoder == nil {
+			var err error
+			t.decoder, err = t.Format.CreateDecoder()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		samples, err := t.decoder.Decode(pkt)
+		if err != nil {
+			return nil, err
+		}
+
+		u.Samples = samples
+	}
+
+	// route packet as is
+	if t.encoder == nil {
+		return u, nil
+	}
+
+	// encode into RTP
+	pkts, err := t.encoder.Encode(u.Samples)
+	if err != nil {
+		return nil, err
+	}
+	u.RTPPackets = pkts
+
+	for _, newPKT := range u.RTPPackets {
+		newPKT.Timestamp += pkt.Timestamp
+	}
+
+	return u, nil
+}
@@ -0,0 +1,66 @@
+package formatprocessor
+
+import (
+	"XMedia/internal/unit"
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestG711ProcessRTPPacket(t *testing.T) {
+	for _, ca := range []struct {
+		name    string
+		payload []byte
+		reenc   bool
+	}{
+		{
+			"packet",
+			bytes.Repeat([]byte{1}, 160),
+			false,
+		},
+		{
+			"oversized packet",
+			bytes.Repeat([]byte{1}, 3000),
+			true,
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			p := &g711{
+				UDPMaxPayloadSize: 1472,
+				Format:            &format.G711{PayloadTyp: 0, MULaw: true, SampleRate: 8000, ChannelCount: 1},
+				Parent:            nilLogger{},
+			}
+			require.NoError(t, p.initialize())
+
+			pkt := &rtp.Packet{
+				Header:  rtp.Header{Version: 2, Marker: true, PayloadType: 0, SequenceNumber: 100, Timestamp: 8000},
+				Payload: ca.payload,
+			}
+
+			u, err := p.ProcessRTPPacket(pkt, time.Now(), 0, true)
+			require.NoError(t, err)
+			require.Equal(t, ca.payload, u.(*unit.G711).Samples)
+
+			if !ca.reenc {
+				require.Equal(t, []*rtp.Packet{pkt}, u.GetRTPPackets())
+				return
+			}
+
+			// samples are split into packets that don't exceed the maximum size
+			pkts := u.GetRTPPackets()
+			require.Greater(t, len(pkts), 1)
+
+			var samples []byte
+			for _, pkt := range pkts {
+				require.LessOrEqual(t, pkt.MarshalSize(), 1472)
+				require.Equal(t, uint32(8000+len(samples)), pkt.Timestamp)
+				samples = append(samples, pkt.Payload...)
+			}
+			require.Equal(t, ca.payload, samples)
+		})
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"XMedia/internal/unit"
 	"bytes"
 	"errors"
+	"fmt"
+	"sync"
 	"time"
 
 	mch264 "github.com/bluenviron/mediacommon/v2/pkg/codecs/h264"
@@ -72,18 +74,104 @@ func rtpH264ExtractParams(payload []byte) ([]byte, []byte) {
 	}
 }
 
+func h264ProfileName(profileIdc uint8) string {
+	switch profileIdc {
+	case 66:
+		return "Baseline"
+	case 77:
+		return "Main"
+	case 88:
+		return "Extended"
+	case 100:
+		return "High"
+	case 110:
+		return "High 10"
+	case 122:
+		return "High 4:2:2"
+	case 244:
+		return "High 4:4:4"
+	default:
+		return fmt.Sprintf("%d", profileIdc)
+	}
+}
+
+func h264ChromaFormatName(chromaFormatIdc uint32) string {
+	switch chromaFormatIdc {
+	case 0:
+		return "4:0:0"
+	case 2:
+		return "4:2:2"
+	case 3:
+		return "4:4:4"
+	default:
+		return "4:2:0"
+	}
+}
+
+// read track properties from a SPS and its VUI.
+func h264TrackInfo(buf []byte) (TrackInfo, error) {
+	var sps mch264.SPS
+	err := sps.Unmarshal(buf)
+	if err != nil {
+		return TrackInfo{}, err
+	}
+
+	chromaFormatIdc := sps.ChromaFormatIdc
+	switch sps.ProfileIdc {
+	case 100, 110, 122, 244, 44, 83, 86, 118, 128, 138, 139, 134, 135:
+	default:
+		// chroma_format_idc is not present and is inferred to be 1
+		chromaFormatIdc = 1
+	}
+
+	return TrackInfo{
+		Width:        sps.Width(),
+		Height:       sps.Height(),
+		Profile:      h264ProfileName(sps.ProfileIdc),
+		Level:        fmt.Sprintf("%d.%d", sps.LevelIdc/10, sps.LevelIdc%10),
+		ChromaFormat: h264ChromaFormatName(chromaFormatIdc),
+		FPS:          sps.FPS(),
+	}, nil
+}
+
+// access unit delimiter with primary_pic_type = 7 (any slice type).
+var h264AUD = []byte{byte(mch264.NALUTypeAccessUnitDelimiter), 0xF0}
+
 type h264 struct {
 	UDPMaxPayloadSize  int
 	Format             *format.H264
 	GenerateRTPPackets bool
+	Options            H264Options
 	Parent             logger.Writer
 
-	encoder     *rtph264.Encoder
-	decoder     *rtph264.Decoder
-	randomStart uint32
+	encoder      *rtph264.Encoder
+	decoder      *rtph264.Decoder
+	randomStart  uint32
+	dtsExtractor *mch264.DTSExtractor
+
+	trackInfoMutex sync.RWMutex
+	trackInfo      *TrackInfo
+
+	captionsFound bool
+
+	userDataMutex  sync.RWMutex
+	latestUserData map[[16]byte]UserDataSample
+
+	picTimingSPSRaw      []byte
+	picTimingSPSParsed   *mch264.SPS
+	picTimingErrorLogged bool
+	lastTimecode         *unit.Timecode
+	timecodeMutex        sync.RWMutex
+	latestTimecode       *TimecodeSample
+
+	injectedSEI seiQueue
 }
 
 func (t *h264) initialize() error {
+	if t.Format.SPS != nil {
+		t.updateTrackInfo(t.Format.SPS)
+	}
+
 	if t.GenerateRTPPackets {
 		err := t.createEncoder(nil, nil)
 		if err != nil {
@@ -124,10 +212,56 @@ func (t *h264) updateTrackParametersFromRTPPacket(payload []byte) {
 		if pps == nil {
 			pps = t.Format.PPS
 		}
+		if !bytes.Equal(sps, t.Format.SPS) {
+			t.updateTrackInfo(sps)
+		}
 		t.Format.SafeSetParams(sps, pps)
 	}
 }
 
+func (t *h264) updateTrackInfo(sps []byte) {
+	info, err := h264TrackInfo(sps)
+	if err != nil {
+		t.Parent.Log(logger.Warn, "unable to parse SPS: %v", err)
+		return
+	}
+
+	t.trackInfoMutex.Lock()
+	prev := t.trackInfo
+	t.trackInfo = &info
+	t.trackInfoMutex.Unlock()
+
+	if prev != nil && *prev == info {
+		return
+	}
+
+	desc := fmt.Sprintf("%dx%d, profile %s, level %s, chroma %s, %.2f fps",
+		info.Width, info.Height, info.Profile, info.Level, info.ChromaFormat, info.FPS)
+
+	if prev == nil {
+		t.Parent.Log(logger.Info, "H264 track: %s", desc)
+	} else {
+		t.Parent.Log(logger.Warn, "H264 track parameters changed: %s", desc)
+	}
+}
+
+// TrackInfo implements TrackInfoProvider.
+func (t *h264) TrackInfo() (TrackInfo, bool) {
+	t.trackInfoMutex.RLock()
+	defer t.trackInfoMutex.RUnlock()
+
+	if t.trackInfo == nil {
+		return TrackInfo{}, false
+	}
+	return *t.trackInfo, true
+}
+
+// HasParameters implements ParametersChecker.
+func (t *h264) HasParameters() bool {
+	sps, pps := t.Format.SafeParams()
+	return sps != nil && pps != nil
+}
+
 func (t *h264) ProcessUnit(unit.Unit) error {
 	return nil
 }
@@ -155,8 +289,17 @@ func (t *h264) ProcessRTPPacket(
 		pkt.Padding = false
 		pkt.PaddingSize = 0
 
-		// RTP packets exceed maximum size: start re-encoding them
-		if pkt.MarshalSize() > t.UDPMaxPayloadSize {
+		// bitstream must be normalized or SEI must be injected: re-encode all packets
+		if t.Options.rewritesBitstream() || t.injectedSEI.hasPending() {
+			t.Parent.Log(logger.Info, "rewriting H264 bitstream, remuxing RTP packets")
+
+			v1 := pkt.SSRC
+			v2 := pkt.SequenceNumber
+			err := t.createEncoder(&v1, &v2)
+			if err != nil {
+				return nil, err
+			}
+		} else if pkt.MarshalSize() > t.UDPMaxPayloadSize { // RTP packets exceed maximum size: start re-encoding them
 			t.Parent.Log(logger.Info, "RTP packets are too big, remuxing them into smaller ones")
 
 			v1 := pkt.SSRC
@@ -169,7 +312,7 @@ func (t *h264) ProcessRTPPacket(
 	}
 
 	// decode from RTP  这里判断结果是 要生成 u.AU ,u.AU是给非RTSP读者使用的，或者作为二次RTP编码的输入
-	if hasNonRTSPReaders || t.decoder != nil || t.encoder != nil {
+	if hasNonRTSPReaders || t.Options.extractsSEI() || t.decoder != nil || t.encoder != nil {
 		if t.decoder == nil {
 			var err error
 			t.decoder, err = t.Format.CreateDecoder()
@@ -193,8 +336,18 @@ func (t *h264) ProcessRTPPacket(
 			}
 			return nil, err
 		}
+		// SEI messages must be extracted before SEI NALUs are stripped
+		if t.Options.extractsSEI() {
+			t.extractSEI(u, au)
+		}
+
 		//修改必要信息 如I帧前面加SPS/PPS
 		u.AU = t.remuxAccessUnit(au)
+		// parameters are needed by non-RTSP readers and by the DTS extractor.
+		// With RepeatParameters, RTP packets are re-encoded from the AU and RTSP readers receive them too.
+		u.AU = t.insertParameters(u.AU)
+		u.AU = t.injectSEI(u.AU)
+		t.fillDTS(u)
 	}
 
 	// route packet as is 上传需要的数据都满足 RTP是原样的数据，NALU已经解码更新
@@ -218,54 +371,243 @@ func (t *h264) ProcessRTPPacket(
 	return u, nil
 }
 
-func (t *h264) remuxAccessUnit(au [][]byte) [][]byte {
-	isKeyFrame := false
-	n := 0
+func (t *h264) extractSEI(u *unit.H264, au [][]byte) {
+	msgs := h264SEIMessages(au)
+	if len(msgs) == 0 {
+		return
+	}
 
-	for _, nalu := range au {
-		typ := mch264.NALUType(nalu[0] & 0x1F)
+	if t.Options.ExtractCaptions {
+		t.extractCaptions(u, msgs)
+	}
+	if t.Options.ExtractUserData {
+		t.extractUserData(u, msgs)
+	}
+	if t.Options.ExtractTimecodes {
+		t.extractTimecode(u, msgs)
+	}
+}
 
-		switch typ {
-		case mch264.NALUTypeSPS, mch264.NALUTypePPS: // parameters: remove
-			continue
+func (t *h264) extractCaptions(u *unit.H264, msgs []seiMessage) {
+	u.Captions = seiExtractCaptions(msgs)
 
-		case mch264.NALUTypeAccessUnitDelimiter: // AUD: remove
+	if len(u.Captions) != 0 && !t.captionsFound {
+		t.captionsFound = true
+		t.Parent.Log(logger.Info, "closed captions (CEA-608/708) found in H264 track")
+	}
+}
+
+func (t *h264) extractUserData(u *unit.H264, msgs []seiMessage) {
+	u.UserData = seiExtractUserData(msgs, t.Options.UserDataUUIDs)
+
+	if len(u.UserData) == 0 {
+		return
+	}
+
+	t.userDataMutex.Lock()
+	defer t.userDataMutex.Unlock()
+
+	if t.latestUserData == nil {
+		t.latestUserData = make(map[[16]byte]UserDataSample)
+	}
+
+	for _, ud := range u.UserData {
+		t.latestUserData[ud.UUID] = UserDataSample{
+			UUID:    ud.UUID,
+			Payload: ud.Payload,
+			NTP:     u.NTP,
+		}
+	}
+}
+
+// picTimingSPS returns the SPS that describes the syntax of pic_timing SEI messages.
+func (t *h264) picTimingSPS() *mch264.SPS {
+	if t.Format.SPS == nil {
+		return nil
+	}
+
+	if t.picTimingSPSParsed == nil || !bytes.Equal(t.picTimingSPSRaw, t.Format.SPS) {
+		var sps mch264.SPS
+		err := sps.Unmarshal(t.Format.SPS)
+		if err != nil {
+			return nil
+		}
+
+		t.picTimingSPSRaw = t.Format.SPS
+		t.picTimingSPSParsed = &sps
+	}
+
+	return t.picTimingSPSParsed
+}
+
+func (t *h264) extractTimecode(u *unit.H264, msgs []seiMessage) {
+	for _, msg := range msgs {
+		if msg.payloadType != seiPayloadTypePicTiming {
 			continue
+		}
 
-		case mch264.NALUTypeIDR: // key frame
-			if !isKeyFrame {
-				isKeyFrame = true
+		sps := t.picTimingSPS()
+		if sps == nil {
+			return
+		}
 
-				// prepend parameters
-				if t.Format.SPS != nil && t.Format.PPS != nil {
-					n += 2
-				}
+		tc, err := h264ParsePicTiming(msg.payload, sps, t.lastTimecode)
+		if err != nil {
+			if !t.picTimingErrorLogged {
+				t.picTimingErrorLogged = true
+				t.Parent.Log(logger.Warn, "unable to parse pic_timing SEI: %v", err)
 			}
+			return
+		}
+
+		if tc == nil {
+			return
+		}
+
+		u.Timecode = tc
+		t.lastTimecode = tc
+
+		t.timecodeMutex.Lock()
+		t.latestTimecode = &TimecodeSample{
+			Timecode: *tc,
+			NTP:      u.NTP,
+		}
+		t.timecodeMutex.Unlock()
+
+		return
+	}
+}
+
+// LatestTimecode implements TimecodeProvider.
+func (t *h264) LatestTimecode() (TimecodeSample, bool) {
+	t.timecodeMutex.RLock()
+	defer t.timecodeMutex.RUnlock()
+
+	if t.latestTimecode == nil {
+		return TimecodeSample{}, false
+	}
+	return *t.latestTimecode, true
+}
+
+// InjectSEIUserData implements SEIInjector.
+func (t *h264) InjectSEIUserData(uuid [16]byte, payload []byte) {
+	t.injectedSEI.push(seiMarshalUserData(uuid, payload))
+}
+
+// insert queued SEI messages before the first slice of an access unit.
+func (t *h264) injectSEI(au [][]byte) [][]byte {
+	if len(au) == 0 || !t.injectedSEI.hasPending() {
+		return au
+	}
+
+	pos := 0
+	for pos < len(au) {
+		switch mch264.NALUType(au[pos][0] & 0x1F) {
+		case mch264.NALUTypeAccessUnitDelimiter, mch264.NALUTypeSPS, mch264.NALUTypePPS:
+			pos++
+			continue
+		}
+		break
+	}
+
+	ret := make([][]byte, 0, len(au)+1)
+	ret = append(ret, au[:pos]...)
+	ret = append(ret, h264MarshalSEI(t.injectedSEI.pop()))
+	ret = append(ret, au[pos:]...)
+	return ret
+}
+
+// LatestUserData implements UserDataProvider.
+func (t *h264) LatestUserData() []UserDataSample {
+	t.userDataMutex.RLock()
+	defer t.userDataMutex.RUnlock()
+
+	ret := make([]UserDataSample, 0, len(t.latestUserData))
+	for _, s := range t.latestUserData {
+		ret = append(ret, s)
+	}
+	return ret
+}
+
+// compute the DTS of an access unit, taking into account reordered frames (B-frames).
+func (t *h264) fillDTS(u *unit.H264) {
+	u.DTS = u.PTS
+
+	if u.AU == nil {
+		return
+	}
+
+	u.RandomAccess = mch264.IsRandomAccess(u.AU)
+
+	// the extractor needs a SPS, that is prepended to IDRs by insertParameters()
+	if t.dtsExtractor == nil {
+		if !u.RandomAccess {
+			return
+		}
+
+		t.dtsExtractor = &mch264.DTSExtractor{}
+		t.dtsExtractor.Initialize()
+	}
+
+	dts, err := t.dtsExtractor.Extract(u.AU, u.PTS)
+	if err != nil {
+		t.Parent.Log(logger.Warn, "unable to extract DTS: %v", err)
+		t.dtsExtractor = nil
+		return
+	}
+
+	u.DTS = dts
+}
+
+// whether a NALU is kept by remuxAccessUnit().
+func (t *h264) keepNALU(typ mch264.NALUType) bool {
+	switch typ {
+	case mch264.NALUTypeSPS, mch264.NALUTypePPS: // parameters: remove
+		return false
+
+	case mch264.NALUTypeAccessUnitDelimiter: // AUD: remove
+		return false
+
+	case mch264.NALUTypeSEI:
+		return !t.Options.StripSEI
+
+	case mch264.NALUTypeFillerData:
+		return !t.Options.DropFiller
+	}
+
+	return true
+}
+
+// remove parameters and the AUD, filter NALUs and insert the AUD again if requested.
+func (t *h264) remuxAccessUnit(au [][]byte) [][]byte {
+	n := 0
+
+	for _, nalu := range au {
+		if t.keepNALU(mch264.NALUType(nalu[0] & 0x1F)) {
+			n++
 		}
-		n++
 	}
 
 	if n == 0 {
 		return nil
 	}
 
+	if t.Options.InsertAUD {
+		n++
+	}
+
 	filteredNALUs := make([][]byte, n)
 	i := 0
 
-	if isKeyFrame && t.Format.SPS != nil && t.Format.PPS != nil {
-		filteredNALUs[0] = t.Format.SPS
-		filteredNALUs[1] = t.Format.PPS
-		i = 2
+	if t.Options.InsertAUD {
+		filteredNALUs[0] = h264AUD
+		i = 1
 	}
 
 	for _, nalu := range au {
 		typ := mch264.NALUType(nalu[0] & 0x1F)
 
-		switch typ {
-		case mch264.NALUTypeSPS, mch264.NALUTypePPS:
-			continue
-
-		case mch264.NALUTypeAccessUnitDelimiter:
+		if !t.keepNALU(typ) {
 			continue
 		}
 
@@ -275,3 +617,34 @@ func (t *h264) remuxAccessUnit(au [][]byte) [][]byte {
 
 	return filteredNALUs
 }
+
+// insertParameters inserts the current SPS and PPS at the beginning of access units
+// that contain an IDR, after the AUD, if any.
+func (t *h264) insertParameters(au [][]byte) [][]byte {
+	if t.Format.SPS == nil || t.Format.PPS == nil {
+		return au
+	}
+
+	idr := false
+	for _, nalu := range au {
+		if mch264.NALUType(nalu[0]&0x1F) == mch264.NALUTypeIDR {
+			idr = true
+			break
+		}
+	}
+	if !idr {
+		return au
+	}
+
+	pos := 0
+	if mch264.NALUType(au[0][0]&0x1F) == mch264.NALUTypeAccessUnitDelimiter {
+		pos = 1
+	}
+
+	ret := make([][]byte, 0, len(au)+2)
+	ret = append(ret, au[:pos]...)
+	ret = append(ret, t.Format.SPS, t.Format.PPS)
+	ret = append(ret, au[pos:]...)
+
+	return ret
+}
@@ -0,0 +1,415 @@
+package formatprocessor
+
+import (
+	"XMedia/internal/unit"
+	"bytes"
+	"fmt"
+	"sync"
+
+	"github.com/bluenviron/mediacommon/v2/pkg/bits"
+	mch264 "github.com/bluenviron/mediacommon/v2/pkg/codecs/h264"
+)
+
+// SEI payload types.
+const (
+	seiPayloadTypePicTiming            = 1
+	seiPayloadTypeUserDataRegistered   = 4
+	seiPayloadTypeUserDataUnregistered = 5
+)
+
+// counting_type that drops frame numbers (Table D-3).
+const seiCountingTypeDropFrame = 4
+
+// ATSC A/53 identifiers of closed captions inside user_data_registered_itu_t_t35.
+const (
+	t35CountryCodeUSA      = 0xB5
+	t35ProviderCodeATSC    = 0x0031
+	atscUserDataTypeCCData = 0x03
+)
+
+var atscUserIdentifierGA94 = []byte("GA94")
+
+type seiMessage struct {
+	payloadType int
+	payload     []byte
+}
+
+// parse the messages contained into a SEI NALU.
+func h264ParseSEI(nalu []byte) []seiMessage {
+	if len(nalu) < 2 {
+		return nil
+	}
+
+	buf := mch264.EmulationPreventionRemove(nalu[1:])
+	var ret []seiMessage
+
+	// stop at rbsp_trailing_bits
+	for len(buf) > 1 || (len(buf) == 1 && buf[0] != 0x80) {
+		payloadType := 0
+		for len(buf) > 0 && buf[0] == 0xFF {
+			payloadType += 255
+			buf = buf[1:]
+		}
+		if len(buf) == 0 {
+			return ret
+		}
+		payloadType += int(buf[0])
+		buf = buf[1:]
+
+		payloadSize := 0
+		for len(buf) > 0 && buf[0] == 0xFF {
+			payloadSize += 255
+			buf = buf[1:]
+		}
+		if len(buf) == 0 {
+			return ret
+		}
+		payloadSize += int(buf[0])
+		buf = buf[1:]
+
+		if payloadSize > len(buf) {
+			return ret
+		}
+
+		ret = append(ret, seiMessage{
+			payloadType: payloadType,
+			payload:     buf[:payloadSize],
+		})
+		buf = buf[payloadSize:]
+	}
+
+	return ret
+}
+
+// parse the messages of all the SEI NALUs of an access unit.
+func h264SEIMessages(au [][]byte) []seiMessage {
+	var ret []seiMessage
+
+	for _, nalu := range au {
+		if mch264.NALUType(nalu[0]&0x1F) == mch264.NALUTypeSEI {
+			ret = append(ret, h264ParseSEI(nalu)...)
+		}
+	}
+
+	return ret
+}
+
+// extract the cc_data() packets of a user_data_registered_itu_t_t35 payload (ATSC A/53 part 4).
+func seiCaptionPackets(payload []byte) []unit.CaptionPacket {
+	if len(payload) < 10 ||
+		payload[0] != t35CountryCodeUSA ||
+		(uint16(payload[1])<<8|uint16(payload[2])) != t35ProviderCodeATSC ||
+		!bytes.Equal(payload[3:7], atscUserIdentifierGA94) ||
+		payload[7] != atscUserDataTypeCCData {
+		return nil
+	}
+
+	// process_cc_data_flag
+	if (payload[8] & 0x40) == 0 {
+		return nil
+	}
+
+	ccCount := int(payload[8] & 0x1F)
+	buf := payload[10:]
+
+	if len(buf) < ccCount*3 {
+		return nil
+	}
+
+	var ret []unit.CaptionPacket
+
+	for i := 0; i < ccCount; i++ {
+		b := buf[i*3 : i*3+3]
+
+		// cc_valid
+		if (b[0] & 0x04) == 0 {
+			continue
+		}
+
+		ret = append(ret, unit.CaptionPacket{
+			Type: unit.CaptionType(b[0] & 0x03),
+			Data: [2]byte{b[1], b[2]},
+		})
+	}
+
+	return ret
+}
+
+// extract closed captions from the SEI messages of an access unit.
+func seiExtractCaptions(msgs []seiMessage) []unit.CaptionPacket {
+	var ret []unit.CaptionPacket
+
+	for _, msg := range msgs {
+		if msg.payloadType == seiPayloadTypeUserDataRegistered {
+			ret = append(ret, seiCaptionPackets(msg.payload)...)
+		}
+	}
+
+	return ret
+}
+
+// extract user_data_unregistered payloads from the SEI messages of an access unit.
+// If uuids is not empty, only payloads with one of these UUIDs are returned.
+func seiExtractUserData(msgs []seiMessage, uuids [][16]byte) []unit.SEIUserData {
+	var ret []unit.SEIUserData
+
+	for _, msg := range msgs {
+		if msg.payloadType != seiPayloadTypeUserDataUnregistered || len(msg.payload) < 16 {
+			continue
+		}
+
+		var ud unit.SEIUserData
+		copy(ud.UUID[:], msg.payload[:16])
+
+		if !seiUUIDAllowed(ud.UUID, uuids) {
+			continue
+		}
+
+		ud.Payload = msg.payload[16:]
+		ret = append(ret, ud)
+	}
+
+	return ret
+}
+
+// number of clock timestamps of every pic_struct (Table D-1).
+var h264NumClockTS = [...]int{1, 1, 1, 2, 2, 3, 3, 2, 3}
+
+// parse the timecode of a pic_timing SEI message (D.1.3), that is the first clock timestamp.
+// Fields that are not present are inherited from the previous timecode.
+// It returns nil when the message doesn't contain clock timestamps.
+func h264ParsePicTiming(payload []byte, sps *mch264.SPS, prev *unit.Timecode) (*unit.Timecode, error) {
+	vui := sps.VUI
+	if vui == nil {
+		return nil, nil
+	}
+
+	pos := 0
+
+	hrd := vui.NalHRD
+	if hrd == nil {
+		hrd = vui.VclHRD
+	}
+
+	// inferred when there are no HRD parameters
+	timeOffsetLength := 24
+
+	if hrd != nil {
+		// cpb_removal_delay, dpb_output_delay
+		n := int(hrd.CpbRemovalDelayLengthMinus1) + 1 + int(hrd.DpbOutputDelayLengthMinus1) + 1
+		err := bits.HasSpace(payload, pos, n)
+		if err != nil {
+			return nil, err
+		}
+		pos += n
+
+		timeOffsetLength = int(hrd.TimeOffsetLength)
+	}
+
+	if !vui.PicStructPresentFlag {
+		return nil, nil
+	}
+
+	picStruct, err := bits.ReadBits(payload, &pos, 4)
+	if err != nil {
+		return nil, err
+	}
+
+	if int(picStruct) >= len(h264NumClockTS) {
+		return nil, fmt.Errorf("invalid pic_struct: %d", picStruct)
+	}
+
+	var ret *unit.Timecode
+
+	for i := 0; i < h264NumClockTS[picStruct]; i++ {
+		clockTimestampFlag, err := bits.ReadFlag(payload, &pos)
+		if err != nil {
+			return nil, err
+		}
+
+		if !clockTimestampFlag {
+			continue
+		}
+
+		tc, err := h264ParseClockTimestamp(payload, &pos, timeOffsetLength, prev)
+		if err != nil {
+			return nil, err
+		}
+
+		if ret == nil {
+			ret = tc
+		}
+		prev = tc
+	}
+
+	return ret, nil
+}
+
+func h264ParseClockTimestamp(payload []byte, pos *int, timeOffsetLength int, prev *unit.Timecode) (*unit.Timecode, error) {
+	// ct_type, nuit_field_based_flag, counting_type, full_timestamp_flag,
+	// discontinuity_flag, cnt_dropped_flag, n_frames
+	err := bits.HasSpace(payload, *pos, 19)
+	if err != nil {
+		return nil, err
+	}
+
+	var tc unit.Timecode
+	if prev != nil {
+		tc = *prev
+	}
+
+	*pos += 3
+	countingType := bits.ReadBitsUnsafe(payload, pos, 5)
+	fullTimestampFlag := bits.ReadFlagUnsafe(payload, pos)
+	*pos += 2
+	tc.Frames = int(bits.ReadBitsUnsafe(payload, pos, 8))
+	tc.DropFrame = countingType == seiCountingTypeDropFrame
+
+	if fullTimestampFlag {
+		err = bits.HasSpace(payload, *pos, 17)
+		if err != nil {
+			return nil, err
+		}
+
+		tc.Seconds = int(bits.ReadBitsUnsafe(payload, pos, 6))
+		tc.Minutes = int(bits.ReadBitsUnsafe(payload, pos, 6))
+		tc.Hours = int(bits.ReadBitsUnsafe(payload, pos, 5))
+	} else {
+		fields := []struct {
+			v    *int
+			size int
+		}{
+			{&tc.Seconds, 6},
+			{&tc.Minutes, 6},
+			{&tc.Hours, 5},
+		}
+
+		for _, f := range fields {
+			var present bool
+			present, err = bits.ReadFlag(payload, pos)
+			if err != nil {
+				return nil, err
+			}
+
+			if !present {
+				break
+			}
+
+			var v uint64
+			v, err = bits.ReadBits(payload, pos, f.size)
+			if err != nil {
+				return nil, err
+			}
+			*f.v = int(v)
+		}
+	}
+
+	if tc.Seconds > 59 || tc.Minutes > 59 || tc.Hours > 23 {
+		return nil, fmt.Errorf("invalid clock timestamp")
+	}
+
+	// time_offset
+	if timeOffsetLength > 0 {
+		err = bits.HasSpace(payload, *pos, timeOffsetLength)
+		if err != nil {
+			return nil, err
+		}
+		*pos += timeOffsetLength
+	}
+
+	return &tc, nil
+}
+
+func seiUUIDAllowed(id [16]byte, uuids [][16]byte) bool {
+	if len(uuids) == 0 {
+		return true
+	}
+
+	for _, u := range uuids {
+		if u == id {
+			return true
+		}
+	}
+	return false
+}
+
+// insert emulation prevention bytes into a RBSP.
+func seiEmulationPreventionAdd(rbsp []byte) []byte {
+	ret := make([]byte, 0, len(rbsp)+len(rbsp)/64)
+	zeros := 0
+
+	for _, b := range rbsp {
+		if zeros == 2 && b <= 3 {
+			ret = append(ret, 3)
+			zeros = 0
+		}
+
+		ret = append(ret, b)
+
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+	}
+
+	return ret
+}
+
+// encode a user_data_unregistered SEI message.
+func seiMarshalUserData(uuid [16]byte, payload []byte) []byte {
+	size := 16 + len(payload)
+	ret := make([]byte, 0, 2+size/255+size)
+
+	ret = append(ret, seiPayloadTypeUserDataUnregistered)
+	for ; size >= 255; size -= 255 {
+		ret = append(ret, 0xFF)
+	}
+	ret = append(ret, byte(size))
+
+	ret = append(ret, uuid[:]...)
+	ret = append(ret, payload...)
+
+	return ret
+}
+
+// encode SEI messages into the payload of a SEI NALU, without header.
+func seiMarshalPayload(msgs [][]byte) []byte {
+	var rbsp []byte
+	for _, msg := range msgs {
+		rbsp = append(rbsp, msg...)
+	}
+	rbsp = append(rbsp, 0x80) // rbsp_trailing_bits
+
+	return seiEmulationPreventionAdd(rbsp)
+}
+
+func h264MarshalSEI(msgs [][]byte) []byte {
+	return append([]byte{byte(mch264.NALUTypeSEI)}, seiMarshalPayload(msgs)...)
+}
+
+// seiQueue contains SEI messages that must be inserted into the next access unit.
+type seiQueue struct {
+	mutex   sync.Mutex
+	pending [][]byte
+}
+
+func (q *seiQueue) push(msg []byte) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.pending = append(q.pending, msg)
+}
+
+func (q *seiQueue) hasPending() bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return len(q.pending) != 0
+}
+
+func (q *seiQueue) pop() [][]byte {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	ret := q.pending
+	q.pending = nil
+	return ret
+}
@@ -0,0 +1,343 @@
+package formatprocessor
+
+import (
+	"XMedia/internal/unit"
+	"bytes"
+	"testing"
+
+	mch264 "github.com/bluenviron/mediacommon/v2/pkg/codecs/h264"
+	"github.com/stretchr/testify/require"
+)
+
+// bitWriter writes values with an arbitrary number of bits.
+type bitWriter struct {
+	buf []byte
+	n   int
+}
+
+func (w *bitWriter) write(v uint64, size int) *bitWriter {
+	for i := size - 1; i >= 0; i-- {
+		if w.n%8 == 0 {
+			w.buf = append(w.buf, 0)
+		}
+		if (v>>i)&1 != 0 {
+			w.buf[len(w.buf)-1] |= 1 << (7 - w.n%8)
+		}
+		w.n++
+	}
+	return w
+}
+
+// clock timestamp with full_timestamp_flag set.
+func (w *bitWriter) fullClockTimestamp(countingType uint64, tc unit.Timecode) *bitWriter {
+	w.write(1, 1)            // clock_timestamp_flag
+	w.write(0, 2)            // ct_type
+	w.write(0, 1)            // nuit_field_based_flag
+	w.write(countingType, 5) // counting_type
+	w.write(1, 1)            // full_timestamp_flag
+	w.write(0, 1)            // discontinuity_flag
+	w.write(0, 1)            // cnt_dropped_flag
+	w.write(uint64(tc.Frames), 8)
+	w.write(uint64(tc.Seconds), 6)
+	w.write(uint64(tc.Minutes), 6)
+	return w.write(uint64(tc.Hours), 5)
+}
+
+func TestH264ParsePicTiming(t *testing.T) {
+	spsNoHRD := &mch264.SPS{
+		VUI: &mch264.SPS_VUI{
+			PicStructPresentFlag: true,
+		},
+	}
+
+	spsHRD := &mch264.SPS{
+		VUI: &mch264.SPS_VUI{
+			PicStructPresentFlag: true,
+			NalHRD: &mch264.SPS_HRD{
+				CpbRemovalDelayLengthMinus1: 23,
+				DpbOutputDelayLengthMinus1:  23,
+				TimeOffsetLength:            0,
+			},
+		},
+	}
+
+	for _, ca := range []struct {
+		name    string
+		sps     *mch264.SPS
+		prev    *unit.Timecode
+		payload []byte
+		tc      *unit.Timecode
+		err     bool
+	}{
+		{
+			"full timestamp",
+			spsNoHRD,
+			nil,
+			(&bitWriter{}).
+				write(0, 4). // pic_struct
+				fullClockTimestamp(0, unit.Timecode{Hours: 1, Minutes: 2, Seconds: 3, Frames: 4}).
+				write(0, 24). // time_offset
+				buf,
+			&unit.Timecode{Hours: 1, Minutes: 2, Seconds: 3, Frames: 4},
+			false,
+		},
+		{
+			"hrd delays and drop frame",
+			spsHRD,
+			nil,
+			(&bitWriter{}).
+				write(0x123456, 24). // cpb_removal_delay
+				write(0x654321, 24). // dpb_output_delay
+				write(0, 4).
+				fullClockTimestamp(4, unit.Timecode{Hours: 23, Minutes: 59, Seconds: 59, Frames: 29}).
+				buf,
+			&unit.Timecode{Hours: 23, Minutes: 59, Seconds: 59, Frames: 29, DropFrame: true},
+			false,
+		},
+		{
+			"partial timestamp",
+			spsHRD,
+			&unit.Timecode{Hours: 10, Minutes: 20, Seconds: 30, Frames: 5},
+			(&bitWriter{}).
+				write(0, 48).
+				write(0, 4).
+				write(1, 1). // clock_timestamp_flag
+				write(0, 2).
+				write(0, 1).
+				write(0, 5).
+				write(0, 1). // full_timestamp_flag
+				write(0, 1).
+				write(0, 1).
+				write(6, 8). // n_frames
+				write(1, 1). // seconds_flag
+				write(31, 6).
+				write(0, 1). // minutes_flag
+				buf,
+			&unit.Timecode{Hours: 10, Minutes: 20, Seconds: 31, Frames: 6},
+			false,
+		},
+		{
+			"first clock timestamp missing",
+			spsHRD,
+			nil,
+			(&bitWriter{}).
+				write(0, 48).
+				write(3, 4). // pic_struct: top field, bottom field
+				write(0, 1). // clock_timestamp_flag
+				fullClockTimestamp(0, unit.Timecode{Hours: 5, Frames: 1}).
+				buf,
+			&unit.Timecode{Hours: 5, Frames: 1},
+			false,
+		},
+		{
+			"no clock timestamps",
+			spsHRD,
+			nil,
+			(&bitWriter{}).
+				write(0, 48).
+				write(0, 4).
+				write(0, 1).
+				buf,
+			nil,
+			false,
+		},
+		{
+			"no pic_struct",
+			&mch264.SPS{VUI: &mch264.SPS_VUI{}},
+			nil,
+			[]byte{0x80},
+			nil,
+			false,
+		},
+		{
+			"truncated",
+			spsNoHRD,
+			nil,
+			(&bitWriter{}).
+				write(0, 4).
+				write(1, 1).
+				write(0, 8).
+				buf,
+			nil,
+			true,
+		},
+		{
+			"invalid pic_struct",
+			spsNoHRD,
+			nil,
+			(&bitWriter{}).write(9, 4).write(0, 4).buf,
+			nil,
+			true,
+		},
+		{
+			"invalid seconds",
+			spsHRD,
+			nil,
+			(&bitWriter{}).
+				write(0, 48).
+				write(0, 4).
+				fullClockTimestamp(0, unit.Timecode{Seconds: 60}).
+				buf,
+			nil,
+			true,
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			tc, err := h264ParsePicTiming(ca.payload, ca.sps, ca.prev)
+			if ca.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, ca.tc, tc)
+		})
+	}
+}
+
+func TestTimecodeString(t *testing.T) {
+	require.Equal(t, "01:02:03:04", unit.Timecode{Hours: 1, Minutes: 2, Seconds: 3, Frames: 4}.String())
+	require.Equal(t, "10:00:00;02", unit.Timecode{Hours: 10, Frames: 2, DropFrame: true}.String())
+}
+
+// user_data_registered_itu_t_t35 payload with ATSC A/53 cc_data.
+func testGA94Payload(ccs ...[3]byte) []byte {
+	payload := []byte{
+		0xb5,       // itu_t_t35_country_code
+		0x00, 0x31, // itu_t_t35_provider_code
+		'G', 'A', '9', '4',
+		0x03,                  // user_data_type_code
+		0x40 | byte(len(ccs)), // process_cc_data_flag, cc_count
+		0xff,                  // em_data
+	}
+	for _, cc := range ccs {
+		payload = append(payload, cc[:]...)
+	}
+	return append(payload, 0xff) // marker_bits
+}
+
+func TestSEICaptionPackets(t *testing.T) {
+	for _, ca := range []struct {
+		name    string
+		payload []byte
+		packets []unit.CaptionPacket
+	}{
+		{
+			"cea-608 and cea-708",
+			testGA94Payload(
+				[3]byte{0xfc, 0x94, 0x2c},
+				[3]byte{0xfd, 0x80, 0x80},
+				[3]byte{0xff, 0x02, 0x21},
+				[3]byte{0xfe, 0x8a, 0x00},
+			),
+			[]unit.CaptionPacket{
+				{Type: unit.CaptionTypeCEA608Field1, Data: [2]byte{0x94, 0x2c}},
+				{Type: unit.CaptionTypeCEA608Field2, Data: [2]byte{0x80, 0x80}},
+				{Type: unit.CaptionTypeDTVCCStart, Data: [2]byte{0x02, 0x21}},
+				{Type: unit.CaptionTypeDTVCCData, Data: [2]byte{0x8a, 0x00}},
+			},
+		},
+		{
+			"invalid packets are skipped",
+			testGA94Payload(
+				[3]byte{0xfa, 0x00, 0x00},
+				[3]byte{0xfc, 0x20, 0x41},
+			),
+			[]unit.CaptionPacket{
+				{Type: unit.CaptionTypeCEA608Field1, Data: [2]byte{0x20, 0x41}},
+			},
+		},
+		{
+			"no packets",
+			testGA94Payload(),
+			nil,
+		},
+		{
+			"wrong country code",
+			append([]byte{0xb4}, testGA94Payload([3]byte{0xfc, 0x20, 0x41})[1:]...),
+			nil,
+		},
+		{
+			"wrong provider code",
+			append([]byte{0xb5, 0x00, 0x2f}, testGA94Payload([3]byte{0xfc, 0x20, 0x41})[3:]...),
+			nil,
+		},
+		{
+			"wrong user identifier",
+			append(append([]byte{0xb5, 0x00, 0x31}, []byte("DTG1")...), testGA94Payload([3]byte{0xfc, 0x20, 0x41})[7:]...),
+			nil,
+		},
+		{
+			"bar data",
+			append(append([]byte{}, testGA94Payload([3]byte{0xfc, 0x20, 0x41})[:7]...),
+				append([]byte{0x06}, testGA94Payload([3]byte{0xfc, 0x20, 0x41})[8:]...)...),
+			nil,
+		},
+		{
+			"process_cc_data_flag unset",
+			func() []byte {
+				buf := testGA94Payload([3]byte{0xfc, 0x20, 0x41})
+				buf[8] &^= 0x40
+				return buf
+			}(),
+			nil,
+		},
+		{
+			"truncated cc_data",
+			testGA94Payload([3]byte{0xfc, 0x20, 0x41}, [3]byte{0xfc, 0x20, 0x41})[:14],
+			nil,
+		},
+		{
+			"too short",
+			[]byte{0xb5, 0x00, 0x31, 'G', 'A'},
+			nil,
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			require.Equal(t, ca.packets, seiCaptionPackets(ca.payload))
+		})
+	}
+}
+
+func TestH264SEIRoundTrip(t *testing.T) {
+	captions := testGA94Payload([3]byte{0xfc, 0x94, 0x2c})
+
+	// payload with start code emulations and a size that needs more than one byte
+	userData := bytes.Repeat([]byte{0x00, 0x00, 0x01, 0x00, 0x00, 0x03}, 60)
+
+	var uuid [16]byte
+	copy(uuid[:], "0123456789abcdef")
+
+	registered := append([]byte{seiPayloadTypeUserDataRegistered, byte(len(captions))}, captions...)
+
+	nalu := h264MarshalSEI([][]byte{registered, seiMarshalUserData(uuid, userData)})
+	require.NotContains(t, string(nalu), string([]byte{0x00, 0x00, 0x01}))
+
+	au := [][]byte{
+		{0x09, 0xf0},
+		nalu,
+		{0x65, 0x88, 0x84, 0x00},
+	}
+
+	msgs := h264SEIMessages(au)
+	require.Len(t, msgs, 2)
+
+	require.Equal(t, []unit.CaptionPacket{
+		{Type: unit.CaptionTypeCEA608Field1, Data: [2]byte{0x94, 0x2c}},
+	}, seiExtractCaptions(msgs))
+
+	require.Equal(t, []unit.SEIUserData{
+		{UUID: uuid, Payload: userData},
+	}, seiExtractUserData(msgs, nil))
+
+	require.Empty(t, seiExtractUserData(msgs, [][16]byte{{1}}))
+}
+
+func TestH264ParseSEITruncated(t *testing.T) {
+	// the second message is truncated
+	nalu := []byte{0x06, 0x05, 0x10, 0x01, 0x02}
+	require.Empty(t, h264ParseSEI(nalu))
+
+	nalu = h264MarshalSEI([][]byte{seiMarshalUserData([16]byte{1}, []byte{1, 2, 3})})
+	nalu = append(nalu[:len(nalu)-1], 0x05, 0xff)
+	require.Len(t, h264ParseSEI(nalu), 1)
+}
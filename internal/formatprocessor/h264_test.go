@@ -0,0 +1,390 @@
+package formatprocessor
+
+import (
+	"XMedia/internal/unit"
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/bluenviron/gortsplib/v4/pkg/format/rtph264"
+	mch264 "github.com/bluenviron/mediacommon/v2/pkg/codecs/h264"
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+)
+
+var testSPS = []byte{
+	0x67, 0x42, 0xc0, 0x28, 0xd9, 0x00, 0x78, 0x02,
+	0x27, 0xe5, 0x84, 0x00, 0x00, 0x03, 0x00, 0x04,
+	0x00, 0x00, 0x03, 0x00, 0xf0, 0x3c, 0x60, 0xc9,
+	0x20,
+}
+
+var testPPS = []byte{0x68, 0xee, 0x3c, 0x80}
+
+func newTestH264(t *testing.T, forma *format.H264, options H264Options) Processor {
+	p, err := New(1472, forma, false, Options{H264: options}, nilLogger{})
+	require.NoError(t, err)
+	return p
+}
+
+func decodeTestRTPPackets(t *testing.T, pkts []*rtp.Packet) [][]byte {
+	dec := &rtph264.Decoder{PacketizationMode: 1}
+	require.NoError(t, dec.Init())
+
+	for i, pkt := range pkts {
+		au, err := dec.Decode(pkt)
+		if i != len(pkts)-1 {
+			continue
+		}
+		require.NoError(t, err)
+		return au
+	}
+
+	return nil
+}
+
+func TestH264PacketizationMode1(t *testing.T) {
+	t.Run("source in mode 0", func(t *testing.T) {
+		// the path announces tracks published in mode 0 with mode 1
+		forma := &format.H264{PayloadTyp: 96, PacketizationMode: 1, SPS: testSPS, PPS: testPPS}
+		p := newTestH264(t, forma, H264Options{})
+
+		// single NAL unit packets are valid in mode 1 and are routed as they are
+		pkt := &rtp.Packet{
+			Header:  rtp.Header{Version: 2, Marker: true, PayloadType: 96, SequenceNumber: 100},
+			Payload: []byte{byte(mch264.NALUTypeNonIDR), 1, 2, 3},
+		}
+
+		u, err := p.ProcessRTPPacket(pkt, time.Now(), 0, false)
+		require.NoError(t, err)
+		require.Equal(t, []*rtp.Packet{pkt}, u.GetRTPPackets())
+
+		// packets that exceed the maximum size are fragmented
+		big := append([]byte{byte(mch264.NALUTypeNonIDR)}, bytes.Repeat([]byte{1}, 3000)...)
+
+		u, err = p.ProcessRTPPacket(&rtp.Packet{
+			Header:  rtp.Header{Version: 2, Marker: true, PayloadType: 96, SequenceNumber: 101},
+			Payload: big,
+		}, time.Now(), 0, false)
+		require.NoError(t, err)
+
+		pkts := u.GetRTPPackets()
+		require.Greater(t, len(pkts), 1)
+		for _, pkt := range pkts {
+			require.Equal(t, mch264.NALUTypeFUA, mch264.NALUType(pkt.Payload[0]&0x1F))
+			require.LessOrEqual(t, pkt.MarshalSize(), 1472)
+		}
+		require.Equal(t, [][]byte{big}, decodeTestRTPPackets(t, pkts))
+	})
+
+	t.Run("format is not modified", func(t *testing.T) {
+		forma := &format.H264{PayloadTyp: 96, SPS: testSPS, PPS: testPPS}
+		p := newTestH264(t, forma, H264Options{RepeatParameters: true, InsertAUD: true})
+
+		pkt := &rtp.Packet{
+			Header:  rtp.Header{Version: 2, Marker: true, PayloadType: 96, SequenceNumber: 100},
+			Payload: []byte{byte(mch264.NALUTypeNonIDR), 1, 2, 3},
+		}
+
+		_, err := p.ProcessRTPPacket(pkt, time.Now(), 0, false)
+		require.NoError(t, err)
+		require.Equal(t, 0, forma.PacketizationMode)
+	})
+}
+
+func TestH264RepeatParameters(t *testing.T) {
+	for _, ca := range []struct {
+		name    string
+		options H264Options
+		in      [][]byte
+		out     [][]byte
+	}{
+		{
+			"idr",
+			H264Options{RepeatParameters: true},
+			[][]byte{{byte(mch264.NALUTypeIDR), 1}},
+			[][]byte{testSPS, testPPS, {byte(mch264.NALUTypeIDR), 1}},
+		},
+		{
+			"idr with in-band parameters",
+			H264Options{RepeatParameters: true},
+			[][]byte{{byte(mch264.NALUTypePPS), 1}, {byte(mch264.NALUTypeIDR), 1}},
+			[][]byte{testSPS, {byte(mch264.NALUTypePPS), 1}, {byte(mch264.NALUTypeIDR), 1}},
+		},
+		{
+			"idr with aud",
+			H264Options{RepeatParameters: true, InsertAUD: true},
+			[][]byte{{byte(mch264.NALUTypeSEI), 1}, {byte(mch264.NALUTypeIDR), 1}},
+			[][]byte{h264AUD, testSPS, testPPS, {byte(mch264.NALUTypeSEI), 1}, {byte(mch264.NALUTypeIDR), 1}},
+		},
+		{
+			"non-idr",
+			H264Options{RepeatParameters: true},
+			[][]byte{{byte(mch264.NALUTypeNonIDR), 1}},
+			[][]byte{{byte(mch264.NALUTypeNonIDR), 1}},
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			forma := &format.H264{PayloadTyp: 96, PacketizationMode: 1, SPS: testSPS, PPS: testPPS}
+			p := newTestH264(t, forma, ca.options)
+
+			enc := &rtph264.Encoder{PayloadType: 96, PacketizationMode: 1}
+			require.NoError(t, enc.Init())
+
+			pkts, err := enc.Encode(ca.in)
+			require.NoError(t, err)
+
+			var out []*rtp.Packet
+			for _, pkt := range pkts {
+				u, err := p.ProcessRTPPacket(pkt, time.Now(), 0, false)
+				require.NoError(t, err)
+				out = append(out, u.GetRTPPackets()...)
+			}
+
+			// RTSP readers receive parameters before the IDR
+			require.Equal(t, ca.out, decodeTestRTPPackets(t, out))
+		})
+	}
+}
+
+var testDTSSPS = []byte{
+	0x67, 0x64, 0x00, 0x28, 0xac, 0xd9, 0x40, 0x78,
+	0x02, 0x27, 0xe5, 0x84, 0x00, 0x00, 0x03, 0x00,
+	0x04, 0x00, 0x00, 0x03, 0x00, 0xf0, 0x3c, 0x60,
+	0xc6, 0x58,
+}
+
+func TestH264FillDTS(t *testing.T) {
+	ts := func(v time.Duration) int64 {
+		return int64(v * 90000 / time.Second)
+	}
+
+	type sample struct {
+		au           [][]byte
+		pts          int64
+		dts          int64
+		randomAccess bool
+	}
+
+	for _, ca := range []struct {
+		name     string
+		sequence []sample
+	}{
+		{
+			"reordered frames",
+			[]sample{
+				{
+					[][]byte{testDTSSPS, {0x65, 0x88, 0x84, 0x00, 0x33, 0xff}},
+					ts(333333333), ts(333333333), true,
+				},
+				{
+					[][]byte{{0x41, 0x9a, 0x21, 0x6c, 0x45, 0xff}},
+					ts(366666666), ts(366666666), false,
+				},
+				{
+					[][]byte{{0x41, 0x9a, 0x42, 0x3c, 0x21, 0x93}},
+					ts(400000000), ts(400000000), false,
+				},
+				{
+					[][]byte{{0x41, 0x9a, 0x63, 0x49, 0xe1, 0x0f}},
+					ts(433333333), ts(433333333), false,
+				},
+				{
+					[][]byte{{0x41, 0x9a, 0x86, 0x49, 0xe1, 0x0f}},
+					ts(533333333), ts(434333333), false,
+				},
+				{
+					[][]byte{{0x41, 0x9e, 0xa5, 0x42, 0x7f, 0xf9}},
+					ts(500000000), ts(435333333), false,
+				},
+				{
+					[][]byte{{0x01, 0x9e, 0xc4, 0x69, 0x13, 0xff}},
+					ts(466666666), ts(466666666), false,
+				},
+				{
+					[][]byte{{0x41, 0x9a, 0xc8, 0x4b, 0xa8, 0x42}},
+					ts(600000000), ts(499999999), false,
+				},
+				{
+					[][]byte{testDTSSPS, {0x65, 0x88, 0x84, 0x00, 0x33, 0xff}},
+					ts(599999999), ts(533333332), true,
+				},
+			},
+		},
+		{
+			"start from non-IDR",
+			[]sample{
+				{
+					[][]byte{{0x41, 0x9a, 0x21, 0x6c, 0x45, 0xff}},
+					ts(100000000), ts(100000000), false,
+				},
+				{
+					[][]byte{testDTSSPS, {0x65, 0x88, 0x84, 0x00, 0x33, 0xff}},
+					ts(133333333), ts(133333333), true,
+				},
+				{
+					[][]byte{{0x41, 0x9a, 0x21, 0x6c, 0x45, 0xff}},
+					ts(166666666), ts(166666666), false,
+				},
+			},
+		},
+		{
+			"extraction error",
+			[]sample{
+				{
+					// IDR without SPS
+					[][]byte{{0x65, 0x88, 0x84, 0x00, 0x33, 0xff}},
+					ts(100000000), ts(100000000), true,
+				},
+				{
+					[][]byte{{0x41, 0x9a, 0x21, 0x6c, 0x45, 0xff}},
+					ts(133333333), ts(133333333), false,
+				},
+				{
+					[][]byte{testDTSSPS, {0x65, 0x88, 0x84, 0x00, 0x33, 0xff}},
+					ts(166666666), ts(166666666), true,
+				},
+			},
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			p := &h264{Parent: nilLogger{}}
+
+			for i, s := range ca.sequence {
+				u := &unit.H264{
+					Base: unit.Base{PTS: s.pts},
+					AU:   s.au,
+				}
+				p.fillDTS(u)
+				require.Equal(t, s.dts, u.DTS, "sample %d", i)
+				require.Equal(t, s.randomAccess, u.RandomAccess, "sample %d", i)
+			}
+		})
+	}
+
+	t.Run("no access unit", func(t *testing.T) {
+		p := &h264{Parent: nilLogger{}}
+		u := &unit.H264{Base: unit.Base{PTS: 1234}}
+		p.fillDTS(u)
+		require.Equal(t, int64(1234), u.DTS)
+		require.False(t, u.RandomAccess)
+		require.Nil(t, p.dtsExtractor)
+	})
+}
+
+func TestH264TrackInfo(t *testing.T) {
+	for _, ca := range []struct {
+		name string
+		sps  []byte
+		info TrackInfo
+	}{
+		{
+			"352x288 high",
+			[]byte{
+				0x67, 0x64, 0x00, 0x0c, 0xac, 0x3b, 0x50, 0xb0,
+				0x4b, 0x42, 0x00, 0x00, 0x03, 0x00, 0x02, 0x00,
+				0x00, 0x03, 0x00, 0x3d, 0x08,
+			},
+			TrackInfo{
+				Width:        352,
+				Height:       288,
+				Profile:      "High",
+				Level:        "1.2",
+				ChromaFormat: "4:2:0",
+				FPS:          15,
+			},
+		},
+		{
+			"1280x720 high",
+			[]byte{
+				0x67, 0x64, 0x00, 0x1f, 0xac, 0xd9, 0x40, 0x50,
+				0x05, 0xbb, 0x01, 0x6c, 0x80, 0x00, 0x00, 0x03,
+				0x00, 0x80, 0x00, 0x00, 0x1e, 0x07, 0x8c, 0x18,
+				0xcb,
+			},
+			TrackInfo{
+				Width:        1280,
+				Height:       720,
+				Profile:      "High",
+				Level:        "3.1",
+				ChromaFormat: "4:2:0",
+				FPS:          30,
+			},
+		},
+		{
+			"1280x960 high with fixed frame rate",
+			[]byte{103, 100, 0, 32, 172, 23, 42, 1, 64, 30, 104, 64, 0, 1, 194, 0, 0, 87, 228, 33},
+			TrackInfo{
+				Width:        1280,
+				Height:       960,
+				Profile:      "High",
+				Level:        "3.2",
+				ChromaFormat: "4:2:0",
+				FPS:          25,
+			},
+		},
+		{
+			"1920x1080 baseline",
+			testSPS,
+			TrackInfo{
+				Width:        1920,
+				Height:       1080,
+				Profile:      "Baseline",
+				Level:        "4.0",
+				ChromaFormat: "4:2:0",
+				FPS:          30,
+			},
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			info, err := h264TrackInfo(ca.sps)
+			require.NoError(t, err)
+			require.Equal(t, ca.info, info)
+		})
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := h264TrackInfo([]byte{0x67, 0x64})
+		require.Error(t, err)
+	})
+}
+
+func TestH264TrackInfoFromRTPPacket(t *testing.T) {
+	forma := &format.H264{PayloadTyp: 96, PacketizationMode: 1}
+	p := newTestH264(t, forma, H264Options{})
+
+	_, ok := p.(TrackInfoProvider).TrackInfo()
+	require.False(t, ok)
+
+	// parameters announced in-band update the track properties
+	_, err := p.ProcessRTPPacket(&rtp.Packet{
+		Header:  rtp.Header{Version: 2, Marker: true, PayloadType: 96, SequenceNumber: 100},
+		Payload: testSPS,
+	}, time.Now(), 0, false)
+	require.NoError(t, err)
+
+	info, ok := p.(TrackInfoProvider).TrackInfo()
+	require.True(t, ok)
+	require.Equal(t, 1920, info.Width)
+	require.Equal(t, 1080, info.Height)
+	require.Equal(t, "Baseline", info.Profile)
+}
+
+func TestH264ProfileName(t *testing.T) {
+	for _, ca := range []struct {
+		profileIdc uint8
+		name       string
+	}{
+		{66, "Baseline"},
+		{77, "Main"},
+		{88, "Extended"},
+		{100, "High"},
+		{110, "High 10"},
+		{122, "High 4:2:2"},
+		{244, "High 4:4:4"},
+		{118, "118"},
+	} {
+		require.Equal(t, ca.name, h264ProfileName(ca.profileIdc))
+	}
+}
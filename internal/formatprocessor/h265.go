@@ -0,0 +1,334 @@
+package formatprocessor
+
+import (
+	"XMedia/internal/logger"
+	"XMedia/internal/unit"
+	"bytes"
+	"errors"
+	"time"
+
+	mch265 "github.com/bluenviron/mediacommon/v2/pkg/codecs/h265"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/bluenviron/gortsplib/v4/pkg/format/rtph265"
+	"github.com/pion/rtp"
+)
+
+// extract VPS, SPS and PPS without decoding RTP packets
+func rtpH265ExtractParams(payload []byte) ([]byte, []byte, []byte) {
+	if len(payload) < 2 {
+		return nil, nil, nil
+	}
+
+	typ := mch265.NALUType((payload[0] >> 1) & 0b111111)
+
+	switch typ {
+	case mch265.NALUType_VPS_NUT:
+		return payload, nil, nil
+
+	case mch265.NALUType_SPS_NUT:
+		return nil, payload, nil
+
+	case mch265.NALUType_PPS_NUT:
+		return nil, nil, payload
+
+	case mch265.NALUType_AggregationUnit:
+		payload = payload[2:]
+		var vps []byte
+		var sps []byte
+		var pps []byte
+
+		for len(payload) > 0 {
+			if len(payload) < 2 {
+				break
+			}
+
+			size := uint16(payload[0])<<8 | uint16(payload[1])
+			payload = payload[2:]
+
+			if size == 0 {
+				break
+			}
+
+			if int(size) > len(payload) {
+				return nil, nil, nil
+			}
+
+			nalu := payload[:size]
+			payload = payload[size:]
+
+			typ = mch265.NALUType((nalu[0] >> 1) & 0b111111)
+
+			switch typ {
+			case mch265.NALUType_VPS_NUT:
+				vps = nalu
+
+			case mch265.NALUType_SPS_NUT:
+				sps = nalu
+
+			case mch265.NALUType_PPS_NUT:
+				pps = nalu
+			}
+		}
+
+		return vps, sps, pps
+
+	default:
+		return nil, nil, nil
+	}
+}
+
+type h265 struct {
+	UDPMaxPayloadSize  int
+	Format             *format.H265
+	GenerateRTPPackets bool
+	Parent             logger.Writer
+
+	encoder     *rtph265.Encoder
+	decoder     *rtph265.Decoder
+	randomStart uint32
+
+	injectedSEI seiQueue
+}
+
+func (t *h265) initialize() error {
+	if t.GenerateRTPPackets {
+		err := t.createEncoder(nil, nil)
+		if err != nil {
+			return err
+		}
+
+		t.randomStart, err = randUint32()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (t *h265) createEncoder(
+	ssrc *uint32,
+	initialSequenceNumber *uint16,
+) error {
+	t.encoder = &rtph265.Encoder{
+		PayloadMaxSize:        t.UDPMaxPayloadSize - 12,
+		PayloadType:           t.Format.PayloadTyp,
+		SSRC:                  ssrc,
+		InitialSequenceNumber: initialSequenceNumber,
+		MaxDONDiff:            t.Format.MaxDONDiff,
+	}
+	return t.encoder.Init()
+}
+
+func (t *h265) updateTrackParametersFromRTPPacket(payload []byte) {
+	vps, sps, pps := rtpH265ExtractParams(payload)
+
+	if (vps != nil && !bytes.Equal(vps, t.Format.VPS)) ||
+		(sps != nil && !bytes.Equal(sps, t.Format.SPS)) ||
+		(pps != nil && !bytes.Equal(pps, t.Format.PPS)) {
+		if vps == nil {
+			vps = t.Format.VPS
+		}
+		if sps == nil {
+			sps = t.Format.SPS
+		}
+		if pps == nil {
+			pps = t.Format.PPS
+		}
+		t.Format.SafeSetParams(vps, sps, pps)
+	}
+}
+
+// HasParameters implements ParametersChecker.
+func (t *h265) HasParameters() bool {
+	vps, sps, pps := t.Format.SafeParams()
+	return vps != nil && sps != nil && pps != nil
+}
+
+func (t *h265) ProcessUnit(unit.Unit) error {
+	return nil
+}
+
+// process a RTP packet and convert it into a unit.
+func (t *h265) ProcessRTPPacket(
+	pkt *rtp.Packet,
+	ntp time.Time,
+	pts int64,
+	hasNonRTSPReaders bool,
+) (unit.Unit, error) {
+	u := &unit.H265{
+		Base: unit.Base{
+			RTPPackets: []*rtp.Packet{pkt},
+			NTP:        ntp,
+			PTS:        pts,
+		},
+	}
+
+	t.updateTrackParametersFromRTPPacket(pkt.Payload)
+
+	if t.encoder == nil {
+		// remove padding
+		pkt.Padding = false
+		pkt.PaddingSize = 0
+
+		// SEI must be injected: re-encode all packets
+		if t.injectedSEI.hasPending() {
+			t.Parent.Log(logger.Info, "rewriting H265 bitstream, remuxing RTP packets")
+
+			v1 := pkt.SSRC
+			v2 := pkt.SequenceNumber
+			err := t.createEncoder(&v1, &v2)
+			if err != nil {
+				return nil, err
+			}
+		} else if pkt.MarshalSize() > t.UDPMaxPayloadSize { // RTP packets exceed maximum size: start re-encoding them
+			t.Parent.Log(logger.Info, "RTP packets are too big, remuxing them into smaller ones")
+
+			v1 := pkt.SSRC
+			v2 := pkt.SequenceNumber
+			err := t.createEncoder(&v1, &v2)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// decode from RTP
+	if hasNonRTSPReaders || t.decoder != nil || t.encoder != nil {
+		if t.decoder == nil {
+			var err error
+			t.decoder, err = t.Format.CreateDecoder()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		au, err := t.decoder.Decode(pkt)
+
+		if t.encoder != nil {
+			u.RTPPackets = nil
+		}
+
+		if err != nil {
+			if errors.Is(err, rtph265.ErrNonStartingPacketAndNoPrevious) ||
+				errors.Is(err, rtph265.ErrMorePacketsNeeded) {
+				return u, nil
+			}
+			return nil, err
+		}
+
+		u.AU = t.remuxAccessUnit(au)
+		u.AU = t.injectSEI(u.AU)
+	}
+
+	// route packet as is
+	if t.encoder == nil {
+		return u, nil
+	}
+
+	// encode into RTP
+	if len(u.AU) != 0 {
+		pkts, err := t.encoder.Encode(u.AU)
+		if err != nil {
+			return nil, err
+		}
+		u.RTPPackets = pkts
+
+		for _, newPKT := range u.RTPPackets {
+			newPKT.Timestamp = pkt.Timestamp
+		}
+	}
+
+	return u, nil
+}
+
+// InjectSEIUserData implements SEIInjector.
+func (t *h265) InjectSEIUserData(uuid [16]byte, payload []byte) {
+	t.injectedSEI.push(seiMarshalUserData(uuid, payload))
+}
+
+// insert queued SEI messages, as a prefix SEI NALU, before the first slice of an access unit.
+func (t *h265) injectSEI(au [][]byte) [][]byte {
+	if len(au) == 0 || !t.injectedSEI.hasPending() {
+		return au
+	}
+
+	pos := 0
+	for pos < len(au) {
+		switch mch265.NALUType((au[pos][0] >> 1) & 0b111111) {
+		case mch265.NALUType_AUD_NUT, mch265.NALUType_VPS_NUT, mch265.NALUType_SPS_NUT, mch265.NALUType_PPS_NUT:
+			pos++
+			continue
+		}
+		break
+	}
+
+	// nuh_layer_id = 0, nuh_temporal_id_plus1 = 1
+	nalu := append([]byte{byte(mch265.NALUType_PREFIX_SEI_NUT) << 1, 1}, seiMarshalPayload(t.injectedSEI.pop())...)
+
+	ret := make([][]byte, 0, len(au)+1)
+	ret = append(ret, au[:pos]...)
+	ret = append(ret, nalu)
+	ret = append(ret, au[pos:]...)
+	return ret
+}
+
+func (t *h265) remuxAccessUnit(au [][]byte) [][]byte {
+	isKeyFrame := false
+	n := 0
+
+	for _, nalu := range au {
+		typ := mch265.NALUType((nalu[0] >> 1) & 0b111111)
+
+		switch typ {
+		case mch265.NALUType_VPS_NUT, mch265.NALUType_SPS_NUT, mch265.NALUType_PPS_NUT: // parameters: remove
+			continue
+
+		case mch265.NALUType_AUD_NUT: // AUD: remove
+			continue
+
+		case mch265.NALUType_IDR_W_RADL, mch265.NALUType_IDR_N_LP, mch265.NALUType_CRA_NUT: // key frame
+			if !isKeyFrame {
+				isKeyFrame = true
+
+				// prepend parameters
+				if t.Format.VPS != nil && t.Format.SPS != nil && t.Format.PPS != nil {
+					n += 3
+				}
+			}
+		}
+		n++
+	}
+
+	if n == 0 {
+		return nil
+	}
+
+	filteredNALUs := make([][]byte, n)
+	i := 0
+
+	if isKeyFrame && t.Format.VPS != nil && t.Format.SPS != nil && t.Format.PPS != nil {
+		filteredNALUs[0] = t.Format.VPS
+		filteredNALUs[1] = t.Format.SPS
+		filteredNALUs[2] = t.Format.PPS
+		i = 3
+	}
+
+	for _, nalu := range au {
+		typ := mch265.NALUType((nalu[0] >> 1) & 0b111111)
+
+		switch typ {
+		case mch265.NALUType_VPS_NUT, mch265.NALUType_SPS_NUT, mch265.NALUType_PPS_NUT:
+			continue
+
+		case mch265.NALUType_AUD_NUT:
+			continue
+		}
+
+		filteredNALUs[i] = nalu
+		i++
+	}
+
+	return filteredNALUs
+}
@@ -0,0 +1,214 @@
+package formatprocessor
+
+import (
+	"XMedia/internal/logger"
+	"XMedia/internal/unit"
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/bluenviron/gortsplib/v4/pkg/format/rtph265"
+	mch265 "github.com/bluenviron/mediacommon/v2/pkg/codecs/h265"
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+)
+
+type nilLogger struct{}
+
+func (nilLogger) Log(logger.Level, string, ...interface{}) {}
+
+var (
+	testH265VPS = []byte{byte(mch265.NALUType_VPS_NUT) << 1, 1, 0x0c}
+	testH265SPS = []byte{byte(mch265.NALUType_SPS_NUT) << 1, 1, 0x01}
+	testH265PPS = []byte{byte(mch265.NALUType_PPS_NUT) << 1, 1, 0xc1}
+	testH265IDR = []byte{byte(mch265.NALUType_IDR_W_RADL) << 1, 1, 0xaf}
+	testH265P   = []byte{byte(mch265.NALUType_TRAIL_R) << 1, 1, 0x01}
+)
+
+func newTestH265(t *testing.T, forma *format.H265) *h265 {
+	p := &h265{
+		UDPMaxPayloadSize: 1472,
+		Format:            forma,
+		Parent:            nilLogger{},
+	}
+	require.NoError(t, p.initialize())
+	return p
+}
+
+func TestH265ExtractParams(t *testing.T) {
+	for _, ca := range []struct {
+		name    string
+		payload []byte
+		vps     []byte
+		sps     []byte
+		pps     []byte
+	}{
+		{
+			"vps",
+			testH265VPS,
+			testH265VPS,
+			nil,
+			nil,
+		},
+		{
+			"sps",
+			testH265SPS,
+			nil,
+			testH265SPS,
+			nil,
+		},
+		{
+			"pps",
+			testH265PPS,
+			nil,
+			nil,
+			testH265PPS,
+		},
+		{
+			"aggregation unit",
+			append([]byte{byte(mch265.NALUType_AggregationUnit) << 1, 1},
+				append(append(append(
+					[]byte{0, 3}, testH265VPS...),
+					append([]byte{0, 3}, testH265SPS...)...),
+					append([]byte{0, 3}, testH265PPS...)...)...),
+			testH265VPS,
+			testH265SPS,
+			testH265PPS,
+		},
+		{
+			"aggregation unit with invalid size",
+			append([]byte{byte(mch265.NALUType_AggregationUnit) << 1, 1, 0, 10}, testH265VPS...),
+			nil,
+			nil,
+			nil,
+		},
+		{
+			"slice",
+			testH265IDR,
+			nil,
+			nil,
+			nil,
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			vps, sps, pps := rtpH265ExtractParams(ca.payload)
+			require.Equal(t, ca.vps, vps)
+			require.Equal(t, ca.sps, sps)
+			require.Equal(t, ca.pps, pps)
+		})
+	}
+}
+
+func TestH265ProcessRTPPacket(t *testing.T) {
+	for _, ca := range []struct {
+		name   string
+		forma  *format.H265
+		in     [][][]byte
+		out    [][]byte
+		params [][]byte
+	}{
+		{
+			"in-band parameters",
+			&format.H265{PayloadTyp: 96},
+			[][][]byte{{testH265VPS, testH265SPS, testH265PPS, testH265IDR}},
+			[][]byte{testH265VPS, testH265SPS, testH265PPS, testH265IDR},
+			[][]byte{testH265VPS, testH265SPS, testH265PPS},
+		},
+		{
+			"parameters are prepended to key frames",
+			&format.H265{PayloadTyp: 96, VPS: testH265VPS, SPS: testH265SPS, PPS: testH265PPS},
+			[][][]byte{{testH265IDR}},
+			[][]byte{testH265VPS, testH265SPS, testH265PPS, testH265IDR},
+			[][]byte{testH265VPS, testH265SPS, testH265PPS},
+		},
+		{
+			"updated parameters",
+			&format.H265{PayloadTyp: 96, VPS: testH265VPS, SPS: testH265SPS, PPS: testH265PPS},
+			[][][]byte{{{byte(mch265.NALUType_PPS_NUT) << 1, 1, 0xc2}, testH265IDR}},
+			[][]byte{testH265VPS, testH265SPS, {byte(mch265.NALUType_PPS_NUT) << 1, 1, 0xc2}, testH265IDR},
+			[][]byte{testH265VPS, testH265SPS, {byte(mch265.NALUType_PPS_NUT) << 1, 1, 0xc2}},
+		},
+		{
+			"non key frame",
+			&format.H265{PayloadTyp: 96, VPS: testH265VPS, SPS: testH265SPS, PPS: testH265PPS},
+			[][][]byte{{testH265P}},
+			[][]byte{testH265P},
+			[][]byte{testH265VPS, testH265SPS, testH265PPS},
+		},
+		{
+			"access unit delimiters are removed",
+			&format.H265{PayloadTyp: 96},
+			[][][]byte{{{byte(mch265.NALUType_AUD_NUT) << 1, 1, 0x50}, testH265P}},
+			[][]byte{testH265P},
+			[][]byte{nil, nil, nil},
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			p := newTestH265(t, ca.forma)
+
+			enc := &rtph265.Encoder{PayloadType: 96}
+			require.NoError(t, enc.Init())
+
+			var au [][]byte
+			for _, in := range ca.in {
+				pkts, err := enc.Encode(in)
+				require.NoError(t, err)
+
+				for _, pkt := range pkts {
+					u, err := p.ProcessRTPPacket(pkt, time.Now(), 0, true)
+					require.NoError(t, err)
+					au = u.(*unit.H265).AU
+				}
+			}
+
+			require.Equal(t, ca.out, au)
+
+			vps, sps, pps := ca.forma.SafeParams()
+			require.Equal(t, ca.params, [][]byte{vps, sps, pps})
+		})
+	}
+}
+
+func TestH265OversizedPackets(t *testing.T) {
+	p := newTestH265(t, &format.H265{PayloadTyp: 96})
+
+	// packets that don't exceed the maximum size are routed as they are
+	pkt := &rtp.Packet{
+		Header:  rtp.Header{Version: 2, Marker: true, PayloadType: 96, SequenceNumber: 100},
+		Payload: testH265P,
+	}
+
+	u, err := p.ProcessRTPPacket(pkt, time.Now(), 0, false)
+	require.NoError(t, err)
+	require.Equal(t, []*rtp.Packet{pkt}, u.GetRTPPackets())
+
+	// packets that exceed the maximum size are fragmented
+	big := append([]byte{byte(mch265.NALUType_TRAIL_R) << 1, 1}, bytes.Repeat([]byte{1}, 3000)...)
+
+	u, err = p.ProcessRTPPacket(&rtp.Packet{
+		Header:  rtp.Header{Version: 2, Marker: true, PayloadType: 96, SequenceNumber: 101, Timestamp: 3000},
+		Payload: big,
+	}, time.Now(), 0, false)
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{big}, u.(*unit.H265).AU)
+
+	pkts := u.GetRTPPackets()
+	require.Greater(t, len(pkts), 1)
+
+	dec := &rtph265.Decoder{}
+	require.NoError(t, dec.Init())
+
+	for i, pkt := range pkts {
+		require.LessOrEqual(t, pkt.MarshalSize(), 1472)
+		require.Equal(t, uint32(3000), pkt.Timestamp)
+
+		au, err := dec.Decode(pkt)
+		if i != len(pkts)-1 {
+			require.ErrorIs(t, err, rtph265.ErrMorePacketsNeeded)
+			continue
+		}
+		require.NoError(t, err)
+		require.Equal(t, [][]byte{big}, au)
+	}
+}
@@ -0,0 +1,168 @@
+package formatprocessor
+
+import (
+	"XMedia/internal/logger"
+	"XMedia/internal/unit"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/bluenviron/gortsplib/v4/pkg/format/rtpklv"
+	"github.com/pion/rtp"
+)
+
+type klv struct {
+	UDPMaxPayloadSize  int
+	Format             *format.KLV
+	GenerateRTPPackets bool
+	Parent             logger.Writer
+
+	encoder     *rtpklv.Encoder
+	decoder     *rtpklv.Decoder
+	randomStart uint32
+
+	telemetryMutex sync.RWMutex
+	telemetry      *KLVTelemetry
+}
+
+func (t *klv) initialize() error {
+	if t.GenerateRTPPackets {
+		err := t.createEncoder(nil, nil)
+		if err != nil {
+			return err
+		}
+
+		t.randomStart, err = randUint32()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (t *klv) createEncoder(
+	ssrc *uint32,
+	initialSequenceNumber *uint16,
+) error {
+	t.encoder = &rtpklv.Encoder{
+		PayloadMaxSize:        t.UDPMaxPayloadSize - 12,
+		PayloadType:           t.Format.PayloadTyp,
+		SSRC:                  ssrc,
+		InitialSequenceNumber: initialSequenceNumber,
+	}
+	return t.encoder.Init()
+}
+
+func (t *klv) updateTelemetry(buf []byte) {
+	tel, ok := misb0601Decode(buf)
+	if !ok {
+		return
+	}
+
+	t.telemetryMutex.Lock()
+	defer t.telemetryMutex.Unlock()
+
+	if t.telemetry == nil {
+		t.Parent.Log(logger.Info, "MISB ST 0601 telemetry found in KLV track")
+	}
+	t.telemetry = tel
+}
+
+// LatestTelemetry implements TelemetryProvider.
+func (t *klv) LatestTelemetry() (KLVTelemetry, bool) {
+	t.telemetryMutex.RLock()
+	defer t.telemetryMutex.RUnlock()
+
+	if t.telemetry == nil {
+		return KLVTelemetry{}, false
+	}
+	return *t.telemetry, true
+}
+
+func (t *klv) ProcessUnit(unit.Unit) error {
+	return nil
+}
+
+// process a RTP packet and convert it into a unit.
+func (t *klv) ProcessRTPPacket(
+	pkt *rtp.Packet,
+	ntp time.Time,
+	pts int64,
+	_ bool,
+) (unit.Unit, error) {
+	u := &unit.KLV{
+		Base: unit.Base{
+			RTPPackets: []*rtp.Packet{pkt},
+			NTP:        ntp,
+			PTS:        pts,
+		},
+	}
+
+	if t.encoder == nil {
+		// remove padding
+		pkt.Padding = false
+		pkt.PaddingSize = 0
+
+		// RTP packets exceed maximum size: start re-encoding them
+		if pkt.MarshalSize() > t.UDPMaxPayloadSize {
+			t.Parent.Log(logger.Info, "RTP packets are too big, remuxing them into smaller ones")
+
+			v1 := pkt.SSRC
+			v2 := pkt.SequenceNumber
+			err := t.createEncoder(&v1, &v2)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// decode from RTP.
+	// KLV units are always decoded, in order to read telemetry.
+	if t.decoder == nil {
+		var err error
+		t.decoder, err = t.Format.CreateDecoder()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	buf, err := t.decoder.Decode(pkt)
+
+	if t.encoder != nil {
+		u.RTPPackets = nil
+	}
+
+	if err != nil {
+		if errors.Is(err, rtpklv.ErrNonStartingPacketAndNoPrevious) ||
+			errors.Is(err, rtpklv.ErrMorePacketsNeeded) {
+			return u, nil
+		}
+		return nil, err
+	}
+
+	// the decoder reuses its buffer, while units are read asynchronously
+	u.Unit = append([]byte(nil), buf...)
+	t.updateTelemetry(u.Unit)
+
+	// route packet as is
+	if t.encoder == nil {
+		return u, nil
+	}
+
+	// encode into RTP
+	if len(u.Unit) != 0 {
+		pkts, err := t.encoder.Encode(u.Unit)
+		if err != nil {
+			return nil, err
+		}
+		u.RTPPackets = pkts
+
+		for _, newPKT := range u.RTPPackets {
+			newPKT.Timestamp = pkt.Timestamp
+		}
+	}
+
+	return u, nil
+}
@@ -0,0 +1,136 @@
+package formatprocessor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+)
+
+// universal key of the UAS Datalink Local Set (MISB ST 0601).
+var misb0601Key = []byte{
+	0x06, 0x0E, 0x2B, 0x34, 0x02, 0x0B, 0x01, 0x01,
+	0x0E, 0x01, 0x03, 0x01, 0x01, 0x00, 0x00, 0x00,
+}
+
+// MISB ST 0601 tags.
+const (
+	misb0601TagPrecisionTimeStamp = 2
+	misb0601TagSensorLatitude     = 13
+	misb0601TagSensorLongitude    = 14
+	misb0601TagSensorAltitude     = 15
+)
+
+// KLVTelemetry contains common fields of a MISB ST 0601 local set.
+// Fields that are not present in the local set are nil.
+type KLVTelemetry struct {
+	Timestamp *time.Time
+	Latitude  *float64
+	Longitude *float64
+	Altitude  *float64
+}
+
+// read a BER-encoded length.
+func berLength(buf []byte) (int, int, bool) {
+	if len(buf) == 0 {
+		return 0, 0, false
+	}
+
+	if buf[0] < 0x80 {
+		return int(buf[0]), 1, true
+	}
+
+	n := int(buf[0] & 0x7F)
+	if n == 0 || n > 4 || len(buf) < 1+n {
+		return 0, 0, false
+	}
+
+	l := 0
+	for _, b := range buf[1 : 1+n] {
+		l = l<<8 | int(b)
+	}
+	return l, 1 + n, true
+}
+
+// read a BER-OID-encoded tag.
+func berOIDTag(buf []byte) (int, int, bool) {
+	v := 0
+	for i, b := range buf {
+		if i >= 4 {
+			return 0, 0, false
+		}
+
+		v = v<<7 | int(b&0x7F)
+		if (b & 0x80) == 0 {
+			return v, i + 1, true
+		}
+	}
+	return 0, 0, false
+}
+
+// map a signed integer into the range [-max, max], as described by MISB ST 0601.
+func misb0601MapInt32(v []byte, maxVal float64) *float64 {
+	if len(v) != 4 {
+		return nil
+	}
+
+	i := int32(binary.BigEndian.Uint32(v))
+	if i == -0x80000000 { // reserved for "out of range"
+		return nil
+	}
+
+	f := float64(i) * (2 * maxVal) / 0xFFFFFFFE
+	return &f
+}
+
+// decode a MISB ST 0601 local set.
+func misb0601Decode(buf []byte) (*KLVTelemetry, bool) {
+	if len(buf) < len(misb0601Key) || !bytes.Equal(buf[:len(misb0601Key)], misb0601Key) {
+		return nil, false
+	}
+	buf = buf[len(misb0601Key):]
+
+	l, n, ok := berLength(buf)
+	if !ok || len(buf) < n+l {
+		return nil, false
+	}
+	buf = buf[n : n+l]
+
+	t := &KLVTelemetry{}
+
+	for len(buf) > 0 {
+		tag, n, ok := berOIDTag(buf)
+		if !ok {
+			return nil, false
+		}
+		buf = buf[n:]
+
+		l, n, ok := berLength(buf)
+		if !ok || len(buf) < n+l {
+			return nil, false
+		}
+		v := buf[n : n+l]
+		buf = buf[n+l:]
+
+		switch tag {
+		case misb0601TagPrecisionTimeStamp:
+			if len(v) == 8 {
+				ts := time.UnixMicro(int64(binary.BigEndian.Uint64(v))).UTC()
+				t.Timestamp = &ts
+			}
+
+		case misb0601TagSensorLatitude:
+			t.Latitude = misb0601MapInt32(v, 90)
+
+		case misb0601TagSensorLongitude:
+			t.Longitude = misb0601MapInt32(v, 180)
+
+		case misb0601TagSensorAltitude:
+			if len(v) == 2 {
+				alt := float64(binary.BigEndian.Uint16(v))*19900/0xFFFF - 900
+				t.Altitude = &alt
+			}
+		}
+	}
+
+	return t, true
+}
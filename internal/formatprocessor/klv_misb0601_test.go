@@ -0,0 +1,213 @@
+package formatprocessor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type misb0601Item struct {
+	tag   []byte
+	value []byte
+}
+
+// encode a MISB ST 0601 local set, whose length is BER-encoded in long form when needed.
+func misb0601Encode(items ...misb0601Item) []byte {
+	var body []byte
+	for _, it := range items {
+		body = append(body, it.tag...)
+		body = append(body, byte(len(it.value)))
+		body = append(body, it.value...)
+	}
+
+	buf := append([]byte(nil), misb0601Key...)
+	if len(body) < 0x80 {
+		buf = append(buf, byte(len(body)))
+	} else {
+		buf = append(buf, 0x82, byte(len(body)>>8), byte(len(body)))
+	}
+	return append(buf, body...)
+}
+
+func floatPtr(v float64) *float64 {
+	return &v
+}
+
+func TestBERLength(t *testing.T) {
+	for _, ca := range []struct {
+		name string
+		buf  []byte
+		l    int
+		n    int
+		ok   bool
+	}{
+		{"short form", []byte{0x7F}, 127, 1, true},
+		{"long form", []byte{0x81, 0xC9}, 201, 2, true},
+		{"long form, 2 bytes", []byte{0x82, 0x01, 0x00}, 256, 3, true},
+		{"empty", []byte{}, 0, 0, false},
+		{"truncated", []byte{0x82, 0x01}, 0, 0, false},
+		{"indefinite", []byte{0x80}, 0, 0, false},
+		{"too long", []byte{0x85, 1, 2, 3, 4, 5}, 0, 0, false},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			l, n, ok := berLength(ca.buf)
+			require.Equal(t, ca.ok, ok)
+			require.Equal(t, ca.l, l)
+			require.Equal(t, ca.n, n)
+		})
+	}
+}
+
+func TestBEROIDTag(t *testing.T) {
+	for _, ca := range []struct {
+		name string
+		buf  []byte
+		tag  int
+		n    int
+		ok   bool
+	}{
+		{"single byte", []byte{0x0D, 0x04}, 13, 1, true},
+		{"two bytes", []byte{0x81, 0x10}, 144, 2, true},
+		{"truncated", []byte{0x81}, 0, 0, false},
+		{"too long", []byte{0x81, 0x81, 0x81, 0x81, 0x01}, 0, 0, false},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			tag, n, ok := berOIDTag(ca.buf)
+			require.Equal(t, ca.ok, ok)
+			require.Equal(t, ca.tag, tag)
+			require.Equal(t, ca.n, n)
+		})
+	}
+}
+
+func TestMISB0601Decode(t *testing.T) {
+	// values of the example of MISB ST 0601
+	timestamp := time.Date(2009, 1, 12, 22, 8, 22, 0, time.UTC)
+	timestampItem := misb0601Item{[]byte{2}, []byte{0x00, 0x04, 0x60, 0x50, 0x58, 0x4E, 0x01, 0x80}}
+	latitudeItem := misb0601Item{[]byte{13}, []byte{0x55, 0x95, 0xB6, 0x6D}}
+	longitudeItem := misb0601Item{[]byte{14}, []byte{0x5B, 0x53, 0x60, 0xC4}}
+	altitudeItem := misb0601Item{[]byte{15}, []byte{0xC2, 0x21}}
+
+	for _, ca := range []struct {
+		name string
+		buf  []byte
+		t    *KLVTelemetry
+	}{
+		{
+			"all fields",
+			misb0601Encode(
+				timestampItem,
+				misb0601Item{[]byte{3}, []byte("MISSION01")}, // mission ID, ignored
+				latitudeItem,
+				longitudeItem,
+				altitudeItem,
+				misb0601Item{[]byte{1}, []byte{0xC8, 0x4C}}, // checksum, that is not verified
+			),
+			&KLVTelemetry{
+				Timestamp: &timestamp,
+				Latitude:  floatPtr(60.176822966978335),
+				Longitude: floatPtr(128.42675904204452),
+				Altitude:  floatPtr(14190.719462882429),
+			},
+		},
+		{
+			"missing fields",
+			misb0601Encode(latitudeItem),
+			&KLVTelemetry{
+				Latitude: floatPtr(60.176822966978335),
+			},
+		},
+		{
+			"negative values",
+			misb0601Encode(
+				misb0601Item{[]byte{13}, []byte{0x80, 0x00, 0x00, 0x01}},
+				misb0601Item{[]byte{14}, []byte{0xFF, 0xFF, 0xFF, 0xFF}},
+				misb0601Item{[]byte{15}, []byte{0x00, 0x00}},
+			),
+			&KLVTelemetry{
+				Latitude:  floatPtr(-90),
+				Longitude: floatPtr(-180.0 * 2 / 0xFFFFFFFE),
+				Altitude:  floatPtr(-900),
+			},
+		},
+		{
+			"out of range",
+			misb0601Encode(misb0601Item{[]byte{13}, []byte{0x80, 0x00, 0x00, 0x00}}),
+			&KLVTelemetry{},
+		},
+		{
+			"values with wrong size",
+			misb0601Encode(
+				misb0601Item{[]byte{2}, []byte{0x00, 0x04}},
+				misb0601Item{[]byte{13}, []byte{0x55, 0x95}},
+				misb0601Item{[]byte{15}, []byte{0xC2}},
+			),
+			&KLVTelemetry{},
+		},
+		{
+			"multi-byte tag",
+			misb0601Encode(
+				misb0601Item{[]byte{0x81, 0x10}, []byte{1, 2, 3}},
+				latitudeItem,
+			),
+			&KLVTelemetry{
+				Latitude: floatPtr(60.176822966978335),
+			},
+		},
+		{
+			"long form length",
+			misb0601Encode(
+				misb0601Item{[]byte{3}, make([]byte, 127)},
+				misb0601Item{[]byte{3}, make([]byte, 127)},
+				latitudeItem,
+			),
+			&KLVTelemetry{
+				Latitude: floatPtr(60.176822966978335),
+			},
+		},
+		{
+			"wrong key",
+			append([]byte{0x06, 0x0E, 0x2B, 0x34, 0x02, 0x0B, 0x01, 0x01,
+				0x0E, 0x01, 0x03, 0x01, 0x02, 0x00, 0x00, 0x00}, 0x00),
+			nil,
+		},
+		{
+			"truncated local set",
+			misb0601Encode(latitudeItem)[:len(misb0601Key)+4],
+			nil,
+		},
+		{
+			"truncated item",
+			append(misb0601Key[:len(misb0601Key):len(misb0601Key)], 0x03, 13, 0x04, 0x55),
+			nil,
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			tel, ok := misb0601Decode(ca.buf)
+			if ca.t == nil {
+				require.False(t, ok)
+				return
+			}
+
+			require.True(t, ok)
+			require.Equal(t, ca.t.Timestamp, tel.Timestamp)
+
+			for _, f := range []struct {
+				expected *float64
+				actual   *float64
+			}{
+				{ca.t.Latitude, tel.Latitude},
+				{ca.t.Longitude, tel.Longitude},
+				{ca.t.Altitude, tel.Altitude},
+			} {
+				if f.expected == nil {
+					require.Nil(t, f.actual)
+				} else {
+					require.NotNil(t, f.actual)
+					require.InDelta(t, *f.expected, *f.actual, 1e-9)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package formatprocessor
+
+import (
+	"XMedia/internal/unit"
+	"testing"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKLVProcessRTPPacket(t *testing.T) {
+	p := &klv{
+		UDPMaxPayloadSize: 1472,
+		Format:            &format.KLV{PayloadTyp: 96},
+		Parent:            nilLogger{},
+	}
+	require.NoError(t, p.initialize())
+
+	klvUnit := func(v byte) []byte {
+		return []byte{
+			0x06, 0x0e, 0x2b, 0x34, 0x02, 0x0b, 0x01, 0x01,
+			0x0e, 0x01, 0x03, 0x01, 0x01, 0x00, 0x00, 0x00,
+			0x01, v,
+		}
+	}
+
+	var units []*unit.KLV
+
+	for i := 0; i < 3; i++ {
+		u, err := p.ProcessRTPPacket(&rtp.Packet{
+			Header: rtp.Header{
+				Version:        2,
+				Marker:         true,
+				PayloadType:    96,
+				SequenceNumber: uint16(100 + i),
+				Timestamp:      uint32(i * 3000),
+			},
+			Payload: klvUnit(byte(i)),
+		}, time.Now(), int64(i*3000), true)
+		require.NoError(t, err)
+		units = append(units, u.(*unit.KLV))
+	}
+
+	// units are read asynchronously and must not be overwritten by the following ones
+	for i, u := range units {
+		require.Equal(t, klvUnit(byte(i)), u.Unit)
+	}
+}
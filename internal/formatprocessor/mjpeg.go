@@ -0,0 +1,191 @@
+package formatprocessor
+
+import (
+	"XMedia/internal/logger"
+	"XMedia/internal/unit"
+	"errors"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/bluenviron/gortsplib/v4/pkg/format/rtpmjpeg"
+	"github.com/bluenviron/mediacommon/v2/pkg/codecs/jpeg"
+	"github.com/pion/rtp"
+)
+
+// extract the frame size from the SOF marker of a JPEG image
+func jpegExtractSize(image []byte) (int, int, bool) {
+	if len(image) < 2 || image[0] != 0xFF || image[1] != jpeg.MarkerStartOfImage {
+		return 0, 0, false
+	}
+	image = image[2:]
+
+	for len(image) >= 4 {
+		if image[0] != 0xFF {
+			return 0, 0, false
+		}
+
+		marker := image[1]
+		size := int(image[2])<<8 | int(image[3])
+		if size < 2 || (size+2) > len(image) {
+			return 0, 0, false
+		}
+
+		switch marker {
+		case jpeg.MarkerStartOfFrame1:
+			var sof jpeg.StartOfFrame1
+			err := sof.Unmarshal(image[4 : size+2])
+			if err != nil {
+				return 0, 0, false
+			}
+			return sof.Width, sof.Height, true
+
+		case jpeg.MarkerStartOfScan:
+			return 0, 0, false
+		}
+
+		image = image[size+2:]
+	}
+
+	return 0, 0, false
+}
+
+type mjpeg struct {
+	UDPMaxPayloadSize  int
+	Format             *format.MJPEG
+	GenerateRTPPackets bool
+	Parent             logger.Writer
+
+	encoder     *rtpmjpeg.Encoder
+	decoder     *rtpmjpeg.Decoder
+	randomStart uint32
+	width       int
+	height      int
+}
+
+func (t *mjpeg) initialize() error {
+	if t.GenerateRTPPackets {
+		err := t.createEncoder(nil, nil)
+		if err != nil {
+			return err
+		}
+
+		t.randomStart, err = randUint32()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (t *mjpeg) createEncoder(
+	ssrc *uint32,
+	initialSequenceNumber *uint16,
+) error {
+	t.encoder = &rtpmjpeg.Encoder{
+		PayloadMaxSize:        t.UDPMaxPayloadSize - 12,
+		SSRC:                  ssrc,
+		InitialSequenceNumber: initialSequenceNumber,
+	}
+	return t.encoder.Init()
+}
+
+// the frame size is the only parameter that can be found in the bitstream.
+func (t *mjpeg) updateTrackParametersFromFrame(frame []byte) {
+	width, height, ok := jpegExtractSize(frame)
+	if !ok {
+		return
+	}
+
+	if width != t.width || height != t.height {
+		t.Parent.Log(logger.Info, "M-JPEG frame size is %dx%d", width, height)
+		t.width = width
+		t.height = height
+	}
+}
+
+func (t *mjpeg) ProcessUnit(unit.Unit) error {
+	return nil
+}
+
+// process a RTP packet and convert it into a unit.
+func (t *mjpeg) ProcessRTPPacket(
+	pkt *rtp.Packet,
+	ntp time.Time,
+	pts int64,
+	hasNonRTSPReaders bool,
+) (unit.Unit, error) {
+	u := &unit.MJPEG{
+		Base: unit.Base{
+			RTPPackets: []*rtp.Packet{pkt},
+			NTP:        ntp,
+			PTS:        pts,
+		},
+	}
+
+	if t.encoder == nil {
+		// remove padding
+		pkt.Padding = false
+		pkt.PaddingSize = 0
+
+		// RTP packets exceed maximum size: start re-encoding them
+		if pkt.MarshalSize() > t.UDPMaxPayloadSize {
+			t.Parent.Log(logger.Info, "RTP packets are too big, remuxing them into smaller ones")
+
+			v1 := pkt.SSRC
+			v2 := pkt.SequenceNumber
+			err := t.createEncoder(&v1, &v2)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// decode from RTP
+	if hasNonRTSPReaders || t.decoder != nil || t.encoder != nil {
+		if t.decoder == nil {
+			var err error
+			t.decoder, err = t.Format.CreateDecoder()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		frame, err := t.decoder.Decode(pkt)
+
+		if t.encoder != nil {
+			u.RTPPackets = nil
+		}
+
+		if err != nil {
+			if errors.Is(err, rtpmjpeg.ErrNonStartingPacketAndNoPrevious) ||
+				errors.Is(err, rtpmjpeg.ErrMorePacketsNeeded) {
+				return u, nil
+			}
+			return nil, err
+		}
+
+		t.updateTrackParametersFromFrame(frame)
+		u.Frame = frame
+	}
+
+	// route packet as is
+	if t.encoder == nil {
+		return u, nil
+	}
+
+	// encode into RTP
+	if len(u.Frame) != 0 {
+		pkts, err := t.encoder.Encode(u.Frame)
+		if err != nil {
+			return nil, err
+		}
+		u.RTPPackets = pkts
+
+		for _, newPKT := range u.RTPPackets {
+			newPKT.Timestamp = pkt.Timestamp
+		}
+	}
+
+	return u, nil
+}
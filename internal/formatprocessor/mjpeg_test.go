@@ -0,0 +1,159 @@
+package formatprocessor
+
+import (
+	"XMedia/internal/unit"
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/bluenviron/gortsplib/v4/pkg/format/rtpmjpeg"
+	"github.com/bluenviron/mediacommon/v2/pkg/codecs/jpeg"
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+)
+
+// build a JPEG image that can be encoded into RTP/M-JPEG packets.
+func testJPEGImage(width int, height int, dataSize int) []byte {
+	buf := jpeg.StartOfImage{}.Marshal(nil)
+
+	buf = jpeg.DefineQuantizationTable{
+		Tables: []jpeg.QuantizationTable{
+			{ID: 0, Data: bytes.Repeat([]byte{1}, 64)},
+			{ID: 1, Data: bytes.Repeat([]byte{2}, 64)},
+		},
+	}.Marshal(buf)
+
+	buf = jpeg.StartOfFrame1{
+		Type:                   1,
+		Width:                  width,
+		Height:                 height,
+		QuantizationTableCount: 2,
+	}.Marshal(buf)
+
+	buf = jpeg.StartOfScan{}.Marshal(buf)
+	buf = append(buf, bytes.Repeat([]byte{0x55}, dataSize)...)
+
+	return append(buf, 0xFF, jpeg.MarkerEndOfImage)
+}
+
+func TestJPEGExtractSize(t *testing.T) {
+	for _, ca := range []struct {
+		name   string
+		image  []byte
+		width  int
+		height int
+		ok     bool
+	}{
+		{
+			"valid",
+			testJPEGImage(640, 480, 10),
+			640,
+			480,
+			true,
+		},
+		{
+			"missing SOI",
+			testJPEGImage(640, 480, 10)[2:],
+			0,
+			0,
+			false,
+		},
+		{
+			"SOS before SOF",
+			append(jpeg.StartOfScan{}.Marshal(jpeg.StartOfImage{}.Marshal(nil)),
+				testJPEGImage(640, 480, 10)[2:]...),
+			0,
+			0,
+			false,
+		},
+		{
+			"truncated",
+			testJPEGImage(640, 480, 10)[:140],
+			0,
+			0,
+			false,
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			width, height, ok := jpegExtractSize(ca.image)
+			require.Equal(t, ca.ok, ok)
+			require.Equal(t, ca.width, width)
+			require.Equal(t, ca.height, height)
+		})
+	}
+}
+
+func TestMJPEGProcessRTPPacket(t *testing.T) {
+	for _, ca := range []struct {
+		name           string
+		payloadMaxSize int
+		reenc          bool
+	}{
+		{
+			"packets routed as they are",
+			1400,
+			false,
+		},
+		{
+			"oversized packets",
+			4000,
+			true,
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			p := &mjpeg{
+				UDPMaxPayloadSize: 1472,
+				Format:            &format.MJPEG{},
+				Parent:            nilLogger{},
+			}
+			require.NoError(t, p.initialize())
+
+			enc := &rtpmjpeg.Encoder{PayloadMaxSize: ca.payloadMaxSize}
+			require.NoError(t, enc.Init())
+
+			pkts, err := enc.Encode(testJPEGImage(640, 480, 5000))
+			require.NoError(t, err)
+
+			var frame []byte
+			var out []*rtp.Packet
+
+			for _, pkt := range pkts {
+				pkt.Timestamp = 90000
+				in := *pkt
+
+				u, err := p.ProcessRTPPacket(pkt, time.Now(), 0, true)
+				require.NoError(t, err)
+
+				if !ca.reenc {
+					require.Equal(t, []*rtp.Packet{&in}, u.GetRTPPackets())
+				}
+
+				out = append(out, u.GetRTPPackets()...)
+				if f := u.(*unit.MJPEG).Frame; f != nil {
+					frame = f
+				}
+			}
+
+			require.NotNil(t, frame)
+			require.Equal(t, 640, p.width)
+			require.Equal(t, 480, p.height)
+
+			// RTSP readers receive packets that don't exceed the maximum size
+			dec := &rtpmjpeg.Decoder{}
+			require.NoError(t, dec.Init())
+
+			for i, pkt := range out {
+				require.LessOrEqual(t, pkt.MarshalSize(), 1472)
+				require.Equal(t, uint32(90000), pkt.Timestamp)
+
+				decoded, err := dec.Decode(pkt)
+				if i != len(out)-1 {
+					continue
+				}
+				require.NoError(t, err)
+				require.Equal(t, frame, decoded)
+			}
+		})
+	}
+}
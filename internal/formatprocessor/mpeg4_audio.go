@@ -4,7 +4,6 @@ import (
 	"XMedia/internal/logger"
 	"XMedia/internal/unit"
 	"errors"
-	"fmt"
 	"time"
 
 	"github.com/bluenviron/gortsplib/v4/pkg/format"
@@ -25,7 +24,7 @@ type mpeg4Audio struct {
 
 func (t *mpeg4Audio) initialize() error {
 	if t.GenerateRTPPackets {
-		err := t.createEncoder()
+		err := t.createEncoder(nil, nil)
 		if err != nil {
 			return err
 		}
@@ -38,13 +37,18 @@ func (t *mpeg4Audio) initialize() error {
 	return nil
 }
 
-func (t *mpeg4Audio) createEncoder() error {
+func (t *mpeg4Audio) createEncoder(
+	ssrc *uint32,
+	initialSequenceNumber *uint16,
+) error {
 	t.encoder = &rtpmpeg4audio.Encoder{
-		PayloadMaxSize:   t.UDPMaxPayloadSize - 12,
-		PayloadType:      t.Format.PayloadTyp,
-		SizeLength:       t.Format.SizeLength,
-		IndexLength:      t.Format.IndexLength,
-		IndexDeltaLength: t.Format.IndexDeltaLength,
+		PayloadMaxSize:        t.UDPMaxPayloadSize - 12,
+		PayloadType:           t.Format.PayloadTyp,
+		SizeLength:            t.Format.SizeLength,
+		IndexLength:           t.Format.IndexLength,
+		IndexDeltaLength:      t.Format.IndexDeltaLength,
+		SSRC:                  ssrc,
+		InitialSequenceNumber: initialSequenceNumber,
 	}
 	return t.encoder.Init()
 }
@@ -68,17 +72,26 @@ func (t *mpeg4Audio) ProcessRTPPacket(
 		},
 	}
 
-	// remove padding
-	pkt.Padding = false
-	pkt.PaddingSize = 0
+	if t.encoder == nil {
+		// remove padding
+		pkt.Padding = false
+		pkt.PaddingSize = 0
 
-	if pkt.MarshalSize() > t.UDPMaxPayloadSize {
-		return nil, fmt.Errorf("payload size (%d) is greater than maximum allowed (%d)",
-			pkt.MarshalSize(), t.UDPMaxPayloadSize)
+		// RTP packets exceed maximum size: start re-encoding them
+		if pkt.MarshalSize() > t.UDPMaxPayloadSize {
+			t.Parent.Log(logger.Info, "RTP packets are too big, remuxing them into smaller ones")
+
+			v1 := pkt.SSRC
+			v2 := pkt.SequenceNumber
+			err := t.createEncoder(&v1, &v2)
+			if err != nil {
+				return nil, err
+			}
+		}
 	}
 
 	// decode from RTP
-	if hasNonRTSPReaders || t.decoder != nil {
+	if hasNonRTSPReaders || t.decoder != nil || t.encoder != nil {
 		if t.decoder == nil {
 			var err error
 			t.decoder, err = t.Format.CreateDecoder()
@@ -88,6 +101,11 @@ func (t *mpeg4Audio) ProcessRTPPacket(
 		}
 
 		aus, err := t.decoder.Decode(pkt)
+
+		if t.encoder != nil {
+			u.RTPPackets = nil
+		}
+
 		if err != nil {
 			if errors.Is(err, rtpmpeg4audio.ErrMorePacketsNeeded) {
 				return u, nil
@@ -99,5 +117,23 @@ func (t *mpeg4Audio) ProcessRTPPacket(
 	}
 
 	// route packet as is
+	if t.encoder == nil {
+		return u, nil
+	}
+
+	// encode into RTP
+	if len(u.AUs) != 0 {
+		pkts, err := t.encoder.Encode(u.AUs)
+		if err != nil {
+			return nil, err
+		}
+		u.RTPPackets = pkts
+
+		// the encoder spaces batches by the AU duration, starting from zero
+		for _, newPKT := range u.RTPPackets {
+			newPKT.Timestamp += pkt.Timestamp
+		}
+	}
+
 	return u, nil
 }
@@ -0,0 +1,187 @@
+package formatprocessor
+
+import (
+	"XMedia/internal/unit"
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/bluenviron/gortsplib/v4/pkg/format/rtpfragmented"
+	"github.com/bluenviron/gortsplib/v4/pkg/format/rtpmpeg4audio"
+	"github.com/bluenviron/mediacommon/v2/pkg/codecs/mpeg4audio"
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMPEG4AudioProcessRTPPacket(t *testing.T) {
+	for _, ca := range []struct {
+		name  string
+		aus   [][]byte
+		reenc bool
+	}{
+		{
+			"packet routed as it is",
+			[][]byte{bytes.Repeat([]byte{1}, 200), bytes.Repeat([]byte{2}, 200)},
+			false,
+		},
+		{
+			"oversized packet with multiple AUs",
+			[][]byte{bytes.Repeat([]byte{1}, 1000), bytes.Repeat([]byte{2}, 1000), bytes.Repeat([]byte{3}, 1000)},
+			true,
+		},
+		{
+			"oversized AU",
+			[][]byte{bytes.Repeat([]byte{1}, 4000)},
+			true,
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			forma := &format.MPEG4Audio{
+				PayloadTyp: 96,
+				Config: &mpeg4audio.AudioSpecificConfig{
+					Type:         mpeg4audio.ObjectTypeAACLC,
+					SampleRate:   48000,
+					ChannelCount: 2,
+				},
+				SizeLength:       13,
+				IndexLength:      3,
+				IndexDeltaLength: 3,
+			}
+
+			p := &mpeg4Audio{
+				UDPMaxPayloadSize: 1472,
+				Format:            forma,
+				Parent:            nilLogger{},
+			}
+			require.NoError(t, p.initialize())
+
+			// the source doesn't limit the size of packets
+			enc := &rtpmpeg4audio.Encoder{
+				PayloadType:      96,
+				PayloadMaxSize:   10000,
+				SizeLength:       13,
+				IndexLength:      3,
+				IndexDeltaLength: 3,
+			}
+			require.NoError(t, enc.Init())
+
+			pkts, err := enc.Encode(ca.aus)
+			require.NoError(t, err)
+			require.Len(t, pkts, 1)
+			pkts[0].Timestamp = 48000
+			in := *pkts[0]
+
+			u, err := p.ProcessRTPPacket(pkts[0], time.Now(), 0, true)
+			require.NoError(t, err)
+			require.Equal(t, ca.aus, u.(*unit.MPEG4Audio).AUs)
+
+			out := u.GetRTPPackets()
+
+			if !ca.reenc {
+				require.Equal(t, []*rtp.Packet{&in}, out)
+				return
+			}
+
+			dec, err := forma.CreateDecoder()
+			require.NoError(t, err)
+
+			var aus [][]byte
+			for _, pkt := range out {
+				require.LessOrEqual(t, pkt.MarshalSize(), 1472)
+				require.GreaterOrEqual(t, pkt.Timestamp, uint32(48000))
+
+				decoded, err := dec.Decode(pkt)
+				if errors.Is(err, rtpmpeg4audio.ErrMorePacketsNeeded) {
+					continue
+				}
+				require.NoError(t, err)
+
+				// AUs are spaced by their duration
+				require.Equal(t, uint32(48000+len(aus)*mpeg4audio.SamplesPerAccessUnit), pkt.Timestamp)
+				aus = append(aus, decoded...)
+			}
+			require.Equal(t, ca.aus, aus)
+		})
+	}
+}
+
+func TestMPEG4AudioLATMProcessRTPPacket(t *testing.T) {
+	for _, ca := range []struct {
+		name    string
+		element []byte
+		reenc   bool
+	}{
+		{
+			"packet routed as it is",
+			bytes.Repeat([]byte{1}, 500),
+			false,
+		},
+		{
+			"oversized packet",
+			bytes.Repeat([]byte{1}, 4000),
+			true,
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			forma := &format.MPEG4AudioLATM{
+				PayloadTyp: 96,
+				StreamMuxConfig: &mpeg4audio.StreamMuxConfig{
+					Programs: []*mpeg4audio.StreamMuxConfigProgram{{
+						Layers: []*mpeg4audio.StreamMuxConfigLayer{{
+							AudioSpecificConfig: &mpeg4audio.AudioSpecificConfig{
+								Type:         mpeg4audio.ObjectTypeAACLC,
+								SampleRate:   48000,
+								ChannelCount: 2,
+							},
+							LatmBufferFullness: 255,
+						}},
+					}},
+				},
+			}
+
+			p := &mpeg4AudioLATM{
+				UDPMaxPayloadSize: 1472,
+				Format:            forma,
+				Parent:            nilLogger{},
+			}
+			require.NoError(t, p.initialize())
+
+			pkt := &rtp.Packet{
+				Header:  rtp.Header{Version: 2, Marker: true, PayloadType: 96, SequenceNumber: 100, Timestamp: 48000},
+				Payload: ca.element,
+			}
+			in := *pkt
+
+			u, err := p.ProcessRTPPacket(pkt, time.Now(), 0, true)
+			require.NoError(t, err)
+			require.Equal(t, ca.element, u.(*unit.MPEG4AudioLATM).Element)
+
+			out := u.GetRTPPackets()
+
+			if !ca.reenc {
+				require.Equal(t, []*rtp.Packet{&in}, out)
+				return
+			}
+
+			require.Greater(t, len(out), 1)
+
+			dec, err := forma.CreateDecoder()
+			require.NoError(t, err)
+
+			for i, pkt := range out {
+				require.LessOrEqual(t, pkt.MarshalSize(), 1472)
+				require.Equal(t, uint32(48000), pkt.Timestamp)
+
+				el, err := dec.Decode(pkt)
+				if i != len(out)-1 {
+					require.ErrorIs(t, err, rtpfragmented.ErrMorePacketsNeeded)
+					continue
+				}
+				require.NoError(t, err)
+				require.Equal(t, ca.element, el)
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package formatprocessor
+
+import (
+	"XMedia/internal/logger"
+	"XMedia/internal/unit"
+	"bytes"
+	"errors"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/bluenviron/gortsplib/v4/pkg/format/rtpmpeg4video"
+	"github.com/bluenviron/mediacommon/v2/pkg/codecs/mpeg4video"
+	"github.com/pion/rtp"
+)
+
+// extract the config (VOS + VO + VOL headers) that precedes the first GOV.
+func mpeg4VideoExtractConfig(payload []byte) []byte {
+	if !bytes.HasPrefix(payload, []byte{0, 0, 1, byte(mpeg4video.VisualObjectSequenceStartCode)}) {
+		return nil
+	}
+
+	end := bytes.Index(payload[4:], []byte{0, 0, 1, byte(mpeg4video.GroupOfVOPStartCode)})
+	if end < 0 {
+		return nil
+	}
+
+	return payload[:end+4]
+}
+
+type mpeg4Video struct {
+	UDPMaxPayloadSize  int
+	Format             *format.MPEG4Video
+	GenerateRTPPackets bool
+	Parent             logger.Writer
+
+	encoder     *rtpmpeg4video.Encoder
+	decoder     *rtpmpeg4video.Decoder
+	randomStart uint32
+}
+
+func (t *mpeg4Video) initialize() error {
+	if t.GenerateRTPPackets {
+		err := t.createEncoder(nil, nil)
+		if err != nil {
+			return err
+		}
+
+		t.randomStart, err = randUint32()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (t *mpeg4Video) createEncoder(
+	ssrc *uint32,
+	initialSequenceNumber *uint16,
+) error {
+	t.encoder = &rtpmpeg4video.Encoder{
+		PayloadMaxSize:        t.UDPMaxPayloadSize - 12,
+		PayloadType:           t.Format.PayloadTyp,
+		SSRC:                  ssrc,
+		InitialSequenceNumber: initialSequenceNumber,
+	}
+	return t.encoder.Init()
+}
+
+func (t *mpeg4Video) updateTrackParametersFromRTPPacket(payload []byte) {
+	config := mpeg4VideoExtractConfig(payload)
+
+	if config != nil && !bytes.Equal(config, t.Format.SafeParams()) {
+		t.Format.SafeSetParams(config)
+	}
+}
+
+// HasParameters implements ParametersChecker.
+func (t *mpeg4Video) HasParameters() bool {
+	return t.Format.SafeParams() != nil
+}
+
+func (t *mpeg4Video) ProcessUnit(unit.Unit) error {
+	return nil
+}
+
+// process a RTP packet and convert it into a unit.
+func (t *mpeg4Video) ProcessRTPPacket(
+	pkt *rtp.Packet,
+	ntp time.Time,
+	pts int64,
+	hasNonRTSPReaders bool,
+) (unit.Unit, error) {
+	u := &unit.MPEG4Video{
+		Base: unit.Base{
+			RTPPackets: []*rtp.Packet{pkt},
+			NTP:        ntp,
+			PTS:        pts,
+		},
+	}
+
+	t.updateTrackParametersFromRTPPacket(pkt.Payload)
+
+	if t.encoder == nil {
+		// remove padding
+		pkt.Padding = false
+		pkt.PaddingSize = 0
+
+		// RTP packets exceed maximum size: start re-encoding them
+		if pkt.MarshalSize() > t.UDPMaxPayloadSize {
+			t.Parent.Log(logger.Info, "RTP packets are too big, remuxing them into smaller ones")
+
+			v1 := pkt.SSRC
+			v2 := pkt.SequenceNumber
+			err := t.createEncoder(&v1, &v2)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// decode from RTP
+	if hasNonRTSPReaders || t.decoder != nil || t.encoder != nil {
+		if t.decoder == nil {
+			var err error
+			t.decoder, err = t.Format.CreateDecoder()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		frame, err := t.decoder.Decode(pkt)
+
+		if t.encoder != nil {
+			u.RTPPackets = nil
+		}
+
+		if err != nil {
+			if errors.Is(err, rtpmpeg4video.ErrMorePacketsNeeded) {
+				return u, nil
+			}
+			return nil, err
+		}
+
+		u.Frame = t.remuxFrame(frame)
+	}
+
+	// route packet as is
+	if t.encoder == nil {
+		return u, nil
+	}
+
+	// encode into RTP
+	if len(u.Frame) != 0 {
+		pkts, err := t.encoder.Encode(u.Frame)
+		if err != nil {
+			return nil, err
+		}
+		u.RTPPackets = pkts
+
+		for _, newPKT := range u.RTPPackets {
+			newPKT.Timestamp = pkt.Timestamp
+		}
+	}
+
+	return u, nil
+}
+
+// remove the config from frames and put it back in front of every GOV.
+func (t *mpeg4Video) remuxFrame(frame []byte) []byte {
+	if config := mpeg4VideoExtractConfig(frame); config != nil {
+		frame = frame[len(config):]
+	}
+
+	if bytes.Contains(frame, []byte{0, 0, 1, byte(mpeg4video.GroupOfVOPStartCode)}) {
+		config := t.Format.SafeParams()
+		f := make([]byte, len(config)+len(frame))
+		n := copy(f, config)
+		copy(f[n:], frame)
+		frame = f
+	}
+
+	if len(frame) == 0 {
+		return nil
+	}
+
+	return frame
+}
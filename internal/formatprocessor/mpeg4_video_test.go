@@ -0,0 +1,158 @@
+package formatprocessor
+
+import (
+	"XMedia/internal/unit"
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/bluenviron/gortsplib/v4/pkg/format/rtpmpeg4video"
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	testMPEG4VideoConfig = []byte{
+		0x00, 0x00, 0x01, 0xb0, 0x01, // visual object sequence
+		0x00, 0x00, 0x01, 0xb5, 0x89, 0x13, // visual object
+		0x00, 0x00, 0x01, 0x00, // video object
+		0x00, 0x00, 0x01, 0x20, 0x00, 0xc4, 0x8d, 0x88, // video object layer
+	}
+	testMPEG4VideoConfig2 = []byte{
+		0x00, 0x00, 0x01, 0xb0, 0x03,
+		0x00, 0x00, 0x01, 0xb5, 0x89, 0x13,
+		0x00, 0x00, 0x01, 0x00,
+		0x00, 0x00, 0x01, 0x20, 0x00, 0xc4, 0x8d, 0x89,
+	}
+	testMPEG4VideoGOV = []byte{0x00, 0x00, 0x01, 0xb3, 0x00, 0x10, 0x07}
+	testMPEG4VideoVOP = []byte{0x00, 0x00, 0x01, 0xb6, 0x12, 0x34}
+)
+
+func concatBytes(bufs ...[]byte) []byte {
+	return bytes.Join(bufs, nil)
+}
+
+func TestMPEG4VideoExtractConfig(t *testing.T) {
+	for _, ca := range []struct {
+		name    string
+		payload []byte
+		config  []byte
+	}{
+		{
+			"config and GOV",
+			concatBytes(testMPEG4VideoConfig, testMPEG4VideoGOV, testMPEG4VideoVOP),
+			testMPEG4VideoConfig,
+		},
+		{
+			"config without GOV",
+			testMPEG4VideoConfig,
+			nil,
+		},
+		{
+			"frame",
+			testMPEG4VideoVOP,
+			nil,
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			require.Equal(t, ca.config, mpeg4VideoExtractConfig(ca.payload))
+		})
+	}
+}
+
+func TestMPEG4VideoProcessRTPPacket(t *testing.T) {
+	for _, ca := range []struct {
+		name   string
+		config []byte
+		in     []byte
+		out    []byte
+		params []byte
+	}{
+		{
+			"in-band config",
+			nil,
+			concatBytes(testMPEG4VideoConfig, testMPEG4VideoGOV, testMPEG4VideoVOP),
+			concatBytes(testMPEG4VideoConfig, testMPEG4VideoGOV, testMPEG4VideoVOP),
+			testMPEG4VideoConfig,
+		},
+		{
+			"updated config",
+			testMPEG4VideoConfig,
+			concatBytes(testMPEG4VideoConfig2, testMPEG4VideoGOV, testMPEG4VideoVOP),
+			concatBytes(testMPEG4VideoConfig2, testMPEG4VideoGOV, testMPEG4VideoVOP),
+			testMPEG4VideoConfig2,
+		},
+		{
+			"config is prepended to GOVs",
+			testMPEG4VideoConfig,
+			concatBytes(testMPEG4VideoGOV, testMPEG4VideoVOP),
+			concatBytes(testMPEG4VideoConfig, testMPEG4VideoGOV, testMPEG4VideoVOP),
+			testMPEG4VideoConfig,
+		},
+		{
+			"frame without GOV",
+			testMPEG4VideoConfig,
+			testMPEG4VideoVOP,
+			testMPEG4VideoVOP,
+			testMPEG4VideoConfig,
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			forma := &format.MPEG4Video{PayloadTyp: 96, Config: ca.config}
+
+			p := &mpeg4Video{
+				UDPMaxPayloadSize: 1472,
+				Format:            forma,
+				Parent:            nilLogger{},
+			}
+			require.NoError(t, p.initialize())
+
+			u, err := p.ProcessRTPPacket(&rtp.Packet{
+				Header:  rtp.Header{Version: 2, Marker: true, PayloadType: 96, SequenceNumber: 100},
+				Payload: ca.in,
+			}, time.Now(), 0, true)
+			require.NoError(t, err)
+
+			require.Equal(t, ca.out, u.(*unit.MPEG4Video).Frame)
+			require.Equal(t, ca.params, forma.SafeParams())
+			require.Equal(t, ca.params != nil, p.HasParameters())
+		})
+	}
+}
+
+func TestMPEG4VideoOversizedPackets(t *testing.T) {
+	p := &mpeg4Video{
+		UDPMaxPayloadSize: 1472,
+		Format:            &format.MPEG4Video{PayloadTyp: 96, Config: testMPEG4VideoConfig},
+		Parent:            nilLogger{},
+	}
+	require.NoError(t, p.initialize())
+
+	frame := concatBytes(testMPEG4VideoVOP, bytes.Repeat([]byte{1}, 3000))
+
+	u, err := p.ProcessRTPPacket(&rtp.Packet{
+		Header:  rtp.Header{Version: 2, Marker: true, PayloadType: 96, SequenceNumber: 100, Timestamp: 3000},
+		Payload: frame,
+	}, time.Now(), 0, false)
+	require.NoError(t, err)
+
+	pkts := u.GetRTPPackets()
+	require.Greater(t, len(pkts), 1)
+
+	dec := &rtpmpeg4video.Decoder{}
+	require.NoError(t, dec.Init())
+
+	for i, pkt := range pkts {
+		require.LessOrEqual(t, pkt.MarshalSize(), 1472)
+		require.Equal(t, uint32(3000), pkt.Timestamp)
+
+		decoded, err := dec.Decode(pkt)
+		if i != len(pkts)-1 {
+			require.ErrorIs(t, err, rtpmpeg4video.ErrMorePacketsNeeded)
+			continue
+		}
+		require.NoError(t, err)
+		require.Equal(t, frame, decoded)
+	}
+}
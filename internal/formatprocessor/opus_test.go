@@ -0,0 +1,54 @@
+package formatprocessor
+
+import (
+	"XMedia/internal/unit"
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpusProcessRTPPacket(t *testing.T) {
+	for _, ca := range []struct {
+		name    string
+		payload []byte
+		err     bool
+	}{
+		{
+			"packet",
+			[]byte{0xfc, 1, 2, 3},
+			false,
+		},
+		{
+			"oversized packet",
+			bytes.Repeat([]byte{1}, 1500),
+			true,
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			p := &opus{
+				UDPMaxPayloadSize: 1472,
+				Format:            &format.Opus{PayloadTyp: 96, ChannelCount: 2},
+				Parent:            nilLogger{},
+			}
+			require.NoError(t, p.initialize())
+
+			pkt := &rtp.Packet{
+				Header:  rtp.Header{Version: 2, Marker: true, PayloadType: 96, SequenceNumber: 100},
+				Payload: ca.payload,
+			}
+
+			u, err := p.ProcessRTPPacket(pkt, time.Now(), 0, true)
+			if ca.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, []*rtp.Packet{pkt}, u.GetRTPPackets())
+			require.Equal(t, [][]byte{ca.payload}, u.(*unit.Opus).Packets)
+		})
+	}
+}
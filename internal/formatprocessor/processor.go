@@ -20,6 +20,101 @@ func randUint32() (uint32, error) {
 	return uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3]), nil
 }
 
+// H264Options are options of the H264 processor.
+type H264Options struct {
+	// insert an access unit delimiter at the beginning of every access unit.
+	InsertAUD bool
+	// remove SEI NALUs.
+	StripSEI bool
+	// send SPS and PPS before every IDR, also to RTSP readers.
+	RepeatParameters bool
+	// remove filler data NALUs.
+	DropFiller bool
+	// extract CEA-608/708 closed captions from SEI NALUs.
+	ExtractCaptions bool
+	// extract user_data_unregistered payloads from SEI NALUs.
+	ExtractUserData bool
+	// UUIDs of the user data payloads to extract. All payloads are extracted when empty.
+	UserDataUUIDs [][16]byte
+	// extract timecodes from pic_timing SEI NALUs.
+	ExtractTimecodes bool
+}
+
+// options that need SEI messages to be parsed.
+func (o H264Options) extractsSEI() bool {
+	return o.ExtractCaptions || o.ExtractUserData || o.ExtractTimecodes
+}
+
+// options that can only be applied by decoding and re-encoding RTP packets.
+func (o H264Options) rewritesBitstream() bool {
+	return o.InsertAUD || o.StripSEI || o.RepeatParameters || o.DropFiller
+}
+
+// Options contains codec-specific options of processors.
+type Options struct {
+	H264 H264Options
+}
+
+// TrackInfo contains properties of a track that are read from the bitstream.
+type TrackInfo struct {
+	Width        int
+	Height       int
+	Profile      string
+	Level        string
+	ChromaFormat string
+	FPS          float64
+}
+
+// TrackInfoProvider is implemented by processors that are able to read TrackInfo.
+type TrackInfoProvider interface {
+	// returns the track properties, if they are known.
+	TrackInfo() (TrackInfo, bool)
+}
+
+// UserDataSample is the latest SEI user data payload with a given UUID.
+type UserDataSample struct {
+	UUID    [16]byte
+	Payload []byte
+	NTP     time.Time
+}
+
+// UserDataProvider is implemented by processors that are able to extract SEI user data.
+type UserDataProvider interface {
+	// returns the latest payload of every UUID.
+	LatestUserData() []UserDataSample
+}
+
+// TimecodeSample is the latest timecode of a track.
+type TimecodeSample struct {
+	Timecode unit.Timecode
+	NTP      time.Time
+}
+
+// TimecodeProvider is implemented by processors that are able to extract timecodes.
+type TimecodeProvider interface {
+	// returns the latest timecode, if any has been received.
+	LatestTimecode() (TimecodeSample, bool)
+}
+
+// SEIInjector is implemented by processors that are able to insert SEI NALUs into access units.
+type SEIInjector interface {
+	// queue a user_data_unregistered payload, that is inserted into the next access unit.
+	InjectSEIUserData(uuid [16]byte, payload []byte)
+}
+
+// TelemetryProvider is implemented by processors that are able to decode telemetry.
+type TelemetryProvider interface {
+	// returns the latest telemetry, if any has been received.
+	LatestTelemetry() (KLVTelemetry, bool)
+}
+
+// ParametersChecker is implemented by processors of codecs that need parameters
+// (SPS, PPS, config) to be decoded.
+type ParametersChecker interface {
+	// returns whether all the parameters needed to decode the track are known.
+	HasParameters() bool
+}
+
 // Processor is the codec-dependent part of the processing that happens inside stream.Stream.
 type Processor interface {
 	// process a Unit.
@@ -41,6 +136,7 @@ func New(
 	udpMaxPayloadSize int,
 	forma format.Format,
 	generateRTPPackets bool,
+	options Options,
 	parent logger.Writer,
 ) (Processor, error) {
 	var proc Processor
@@ -49,6 +145,15 @@ func New(
 
 	case *format.H264:
 		proc = &h264{
+			UDPMaxPayloadSize:  udpMaxPayloadSize,
+			Format:             forma,
+			GenerateRTPPackets: generateRTPPackets,
+			Options:            options.H264,
+			Parent:             parent,
+		}
+
+	case *format.H265:
+		proc = &h265{
 			UDPMaxPayloadSize:  udpMaxPayloadSize,
 			Format:             forma,
 			GenerateRTPPackets: generateRTPPackets,
@@ -62,6 +167,55 @@ func New(
 			GenerateRTPPackets: generateRTPPackets,
 			Parent:             parent,
 		}
+
+	case *format.MPEG4AudioLATM:
+		proc = &mpeg4AudioLATM{
+			UDPMaxPayloadSize:  udpMaxPayloadSize,
+			Format:             forma,
+			GenerateRTPPackets: generateRTPPackets,
+			Parent:             parent,
+		}
+
+	case *format.MJPEG:
+		proc = &mjpeg{
+			UDPMaxPayloadSize:  udpMaxPayloadSize,
+			Format:             forma,
+			GenerateRTPPackets: generateRTPPackets,
+			Parent:             parent,
+		}
+
+	case *format.MPEG4Video:
+		proc = &mpeg4Video{
+			UDPMaxPayloadSize:  udpMaxPayloadSize,
+			Format:             forma,
+			GenerateRTPPackets: generateRTPPackets,
+			Parent:             parent,
+		}
+
+	case *format.Opus:
+		proc = &opus{
+			UDPMaxPayloadSize:  udpMaxPayloadSize,
+			Format:             forma,
+			GenerateRTPPackets: generateRTPPackets,
+			Parent:             parent,
+		}
+
+	case *format.G711:
+		proc = &g711{
+			UDPMaxPayloadSize:  udpMaxPayloadSize,
+			Format:             forma,
+			GenerateRTPPackets: generateRTPPackets,
+			Parent:             parent,
+		}
+
+	case *format.KLV:
+		proc = &klv{
+			UDPMaxPayloadSize:  udpMaxPayloadSize,
+			Format:             forma,
+			GenerateRTPPackets: generateRTPPackets,
+			Parent:             parent,
+		}
+
 	default:
 		// proc = &generic{
 		// 	UDPMaxPayloadSize:  udpMaxPayloadSize,
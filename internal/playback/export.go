@@ -0,0 +1,501 @@
+package playback
+
+import (
+	"XMedia/internal/conf"
+	"XMedia/internal/logger"
+	"XMedia/internal/recordstore"
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var errExportNotFound = errors.New("export not found")
+
+var errExportTooMany = errors.New("too many exports, delete finished ones first")
+
+const (
+	// maximum number of jobs, finished ones included.
+	exportMaxJobs = 100
+	// maximum duration of a clip.
+	exportMaxDuration = 24 * time.Hour
+	// time after which finished jobs are deleted, together with their files.
+	exportRetention = 7 * 24 * time.Hour
+	// interval between two checks of expired jobs.
+	exportExpireInterval = 1 * time.Minute
+)
+
+type exportState string
+
+const (
+	exportStatePending exportState = "pending"
+	exportStateRunning exportState = "running"
+	exportStateDone    exportState = "done"
+	exportStateError   exportState = "error"
+)
+
+// exportJob exports a time range of the recordings of a path into a progressive MP4 file.
+type exportJob struct {
+	ID      string    `json:"id"`
+	Path    string    `json:"path"`
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	Created time.Time `json:"created"`
+	// time at which the job has been completed or has failed.
+	Finished *time.Time  `json:"finished,omitempty"`
+	State    exportState `json:"state"`
+	Progress float64     `json:"progress"`
+	// duration of the clip, that is shorter than the requested one
+	// when recordings end or have a gap.
+	Duration float64 `json:"duration"`
+	Size     int64   `json:"size"`
+	Error    string  `json:"error,omitempty"`
+}
+
+type exportJobList struct {
+	Items []*exportJob `json:"items"`
+}
+
+type exporterParent interface {
+	logger.Writer
+	findPathConf(pathName string) (*conf.Path, error)
+}
+
+// exporter runs export jobs, one at a time.
+// Jobs are stored in a directory, next to the files they produce,
+// and interrupted jobs are started again after a restart.
+// Finished jobs are deleted after a retention period.
+type exporter struct {
+	Path        string
+	MaxJobs     int
+	MaxDuration time.Duration
+	Retention   time.Duration
+	Parent      exporterParent
+
+	ctx           context.Context
+	ctxCancel     func()
+	mutex         sync.Mutex
+	jobs          map[string]*exportJob
+	runningCancel func()
+
+	chNew chan struct{}
+	done  chan struct{}
+}
+
+func (e *exporter) initialize() error {
+	err := os.MkdirAll(e.Path, 0o755)
+	if err != nil {
+		return err
+	}
+
+	e.ctx, e.ctxCancel = context.WithCancel(context.Background())
+	e.jobs = make(map[string]*exportJob)
+	e.chNew = make(chan struct{}, 1)
+	e.done = make(chan struct{})
+
+	err = e.load()
+	if err != nil {
+		e.ctxCancel()
+		return err
+	}
+
+	go e.run()
+
+	return nil
+}
+
+func (e *exporter) close() {
+	e.ctxCancel()
+	<-e.done
+}
+
+// load reads the jobs of a previous run.
+func (e *exporter) load() error {
+	entries, err := os.ReadDir(e.Path)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+
+		// files of jobs that were interrupted
+		if strings.HasSuffix(name, ".tmp") || strings.HasSuffix(name, ".spool") {
+			os.Remove(filepath.Join(e.Path, name))
+			continue
+		}
+
+		if filepath.Ext(name) != ".json" {
+			continue
+		}
+
+		buf, err := os.ReadFile(filepath.Join(e.Path, name))
+		if err != nil {
+			return err
+		}
+
+		var job exportJob
+		err = json.Unmarshal(buf, &job)
+		if err != nil || job.ID+".json" != name {
+			e.Log(logger.Warn, "skipping invalid job file %s", name)
+			continue
+		}
+
+		if job.State == exportStateRunning {
+			job.State = exportStatePending
+			job.Progress = 0
+		}
+
+		if (job.State == exportStateDone || job.State == exportStateError) && job.Finished == nil {
+			job.Finished = &job.Created
+		}
+
+		e.jobs[job.ID] = &job
+	}
+
+	return nil
+}
+
+// Log implements logger.Writer.
+func (e *exporter) Log(level logger.Level, format string, args ...interface{}) {
+	e.Parent.Log(level, "[export] "+format, args...)
+}
+
+func (e *exporter) jobPath(id string) string {
+	return filepath.Join(e.Path, id+".json")
+}
+
+func (e *exporter) filePath(id string) string {
+	return filepath.Join(e.Path, id+".mp4")
+}
+
+// save writes a job into its file. It must be called with the mutex locked.
+func (e *exporter) save(job *exportJob) {
+	buf, err := json.Marshal(job)
+	if err != nil {
+		return
+	}
+
+	tmpPath := e.jobPath(job.ID) + ".tmp"
+
+	err = os.WriteFile(tmpPath, buf, 0o644)
+	if err == nil {
+		err = os.Rename(tmpPath, e.jobPath(job.ID))
+	}
+	if err != nil {
+		e.Log(logger.Error, "unable to save job %s: %v", job.ID, err)
+	}
+}
+
+func (e *exporter) create(pathName string, start time.Time, end time.Time) (*exportJob, error) {
+	_, err := e.Parent.findPathConf(pathName)
+	if err != nil {
+		return nil, err
+	}
+
+	if !end.After(start) {
+		return nil, fmt.Errorf("end must be after start")
+	}
+
+	if end.Sub(start) > e.MaxDuration {
+		return nil, fmt.Errorf("duration of the clip exceeds %v", e.MaxDuration)
+	}
+
+	var id [8]byte
+	_, err = rand.Read(id[:])
+	if err != nil {
+		return nil, err
+	}
+
+	job := &exportJob{
+		ID:      hex.EncodeToString(id[:]),
+		Path:    pathName,
+		Start:   start,
+		End:     end,
+		Created: time.Now(),
+		State:   exportStatePending,
+	}
+
+	e.expire()
+
+	e.mutex.Lock()
+	if len(e.jobs) >= e.MaxJobs {
+		e.mutex.Unlock()
+		return nil, errExportTooMany
+	}
+	e.jobs[job.ID] = job
+	e.save(job)
+	cpy := *job
+	e.mutex.Unlock()
+
+	e.Log(logger.Info, "job %s created, path '%s', from %v to %v", job.ID, pathName, start, end)
+
+	select {
+	case e.chNew <- struct{}{}:
+	default:
+	}
+
+	return &cpy, nil
+}
+
+func (e *exporter) list() []*exportJob {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	out := make([]*exportJob, 0, len(e.jobs))
+	for _, job := range e.jobs {
+		cpy := *job
+		out = append(out, &cpy)
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Created.Before(out[j].Created)
+	})
+
+	return out
+}
+
+func (e *exporter) get(id string) (*exportJob, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	job, ok := e.jobs[id]
+	if !ok {
+		return nil, errExportNotFound
+	}
+
+	cpy := *job
+	return &cpy, nil
+}
+
+// delete deletes a job and its file. A running job is stopped.
+func (e *exporter) delete(id string) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	_, ok := e.jobs[id]
+	if !ok {
+		return errExportNotFound
+	}
+
+	e.remove(id)
+
+	e.Log(logger.Info, "job %s deleted", id)
+
+	return nil
+}
+
+// remove removes a job and its file. It must be called with the mutex locked.
+func (e *exporter) remove(id string) {
+	if e.jobs[id].State == exportStateRunning {
+		e.runningCancel()
+	}
+
+	delete(e.jobs, id)
+	os.Remove(e.jobPath(id))
+	os.Remove(e.filePath(id))
+}
+
+// expire removes finished jobs whose retention period is over.
+func (e *exporter) expire() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	now := time.Now()
+
+	for id, job := range e.jobs {
+		if job.Finished != nil && now.Sub(*job.Finished) >= e.Retention {
+			e.remove(id)
+			e.Log(logger.Info, "job %s expired", id)
+		}
+	}
+}
+
+// next returns the oldest pending job and marks it as running.
+func (e *exporter) next() (*exportJob, context.Context) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	var next *exportJob
+	for _, job := range e.jobs {
+		if job.State == exportStatePending && (next == nil || job.Created.Before(next.Created)) {
+			next = job
+		}
+	}
+
+	if next == nil {
+		return nil, nil
+	}
+
+	next.State = exportStateRunning
+	e.save(next)
+
+	var ctx context.Context
+	ctx, e.runningCancel = context.WithCancel(e.ctx)
+
+	return next, ctx
+}
+
+func (e *exporter) run() {
+	defer close(e.done)
+
+	for {
+		e.expire()
+
+		job, ctx := e.next()
+		if job == nil {
+			select {
+			case <-e.chNew:
+				continue
+			case <-time.After(exportExpireInterval):
+				continue
+			case <-e.ctx.Done():
+				return
+			}
+		}
+
+		e.Log(logger.Info, "job %s started", job.ID)
+
+		duration, size, err := e.export(ctx, job)
+
+		// jobs interrupted by shutdown are started again after a restart
+		if e.ctx.Err() != nil {
+			return
+		}
+
+		e.mutex.Lock()
+
+		// job has been deleted
+		if _, ok := e.jobs[job.ID]; !ok {
+			e.mutex.Unlock()
+			os.Remove(e.filePath(job.ID))
+			continue
+		}
+
+		now := time.Now()
+		job.Finished = &now
+
+		if err != nil {
+			job.State = exportStateError
+			job.Error = err.Error()
+			e.Log(logger.Error, "job %s failed: %v", job.ID, err)
+		} else {
+			job.State = exportStateDone
+			job.Progress = 1
+			job.Duration = duration.Seconds()
+			job.Size = size
+			e.Log(logger.Info, "job %s completed, %v exported", job.ID, duration.Truncate(time.Millisecond))
+		}
+
+		e.runningCancel()
+		e.save(job)
+		e.mutex.Unlock()
+	}
+}
+
+func (e *exporter) setProgress(job *exportJob, progress float64) {
+	e.mutex.Lock()
+	job.Progress = progress
+	e.mutex.Unlock()
+}
+
+// export writes the clip of a job into a temporary file, that is renamed when complete.
+// Payloads of samples are spooled on disk, since they can't be written before the moov box.
+func (e *exporter) export(ctx context.Context, job *exportJob) (time.Duration, int64, error) {
+	pconf, err := e.Parent.findPathConf(job.Path)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	segments, err := recordstore.FindSegments(pconf.RecordPath, job.Path, ".mp4")
+	if err != nil {
+		return 0, 0, err
+	}
+
+	spool, err := os.Create(filepath.Join(e.Path, job.ID+".spool"))
+	if err != nil {
+		return 0, 0, err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	tmpPath := e.filePath(job.ID) + ".tmp"
+
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer os.Remove(tmpPath)
+	defer f.Close()
+
+	bw := bufio.NewWriterSize(f, 1024*1024)
+	total := job.End.Sub(job.Start)
+	exported := time.Duration(0)
+
+	sk := &seeker{
+		start:    job.Start,
+		duration: total,
+		keys:     pconf.RecordEncryptionKeys,
+		m:        &muxerMP4{w: bw, spool: spool},
+		onProgress: func(pos time.Duration) error {
+			err := ctx.Err()
+			if err != nil {
+				return err
+			}
+
+			if pos > exported {
+				exported = min(pos, total)
+				e.setProgress(job, float64(exported)/float64(total))
+			}
+
+			return nil
+		},
+	}
+	sk.initialize()
+
+	err = sk.seekAndMux(segments)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	err = bw.Flush()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	size, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if size == 0 {
+		return 0, 0, recordstore.ErrNoSegmentsFound
+	}
+
+	err = f.Sync()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	err = f.Close()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	err = os.Rename(tmpPath, e.filePath(job.ID))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return exported, size, nil
+}
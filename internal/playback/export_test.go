@@ -0,0 +1,288 @@
+package playback
+
+import (
+	"XMedia/internal/conf"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newTestExporter returns an exporter that doesn't run jobs,
+// with a limit of 2 jobs and clips of 1 minute.
+func newTestExporter(t *testing.T) *exporter {
+	e := &exporter{
+		Path:        t.TempDir(),
+		MaxJobs:     2,
+		MaxDuration: time.Minute,
+		Retention:   time.Hour,
+		Parent: &Server{
+			PathConfs: map[string]*conf.Path{
+				"mypath": {
+					Name:         "mypath",
+					Record:       true,
+					RecordFormat: "fmp4",
+				},
+			},
+			PathDefaults: &conf.Path{},
+			Parent:       nilLogger{},
+		},
+	}
+
+	e.ctx, e.ctxCancel = context.WithCancel(context.Background())
+	t.Cleanup(e.ctxCancel)
+	e.jobs = make(map[string]*exportJob)
+	e.chNew = make(chan struct{}, 1)
+
+	return e
+}
+
+func writeTestJob(t *testing.T, dir string, job *exportJob) {
+	buf, err := json.Marshal(job)
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(dir, job.ID+".json"), buf, 0o644)
+	require.NoError(t, err)
+}
+
+func TestExport(t *testing.T) {
+	ts := newTestServer(t)
+
+	status, body := ts.do(t, http.MethodPost, "/exports?"+url.Values{
+		"path":  {"encrypted"},
+		"start": {testStart.Add(1500 * time.Millisecond).Format(time.RFC3339Nano)},
+		"end":   {testStart.Add(2500 * time.Millisecond).Format(time.RFC3339Nano)},
+	}.Encode())
+	require.Equal(t, http.StatusOK, status)
+
+	var job exportJob
+	err := json.Unmarshal(body, &job)
+	require.NoError(t, err)
+	require.Equal(t, exportStatePending, job.State)
+
+	// job is persisted
+	_, err = os.Stat(filepath.Join(ts.s.ExportPath, job.ID+".json"))
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		status, body = ts.get(t, "/exports/"+job.ID)
+		require.Equal(t, http.StatusOK, status)
+		err = json.Unmarshal(body, &job)
+		require.NoError(t, err)
+		return job.State != exportStatePending && job.State != exportStateRunning
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.Equal(t, exportStateDone, job.State)
+	require.Equal(t, float64(1), job.Progress)
+	require.Equal(t, float64(1), job.Duration)
+	require.NotNil(t, job.Finished)
+
+	status, body = ts.get(t, "/exports")
+	require.Equal(t, http.StatusOK, status)
+
+	var list exportJobList
+	err = json.Unmarshal(body, &list)
+	require.NoError(t, err)
+	require.Len(t, list.Items, 1)
+	require.Equal(t, job.ID, list.Items[0].ID)
+
+	// the clip is decrypted and has the moov box at the front
+	status, body = ts.get(t, "/exports/"+job.ID+"/download")
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, job.Size, int64(len(body)))
+	require.Equal(t, []string{"ftyp", "moov", "mdat"}, topLevelBoxes(t, body))
+
+	status, _ = ts.do(t, http.MethodDelete, "/exports/"+job.ID)
+	require.Equal(t, http.StatusOK, status)
+
+	status, _ = ts.get(t, "/exports/"+job.ID)
+	require.Equal(t, http.StatusNotFound, status)
+
+	entries, err := os.ReadDir(ts.s.ExportPath)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
+
+func TestExportErrors(t *testing.T) {
+	ts := newTestServer(t)
+
+	start := testStart.Format(time.RFC3339Nano)
+
+	for _, ca := range []struct {
+		name   string
+		method string
+		path   string
+		status int
+		err    string
+	}{
+		{
+			"end before start",
+			http.MethodPost,
+			"/exports?" + url.Values{"path": {"plain"}, "start": {start}, "end": {start}}.Encode(),
+			http.StatusBadRequest,
+			"end must be after start",
+		},
+		{
+			"clip too long",
+			http.MethodPost,
+			"/exports?" + url.Values{
+				"path":  {"plain"},
+				"start": {start},
+				"end":   {testStart.Add(25 * time.Hour).Format(time.RFC3339Nano)},
+			}.Encode(),
+			http.StatusBadRequest,
+			"duration of the clip exceeds 24h0m0s",
+		},
+		{
+			"path not recorded",
+			http.MethodPost,
+			"/exports?" + url.Values{"path": {"notrecorded"}, "start": {start}, "end": {start}}.Encode(),
+			http.StatusBadRequest,
+			"path 'notrecorded' is not recorded",
+		},
+		{
+			"unknown job",
+			http.MethodGet,
+			"/exports/0011223344556677",
+			http.StatusNotFound,
+			"export not found",
+		},
+		{
+			"delete unknown job",
+			http.MethodDelete,
+			"/exports/0011223344556677",
+			http.StatusNotFound,
+			"export not found",
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			status, body := ts.do(t, ca.method, ca.path)
+			require.Equal(t, ca.status, status)
+
+			var res struct {
+				Error string `json:"error"`
+			}
+			err := json.Unmarshal(body, &res)
+			require.NoError(t, err)
+			require.Equal(t, ca.err, res.Error)
+		})
+	}
+}
+
+func TestExporterLoad(t *testing.T) {
+	e := newTestExporter(t)
+
+	created := time.Date(2008, 5, 20, 22, 15, 25, 0, time.UTC)
+
+	writeTestJob(t, e.Path, &exportJob{
+		ID:       "0000000000000001",
+		Path:     "mypath",
+		Created:  created,
+		State:    exportStateRunning,
+		Progress: 0.5,
+	})
+	writeTestJob(t, e.Path, &exportJob{
+		ID:      "0000000000000002",
+		Path:    "mypath",
+		Created: created,
+		State:   exportStateDone,
+	})
+
+	// file name doesn't match the job
+	writeTestJob(t, e.Path, &exportJob{ID: "0000000000000003"})
+	err := os.Rename(filepath.Join(e.Path, "0000000000000003.json"), filepath.Join(e.Path, "other.json"))
+	require.NoError(t, err)
+
+	// files of the interrupted job
+	for _, name := range []string{"0000000000000001.mp4.tmp", "0000000000000001.spool"} {
+		err = os.WriteFile(filepath.Join(e.Path, name), []byte{1}, 0o644)
+		require.NoError(t, err)
+	}
+
+	err = e.load()
+	require.NoError(t, err)
+
+	jobs := e.list()
+	require.Len(t, jobs, 2)
+
+	// running jobs are started again
+	require.Equal(t, exportStatePending, jobs[0].State)
+	require.Equal(t, float64(0), jobs[0].Progress)
+
+	// jobs finished before the retention was introduced expire after their creation
+	require.Equal(t, exportStateDone, jobs[1].State)
+	require.True(t, jobs[1].Finished.Equal(created))
+
+	for _, name := range []string{"0000000000000001.mp4.tmp", "0000000000000001.spool"} {
+		_, err = os.Stat(filepath.Join(e.Path, name))
+		require.ErrorIs(t, err, os.ErrNotExist)
+	}
+}
+
+func TestExporterDeleteRunning(t *testing.T) {
+	e := newTestExporter(t)
+
+	job, err := e.create("mypath", testStart, testStart.Add(time.Second))
+	require.NoError(t, err)
+
+	running, ctx := e.next()
+	require.Equal(t, job.ID, running.ID)
+
+	err = os.WriteFile(e.filePath(job.ID), []byte{1}, 0o644)
+	require.NoError(t, err)
+
+	err = e.delete(job.ID)
+	require.NoError(t, err)
+
+	// the export is stopped
+	require.Error(t, ctx.Err())
+
+	_, err = e.get(job.ID)
+	require.ErrorIs(t, err, errExportNotFound)
+
+	entries, err := os.ReadDir(e.Path)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
+
+func TestExporterLimits(t *testing.T) {
+	e := newTestExporter(t)
+
+	_, err := e.create("mypath", testStart, testStart.Add(2*time.Minute))
+	require.EqualError(t, err, "duration of the clip exceeds 1m0s")
+
+	job1, err := e.create("mypath", testStart, testStart.Add(time.Minute))
+	require.NoError(t, err)
+
+	_, err = e.create("mypath", testStart, testStart.Add(time.Minute))
+	require.NoError(t, err)
+
+	_, err = e.create("mypath", testStart, testStart.Add(time.Minute))
+	require.ErrorIs(t, err, errExportTooMany)
+
+	// the first job finishes, then expires
+	e.mutex.Lock()
+	finished := time.Now().Add(-2 * time.Hour)
+	e.jobs[job1.ID].State = exportStateDone
+	e.jobs[job1.ID].Finished = &finished
+	e.mutex.Unlock()
+
+	err = os.WriteFile(e.filePath(job1.ID), []byte{1}, 0o644)
+	require.NoError(t, err)
+
+	_, err = e.create("mypath", testStart, testStart.Add(time.Minute))
+	require.NoError(t, err)
+
+	_, err = e.get(job1.ID)
+	require.ErrorIs(t, err, errExportNotFound)
+
+	for _, fpath := range []string{e.jobPath(job1.ID), e.filePath(job1.ID)} {
+		_, err = os.Stat(fpath)
+		require.ErrorIs(t, err, os.ErrNotExist)
+	}
+}
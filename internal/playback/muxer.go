@@ -0,0 +1,17 @@
+package playback
+
+import (
+	"github.com/bluenviron/mediacommon/v2/pkg/formats/fmp4"
+)
+
+// muxer writes samples read from segments into the output format.
+// Timestamps are relative to the requested start, and can be negative
+// for samples that are needed to decode the first frame.
+type muxer interface {
+	writeInit(init *fmp4.Init)
+	setTrack(trackID int)
+	writeSample(dts int64, duration uint32, ptsOffset int32, isNonSyncSample bool, payload []byte) error
+	// called at the end of every part of the input segments.
+	endPart() error
+	flush() error
+}
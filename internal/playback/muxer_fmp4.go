@@ -0,0 +1,132 @@
+package playback
+
+import (
+	"io"
+
+	"github.com/bluenviron/mediacommon/v2/pkg/formats/fmp4"
+	"github.com/bluenviron/mediacommon/v2/pkg/formats/fmp4/seekablebuffer"
+)
+
+// muxerFMP4 writes a fragmented MP4 file, part by part.
+// Samples that precede the start are placed at the start, with zero duration,
+// since fragmented MP4 files don't have edit lists.
+type muxerFMP4 struct {
+	w io.Writer
+
+	init               *fmp4.Init
+	initWritten        bool
+	nextSequenceNumber uint32
+	partTracks         []*fmp4.PartTrack
+	curTrackID         int
+	curTrack           *fmp4.PartTrack
+	curTrackEnd        int64
+}
+
+func (m *muxerFMP4) writeInit(init *fmp4.Init) {
+	m.init = init
+}
+
+func (m *muxerFMP4) setTrack(trackID int) {
+	m.curTrackID = trackID
+	m.curTrack = nil
+
+	for _, pt := range m.partTracks {
+		if pt.ID == trackID {
+			m.curTrack = pt
+			m.curTrackEnd = int64(pt.BaseTime)
+			for _, sample := range pt.Samples {
+				m.curTrackEnd += int64(sample.Duration)
+			}
+			break
+		}
+	}
+}
+
+func (m *muxerFMP4) writeSample(
+	dts int64,
+	duration uint32,
+	ptsOffset int32,
+	isNonSyncSample bool,
+	payload []byte,
+) error {
+	end := dts + int64(duration)
+	if dts < 0 {
+		dts = 0
+	}
+	if end < dts {
+		end = dts
+	}
+
+	// a discontinuity can't be represented inside a track fragment
+	if m.curTrack != nil && m.curTrackEnd != dts {
+		err := m.endPart()
+		if err != nil {
+			return err
+		}
+	}
+
+	if m.curTrack == nil {
+		m.curTrack = &fmp4.PartTrack{
+			ID:       m.curTrackID,
+			BaseTime: uint64(dts),
+		}
+		m.partTracks = append(m.partTracks, m.curTrack)
+	}
+
+	m.curTrack.Samples = append(m.curTrack.Samples, &fmp4.Sample{
+		Duration:        uint32(end - dts),
+		PTSOffset:       ptsOffset,
+		IsNonSyncSample: isNonSyncSample,
+		Payload:         payload,
+	})
+	m.curTrackEnd = end
+
+	return nil
+}
+
+func (m *muxerFMP4) endPart() error {
+	if !m.initWritten {
+		var buf seekablebuffer.Buffer
+		err := m.init.Marshal(&buf)
+		if err != nil {
+			return err
+		}
+
+		_, err = m.w.Write(buf.Bytes())
+		if err != nil {
+			return err
+		}
+
+		m.initWritten = true
+	}
+
+	if len(m.partTracks) == 0 {
+		return nil
+	}
+
+	part := &fmp4.Part{
+		SequenceNumber: m.nextSequenceNumber,
+		Tracks:         m.partTracks,
+	}
+	m.nextSequenceNumber++
+
+	var buf seekablebuffer.Buffer
+	err := part.Marshal(&buf)
+	if err != nil {
+		return err
+	}
+
+	_, err = m.w.Write(buf.Bytes())
+	if err != nil {
+		return err
+	}
+
+	m.partTracks = nil
+	m.curTrack = nil
+
+	return nil
+}
+
+func (m *muxerFMP4) flush() error {
+	return m.endPart()
+}
@@ -0,0 +1,125 @@
+package playback
+
+import (
+	"io"
+	"os"
+
+	"github.com/bluenviron/mediacommon/v2/pkg/formats/fmp4"
+	"github.com/bluenviron/mediacommon/v2/pkg/formats/pmp4"
+)
+
+type muxerMP4Track struct {
+	pmp4.Track
+	lastDTS int64
+}
+
+// muxerMP4 writes a progressive MP4 file, with the moov box before the media data.
+// Samples are kept in memory until the end, then written all at once.
+// Samples that precede the start are hidden by an edit list.
+type muxerMP4 struct {
+	w io.Writer
+	// when set, payloads are stored here instead of memory.
+	spool *os.File
+
+	spoolSize int64
+
+	tracks   []*muxerMP4Track
+	curTrack *muxerMP4Track
+}
+
+func (m *muxerMP4) writeInit(init *fmp4.Init) {
+	m.tracks = make([]*muxerMP4Track, len(init.Tracks))
+
+	for i, track := range init.Tracks {
+		m.tracks[i] = &muxerMP4Track{
+			Track: pmp4.Track{
+				ID:        track.ID,
+				TimeScale: track.TimeScale,
+				Codec:     track.Codec,
+			},
+		}
+	}
+}
+
+func (m *muxerMP4) setTrack(trackID int) {
+	m.curTrack = nil
+
+	for _, track := range m.tracks {
+		if track.ID == trackID {
+			m.curTrack = track
+			break
+		}
+	}
+}
+
+func (m *muxerMP4) writeSample(
+	dts int64,
+	duration uint32,
+	ptsOffset int32,
+	isNonSyncSample bool,
+	payload []byte,
+) error {
+	t := m.curTrack
+	if t == nil {
+		return nil
+	}
+
+	if len(t.Samples) == 0 {
+		t.TimeOffset = int32(dts)
+	} else if dts > t.lastDTS {
+		// gaps between segments are filled by extending the previous sample
+		t.Samples[len(t.Samples)-1].Duration = uint32(dts - t.lastDTS)
+	}
+
+	getPayload := func() ([]byte, error) {
+		return payload, nil
+	}
+
+	if m.spool != nil {
+		offset := m.spoolSize
+		size := len(payload)
+
+		_, err := m.spool.WriteAt(payload, offset)
+		if err != nil {
+			return err
+		}
+		m.spoolSize += int64(size)
+
+		getPayload = func() ([]byte, error) {
+			buf := make([]byte, size)
+			_, err := m.spool.ReadAt(buf, offset)
+			return buf, err
+		}
+	}
+
+	t.Samples = append(t.Samples, &pmp4.Sample{
+		Duration:        duration,
+		PTSOffset:       ptsOffset,
+		IsNonSyncSample: isNonSyncSample,
+		PayloadSize:     uint32(len(payload)),
+		GetPayload:      getPayload,
+	})
+	t.lastDTS = dts
+
+	return nil
+}
+
+func (m *muxerMP4) endPart() error {
+	return nil
+}
+
+func (m *muxerMP4) flush() error {
+	var pres pmp4.Presentation
+
+	for _, track := range m.tracks {
+		if len(track.Samples) != 0 {
+			pres.Tracks = append(pres.Tracks, &track.Track)
+		}
+	}
+
+	if len(pres.Tracks) == 0 {
+		return nil
+	}
+
+	return pres.Marshal(m.w)
+}
@@ -0,0 +1,85 @@
+package playback
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+func (s *Server) onExportCreate(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	start, err := time.Parse(time.RFC3339Nano, q.Get("start"))
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid start: %w", err))
+		return
+	}
+
+	end, err := time.Parse(time.RFC3339Nano, q.Get("end"))
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid end: %w", err))
+		return
+	}
+
+	job, err := s.exporter.create(q.Get("path"), start, end)
+	if err != nil {
+		if errors.Is(err, errExportTooMany) {
+			s.writeError(w, http.StatusTooManyRequests, err)
+		} else {
+			s.writeError(w, http.StatusBadRequest, err)
+		}
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, job)
+}
+
+func (s *Server) onExportList(w http.ResponseWriter, _ *http.Request) {
+	s.writeJSON(w, http.StatusOK, &exportJobList{Items: s.exporter.list()})
+}
+
+func (s *Server) onExportGet(w http.ResponseWriter, r *http.Request) {
+	job, err := s.exporter.get(r.PathValue("id"))
+	if err != nil {
+		s.writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, job)
+}
+
+func (s *Server) onExportDownload(w http.ResponseWriter, r *http.Request) {
+	job, err := s.exporter.get(r.PathValue("id"))
+	if err != nil {
+		s.writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	if job.State != exportStateDone {
+		s.writeError(w, http.StatusBadRequest, fmt.Errorf("export is %s", job.State))
+		return
+	}
+
+	name := strings.ReplaceAll(job.Path, "/", "_") + "_" + job.Start.Format("2006-01-02_15-04-05") + ".mp4"
+	w.Header().Set("Content-Type", "video/mp4")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+
+	// ServeFile supports range requests, allowing interrupted downloads to be resumed.
+	http.ServeFile(w, r, s.exporter.filePath(job.ID))
+}
+
+func (s *Server) onExportDelete(w http.ResponseWriter, r *http.Request) {
+	err := s.exporter.delete(r.PathValue("id"))
+	if err != nil {
+		if errors.Is(err, errExportNotFound) {
+			s.writeError(w, http.StatusNotFound, err)
+		} else {
+			s.writeError(w, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,104 @@
+package playback
+
+import (
+	"XMedia/internal/logger"
+	"XMedia/internal/recordstore"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// writerWrapper keeps track of whether the response has started,
+// since errors can't be reported after that.
+type writerWrapper struct {
+	w       http.ResponseWriter
+	written bool
+}
+
+func (w *writerWrapper) Write(p []byte) (int, error) {
+	if !w.written {
+		w.written = true
+		w.w.Header().Set("Content-Type", "video/mp4")
+		w.w.WriteHeader(http.StatusOK)
+	}
+	return w.w.Write(p)
+}
+
+func (s *Server) onGet(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	pathName := q.Get("path")
+
+	pconf, err := s.findPathConf(pathName)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	start, err := time.Parse(time.RFC3339Nano, q.Get("start"))
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid start: %w", err))
+		return
+	}
+
+	duration, err := parseDuration(q.Get("duration"))
+	if err != nil || duration == 0 {
+		s.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid duration: %s", q.Get("duration")))
+		return
+	}
+
+	ww := &writerWrapper{w: w}
+
+	var m muxer
+
+	switch q.Get("format") {
+	case "", "fmp4":
+		m = &muxerFMP4{w: ww}
+
+	case "mp4":
+		// payloads of samples can't be written before the moov box,
+		// they are spooled on disk in order not to keep them in memory.
+		var spool *os.File
+		spool, err = os.CreateTemp(s.ExportPath, "get-*.spool")
+		if err != nil {
+			s.writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		defer os.Remove(spool.Name())
+		defer spool.Close()
+
+		m = &muxerMP4{w: ww, spool: spool}
+
+	default:
+		s.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid format: %s", q.Get("format")))
+		return
+	}
+
+	segments, err := recordstore.FindSegments(pconf.RecordPath, pathName, ".mp4")
+	if err == nil {
+		sk := &seeker{
+			start:    start,
+			duration: duration,
+			keys:     pconf.RecordEncryptionKeys,
+			m:        m,
+		}
+		sk.initialize()
+		err = sk.seekAndMux(segments)
+	}
+
+	if err != nil {
+		if ww.written {
+			s.Log(logger.Error, "%v", err)
+			return
+		}
+
+		if errors.Is(err, recordstore.ErrNoSegmentsFound) {
+			s.writeError(w, http.StatusNotFound, err)
+		} else {
+			s.writeError(w, http.StatusInternalServerError, err)
+		}
+		return
+	}
+}
@@ -0,0 +1,224 @@
+package playback
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/bluenviron/mediacommon/v2/pkg/formats/fmp4"
+	"github.com/stretchr/testify/require"
+)
+
+// topLevelBoxes returns the types of the top-level boxes of a MP4 file.
+func topLevelBoxes(t *testing.T, buf []byte) []string {
+	var types []string
+	for len(buf) != 0 {
+		require.GreaterOrEqual(t, len(buf), 8)
+		size := int(binary.BigEndian.Uint32(buf))
+		require.GreaterOrEqual(t, len(buf), size)
+		types = append(types, string(buf[4:8]))
+		buf = buf[size:]
+	}
+	return types
+}
+
+func readFMP4(t *testing.T, buf []byte) (*fmp4.Init, fmp4.Parts) {
+	var init fmp4.Init
+	err := init.Unmarshal(bytes.NewReader(buf))
+	require.NoError(t, err)
+
+	// skip ftyp and moov
+	offset := 0
+	for i := 0; i < 2; i++ {
+		offset += int(binary.BigEndian.Uint32(buf[offset:]))
+	}
+
+	var parts fmp4.Parts
+	err = parts.Unmarshal(buf[offset:])
+	require.NoError(t, err)
+
+	return &init, parts
+}
+
+func TestOnGetFMP4(t *testing.T) {
+	ts := newTestServer(t)
+
+	for _, pathName := range []string{"plain", "encrypted"} {
+		t.Run(pathName, func(t *testing.T) {
+			status, body := ts.get(t, "/get?"+url.Values{
+				"path":     {pathName},
+				"start":    {testStart.Add(1500 * time.Millisecond).Format(time.RFC3339Nano)},
+				"duration": {"1"},
+			}.Encode())
+			require.Equal(t, http.StatusOK, status)
+
+			init, parts := readFMP4(t, body)
+			require.Len(t, init.Tracks, 1)
+			require.Equal(t, uint32(90000), init.Tracks[0].TimeScale)
+
+			var payloads [][]byte
+			var durations []uint32
+			for _, part := range parts {
+				for _, track := range part.Tracks {
+					for _, sample := range track.Samples {
+						payloads = append(payloads, sample.Payload)
+						durations = append(durations, sample.Duration)
+					}
+				}
+			}
+
+			// samples start from the keyframe that precedes the start,
+			// and continue into the following segment
+			require.Equal(t, [][]byte{
+				{0, 10}, {0, 11}, {0, 12}, {0, 13}, {0, 14},
+				{0, 15}, {0, 16}, {0, 17}, {0, 18}, {0, 19},
+				{1, 0}, {1, 1}, {1, 2}, {1, 3}, {1, 4},
+			}, payloads)
+
+			// samples that precede the start have no duration
+			require.Equal(t, []uint32{
+				0, 0, 0, 0, 0,
+				9000, 9000, 9000, 9000, 9000,
+				9000, 9000, 9000, 9000, 9000,
+			}, durations)
+
+			require.False(t, parts[0].Tracks[0].Samples[0].IsNonSyncSample)
+		})
+	}
+}
+
+func TestOnGetMP4(t *testing.T) {
+	ts := newTestServer(t)
+
+	var bodies [][]byte
+
+	for _, pathName := range []string{"plain", "encrypted"} {
+		status, body := ts.get(t, "/get?"+url.Values{
+			"path":     {pathName},
+			"start":    {testStart.Add(1500 * time.Millisecond).Format(time.RFC3339Nano)},
+			"duration": {"1"},
+			"format":   {"mp4"},
+		}.Encode())
+		require.Equal(t, http.StatusOK, status)
+
+		// the moov box precedes media data
+		require.Equal(t, []string{"ftyp", "moov", "mdat"}, topLevelBoxes(t, body))
+
+		bodies = append(bodies, body)
+	}
+
+	// encrypted recordings are decrypted
+	require.Equal(t, bodies[0], bodies[1])
+}
+
+func TestOnGetStopsAtGaps(t *testing.T) {
+	ts := newTestServer(t)
+
+	status, body := ts.get(t, "/get?"+url.Values{
+		"path":     {"plain"},
+		"start":    {testStart.Format(time.RFC3339Nano)},
+		"duration": {"20"},
+	}.Encode())
+	require.Equal(t, http.StatusOK, status)
+
+	_, parts := readFMP4(t, body)
+
+	n := 0
+	for _, part := range parts {
+		for _, track := range part.Tracks {
+			n += len(track.Samples)
+		}
+	}
+
+	// the two contiguous segments are served, the one after the gap is not
+	require.Equal(t, 40, n)
+}
+
+func TestOnGetErrors(t *testing.T) {
+	ts := newTestServer(t)
+
+	start := testStart.Format(time.RFC3339Nano)
+
+	for _, ca := range []struct {
+		name   string
+		query  url.Values
+		status int
+		err    string
+	}{
+		{
+			"path not recorded",
+			url.Values{"path": {"notrecorded"}, "start": {start}, "duration": {"1"}},
+			http.StatusBadRequest,
+			"path 'notrecorded' is not recorded",
+		},
+		{
+			"unsupported format of recordings",
+			url.Values{"path": {"mpegts"}, "start": {start}, "duration": {"1"}},
+			http.StatusBadRequest,
+			"playback of recordings in format 'mpegts' is not supported",
+		},
+		{
+			"missing start",
+			url.Values{"path": {"plain"}, "duration": {"1"}},
+			http.StatusBadRequest,
+			"invalid start: parsing time \"\" as \"2006-01-02T15:04:05.999999999Z07:00\": " +
+				"cannot parse \"\" as \"2006\"",
+		},
+		{
+			"missing duration",
+			url.Values{"path": {"plain"}, "start": {start}},
+			http.StatusBadRequest,
+			"invalid duration: ",
+		},
+		{
+			"zero duration",
+			url.Values{"path": {"plain"}, "start": {start}, "duration": {"0"}},
+			http.StatusBadRequest,
+			"invalid duration: 0",
+		},
+		{
+			"invalid format",
+			url.Values{"path": {"plain"}, "start": {start}, "duration": {"1"}, "format": {"mkv"}},
+			http.StatusBadRequest,
+			"invalid format: mkv",
+		},
+		{
+			"no recordings",
+			url.Values{"path": {"empty"}, "start": {start}, "duration": {"1"}},
+			http.StatusNotFound,
+			"recording segments not found",
+		},
+		{
+			"start after recordings",
+			url.Values{
+				"path":     {"plain"},
+				"start":    {testStart.Add(time.Hour).Format(time.RFC3339Nano)},
+				"duration": {"1"},
+			},
+			http.StatusNotFound,
+			"recording segments not found",
+		},
+		{
+			"encrypted without keys",
+			url.Values{"path": {"keyless"}, "start": {start}, "duration": {"1"}},
+			http.StatusInternalServerError,
+			"segment is encrypted, but no encryption key is set",
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			status, body := ts.get(t, "/get?"+ca.query.Encode())
+			require.Equal(t, ca.status, status)
+
+			var res struct {
+				Error string `json:"error"`
+			}
+			err := json.Unmarshal(body, &res)
+			require.NoError(t, err)
+			require.Equal(t, ca.err, res.Error)
+		})
+	}
+}
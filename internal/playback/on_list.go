@@ -0,0 +1,228 @@
+package playback
+
+import (
+	"XMedia/internal/logger"
+	"XMedia/internal/recordcrypto"
+	"XMedia/internal/recordstore"
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+type listEntry struct {
+	Start    time.Time `json:"start"`
+	Duration float64   `json:"duration"`
+	URL      string    `json:"url"`
+}
+
+type listSegment struct {
+	start    time.Time
+	duration time.Duration
+	init     []byte
+}
+
+// maximum number of segments whose duration is kept in memory.
+const listCacheMaxSize = 10000
+
+// listCacheEntry is a segment that has already been read, that is valid
+// until the segment file changes.
+type listCacheEntry struct {
+	size    int64
+	modTime time.Time
+	seg     *listSegment
+}
+
+// readListSegment reads the duration and the tracks of a segment.
+// Results are cached, since segments don't change after they are completed.
+func (s *Server) readListSegment(seg *recordstore.Segment, keys *recordcrypto.Keys) (*listSegment, error) {
+	st, err := os.Stat(seg.Fpath)
+	if err != nil {
+		return nil, err
+	}
+
+	s.listCacheMutex.Lock()
+	entry, ok := s.listCache[seg.Fpath]
+	s.listCacheMutex.Unlock()
+
+	if ok && entry.size == st.Size() && entry.modTime.Equal(st.ModTime()) {
+		return entry.seg, nil
+	}
+
+	ls, err := s.parseListSegment(seg, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	s.listCacheMutex.Lock()
+	if len(s.listCache) >= listCacheMaxSize {
+		s.listCache = make(map[string]*listCacheEntry)
+	}
+	s.listCache[seg.Fpath] = &listCacheEntry{
+		size:    st.Size(),
+		modTime: st.ModTime(),
+		seg:     ls,
+	}
+	s.listCacheMutex.Unlock()
+
+	return ls, nil
+}
+
+func (s *Server) parseListSegment(seg *recordstore.Segment, keys *recordcrypto.Keys) (*listSegment, error) {
+	f, err := recordcrypto.Open(seg.Fpath, keys)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	init, initRaw, err := segmentFMP4ReadInit(f)
+	if err != nil {
+		return nil, err
+	}
+
+	duration, err := segmentFMP4ReadDuration(f, init, int64(len(initRaw)))
+	if err != nil {
+		return nil, err
+	}
+
+	return &listSegment{
+		start:    seg.Start,
+		duration: duration,
+		init:     initRaw,
+	}, nil
+}
+
+// concatenate merges contiguous segments with the same tracks.
+func concatenate(segments []*listSegment) []*listSegment {
+	var out []*listSegment
+
+	for _, seg := range segments {
+		if len(out) != 0 {
+			prev := out[len(out)-1]
+			prevEnd := prev.start.Add(prev.duration)
+
+			if bytes.Equal(prev.init, seg.init) &&
+				!seg.start.Before(prevEnd.Add(-concatenationTolerance)) &&
+				!seg.start.After(prevEnd.Add(concatenationTolerance)) {
+				prev.duration = seg.start.Add(seg.duration).Sub(prev.start)
+				continue
+			}
+		}
+
+		cpy := *seg
+		out = append(out, &cpy)
+	}
+
+	return out
+}
+
+func (s *Server) onList(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	pathName := q.Get("path")
+
+	pconf, err := s.findPathConf(pathName)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var start time.Time
+	if raw := q.Get("start"); raw != "" {
+		start, err = time.Parse(time.RFC3339Nano, raw)
+		if err != nil {
+			s.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid start: %w", err))
+			return
+		}
+	}
+
+	var end time.Time
+	if raw := q.Get("duration"); raw != "" {
+		if start.IsZero() {
+			s.writeError(w, http.StatusBadRequest, fmt.Errorf("duration requires start"))
+			return
+		}
+
+		var duration time.Duration
+		duration, err = parseDuration(raw)
+		if err != nil {
+			s.writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		end = start.Add(duration)
+	}
+
+	segments, err := recordstore.FindSegments(pconf.RecordPath, pathName, ".mp4")
+	if err != nil {
+		if errors.Is(err, recordstore.ErrNoSegmentsFound) {
+			s.writeError(w, http.StatusNotFound, err)
+		} else {
+			s.writeError(w, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	var listSegments []*listSegment
+
+	for i, seg := range segments {
+		// skip segments outside the requested range.
+		// A segment ends before the following one starts.
+		if !end.IsZero() && !seg.Start.Before(end) {
+			break
+		}
+		if !start.IsZero() && i < len(segments)-1 && !segments[i+1].Start.After(start) {
+			continue
+		}
+
+		ls, err := s.readListSegment(seg, pconf.RecordEncryptionKeys)
+		if err != nil {
+			s.Log(logger.Warn, "unable to read %s: %v", seg.Fpath, err)
+			continue
+		}
+
+		if ls.duration > 0 {
+			listSegments = append(listSegments, ls)
+		}
+	}
+
+	entries := []listEntry{}
+
+	for _, seg := range concatenate(listSegments) {
+		segStart := seg.start
+		segEnd := seg.start.Add(seg.duration)
+
+		// intersect with the requested range
+		if !start.IsZero() && segStart.Before(start) {
+			segStart = start
+		}
+		if !end.IsZero() && segEnd.After(end) {
+			segEnd = end
+		}
+		if !segEnd.After(segStart) {
+			continue
+		}
+
+		u := &url.URL{
+			Scheme: "http",
+			Host:   r.Host,
+			Path:   "/get",
+			RawQuery: url.Values{
+				"path":     []string{pathName},
+				"start":    []string{segStart.Format(time.RFC3339Nano)},
+				"duration": []string{strconv.FormatFloat(segEnd.Sub(segStart).Seconds(), 'f', -1, 64)},
+			}.Encode(),
+		}
+
+		entries = append(entries, listEntry{
+			Start:    segStart,
+			Duration: segEnd.Sub(segStart).Seconds(),
+			URL:      u.String(),
+		})
+	}
+
+	s.writeJSON(w, http.StatusOK, entries)
+}
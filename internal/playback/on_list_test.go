@@ -0,0 +1,172 @@
+package playback
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOnList(t *testing.T) {
+	ts := newTestServer(t)
+
+	for _, ca := range []struct {
+		name  string
+		query url.Values
+		out   []listEntry
+	}{
+		{
+			"plain",
+			url.Values{"path": {"plain"}},
+			[]listEntry{
+				{Start: testStart, Duration: 4},
+				{Start: testStart.Add(10 * time.Second), Duration: 1},
+			},
+		},
+		{
+			"encrypted",
+			url.Values{"path": {"encrypted"}},
+			[]listEntry{
+				{Start: testStart, Duration: 4},
+				{Start: testStart.Add(10 * time.Second), Duration: 1},
+			},
+		},
+		{
+			"range",
+			url.Values{
+				"path":     {"plain"},
+				"start":    {testStart.Add(1500 * time.Millisecond).Format(time.RFC3339Nano)},
+				"duration": {"2"},
+			},
+			[]listEntry{
+				{Start: testStart.Add(1500 * time.Millisecond), Duration: 2},
+			},
+		},
+		{
+			"start only",
+			url.Values{
+				"path":  {"plain"},
+				"start": {testStart.Add(5 * time.Second).Format(time.RFC3339Nano)},
+			},
+			[]listEntry{
+				{Start: testStart.Add(10 * time.Second), Duration: 1},
+			},
+		},
+		{
+			"encrypted without keys",
+			url.Values{"path": {"keyless"}},
+			[]listEntry{},
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			status, body := ts.get(t, "/list?"+ca.query.Encode())
+			require.Equal(t, http.StatusOK, status)
+
+			var entries []listEntry
+			err := json.Unmarshal(body, &entries)
+			require.NoError(t, err)
+
+			for i := range entries {
+				entries[i].Start = entries[i].Start.UTC()
+				require.Contains(t, entries[i].URL, "/get?")
+				entries[i].URL = ""
+			}
+
+			require.Equal(t, ca.out, entries)
+		})
+	}
+}
+
+func TestOnListURL(t *testing.T) {
+	ts := newTestServer(t)
+
+	status, body := ts.get(t, "/list?path=plain")
+	require.Equal(t, http.StatusOK, status)
+
+	var entries []listEntry
+	err := json.Unmarshal(body, &entries)
+	require.NoError(t, err)
+
+	// URLs of entries point to the recordings
+	u, err := url.Parse(entries[0].URL)
+	require.NoError(t, err)
+
+	status, _ = ts.get(t, u.RequestURI())
+	require.Equal(t, http.StatusOK, status)
+}
+
+func TestOnListErrors(t *testing.T) {
+	ts := newTestServer(t)
+
+	for _, ca := range []struct {
+		name   string
+		query  url.Values
+		status int
+		err    string
+	}{
+		{
+			"missing path",
+			url.Values{},
+			http.StatusBadRequest,
+			"invalid path name: cannot be empty",
+		},
+		{
+			"path not recorded",
+			url.Values{"path": {"notrecorded"}},
+			http.StatusBadRequest,
+			"path 'notrecorded' is not recorded",
+		},
+		{
+			"path not in configuration",
+			url.Values{"path": {"unknown"}},
+			http.StatusBadRequest,
+			"path 'unknown' is not recorded",
+		},
+		{
+			"unsupported format",
+			url.Values{"path": {"mpegts"}},
+			http.StatusBadRequest,
+			"playback of recordings in format 'mpegts' is not supported",
+		},
+		{
+			"invalid start",
+			url.Values{"path": {"plain"}, "start": {"yesterday"}},
+			http.StatusBadRequest,
+			"invalid start: parsing time \"yesterday\" as \"2006-01-02T15:04:05.999999999Z07:00\": " +
+				"cannot parse \"yesterday\" as \"2006\"",
+		},
+		{
+			"duration without start",
+			url.Values{"path": {"plain"}, "duration": {"1"}},
+			http.StatusBadRequest,
+			"duration requires start",
+		},
+		{
+			"invalid duration",
+			url.Values{"path": {"plain"}, "start": {testStart.Format(time.RFC3339)}, "duration": {"-1"}},
+			http.StatusBadRequest,
+			"invalid duration: -1",
+		},
+		{
+			"no recordings",
+			url.Values{"path": {"empty"}},
+			http.StatusNotFound,
+			"recording segments not found",
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			status, body := ts.get(t, "/list?"+ca.query.Encode())
+			require.Equal(t, ca.status, status)
+
+			var res struct {
+				Error string `json:"error"`
+			}
+			err := json.Unmarshal(body, &res)
+			require.NoError(t, err)
+			require.Equal(t, ca.err, res.Error)
+		})
+	}
+}
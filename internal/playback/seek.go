@@ -0,0 +1,214 @@
+package playback
+
+import (
+	"XMedia/internal/recordcrypto"
+	"XMedia/internal/recordstore"
+	"bytes"
+	"time"
+
+	"github.com/bluenviron/mediacommon/v2/pkg/formats/fmp4"
+)
+
+// maximum gap between two segments that are considered contiguous.
+const concatenationTolerance = 1 * time.Second
+
+type pendingSample struct {
+	dts    int64
+	sample *fmp4.Sample
+}
+
+// seeker reads the samples of consecutive segments that fall inside a time range,
+// and writes them into a muxer.
+type seeker struct {
+	start    time.Time
+	duration time.Duration
+	keys     *recordcrypto.Keys
+	m        muxer
+	// called after every part with the position reached, relative to the start.
+	// Returning an error stops muxing.
+	onProgress func(time.Duration) error
+
+	firstInit []byte
+	prevEnd   time.Time
+	// samples of video tracks that precede the start and follow the last keyframe.
+	pending map[int][]*pendingSample
+}
+
+func (s *seeker) initialize() {
+	s.pending = make(map[int][]*pendingSample)
+}
+
+// seekAndMux writes the segments, and stops at the first discontinuity,
+// that is a gap or a change of tracks or codec parameters.
+func (s *seeker) seekAndMux(segments []*recordstore.Segment) error {
+	// start from the last segment that begins before the start
+	first := 0
+	for i, seg := range segments {
+		if !seg.Start.After(s.start) {
+			first = i
+		}
+	}
+
+	end := s.start.Add(s.duration)
+
+	for _, seg := range segments[first:] {
+		if !seg.Start.Before(end) {
+			break
+		}
+
+		cont, err := s.muxSegment(seg)
+		if err != nil {
+			return err
+		}
+		if !cont {
+			break
+		}
+	}
+
+	if s.firstInit == nil {
+		return recordstore.ErrNoSegmentsFound
+	}
+
+	return s.m.flush()
+}
+
+func (s *seeker) muxSegment(seg *recordstore.Segment) (bool, error) {
+	f, err := recordcrypto.Open(seg.Fpath, s.keys)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	init, initRaw, err := segmentFMP4ReadInit(f)
+	if err != nil {
+		return false, err
+	}
+
+	if s.firstInit == nil {
+		duration, err := segmentFMP4ReadDuration(f, init, int64(len(initRaw)))
+		if err != nil {
+			return false, err
+		}
+
+		// segment ends before the start
+		if !seg.Start.Add(duration).After(s.start) {
+			return true, nil
+		}
+
+		s.firstInit = initRaw
+		s.m.writeInit(init)
+	} else {
+		if !bytes.Equal(initRaw, s.firstInit) {
+			return false, nil
+		}
+
+		if seg.Start.Sub(s.prevEnd) > concatenationTolerance {
+			return false, nil
+		}
+	}
+
+	timeScales := make(map[int]uint32)
+	isVideo := make(map[int]bool)
+	for _, track := range init.Tracks {
+		timeScales[track.ID] = track.TimeScale
+		isVideo[track.ID] = track.Codec.IsVideo()
+	}
+
+	segOffset := seg.Start.Sub(s.start)
+	segEnd := time.Duration(0)
+
+	err = segmentFMP4ReadParts(f, int64(len(initRaw)), func(part *fmp4.Part) error {
+		atLeastOneSampleBeforeEnd := false
+
+		for _, track := range part.Tracks {
+			timeScale, ok := timeScales[track.ID]
+			if !ok {
+				continue
+			}
+
+			s.m.setTrack(track.ID)
+
+			dts := int64(track.BaseTime) + durationGoToMp4(segOffset, timeScale)
+			maxDTS := durationGoToMp4(s.duration, timeScale)
+
+			for _, sample := range track.Samples {
+				if dts >= maxDTS {
+					break
+				}
+				atLeastOneSampleBeforeEnd = true
+
+				if dts < 0 {
+					// audio samples before the start are discarded,
+					// video samples are kept from the last keyframe.
+					if isVideo[track.ID] {
+						if !sample.IsNonSyncSample {
+							s.pending[track.ID] = nil
+						}
+						s.pending[track.ID] = append(s.pending[track.ID], &pendingSample{dts: dts, sample: sample})
+					}
+				} else {
+					err := s.writePending(track.ID)
+					if err != nil {
+						return err
+					}
+
+					err = s.writeSample(dts, maxDTS, sample)
+					if err != nil {
+						return err
+					}
+				}
+
+				dts += int64(sample.Duration)
+			}
+
+			if d := durationMp4ToGo(dts, timeScale) - segOffset; d > segEnd {
+				segEnd = d
+			}
+		}
+
+		err := s.m.endPart()
+		if err != nil {
+			return err
+		}
+
+		if s.onProgress != nil {
+			err = s.onProgress(segOffset + segEnd)
+			if err != nil {
+				return err
+			}
+		}
+
+		if !atLeastOneSampleBeforeEnd {
+			return errStopIteration
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	s.prevEnd = seg.Start.Add(segEnd)
+
+	return true, nil
+}
+
+func (s *seeker) writePending(trackID int) error {
+	for _, p := range s.pending[trackID] {
+		err := s.m.writeSample(p.dts, p.sample.Duration, p.sample.PTSOffset, p.sample.IsNonSyncSample, p.sample.Payload)
+		if err != nil {
+			return err
+		}
+	}
+	s.pending[trackID] = nil
+	return nil
+}
+
+func (s *seeker) writeSample(dts int64, maxDTS int64, sample *fmp4.Sample) error {
+	// the last sample is truncated at the end
+	duration := sample.Duration
+	if dts+int64(duration) > maxDTS {
+		duration = uint32(maxDTS - dts)
+	}
+
+	return s.m.writeSample(dts, duration, sample.PTSOffset, sample.IsNonSyncSample, sample.Payload)
+}
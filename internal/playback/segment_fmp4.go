@@ -0,0 +1,288 @@
+package playback
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/bluenviron/mediacommon/v2/pkg/formats/fmp4"
+)
+
+var errStopIteration = errors.New("stop iteration")
+
+func durationGoToMp4(v time.Duration, timeScale uint32) int64 {
+	timeScale64 := int64(timeScale)
+	secs := v / time.Second
+	dec := v % time.Second
+	return int64(secs)*timeScale64 + int64(dec)*timeScale64/int64(time.Second)
+}
+
+func durationMp4ToGo(v int64, timeScale uint32) time.Duration {
+	timeScale64 := int64(timeScale)
+	secs := v / timeScale64
+	dec := v % timeScale64
+	return time.Duration(secs)*time.Second + time.Duration(dec)*time.Second/time.Duration(timeScale64)
+}
+
+// readBoxHeader reads the header of a top-level MP4 box,
+// and returns its type and its size, header included.
+func readBoxHeader(r io.ReadSeeker) (string, int64, error) {
+	buf := make([]byte, 8)
+	_, err := io.ReadFull(r, buf)
+	if err != nil {
+		return "", 0, err
+	}
+
+	typ := string(buf[4:])
+	size := int64(binary.BigEndian.Uint32(buf[:4]))
+
+	switch size {
+	case 0: // box extends to the end of file
+		cur, err := r.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return "", 0, err
+		}
+
+		end, err := r.Seek(0, io.SeekEnd)
+		if err != nil {
+			return "", 0, err
+		}
+
+		_, err = r.Seek(cur, io.SeekStart)
+		if err != nil {
+			return "", 0, err
+		}
+
+		size = end - cur + 8
+
+	case 1: // 64-bit size
+		_, err = io.ReadFull(r, buf)
+		if err != nil {
+			return "", 0, err
+		}
+		size = int64(binary.BigEndian.Uint64(buf))
+	}
+
+	if size < 8 {
+		return "", 0, fmt.Errorf("invalid size of box '%s'", typ)
+	}
+
+	return typ, size, nil
+}
+
+// segmentFMP4ReadInit reads the initialization section (ftyp and moov) of a segment.
+// The raw section is returned too, in order to compare initialization sections of different segments.
+func segmentFMP4ReadInit(r io.ReadSeeker) (*fmp4.Init, []byte, error) {
+	_, err := r.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	end := int64(0)
+
+	for {
+		typ, size, err := readBoxHeader(r)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		end += size
+
+		if typ == "moov" {
+			break
+		}
+
+		if typ != "ftyp" {
+			return nil, nil, fmt.Errorf("unexpected box '%s'", typ)
+		}
+
+		_, err = r.Seek(end, io.SeekStart)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	_, err = r.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	buf := make([]byte, end)
+	_, err = io.ReadFull(r, buf)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var init fmp4.Init
+	err = init.Unmarshal(bytes.NewReader(buf))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &init, buf, nil
+}
+
+// readPartAt reads a moof box and the following mdat box.
+func readPartAt(r io.ReadSeeker, offset int64, moofSize int64) ([]*fmp4.Part, int64, error) {
+	_, err := r.Seek(offset+moofSize, io.SeekStart)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	typ, mdatSize, err := readBoxHeader(r)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if typ != "mdat" {
+		return nil, 0, fmt.Errorf("unexpected box '%s'", typ)
+	}
+
+	_, err = r.Seek(offset, io.SeekStart)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	buf := make([]byte, moofSize+mdatSize)
+	_, err = io.ReadFull(r, buf)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var parts fmp4.Parts
+	err = parts.Unmarshal(buf)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return parts, moofSize + mdatSize, nil
+}
+
+// segmentFMP4ReadParts calls cb for every part of a segment, in order.
+// A truncated part at the end of the segment, left by a crash, is ignored.
+func segmentFMP4ReadParts(r io.ReadSeeker, initSize int64, cb func(*fmp4.Part) error) error {
+	offset := initSize
+
+	for {
+		_, err := r.Seek(offset, io.SeekStart)
+		if err != nil {
+			return err
+		}
+
+		typ, size, err := readBoxHeader(r)
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil
+			}
+			return err
+		}
+
+		if typ != "moof" {
+			offset += size
+			continue
+		}
+
+		parts, n, err := readPartAt(r, offset, size)
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil
+			}
+			return err
+		}
+
+		for _, part := range parts {
+			err = cb(part)
+			if err != nil {
+				if errors.Is(err, errStopIteration) {
+					return nil
+				}
+				return err
+			}
+		}
+
+		offset += n
+	}
+}
+
+// segmentFMP4ReadDuration returns the duration of a segment,
+// that is read from the last complete part.
+func segmentFMP4ReadDuration(r io.ReadSeeker, init *fmp4.Init, initSize int64) (time.Duration, error) {
+	fileSize, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+
+	offset := initSize
+	lastMoofOffset := int64(-1)
+	lastMoofSize := int64(0)
+
+	for {
+		_, err = r.Seek(offset, io.SeekStart)
+		if err != nil {
+			return 0, err
+		}
+
+		typ, size, err := readBoxHeader(r)
+		if err != nil || offset+size > fileSize {
+			break
+		}
+
+		if typ == "moof" {
+			_, err = r.Seek(offset+size, io.SeekStart)
+			if err != nil {
+				return 0, err
+			}
+
+			_, mdatSize, err := readBoxHeader(r)
+			if err != nil || offset+size+mdatSize > fileSize {
+				break
+			}
+
+			lastMoofOffset = offset
+			lastMoofSize = size
+			offset += size + mdatSize
+			continue
+		}
+
+		offset += size
+	}
+
+	if lastMoofOffset < 0 {
+		return 0, nil
+	}
+
+	parts, _, err := readPartAt(r, lastMoofOffset, lastMoofSize)
+	if err != nil {
+		return 0, err
+	}
+
+	timeScales := make(map[int]uint32)
+	for _, track := range init.Tracks {
+		timeScales[track.ID] = track.TimeScale
+	}
+
+	var duration time.Duration
+
+	for _, part := range parts {
+		for _, track := range part.Tracks {
+			timeScale, ok := timeScales[track.ID]
+			if !ok {
+				continue
+			}
+
+			end := int64(track.BaseTime)
+			for _, sample := range track.Samples {
+				end += int64(sample.Duration)
+			}
+
+			if d := durationMp4ToGo(end, timeScale); d > duration {
+				duration = d
+			}
+		}
+	}
+
+	return duration, nil
+}
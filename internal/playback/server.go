@@ -0,0 +1,156 @@
+// Package playback contains the playback server.
+package playback
+
+import (
+	"XMedia/internal/conf"
+	"XMedia/internal/defs"
+	"XMedia/internal/logger"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type serverParent interface {
+	logger.Writer
+}
+
+// Server is the playback server, that serves recordings.
+type Server struct {
+	Address      string
+	ReadTimeout  conf.Duration
+	WriteTimeout conf.Duration
+	// directory of exported clips. Exports are disabled when empty.
+	ExportPath   string
+	PathConfs    map[string]*conf.Path
+	PathDefaults *conf.Path
+	Parent       serverParent
+
+	ln             net.Listener
+	httpServer     *http.Server
+	exporter       *exporter
+	listCacheMutex sync.Mutex
+	listCache      map[string]*listCacheEntry
+}
+
+// Initialize initializes Server.
+func (s *Server) Initialize() error {
+	s.listCache = make(map[string]*listCacheEntry)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /list", s.onList)
+	mux.HandleFunc("GET /get", s.onGet)
+
+	if s.ExportPath != "" {
+		s.exporter = &exporter{
+			Path:        s.ExportPath,
+			MaxJobs:     exportMaxJobs,
+			MaxDuration: exportMaxDuration,
+			Retention:   exportRetention,
+			Parent:      s,
+		}
+		err := s.exporter.initialize()
+		if err != nil {
+			return err
+		}
+
+		mux.HandleFunc("POST /exports", s.onExportCreate)
+		mux.HandleFunc("GET /exports", s.onExportList)
+		mux.HandleFunc("GET /exports/{id}", s.onExportGet)
+		mux.HandleFunc("GET /exports/{id}/download", s.onExportDownload)
+		mux.HandleFunc("DELETE /exports/{id}", s.onExportDelete)
+	}
+
+	var err error
+	s.ln, err = net.Listen("tcp", s.Address)
+	if err != nil {
+		if s.exporter != nil {
+			s.exporter.close()
+		}
+		return err
+	}
+
+	s.httpServer = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: time.Duration(s.ReadTimeout),
+		WriteTimeout:      time.Duration(s.WriteTimeout),
+	}
+
+	go s.httpServer.Serve(s.ln) //nolint:errcheck
+
+	s.Log(logger.Info, "listener opened on %s", s.Address)
+
+	if !isLoopback(s.ln.Addr()) {
+		s.Log(logger.Warn, "listener is reachable from other hosts, while the playback server "+
+			"doesn't authenticate clients and serves encrypted recordings decrypted")
+	}
+
+	return nil
+}
+
+// Close closes Server.
+func (s *Server) Close() {
+	s.Log(logger.Info, "listener is closing")
+	s.httpServer.Close()
+	s.ln.Close() //nolint:errcheck
+
+	if s.exporter != nil {
+		s.exporter.close()
+	}
+}
+
+// Log implements logger.Writer.
+func (s *Server) Log(level logger.Level, format string, args ...interface{}) {
+	s.Parent.Log(level, "[playback] "+format, args...)
+}
+
+func (s *Server) writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v) //nolint:errcheck
+}
+
+func (s *Server) writeError(w http.ResponseWriter, status int, err error) {
+	// show error in logs
+	s.Log(logger.Error, err.Error())
+
+	s.writeJSON(w, status, &defs.APIError{Error: err.Error()})
+}
+
+// findPathConf returns the configuration of a recorded path.
+func (s *Server) findPathConf(pathName string) (*conf.Path, error) {
+	err := conf.IsValidPathName(pathName)
+	if err != nil {
+		return nil, fmt.Errorf("invalid path name: %w", err)
+	}
+
+	pconf := conf.FindPathConf(s.PathConfs, s.PathDefaults, pathName)
+
+	if !pconf.Record {
+		return nil, fmt.Errorf("path '%s' is not recorded", pathName)
+	}
+
+	if pconf.RecordFormat != "fmp4" {
+		return nil, fmt.Errorf("playback of recordings in format '%s' is not supported", pconf.RecordFormat)
+	}
+
+	return pconf, nil
+}
+
+// isLoopback returns whether a listener accepts local connections only.
+func isLoopback(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	return ok && tcpAddr.IP.IsLoopback()
+}
+
+func parseDuration(raw string) (time.Duration, error) {
+	secs, err := strconv.ParseFloat(raw, 64)
+	if err != nil || secs < 0 {
+		return 0, fmt.Errorf("invalid duration: %s", raw)
+	}
+
+	return time.Duration(secs * float64(time.Second)), nil
+}
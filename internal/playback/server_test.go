@@ -0,0 +1,218 @@
+package playback
+
+import (
+	"XMedia/internal/conf"
+	"XMedia/internal/logger"
+	"XMedia/internal/recordcrypto"
+	"XMedia/internal/recordstore"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bluenviron/mediacommon/v2/pkg/formats/fmp4"
+	"github.com/bluenviron/mediacommon/v2/pkg/formats/fmp4/seekablebuffer"
+	"github.com/bluenviron/mediacommon/v2/pkg/formats/mp4"
+	"github.com/stretchr/testify/require"
+)
+
+type nilLogger struct{}
+
+func (nilLogger) Log(logger.Level, string, ...interface{}) {}
+
+var testSPS = []byte{
+	0x67, 0x42, 0xc0, 0x28, 0xd9, 0x00, 0x78, 0x02,
+	0x27, 0xe5, 0x84, 0x00, 0x00, 0x03, 0x00, 0x04,
+	0x00, 0x00, 0x03, 0x00, 0xf0, 0x3c, 0x60, 0xc9,
+	0x20,
+}
+
+var testPPS = []byte{0x68, 0xee, 0x3c, 0x80}
+
+var testStart = time.Date(2008, 5, 20, 22, 15, 25, 0, time.UTC)
+
+func writeTestKeys(t *testing.T, keys ...string) *recordcrypto.Keys {
+	fpath := filepath.Join(t.TempDir(), "keys")
+	err := os.WriteFile(fpath, []byte(strings.Join(keys, "\n")), 0o600)
+	require.NoError(t, err)
+
+	ks, err := recordcrypto.LoadKeys(fpath)
+	require.NoError(t, err)
+
+	return ks
+}
+
+// writeTestSegment writes a segment with a H264 track, with a sample every 100ms,
+// a keyframe every second and a part every second.
+// Payloads of samples contain the index of the segment and of the sample.
+func writeTestSegment(
+	t *testing.T,
+	pconf *conf.Path,
+	start time.Time,
+	index byte,
+	duration time.Duration,
+	keys *recordcrypto.Keys,
+) {
+	fpath := recordstore.Path{
+		Start: start,
+		Path:  pconf.Name,
+	}.Encode(pconf.RecordPath) + ".mp4"
+
+	err := os.MkdirAll(filepath.Dir(fpath), 0o755)
+	require.NoError(t, err)
+
+	f, err := os.Create(fpath)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var w io.Writer = f
+	if keys != nil {
+		cw := &recordcrypto.Writer{W: f, Keys: keys}
+		err = cw.Initialize()
+		require.NoError(t, err)
+		w = cw
+	}
+
+	init := fmp4.Init{
+		Tracks: []*fmp4.InitTrack{{
+			ID:        1,
+			TimeScale: 90000,
+			Codec:     &mp4.CodecH264{SPS: testSPS, PPS: testPPS},
+		}},
+	}
+
+	var buf seekablebuffer.Buffer
+	err = init.Marshal(&buf)
+	require.NoError(t, err)
+
+	_, err = w.Write(buf.Bytes())
+	require.NoError(t, err)
+
+	count := int(duration / (100 * time.Millisecond))
+
+	for p := 0; p*10 < count; p++ {
+		track := &fmp4.PartTrack{
+			ID:       1,
+			BaseTime: uint64(p * 90000),
+		}
+
+		for i := p * 10; i < min(count, (p+1)*10); i++ {
+			track.Samples = append(track.Samples, &fmp4.Sample{
+				Duration:        9000,
+				IsNonSyncSample: i%10 != 0,
+				Payload:         []byte{index, byte(i)},
+			})
+		}
+
+		part := fmp4.Part{
+			SequenceNumber: uint32(p),
+			Tracks:         []*fmp4.PartTrack{track},
+		}
+
+		var buf seekablebuffer.Buffer
+		err = part.Marshal(&buf)
+		require.NoError(t, err)
+
+		_, err = w.Write(buf.Bytes())
+		require.NoError(t, err)
+	}
+
+	if cw, ok := w.(*recordcrypto.Writer); ok {
+		err = cw.Close()
+		require.NoError(t, err)
+	}
+}
+
+type testServer struct {
+	s     *Server
+	url   string
+	paths map[string]*conf.Path
+	keys  *recordcrypto.Keys
+}
+
+// newTestServer creates a playback server with the following paths:
+// plain and encrypted, that contain the same recordings,
+// keyless, that contains encrypted recordings without keys,
+// empty, that doesn't contain recordings,
+// notrecorded and mpegts, that can't be played back.
+// Exports are enabled.
+func newTestServer(t *testing.T) *testServer {
+	dir := t.TempDir()
+	recordPath := filepath.Join(dir, "%path", "%Y-%m-%d_%H-%M-%S-%f")
+	keys := writeTestKeys(t, "00112233445566778899aabbccddeeff00112233445566778899aabbccddeeff")
+
+	newPath := func(name string, record bool, format string, keys *recordcrypto.Keys) *conf.Path {
+		return &conf.Path{
+			Name:                 name,
+			Record:               record,
+			RecordPath:           recordPath,
+			RecordFormat:         format,
+			RecordEncryptionKeys: keys,
+		}
+	}
+
+	paths := map[string]*conf.Path{
+		"plain":       newPath("plain", true, "fmp4", nil),
+		"encrypted":   newPath("encrypted", true, "fmp4", keys),
+		"keyless":     newPath("keyless", true, "fmp4", nil),
+		"empty":       newPath("empty", true, "fmp4", nil),
+		"notrecorded": newPath("notrecorded", false, "fmp4", nil),
+		"mpegts":      newPath("mpegts", true, "mpegts", nil),
+	}
+
+	// two contiguous segments, then a gap and a third segment
+	for _, ca := range []struct {
+		path string
+		keys *recordcrypto.Keys
+	}{
+		{"plain", nil},
+		{"encrypted", keys},
+		{"keyless", keys},
+	} {
+		writeTestSegment(t, paths[ca.path], testStart, 0, 2*time.Second, ca.keys)
+		writeTestSegment(t, paths[ca.path], testStart.Add(2*time.Second), 1, 2*time.Second, ca.keys)
+		writeTestSegment(t, paths[ca.path], testStart.Add(10*time.Second), 2, 1*time.Second, ca.keys)
+	}
+
+	s := &Server{
+		Address:      "127.0.0.1:0",
+		ReadTimeout:  conf.Duration(10 * time.Second),
+		WriteTimeout: conf.Duration(10 * time.Second),
+		ExportPath:   filepath.Join(dir, "exports"),
+		PathConfs:    paths,
+		PathDefaults: &conf.Path{},
+		Parent:       nilLogger{},
+	}
+	err := s.Initialize()
+	require.NoError(t, err)
+
+	t.Cleanup(s.Close)
+
+	return &testServer{
+		s:     s,
+		url:   "http://" + s.ln.Addr().String(),
+		paths: paths,
+		keys:  keys,
+	}
+}
+
+func (ts *testServer) get(t *testing.T, path string) (int, []byte) {
+	return ts.do(t, http.MethodGet, path)
+}
+
+func (ts *testServer) do(t *testing.T, method string, path string) (int, []byte) {
+	req, err := http.NewRequest(method, ts.url+path, nil)
+	require.NoError(t, err)
+
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+
+	return res.StatusCode, body
+}
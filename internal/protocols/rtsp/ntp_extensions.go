@@ -0,0 +1,106 @@
+package rtsp
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/description"
+	"github.com/bluenviron/gortsplib/v4/pkg/sdp"
+	"github.com/pion/rtp"
+)
+
+const (
+	absCaptureTimeURI = "http://www.webrtc.org/experiments/rtp-hdrext/abs-capture-time"
+
+	// profile of the RTP header extension defined in the ONVIF Streaming Specification, section 6.3.
+	onvifReplayExtensionProfile = 0xABAC
+)
+
+// NTP timestamp (seconds since 1900 in 32.32 fixed point) to time.Time.
+func ntpToTime(v uint64) time.Time {
+	s := int64(v>>32) - 2208988800
+	ns := int64(((v & 0xFFFFFFFF) * 1e9) >> 32)
+	return time.Unix(s, ns)
+}
+
+// AbsCaptureTimeIDs returns, for each media of a session, the ID of the abs-capture-time
+// RTP header extension that has been negotiated in the SDP.
+func AbsCaptureTimeIDs(byts []byte, desc *description.Session) map[*description.Media]uint8 {
+	var sd sdp.SessionDescription
+	err := sd.Unmarshal(byts)
+	if err != nil || len(sd.MediaDescriptions) != len(desc.Medias) {
+		return nil
+	}
+
+	ret := make(map[*description.Media]uint8)
+
+	for i, md := range sd.MediaDescriptions {
+		for _, attr := range md.Attributes {
+			if attr.Key != "extmap" {
+				continue
+			}
+
+			// <value>["/"<direction>] <URI> [<extensionattributes>]
+			fields := strings.Fields(attr.Value)
+			if len(fields) < 2 || fields[1] != absCaptureTimeURI {
+				continue
+			}
+
+			id, err := strconv.ParseUint(strings.Split(fields[0], "/")[0], 10, 8)
+			if err != nil || id == 0 {
+				continue
+			}
+
+			ret[desc.Medias[i]] = uint8(id)
+		}
+	}
+
+	return ret
+}
+
+// extract the absolute time from the ONVIF replay extension.
+func onvifReplayNTP(pkt *rtp.Packet) (time.Time, bool) {
+	if !pkt.Extension || pkt.ExtensionProfile != onvifReplayExtensionProfile {
+		return time.Time{}, false
+	}
+
+	payload := pkt.GetExtension(0)
+	if len(payload) < 8 {
+		return time.Time{}, false
+	}
+
+	v := uint64(payload[0])<<56 | uint64(payload[1])<<48 | uint64(payload[2])<<40 | uint64(payload[3])<<32 |
+		uint64(payload[4])<<24 | uint64(payload[5])<<16 | uint64(payload[6])<<8 | uint64(payload[7])
+	if v == 0 {
+		return time.Time{}, false
+	}
+
+	return ntpToTime(v), true
+}
+
+// extract the absolute time from the abs-capture-time extension.
+func absCaptureTimeNTP(pkt *rtp.Packet, id uint8) (time.Time, bool) {
+	if id == 0 || !pkt.Extension {
+		return time.Time{}, false
+	}
+
+	payload := pkt.GetExtension(id)
+	if payload == nil {
+		return time.Time{}, false
+	}
+
+	var ext rtp.AbsCaptureTimeExtension
+	err := ext.Unmarshal(payload)
+	if err != nil || ext.Timestamp == 0 {
+		return time.Time{}, false
+	}
+
+	return ntpToTime(ext.Timestamp), true
+}
+
+// convert a difference of RTP timestamps into a duration, without overflowing.
+func timestampToDuration(d int64, clockRate int) time.Duration {
+	cr := int64(clockRate)
+	return time.Duration(d/cr)*time.Second + time.Duration(d%cr)*time.Second/time.Duration(cr)
+}
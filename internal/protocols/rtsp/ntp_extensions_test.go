@@ -0,0 +1,125 @@
+package rtsp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+)
+
+// NTP timestamp of 2008-05-20 22:15:25.5 UTC.
+const testNTP = uint64(3420310525)<<32 | 1<<31
+
+var testNTPTime = time.Date(2008, 5, 20, 22, 15, 25, 500000000, time.UTC)
+
+func onvifReplayPacket(t *testing.T, v uint64) *rtp.Packet {
+	pkt := &rtp.Packet{Header: rtp.Header{Version: 2, Extension: true, ExtensionProfile: onvifReplayExtensionProfile}}
+	payload := []byte{
+		byte(v >> 56), byte(v >> 48), byte(v >> 40), byte(v >> 32),
+		byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v),
+		0x80, 0, 0, 0,
+	}
+	require.NoError(t, pkt.SetExtension(0, payload))
+	return pkt
+}
+
+func absCaptureTimePacket(t *testing.T, id uint8, v uint64) *rtp.Packet {
+	pkt := &rtp.Packet{Header: rtp.Header{Version: 2}}
+	payload, err := rtp.AbsCaptureTimeExtension{Timestamp: v}.Marshal()
+	require.NoError(t, err)
+	require.NoError(t, pkt.SetExtension(id, payload))
+	return pkt
+}
+
+func TestOnvifReplayNTP(t *testing.T) {
+	for _, ca := range []struct {
+		name string
+		pkt  *rtp.Packet
+		ntp  time.Time
+		ok   bool
+	}{
+		{
+			"valid",
+			onvifReplayPacket(t, testNTP),
+			testNTPTime,
+			true,
+		},
+		{
+			"zero",
+			onvifReplayPacket(t, 0),
+			time.Time{},
+			false,
+		},
+		{
+			"other profile",
+			absCaptureTimePacket(t, 3, testNTP),
+			time.Time{},
+			false,
+		},
+		{
+			"no extension",
+			&rtp.Packet{Header: rtp.Header{Version: 2}},
+			time.Time{},
+			false,
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			ntp, ok := onvifReplayNTP(ca.pkt)
+			require.Equal(t, ca.ok, ok)
+			require.True(t, ca.ntp.Equal(ntp), "%v != %v", ca.ntp, ntp)
+		})
+	}
+}
+
+func TestAbsCaptureTimeNTP(t *testing.T) {
+	for _, ca := range []struct {
+		name string
+		pkt  *rtp.Packet
+		id   uint8
+		ntp  time.Time
+		ok   bool
+	}{
+		{
+			"valid",
+			absCaptureTimePacket(t, 3, testNTP),
+			3,
+			testNTPTime,
+			true,
+		},
+		{
+			"different id",
+			absCaptureTimePacket(t, 3, testNTP),
+			4,
+			time.Time{},
+			false,
+		},
+		{
+			"not negotiated",
+			absCaptureTimePacket(t, 3, testNTP),
+			0,
+			time.Time{},
+			false,
+		},
+		{
+			"zero",
+			absCaptureTimePacket(t, 3, 0),
+			3,
+			time.Time{},
+			false,
+		},
+		{
+			"no extension",
+			&rtp.Packet{Header: rtp.Header{Version: 2}},
+			3,
+			time.Time{},
+			false,
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			ntp, ok := absCaptureTimeNTP(ca.pkt, ca.id)
+			require.Equal(t, ca.ok, ok)
+			require.True(t, ca.ntp.Equal(ntp), "%v != %v", ca.ntp, ntp)
+		})
+	}
+}
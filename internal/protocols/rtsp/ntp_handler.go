@@ -0,0 +1,114 @@
+package rtsp
+
+import (
+	"XMedia/internal/logger"
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+// minimum interval between two log messages about the absolute time of a track.
+const ntpLogInterval = 10 * time.Second
+
+type ntpState int
+
+const (
+	ntpStateInitial ntpState = iota
+	ntpStateAvailable
+	ntpStateDerived
+)
+
+// ntpHandler computes the absolute time of the RTP packets of a track.
+//
+// RTP header extensions (ONVIF replay, abs-capture-time) take precedence over RTCP sender reports.
+// Since header extensions are allowed to be present in some packets only, once one of them
+// has been received, the absolute time of packets without extensions is extrapolated from it,
+// and sender reports are not used anymore, in order not to switch between different clocks.
+type ntpHandler struct {
+	// replace absolute timestamps with the time of reception.
+	replace          bool
+	clockRate        int
+	absCaptureTimeID uint8
+	// absolute time computed from RTCP sender reports.
+	senderReportNTP func(*rtp.Packet) (time.Time, bool)
+	log             logger.Writer
+
+	state         ntpState
+	extensionSeen bool
+	lastNTP       time.Time
+	lastPTS       int64
+	lastLog       time.Time
+}
+
+func (h *ntpHandler) extensionNTP(pkt *rtp.Packet) (time.Time, bool) {
+	if ntp, ok := onvifReplayNTP(pkt); ok {
+		return ntp, true
+	}
+
+	return absCaptureTimeNTP(pkt, h.absCaptureTimeID)
+}
+
+// extrapolate the absolute time from the last known one.
+func (h *ntpHandler) extrapolate(pts int64) time.Time {
+	return h.lastNTP.Add(timestampToDuration(pts-h.lastPTS, h.clockRate))
+}
+
+func (h *ntpHandler) logRateLimited(now time.Time, level logger.Level, format string, args ...interface{}) {
+	if !h.lastLog.IsZero() && now.Sub(h.lastLog) < ntpLogInterval {
+		return
+	}
+	h.lastLog = now
+	h.log.Log(level, format, args...)
+}
+
+func (h *ntpHandler) setState(state ntpState, now time.Time) {
+	if state == h.state {
+		return
+	}
+
+	switch {
+	case state == ntpStateDerived:
+		h.logRateLimited(now, logger.Warn, "absolute time is not available anymore, deriving it from RTP timestamps")
+
+	case h.state == ntpStateDerived:
+		h.logRateLimited(now, logger.Info, "absolute time is available again")
+	}
+
+	h.state = state
+}
+
+// process returns the absolute time of a packet,
+// or false when the packet must be skipped since the absolute time is not known yet.
+func (h *ntpHandler) process(pkt *rtp.Packet, pts int64, now time.Time) (time.Time, bool) {
+	if h.replace {
+		return now, true
+	}
+
+	if ntp, ok := h.extensionNTP(pkt); ok {
+		if !h.extensionSeen && h.state != ntpStateInitial {
+			h.log.Log(logger.Info, "absolute time is now read from RTP header extensions")
+		}
+		h.extensionSeen = true
+		h.setState(ntpStateAvailable, now)
+		h.lastNTP, h.lastPTS = ntp, pts
+		return ntp, true
+	}
+
+	if h.extensionSeen {
+		return h.extrapolate(pts), true
+	}
+
+	if ntp, ok := h.senderReportNTP(pkt); ok {
+		h.setState(ntpStateAvailable, now)
+		h.lastNTP, h.lastPTS = ntp, pts
+		return ntp, true
+	}
+
+	if h.state == ntpStateInitial {
+		h.logRateLimited(now, logger.Warn, "received RTP packet without absolute time, skipping it")
+		return time.Time{}, false
+	}
+
+	h.setState(ntpStateDerived, now)
+	return h.extrapolate(pts), true
+}
@@ -0,0 +1,168 @@
+package rtsp
+
+import (
+	"XMedia/internal/logger"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+)
+
+type testLogger struct {
+	msgs []string
+}
+
+func (l *testLogger) Log(_ logger.Level, format string, args ...interface{}) {
+	l.msgs = append(l.msgs, fmt.Sprintf(format, args...))
+}
+
+func TestNTPHandler(t *testing.T) {
+	const (
+		none = iota
+		extension
+		senderReport
+	)
+
+	type step struct {
+		// source of the absolute time of the packet
+		source int
+		// absolute time of the source, in seconds from testNTPTime
+		ntp float64
+		// presentation timestamp, in seconds
+		pts float64
+		// expected absolute time, in seconds from testNTPTime; nil when the packet is skipped
+		out *float64
+		// time of reception, in seconds
+		now float64
+		// expected log messages
+		logs []string
+	}
+
+	f := func(v float64) *float64 {
+		return &v
+	}
+
+	for _, ca := range []struct {
+		name  string
+		steps []step
+	}{
+		{
+			"extension in some packets only",
+			[]step{
+				{source: none, pts: 0, out: nil, logs: []string{
+					"received RTP packet without absolute time, skipping it",
+				}},
+				{source: extension, ntp: 10, pts: 1, out: f(10)},
+				{source: none, pts: 2, out: f(11)},
+				{source: none, pts: 3, out: f(12)},
+				{source: extension, ntp: 13.5, pts: 4, out: f(13.5)},
+				{source: none, pts: 5, out: f(14.5)},
+			},
+		},
+		{
+			"sender reports are ignored after an extension",
+			[]step{
+				{source: extension, ntp: 10, pts: 0, out: f(10)},
+				{source: senderReport, ntp: 100, pts: 1, out: f(11)},
+				{source: extension, ntp: 12, pts: 2, out: f(12)},
+				{source: senderReport, ntp: 100, pts: 3, out: f(13)},
+			},
+		},
+		{
+			"switch from sender reports to extension",
+			[]step{
+				{source: senderReport, ntp: 100, pts: 0, out: f(100)},
+				{source: extension, ntp: 10, pts: 1, out: f(10), logs: []string{
+					"absolute time is now read from RTP header extensions",
+				}},
+				{source: senderReport, ntp: 100, pts: 2, out: f(11)},
+			},
+		},
+		{
+			"sender reports lost",
+			[]step{
+				{source: senderReport, ntp: 100, pts: 0, out: f(100)},
+				{source: none, pts: 1, out: f(101), logs: []string{
+					"absolute time is not available anymore, deriving it from RTP timestamps",
+				}},
+				{source: none, pts: 2, out: f(102)},
+				{source: senderReport, ntp: 110, pts: 3, now: 20, out: f(110), logs: []string{
+					"absolute time is available again",
+				}},
+			},
+		},
+		{
+			"state changes are rate limited",
+			[]step{
+				{source: senderReport, ntp: 100, pts: 0, out: f(100)},
+				{source: none, pts: 1, now: 1, out: f(101), logs: []string{
+					"absolute time is not available anymore, deriving it from RTP timestamps",
+				}},
+				{source: senderReport, ntp: 102, pts: 2, now: 2, out: f(102)},
+				{source: none, pts: 3, now: 3, out: f(103)},
+				{source: senderReport, ntp: 104, pts: 4, now: 4, out: f(104)},
+				{source: none, pts: 5, now: 11, out: f(105), logs: []string{
+					"absolute time is not available anymore, deriving it from RTP timestamps",
+				}},
+			},
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			var cur step
+			l := &testLogger{}
+
+			h := &ntpHandler{
+				clockRate:        90000,
+				absCaptureTimeID: 3,
+				senderReportNTP: func(*rtp.Packet) (time.Time, bool) {
+					if cur.source != senderReport {
+						return time.Time{}, false
+					}
+					return testNTPTime.Add(time.Duration(cur.ntp * float64(time.Second))), true
+				},
+				log: l,
+			}
+
+			start := time.Date(2010, 1, 1, 0, 0, 0, 0, time.UTC)
+
+			for i, s := range ca.steps {
+				cur = s
+				l.msgs = nil
+
+				pkt := &rtp.Packet{Header: rtp.Header{Version: 2}}
+				if s.source == extension {
+					v := testNTP + uint64(s.ntp*(1<<32))
+					pkt = absCaptureTimePacket(t, 3, v)
+				}
+
+				ntp, ok := h.process(pkt, int64(s.pts*90000), start.Add(time.Duration(s.now*float64(time.Second))))
+
+				if s.out == nil {
+					require.False(t, ok, "step %d", i)
+				} else {
+					require.True(t, ok, "step %d", i)
+					expected := testNTPTime.Add(time.Duration(*s.out * float64(time.Second)))
+					require.True(t, expected.Equal(ntp), "step %d: %v != %v", i, expected, ntp)
+				}
+
+				require.Equal(t, s.logs, l.msgs, "step %d", i)
+			}
+		})
+	}
+}
+
+func TestNTPHandlerReplace(t *testing.T) {
+	h := &ntpHandler{
+		replace:          true,
+		clockRate:        90000,
+		absCaptureTimeID: 3,
+		log:              &testLogger{},
+	}
+
+	now := time.Date(2010, 1, 1, 0, 0, 0, 0, time.UTC)
+	ntp, ok := h.process(absCaptureTimePacket(t, 3, testNTP), 0, now)
+	require.True(t, ok)
+	require.Equal(t, now, ntp)
+}
@@ -0,0 +1,186 @@
+package rtsp
+
+import (
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+type reorderStats struct {
+	lost       uint64
+	duplicated uint64
+	late       uint64
+}
+
+type reorderPacket struct {
+	pkt      *rtp.Packet
+	received time.Time
+}
+
+// reorderBuffer sorts incoming RTP packets by sequence number.
+// Packets are held until their predecessors arrive, until the buffer
+// spans more than size packets or until they have waited more than latency.
+// Missing predecessors are then declared lost.
+// Latency is checked when packets are received and when expire() is called,
+// that allows to forward packets when the stream stalls.
+type reorderBuffer struct {
+	size    int
+	latency time.Duration
+
+	initialized   bool
+	expected      uint16
+	pending       map[uint16]reorderPacket
+	skipped       map[uint16]struct{}
+	negativeCount int
+}
+
+func (b *reorderBuffer) initialize() {
+	b.pending = make(map[uint16]reorderPacket)
+	b.skipped = make(map[uint16]struct{})
+}
+
+// process processes a RTP packet.
+// It returns the packets that can be forwarded, in order.
+func (b *reorderBuffer) process(pkt *rtp.Packet, now time.Time) ([]*rtp.Packet, reorderStats) {
+	var stats reorderStats
+
+	if !b.initialized {
+		b.initialized = true
+		b.expected = pkt.SequenceNumber + 1
+		return []*rtp.Packet{pkt}, stats
+	}
+
+	relPos := int16(pkt.SequenceNumber - b.expected)
+
+	// packet belongs to a position that has already been forwarded or skipped.
+	if relPos < 0 {
+		b.negativeCount++
+
+		// stream has been resetted, therefore reset buffer too
+		if b.negativeCount > b.size {
+			out := b.flush(&stats)
+			b.negativeCount = 0
+			b.expected = pkt.SequenceNumber + 1
+			return append(out, pkt), stats
+		}
+
+		if _, ok := b.skipped[pkt.SequenceNumber]; ok {
+			delete(b.skipped, pkt.SequenceNumber)
+			stats.late++
+		} else {
+			stats.duplicated++
+		}
+		return nil, stats
+	}
+	b.negativeCount = 0
+
+	if _, ok := b.pending[pkt.SequenceNumber]; ok {
+		stats.duplicated++
+		return nil, stats
+	}
+
+	b.pending[pkt.SequenceNumber] = reorderPacket{pkt: pkt, received: now}
+
+	out := b.drain(nil)
+
+	for len(b.pending) != 0 && (b.span() > b.size || b.expired(now)) {
+		out = b.skip(out, &stats)
+	}
+
+	return out, stats
+}
+
+// expire forwards the packets that have waited more than latency.
+// It returns the packets that can be forwarded, in order.
+func (b *reorderBuffer) expire(now time.Time) ([]*rtp.Packet, reorderStats) {
+	var stats reorderStats
+	var out []*rtp.Packet
+
+	for len(b.pending) != 0 && b.expired(now) {
+		out = b.skip(out, &stats)
+	}
+
+	return out, stats
+}
+
+// deadline returns the time at which the oldest pending packet has to be forwarded,
+// if there's a pending packet and latency is limited.
+func (b *reorderBuffer) deadline() (time.Time, bool) {
+	if b.latency == 0 || len(b.pending) == 0 {
+		return time.Time{}, false
+	}
+	return b.oldest().Add(b.latency), true
+}
+
+func (b *reorderBuffer) expired(now time.Time) bool {
+	return b.latency != 0 && now.Sub(b.oldest()) >= b.latency
+}
+
+// forward consecutive packets starting from the expected one.
+func (b *reorderBuffer) drain(out []*rtp.Packet) []*rtp.Packet {
+	for {
+		p, ok := b.pending[b.expected]
+		if !ok {
+			return out
+		}
+
+		delete(b.pending, b.expected)
+		out = append(out, p.pkt)
+		b.expected++
+	}
+}
+
+// declare lost all packets up to the first pending one.
+func (b *reorderBuffer) skip(out []*rtp.Packet, stats *reorderStats) []*rtp.Packet {
+	first := b.expected
+	minPos := int16(-1)
+
+	for seq := range b.pending {
+		relPos := int16(seq - b.expected)
+		if minPos < 0 || relPos < minPos {
+			minPos = relPos
+			first = seq
+		}
+	}
+
+	// forget positions that are too old to be filled by late packets
+	if len(b.skipped) > 4*b.size {
+		b.skipped = make(map[uint16]struct{})
+	}
+
+	for ; b.expected != first; b.expected++ {
+		b.skipped[b.expected] = struct{}{}
+		stats.lost++
+	}
+
+	return b.drain(out)
+}
+
+// forward all pending packets.
+func (b *reorderBuffer) flush(stats *reorderStats) []*rtp.Packet {
+	var out []*rtp.Packet
+	for len(b.pending) != 0 {
+		out = b.skip(out, stats)
+	}
+	return out
+}
+
+func (b *reorderBuffer) span() int {
+	ret := 0
+	for seq := range b.pending {
+		if relPos := int(int16(seq-b.expected)) + 1; relPos > ret {
+			ret = relPos
+		}
+	}
+	return ret
+}
+
+func (b *reorderBuffer) oldest() time.Time {
+	var ret time.Time
+	for _, p := range b.pending {
+		if ret.IsZero() || p.received.Before(ret) {
+			ret = p.received
+		}
+	}
+	return ret
+}
@@ -0,0 +1,157 @@
+package rtsp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+)
+
+func seqNums(pkts []*rtp.Packet) []uint16 {
+	ret := []uint16{}
+	for _, pkt := range pkts {
+		ret = append(ret, pkt.SequenceNumber)
+	}
+	return ret
+}
+
+func TestReorderBuffer(t *testing.T) {
+	type step struct {
+		seq   uint16
+		out   []uint16
+		stats reorderStats
+	}
+
+	for _, ca := range []struct {
+		name  string
+		steps []step
+	}{
+		{
+			"in order",
+			[]step{
+				{seq: 10, out: []uint16{10}},
+				{seq: 11, out: []uint16{11}},
+				{seq: 12, out: []uint16{12}},
+			},
+		},
+		{
+			"reordered",
+			[]step{
+				{seq: 10, out: []uint16{10}},
+				{seq: 12, out: []uint16{}},
+				{seq: 13, out: []uint16{}},
+				{seq: 11, out: []uint16{11, 12, 13}},
+			},
+		},
+		{
+			"wraparound",
+			[]step{
+				{seq: 65534, out: []uint16{65534}},
+				{seq: 0, out: []uint16{}},
+				{seq: 65535, out: []uint16{65535, 0}},
+				{seq: 2, out: []uint16{}},
+				{seq: 1, out: []uint16{1, 2}},
+			},
+		},
+		{
+			"duplicated",
+			[]step{
+				{seq: 10, out: []uint16{10}},
+				{seq: 10, out: []uint16{}, stats: reorderStats{duplicated: 1}},
+				{seq: 12, out: []uint16{}},
+				{seq: 12, out: []uint16{}, stats: reorderStats{duplicated: 1}},
+				{seq: 11, out: []uint16{11, 12}},
+			},
+		},
+		{
+			"gap",
+			[]step{
+				{seq: 10, out: []uint16{10}},
+				{seq: 13, out: []uint16{}},
+				{seq: 14, out: []uint16{}},
+				{seq: 15, out: []uint16{13, 14, 15}, stats: reorderStats{lost: 2}},
+				{seq: 11, out: []uint16{}, stats: reorderStats{late: 1}},
+				{seq: 11, out: []uint16{}, stats: reorderStats{duplicated: 1}},
+			},
+		},
+		{
+			"gap across wraparound",
+			[]step{
+				{seq: 65534, out: []uint16{65534}},
+				{seq: 1, out: []uint16{}},
+				{seq: 2, out: []uint16{}},
+				{seq: 3, out: []uint16{1, 2, 3}, stats: reorderStats{lost: 2}},
+				{seq: 0, out: []uint16{}, stats: reorderStats{late: 1}},
+			},
+		},
+		{
+			"reset",
+			[]step{
+				{seq: 1000, out: []uint16{1000}},
+				{seq: 10, out: []uint16{}, stats: reorderStats{duplicated: 1}},
+				{seq: 11, out: []uint16{}, stats: reorderStats{duplicated: 1}},
+				{seq: 12, out: []uint16{}, stats: reorderStats{duplicated: 1}},
+				{seq: 13, out: []uint16{}, stats: reorderStats{duplicated: 1}},
+				{seq: 14, out: []uint16{14}},
+				{seq: 15, out: []uint16{15}},
+			},
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			b := &reorderBuffer{size: 4}
+			b.initialize()
+
+			now := time.Date(2008, 5, 20, 22, 15, 25, 0, time.UTC)
+
+			for i, s := range ca.steps {
+				out, stats := b.process(&rtp.Packet{Header: rtp.Header{SequenceNumber: s.seq}}, now)
+				require.Equal(t, s.out, seqNums(out), "step %d", i)
+				require.Equal(t, s.stats, stats, "step %d", i)
+			}
+		})
+	}
+}
+
+func TestReorderBufferExpire(t *testing.T) {
+	b := &reorderBuffer{size: 64, latency: 100 * time.Millisecond}
+	b.initialize()
+
+	now := time.Date(2008, 5, 20, 22, 15, 25, 0, time.UTC)
+
+	_, ok := b.deadline()
+	require.False(t, ok)
+
+	out, _ := b.process(&rtp.Packet{Header: rtp.Header{SequenceNumber: 10}}, now)
+	require.Equal(t, []uint16{10}, seqNums(out))
+
+	out, _ = b.process(&rtp.Packet{Header: rtp.Header{SequenceNumber: 12}}, now)
+	require.Equal(t, []uint16{}, seqNums(out))
+
+	out, _ = b.process(&rtp.Packet{Header: rtp.Header{SequenceNumber: 14}}, now.Add(50*time.Millisecond))
+	require.Equal(t, []uint16{}, seqNums(out))
+
+	deadline, ok := b.deadline()
+	require.True(t, ok)
+	require.Equal(t, now.Add(100*time.Millisecond), deadline)
+
+	// no packet is received, packets are forwarded when their latency is exceeded
+	out, stats := b.expire(now.Add(99 * time.Millisecond))
+	require.Equal(t, []uint16{}, seqNums(out))
+	require.Equal(t, reorderStats{}, stats)
+
+	out, stats = b.expire(now.Add(100 * time.Millisecond))
+	require.Equal(t, []uint16{12}, seqNums(out))
+	require.Equal(t, reorderStats{lost: 1}, stats)
+
+	deadline, ok = b.deadline()
+	require.True(t, ok)
+	require.Equal(t, now.Add(150*time.Millisecond), deadline)
+
+	out, stats = b.expire(now.Add(150 * time.Millisecond))
+	require.Equal(t, []uint16{14}, seqNums(out))
+	require.Equal(t, reorderStats{lost: 1}, stats)
+
+	_, ok = b.deadline()
+	require.False(t, ok)
+}
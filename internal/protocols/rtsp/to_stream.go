@@ -5,6 +5,7 @@ import (
 	"XMedia/internal/conf"
 	"XMedia/internal/logger"
 	"XMedia/internal/stream"
+	"sync"
 	"time"
 
 	"github.com/bluenviron/gortsplib/v4"
@@ -13,14 +14,6 @@ import (
 	"github.com/pion/rtp"
 )
 
-type ntpState int
-
-const (
-	ntpStateInitial ntpState = iota
-	ntpStateReplace
-	ntpStateAvailable
-)
-
 type rtspSource interface {
 	PacketPTS2(*description.Media, *rtp.Packet) (int64, bool)
 	PacketNTP(*description.Media, *rtp.Packet) (time.Time, bool)
@@ -28,62 +21,139 @@ type rtspSource interface {
 }
 
 // ToStream maps a RTSP stream to a MediaMTX stream.
+//
+// When the path uses absolute timestamps, they are taken from the first available source among
+// the ONVIF replay extension, the abs-capture-time extension and RTCP sender reports.
+// absCaptureTimeIDs contains the negotiated IDs of the abs-capture-time extension, if any.
+//
+// It returns a function that must be called before closing the stream,
+// in order to stop the timers of reorder buffers.
 func ToStream(
 	source rtspSource,
 	medias []*description.Media,
+	absCaptureTimeIDs map[*description.Media]uint8,
 	pathConf *conf.Path,
 	strm *stream.Stream,
 	log logger.Writer,
-) {
+) func() {
+	var closers []func()
+
 	for _, medi := range medias {
 		for _, forma := range medi.Formats {
 			cmedi := medi
 			cforma := forma
 
-			var ntpStat ntpState
+			nh := &ntpHandler{
+				replace:          !pathConf.UseAbsoluteTimestamp,
+				clockRate:        cforma.ClockRate(),
+				absCaptureTimeID: absCaptureTimeIDs[cmedi],
+				senderReportNTP: func(pkt *rtp.Packet) (time.Time, bool) {
+					return source.PacketNTP(cmedi, pkt)
+				},
+				log: log,
+			}
+
+			writePacket := func(pkt *rtp.Packet) {
+				pts, ok := source.PacketPTS2(cmedi, pkt)
+				if !ok {
+					return
+				}
+
+				ntp, ok := nh.process(pkt, pts, time.Now())
+				if !ok {
+					return
+				}
 
-			if !pathConf.UseAbsoluteTimestamp {
-				ntpStat = ntpStateReplace
+				strm.WriteRTPPacket(cmedi, cforma, pkt, ntp, pts)
 			}
 
-			handleNTP := func(pkt *rtp.Packet) (time.Time, bool) {
-				switch ntpStat {
-				case ntpStateReplace:
-					return time.Now(), true
+			if pathConf.RtpReorderBufferSize == 0 {
+				source.OnPacketRTP(cmedi, cforma, writePacket)
+				continue
+			}
 
-				case ntpStateInitial:
-					ntp, avail := source.PacketNTP(cmedi, pkt)
-					if !avail {
-						log.Log(logger.Warn, "received RTP packet without absolute time, skipping it")
-						return time.Time{}, false
-					}
+			reorderer := &reorderBuffer{
+				size:    pathConf.RtpReorderBufferSize,
+				latency: time.Duration(pathConf.RtpReorderLatency),
+			}
+			reorderer.initialize()
 
-					ntpStat = ntpStateAvailable
-					return ntp, true
+			// packets are forwarded by the reader of the session and by the timer,
+			// that fires when the oldest pending packet exceeds latency.
+			var mutex sync.Mutex
+			var timer *time.Timer
+			closed := false
 
-				default: // ntpStateAvailable
-					ntp, avail := source.PacketNTP(cmedi, pkt)
-					if !avail {
-						panic("should not happen")
-					}
+			forward := func(pkts []*rtp.Packet, stats reorderStats) {
+				if stats.lost != 0 {
+					strm.AddPacketsLost(cmedi, cforma, stats.lost)
+				}
+				if stats.duplicated != 0 {
+					strm.AddPacketsDuplicated(cmedi, cforma, stats.duplicated)
+				}
+				if stats.late != 0 {
+					strm.AddPacketsLate(cmedi, cforma, stats.late)
+				}
 
-					return ntp, true
+				for _, pkt := range pkts {
+					writePacket(pkt)
 				}
 			}
 
-			source.OnPacketRTP(cmedi, cforma, func(pkt *rtp.Packet) {
-				pts, ok := source.PacketPTS2(cmedi, pkt)
+			var onTimeout func()
+
+			scheduleTimeout := func() {
+				deadline, ok := reorderer.deadline()
 				if !ok {
 					return
 				}
 
-				ntp, ok := handleNTP(pkt)
-				if !ok {
+				if timer == nil {
+					timer = time.AfterFunc(time.Until(deadline), onTimeout)
+				} else {
+					timer.Reset(time.Until(deadline))
+				}
+			}
+
+			onTimeout = func() {
+				mutex.Lock()
+				defer mutex.Unlock()
+
+				if closed {
 					return
 				}
 
-				strm.WriteRTPPacket(cmedi, cforma, pkt, ntp, pts)
+				forward(reorderer.expire(time.Now()))
+				scheduleTimeout()
+			}
+
+			source.OnPacketRTP(cmedi, cforma, func(pkt *rtp.Packet) {
+				mutex.Lock()
+				defer mutex.Unlock()
+
+				if closed {
+					return
+				}
+
+				forward(reorderer.process(pkt, time.Now()))
+				scheduleTimeout()
+			})
+
+			closers = append(closers, func() {
+				mutex.Lock()
+				defer mutex.Unlock()
+
+				closed = true
+				if timer != nil {
+					timer.Stop()
+				}
 			})
 		}
 	}
+
+	return func() {
+		for _, c := range closers {
+			c()
+		}
+	}
 }
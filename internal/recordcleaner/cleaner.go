@@ -0,0 +1,395 @@
+// Package recordcleaner contains the recording cleaner.
+package recordcleaner
+
+import (
+	"XMedia/internal/conf"
+	"XMedia/internal/logger"
+	"XMedia/internal/recordstore"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maximum interval between two cleanups.
+const maxInterval = 30 * time.Minute
+
+// interval between two cleanups when storage limits are set.
+const storageLimitsInterval = 10 * time.Second
+
+// segment is a segment found by the cleaner.
+type segment struct {
+	fpath      string
+	recordPath string
+	pathName   string
+	start      time.Time
+	size       uint64
+	locked     bool
+	// whether the segment is the most recent one of its path, that may be being written.
+	last    bool
+	removed bool
+}
+
+// markLastSegments marks the most recent segment of every path, segments must be sorted by start.
+func markLastSegments(segments []*segment) {
+	last := make(map[string]*segment)
+	for _, seg := range segments {
+		last[seg.pathName] = seg
+	}
+	for _, seg := range last {
+		seg.last = true
+	}
+}
+
+// Cleaner removes recording segments that are older than recordDeleteAfter,
+// and the oldest segments when storage limits are exceeded.
+// Locked segments are never removed.
+type Cleaner struct {
+	PathConfs    map[string]*conf.Path
+	PathDefaults *conf.Path
+	// maximum size of the recordings of all paths. 0 means unlimited.
+	MaxSize uint64
+	// minimum free space of disks that contain recordings. 0 disables the check.
+	MinFreeSpace uint64
+	Parent       logger.Writer
+
+	// overrides freeSpace in tests.
+	freeSpaceFunc func(dir string) (uint64, error)
+
+	ctx       context.Context
+	ctxCancel func()
+
+	done chan struct{}
+}
+
+// Initialize initializes a Cleaner.
+func (c *Cleaner) Initialize() {
+	c.ctx, c.ctxCancel = context.WithCancel(context.Background())
+	c.done = make(chan struct{})
+
+	go c.run()
+}
+
+// Close closes the Cleaner.
+func (c *Cleaner) Close() {
+	c.ctxCancel()
+	<-c.done
+}
+
+// Log implements logger.Writer.
+func (c *Cleaner) Log(level logger.Level, format string, args ...interface{}) {
+	c.Parent.Log(level, "[record cleaner] "+format, args...)
+}
+
+// all path configurations, including defaults.
+func (c *Cleaner) pathConfs() []*conf.Path {
+	ret := []*conf.Path{c.PathDefaults}
+	for _, pconf := range c.PathConfs {
+		ret = append(ret, pconf)
+	}
+	return ret
+}
+
+func (c *Cleaner) interval() time.Duration {
+	ret := maxInterval
+
+	if c.MaxSize != 0 || c.MinFreeSpace != 0 {
+		ret = storageLimitsInterval
+	}
+
+	for _, pconf := range c.pathConfs() {
+		if pconf.RecordMaxSize != 0 {
+			ret = min(ret, storageLimitsInterval)
+		}
+
+		if pconf.RecordDeleteAfter > 0 {
+			if v := time.Duration(pconf.RecordDeleteAfter) / 2; v < ret {
+				ret = v
+			}
+		}
+	}
+
+	return ret
+}
+
+func (c *Cleaner) run() {
+	defer close(c.done)
+
+	interval := c.interval()
+
+	c.doRun()
+
+	for {
+		select {
+		case <-time.After(interval):
+			c.doRun()
+
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+func (c *Cleaner) doRun() {
+	now := time.Now()
+
+	var segments []*segment
+	var recordPaths []string
+
+	// different paths can share the same record path
+	done := make(map[string]struct{})
+
+	// names of recorded paths, by record path
+	pathNames := make(map[string]map[string]struct{})
+
+	// time at which the walk of every record path started
+	walkStarts := make(map[string]time.Time)
+
+	for _, pconf := range c.pathConfs() {
+		if _, ok := done[pconf.RecordPath]; ok || pconf.RecordPath == "" {
+			continue
+		}
+		done[pconf.RecordPath] = struct{}{}
+
+		pathNames[pconf.RecordPath] = make(map[string]struct{})
+		walkStarts[pconf.RecordPath] = time.Now()
+		segments = append(segments, c.processPath(now, pconf.RecordPath, pathNames[pconf.RecordPath])...)
+		recordPaths = append(recordPaths, pconf.RecordPath)
+	}
+
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].start.Before(segments[j].start)
+	})
+
+	markLastSegments(segments)
+
+	c.enforcePathMaxSize(segments)
+	c.enforceMaxSize(segments)
+	c.enforceMinFreeSpace(segments, recordPaths)
+
+	for _, recordPath := range recordPaths {
+		c.pruneTimelines(walkStarts[recordPath], recordPath, pathNames[recordPath], segments)
+		removeEmptyDirs(recordstore.CommonPath(recordPath), false)
+	}
+}
+
+// processPath removes expired segments of a record path and returns the remaining ones.
+// Names of paths that have segments are added to pathNames.
+func (c *Cleaner) processPath(now time.Time, recordPath string, pathNames map[string]struct{}) []*segment {
+	var ret []*segment
+
+	filepath.WalkDir(recordstore.CommonPath(recordPath), func(fpath string, info fs.DirEntry, err error) error { //nolint:errcheck
+		if err != nil || info.IsDir() {
+			return nil
+		}
+
+		// lock files of segments that don't exist anymore
+		if filepath.Ext(fpath) == recordstore.LockExt {
+			if _, err := os.Stat(strings.TrimSuffix(fpath, recordstore.LockExt)); errors.Is(err, fs.ErrNotExist) {
+				os.Remove(fpath)
+			}
+			return nil
+		}
+
+		var pa recordstore.Path
+		if !pa.Decode(recordPath, strings.TrimSuffix(fpath, filepath.Ext(fpath))) {
+			return nil
+		}
+
+		pconf := conf.FindPathConf(c.PathConfs, c.PathDefaults, pa.Path)
+		if pconf.RecordPath != recordPath {
+			return nil
+		}
+
+		pathNames[pa.Path] = struct{}{}
+
+		locked := recordstore.IsLocked(fpath)
+
+		if !locked && pconf.RecordDeleteAfter != 0 && now.Sub(pa.Start) > time.Duration(pconf.RecordDeleteAfter) {
+			c.Log(logger.Info, "removing %s", fpath)
+			os.Remove(fpath)
+			return nil
+		}
+
+		fi, err := info.Info()
+		if err != nil {
+			return nil
+		}
+
+		ret = append(ret, &segment{
+			fpath:      fpath,
+			recordPath: recordPath,
+			pathName:   pa.Path,
+			start:      pa.Start,
+			size:       uint64(fi.Size()),
+			locked:     locked,
+		})
+
+		return nil
+	})
+
+	return ret
+}
+
+// pruneTimelines removes segments that don't exist anymore from the timelines of a record path.
+// Only segments that started before the walk of the record path are considered,
+// and segments that are not among the walked ones are looked up again,
+// since they may have been created after they were walked.
+func (c *Cleaner) pruneTimelines(walkStart time.Time, recordPath string, pathNames map[string]struct{}, segments []*segment) {
+	existing := make(map[string]struct{})
+	for _, seg := range segments {
+		if seg.recordPath == recordPath && !seg.removed {
+			existing[filepath.Clean(strings.TrimSuffix(seg.fpath, filepath.Ext(seg.fpath)))] = struct{}{}
+		}
+	}
+
+	for pathName := range pathNames {
+		err := recordstore.PruneTimeline(recordPath, pathName, walkStart, func(start time.Time) bool {
+			fpath := filepath.Clean(recordstore.Path{Path: pathName, Start: start}.Encode(recordPath))
+			if _, ok := existing[fpath]; ok {
+				return true
+			}
+			return segmentExists(fpath)
+		})
+		if err != nil {
+			c.Log(logger.Warn, "unable to update timeline of path '%s': %v", pathName, err)
+		}
+	}
+}
+
+// segmentExists checks whether a segment exists, in any format.
+func segmentExists(fpathNoExt string) bool {
+	for _, ext := range []string{".mp4", ".ts"} {
+		if _, err := os.Stat(fpathNoExt + ext); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// enforcePathMaxSize removes the oldest segments of paths whose recordings exceed recordMaxSize.
+func (c *Cleaner) enforcePathMaxSize(segments []*segment) {
+	byPath := make(map[string][]*segment)
+	for _, seg := range segments {
+		byPath[seg.pathName] = append(byPath[seg.pathName], seg)
+	}
+
+	for pathName, pathSegments := range byPath {
+		pconf := conf.FindPathConf(c.PathConfs, c.PathDefaults, pathName)
+		if pconf.RecordMaxSize == 0 {
+			continue
+		}
+
+		c.evictUntil(pathSegments, func(total uint64) bool {
+			return total <= pconf.RecordMaxSize
+		}, fmt.Sprintf("recordings of path '%s' exceed recordMaxSize", pathName))
+	}
+}
+
+// enforceMaxSize removes the oldest segments when recordings of all paths exceed recordStorageMaxSize.
+func (c *Cleaner) enforceMaxSize(segments []*segment) {
+	if c.MaxSize == 0 {
+		return
+	}
+
+	c.evictUntil(segments, func(total uint64) bool {
+		return total <= c.MaxSize
+	}, "recordings exceed recordStorageMaxSize")
+}
+
+// enforceMinFreeSpace removes the oldest segments of record paths
+// whose disk has less free space than recordStorageMinFreeSpace.
+func (c *Cleaner) enforceMinFreeSpace(segments []*segment, recordPaths []string) {
+	if c.MinFreeSpace == 0 {
+		return
+	}
+
+	getFreeSpace := c.freeSpaceFunc
+	if getFreeSpace == nil {
+		getFreeSpace = freeSpace
+	}
+
+	for _, recordPath := range recordPaths {
+		commonPath := recordstore.CommonPath(recordPath)
+
+		var pathSegments []*segment
+		for _, seg := range segments {
+			if seg.recordPath == recordPath {
+				pathSegments = append(pathSegments, seg)
+			}
+		}
+
+		c.evictUntil(pathSegments, func(uint64) bool {
+			free, err := getFreeSpace(commonPath)
+			// the check can't be performed, for instance because the directory doesn't exist yet
+			if err != nil {
+				return true
+			}
+			return free >= c.MinFreeSpace
+		}, fmt.Sprintf("free space of the disk that contains %s is lower than recordStorageMinFreeSpace", commonPath))
+	}
+}
+
+// evictUntil removes the oldest segments until a condition is satisfied.
+// The condition receives the total size of the remaining segments.
+// Segments that are locked or are being written are kept.
+func (c *Cleaner) evictUntil(segments []*segment, satisfied func(total uint64) bool, reason string) {
+	total := uint64(0)
+	for _, seg := range segments {
+		if !seg.removed {
+			total += seg.size
+		}
+	}
+
+	if satisfied(total) {
+		return
+	}
+
+	for _, seg := range segments {
+		if seg.removed || seg.locked || seg.last {
+			continue
+		}
+
+		c.Log(logger.Warn, "%s, removing %s", reason, seg.fpath)
+
+		err := os.Remove(seg.fpath)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			c.Log(logger.Error, "unable to remove %s: %v", seg.fpath, err)
+			continue
+		}
+
+		seg.removed = true
+		total -= seg.size
+
+		if satisfied(total) {
+			return
+		}
+	}
+
+	c.Log(logger.Warn, "%s, but all remaining segments are locked or being written", reason)
+}
+
+// removeEmptyDirs removes the empty subdirectories of a directory, and the directory itself when asked.
+func removeEmptyDirs(dir string, removeSelf bool) bool {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return false
+	}
+
+	empty := true
+	for _, entry := range entries {
+		if !entry.IsDir() || !removeEmptyDirs(filepath.Join(dir, entry.Name()), true) {
+			empty = false
+		}
+	}
+
+	if empty && removeSelf {
+		return os.Remove(dir) == nil
+	}
+	return false
+}
@@ -0,0 +1,233 @@
+package recordcleaner
+
+import (
+	"XMedia/internal/conf"
+	"XMedia/internal/logger"
+	"XMedia/internal/recordstore"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type nilLogger struct{}
+
+func (nilLogger) Log(logger.Level, string, ...interface{}) {}
+
+type testLogger struct {
+	warnings []string
+}
+
+func (l *testLogger) Log(level logger.Level, format string, args ...interface{}) {
+	if level == logger.Warn {
+		l.warnings = append(l.warnings, fmt.Sprintf(format, args...))
+	}
+}
+
+// diskUsage returns the size of the segments contained in a directory.
+func diskUsage(t *testing.T, dir string) uint64 {
+	total := uint64(0)
+	err := filepath.WalkDir(dir, func(_ string, info fs.DirEntry, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		fi, err := info.Info()
+		if err != nil {
+			return err
+		}
+		total += uint64(fi.Size())
+		return nil
+	})
+	require.NoError(t, err)
+	return total
+}
+
+func TestCleanerStorageLimits(t *testing.T) {
+	start := time.Date(2008, 5, 20, 22, 15, 25, 0, time.Local)
+
+	for _, ca := range []struct {
+		name         string
+		pathMaxSize  uint64
+		maxSize      uint64
+		minFreeSpace uint64
+		locked       []int
+		remaining    []int
+		exhausted    bool
+	}{
+		{
+			name:        "path max size",
+			pathMaxSize: 150,
+			remaining:   []int{1, 3, 4, 5},
+		},
+		{
+			name:        "path max size with locked segment",
+			pathMaxSize: 150,
+			locked:      []int{0},
+			remaining:   []int{0, 1, 3, 4, 5},
+			exhausted:   true,
+		},
+		{
+			name:      "max size",
+			maxSize:   350,
+			remaining: []int{3, 4, 5},
+		},
+		{
+			name:      "max size with locked segment",
+			maxSize:   350,
+			locked:    []int{1},
+			remaining: []int{1, 4, 5},
+		},
+		{
+			name:         "min free space",
+			minFreeSpace: 550,
+			remaining:    []int{2, 3, 4, 5},
+		},
+		{
+			name:         "min free space with locked segment",
+			minFreeSpace: 550,
+			locked:       []int{0},
+			remaining:    []int{0, 3, 4, 5},
+		},
+		{
+			name:      "segments being written",
+			maxSize:   1,
+			locked:    []int{2},
+			remaining: []int{2, 4, 5},
+			exhausted: true,
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			dir := t.TempDir()
+			recordPath := filepath.Join(dir, "%path/%Y-%m-%d_%H-%M-%S-%f")
+
+			// segments of 100 bytes, that belong alternately to cam1 and cam2,
+			// in chronological order.
+			var fpaths []string
+			for i := 0; i < 6; i++ {
+				pathName := "cam1"
+				if i%2 != 0 {
+					pathName = "cam2"
+				}
+
+				fpath := recordstore.Path{
+					Path:  pathName,
+					Start: start.Add(time.Duration(i) * time.Minute),
+				}.Encode(recordPath) + ".mp4"
+				require.NoError(t, os.MkdirAll(filepath.Dir(fpath), 0o755))
+				require.NoError(t, os.WriteFile(fpath, make([]byte, 100), 0o644))
+				fpaths = append(fpaths, fpath)
+			}
+
+			for _, i := range ca.locked {
+				require.NoError(t, recordstore.SetLocked(fpaths[i], true))
+			}
+
+			l := &testLogger{}
+
+			c := &Cleaner{
+				PathConfs: map[string]*conf.Path{
+					"cam1": {
+						Name:          "cam1",
+						RecordPath:    recordPath,
+						RecordMaxSize: ca.pathMaxSize,
+					},
+				},
+				PathDefaults: &conf.Path{
+					RecordPath: recordPath,
+				},
+				MaxSize:      ca.maxSize,
+				MinFreeSpace: ca.minFreeSpace,
+				// disk with a capacity of 1000 bytes
+				freeSpaceFunc: func(string) (uint64, error) {
+					return 1000 - diskUsage(t, dir), nil
+				},
+				Parent: l,
+			}
+			c.doRun()
+
+			var remaining []int
+			for i, fpath := range fpaths {
+				if _, err := os.Stat(fpath); err == nil {
+					remaining = append(remaining, i)
+				}
+			}
+			require.Equal(t, ca.remaining, remaining)
+
+			// locks are kept
+			for _, i := range ca.locked {
+				require.True(t, recordstore.IsLocked(fpaths[i]))
+			}
+
+			exhausted := false
+			for _, w := range l.warnings {
+				if strings.HasSuffix(w, "but all remaining segments are locked or being written") {
+					exhausted = true
+				}
+			}
+			require.Equal(t, ca.exhausted, exhausted)
+		})
+	}
+}
+
+func TestPruneTimelines(t *testing.T) {
+	dir := t.TempDir()
+	recordPath := filepath.Join(dir, "%path/%Y-%m-%d_%H-%M-%S-%f")
+
+	walkStart := time.Date(2008, 5, 20, 22, 15, 25, 0, time.Local)
+
+	createSegment := func(start time.Time) string {
+		fpath := recordstore.Path{Path: "mypath", Start: start}.Encode(recordPath) + ".mp4"
+		require.NoError(t, os.MkdirAll(filepath.Dir(fpath), 0o755))
+		require.NoError(t, os.WriteFile(fpath, []byte{1}, 0o644))
+		return fpath
+	}
+
+	walked := walkStart.Add(-3 * time.Minute)
+	removed := walkStart.Add(-2 * time.Minute)
+	createdAfterWalk := walkStart.Add(-1 * time.Minute)
+	startedAfterWalk := walkStart.Add(1 * time.Minute)
+
+	for _, start := range []time.Time{walked, removed, createdAfterWalk, startedAfterWalk} {
+		require.NoError(t, recordstore.UpdateTimeline(recordPath, "mypath", start, start.Add(time.Minute)))
+	}
+
+	segments := []*segment{
+		{
+			fpath:      createSegment(walked),
+			recordPath: recordPath,
+			pathName:   "mypath",
+			start:      walked,
+		},
+		{
+			fpath:      recordstore.Path{Path: "mypath", Start: removed}.Encode(recordPath) + ".mp4",
+			recordPath: recordPath,
+			pathName:   "mypath",
+			start:      removed,
+			removed:    true,
+		},
+	}
+
+	// segments that were created during the walk
+	createSegment(createdAfterWalk)
+
+	c := &Cleaner{Parent: nilLogger{}}
+	c.pruneTimelines(walkStart, recordPath, map[string]struct{}{"mypath": {}}, segments)
+
+	entries, err := recordstore.ReadTimeline(recordPath, "mypath")
+	require.NoError(t, err)
+
+	var starts []time.Time
+	for _, entry := range entries {
+		starts = append(starts, entry.Start)
+	}
+
+	require.Len(t, starts, 3)
+	require.True(t, starts[0].Equal(walked))
+	require.True(t, starts[1].Equal(createdAfterWalk))
+	require.True(t, starts[2].Equal(startedAfterWalk))
+}
@@ -0,0 +1,18 @@
+//go:build !windows
+
+package recordcleaner
+
+import (
+	"syscall"
+)
+
+// freeSpace returns the space available to unprivileged users on the disk that contains a directory.
+func freeSpace(dir string) (uint64, error) {
+	var st syscall.Statfs_t
+	err := syscall.Statfs(dir, &st)
+	if err != nil {
+		return 0, err
+	}
+
+	return uint64(st.Bavail) * uint64(st.Bsize), nil //nolint:unconvert
+}
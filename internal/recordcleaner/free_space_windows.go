@@ -0,0 +1,23 @@
+//go:build windows
+
+package recordcleaner
+
+import (
+	"golang.org/x/sys/windows"
+)
+
+// freeSpace returns the space available to the current user on the disk that contains a directory.
+func freeSpace(dir string) (uint64, error) {
+	dirPtr, err := windows.UTF16PtrFromString(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	var free uint64
+	err = windows.GetDiskFreeSpaceEx(dirPtr, &free, nil, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	return free, nil
+}
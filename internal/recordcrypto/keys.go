@@ -0,0 +1,91 @@
+// Package recordcrypto contains the encryption of recording segments.
+package recordcrypto
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// key is an encryption key.
+type key struct {
+	// first bytes of the SHA-256 hash of the key, that identify the key inside segments.
+	id   [8]byte
+	aead cipher.AEAD
+}
+
+// Keys are the keys of a key file.
+// The first key encrypts new segments, all keys decrypt existing ones.
+type Keys struct {
+	keys []*key
+}
+
+// LoadKeys reads a key file, that contains one hex-encoded AES key (16, 24 or 32 bytes) per line.
+// Empty lines and lines starting with # are ignored.
+func LoadKeys(fpath string) (*Keys, error) {
+	f, err := os.Open(fpath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ks := &Keys{}
+
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		raw, err := hex.DecodeString(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s: line %d: invalid key", fpath, n)
+		}
+
+		block, err := aes.NewCipher(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s: line %d: %w", fpath, n, err)
+		}
+
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+
+		k := &key{aead: aead}
+		sum := sha256.Sum256(raw)
+		copy(k.id[:], sum[:])
+
+		ks.keys = append(ks.keys, k)
+	}
+
+	err = scanner.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ks.keys) == 0 {
+		return nil, fmt.Errorf("%s: no keys found", fpath)
+	}
+
+	return ks, nil
+}
+
+func (ks *Keys) encryptionKey() *key {
+	return ks.keys[0]
+}
+
+func (ks *Keys) find(id [8]byte) *key {
+	for _, k := range ks.keys {
+		if k.id == id {
+			return k
+		}
+	}
+	return nil
+}
@@ -0,0 +1,426 @@
+package recordcrypto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// ErrNoKeys is returned when a segment is encrypted and keys are not available.
+var ErrNoKeys = errors.New("segment is encrypted, but no encryption key is set")
+
+// IsEncrypted returns whether a segment is encrypted.
+func IsEncrypted(r io.ReaderAt) bool {
+	buf := make([]byte, len(magic))
+	_, err := r.ReadAt(buf, 0)
+	return err == nil && string(buf) == magic
+}
+
+type chunk struct {
+	// offset of the chunk inside the segment.
+	offset int64
+	// offset of the plaintext of the chunk inside the decrypted segment.
+	plainOffset int64
+	cipherSize  int64
+}
+
+// Reader decrypts an encrypted segment, allowing random access to its content.
+// ReadAt can be called concurrently, while Read and Seek can't.
+type Reader struct {
+	R    io.ReaderAt
+	Size int64
+	Keys *Keys
+
+	key       *key
+	header    []byte
+	chunks    []*chunk
+	final     *chunk
+	plainSize int64
+	pos       int64
+
+	// last decrypted chunk
+	cacheMutex  sync.Mutex
+	cachedIndex int
+	cached      []byte
+}
+
+// Initialize reads the header and the sizes of chunks.
+// A truncated chunk at the end of the segment is ignored, and so is
+// anything that follows the final chunk.
+func (r *Reader) Initialize() error {
+	if r.Keys == nil {
+		return ErrNoKeys
+	}
+
+	r.header = make([]byte, headerSize)
+	_, err := r.R.ReadAt(r.header, 0)
+	if err != nil {
+		return fmt.Errorf("invalid header: %w", err)
+	}
+
+	if string(r.header[:4]) != magic {
+		return fmt.Errorf("segment is not encrypted")
+	}
+
+	if r.header[4] != version {
+		return fmt.Errorf("unsupported version %d", r.header[4])
+	}
+
+	var id [8]byte
+	copy(id[:], r.header[5:])
+
+	r.key = r.Keys.find(id)
+	if r.key == nil {
+		return fmt.Errorf("segment is encrypted with an unknown key")
+	}
+
+	offset := int64(headerSize)
+	buf := make([]byte, 4)
+
+	for {
+		_, err := r.R.ReadAt(buf, offset)
+		if err != nil {
+			break
+		}
+
+		cipherSize := int64(binary.BigEndian.Uint32(buf))
+		if cipherSize < int64(r.key.aead.Overhead()) || cipherSize > maxChunkSize ||
+			offset+chunkHeaderSize+cipherSize > r.Size {
+			break
+		}
+
+		c := &chunk{
+			offset:      offset,
+			plainOffset: r.plainSize,
+			cipherSize:  cipherSize,
+		}
+
+		// only the final chunk is empty
+		if cipherSize == int64(r.key.aead.Overhead()) {
+			if r.isFinal(c, uint64(len(r.chunks))) {
+				r.final = c
+			}
+			break
+		}
+
+		r.chunks = append(r.chunks, c)
+
+		offset += chunkHeaderSize + cipherSize
+		r.plainSize += cipherSize - int64(r.key.aead.Overhead())
+	}
+
+	r.cachedIndex = -1
+
+	return nil
+}
+
+// PlainSize returns the size of the decrypted segment.
+func (r *Reader) PlainSize() int64 {
+	return r.plainSize
+}
+
+// Complete returns whether the segment ends with the final chunk,
+// that is written when the segment is closed.
+// An incomplete segment has either been truncated or its writing has been interrupted.
+func (r *Reader) Complete() bool {
+	return r.final != nil
+}
+
+// ValidSize returns the size of the segment without the truncated chunk at the end, if any.
+func (r *Reader) ValidSize() int64 {
+	if r.final != nil {
+		return r.final.offset + chunkHeaderSize + r.final.cipherSize
+	}
+
+	if len(r.chunks) == 0 {
+		return headerSize
+	}
+
+	last := r.chunks[len(r.chunks)-1]
+	return last.offset + chunkHeaderSize + last.cipherSize
+}
+
+func (r *Reader) openChunk(c *chunk, index uint64, final bool) ([]byte, error) {
+	buf := make([]byte, chunkHeaderSize+c.cipherSize)
+	_, err := r.R.ReadAt(buf, c.offset)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.key.aead.Open(nil, buf[4:chunkHeaderSize], buf[chunkHeaderSize:], chunkAAD(r.header, index, final))
+}
+
+// isFinal checks whether an empty chunk is the final chunk.
+func (r *Reader) isFinal(c *chunk, index uint64) bool {
+	_, err := r.openChunk(c, index, true)
+	return err == nil
+}
+
+func (r *Reader) decryptChunk(i int) ([]byte, error) {
+	r.cacheMutex.Lock()
+	if i == r.cachedIndex {
+		defer r.cacheMutex.Unlock()
+		return r.cached, nil
+	}
+	r.cacheMutex.Unlock()
+
+	plain, err := r.openChunk(r.chunks[i], uint64(i), false)
+	if err != nil {
+		return nil, fmt.Errorf("chunk %d is corrupted: %w", i, err)
+	}
+
+	r.cacheMutex.Lock()
+	r.cachedIndex = i
+	r.cached = plain
+	r.cacheMutex.Unlock()
+
+	return plain, nil
+}
+
+// findChunk returns the index of the chunk that contains a plaintext offset.
+func (r *Reader) findChunk(off int64) int {
+	lo, hi := 0, len(r.chunks)-1
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		if r.chunks[mid].plainOffset <= off {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	return lo
+}
+
+// ReadAt implements io.ReaderAt.
+func (r *Reader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset")
+	}
+
+	n := 0
+
+	for n < len(p) {
+		if off >= r.plainSize {
+			return n, io.EOF
+		}
+
+		i := r.findChunk(off)
+		plain, err := r.decryptChunk(i)
+		if err != nil {
+			return n, err
+		}
+
+		copied := copy(p[n:], plain[off-r.chunks[i].plainOffset:])
+		n += copied
+		off += int64(copied)
+	}
+
+	return n, nil
+}
+
+// Read implements io.Reader.
+func (r *Reader) Read(p []byte) (int, error) {
+	if r.pos >= r.plainSize {
+		return 0, io.EOF
+	}
+
+	if int64(len(p)) > r.plainSize-r.pos {
+		p = p[:r.plainSize-r.pos]
+	}
+
+	n, err := r.ReadAt(p, r.pos)
+	r.pos += int64(n)
+	return n, err
+}
+
+// Seek implements io.Seeker.
+func (r *Reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.plainSize
+	default:
+		return 0, fmt.Errorf("invalid whence")
+	}
+
+	if offset < 0 {
+		return 0, fmt.Errorf("negative position")
+	}
+
+	r.pos = offset
+	return offset, nil
+}
+
+// File is a segment opened for reading.
+type File interface {
+	io.ReadSeeker
+	io.ReaderAt
+	io.Closer
+}
+
+type decryptedFile struct {
+	*Reader
+	f *os.File
+}
+
+func (f *decryptedFile) Close() error {
+	return f.f.Close()
+}
+
+// Open opens a segment for reading, decrypting it when it is encrypted.
+// keys can be nil when encryption is not in use.
+func Open(fpath string, keys *Keys) (File, error) {
+	f, err := os.Open(fpath)
+	if err != nil {
+		return nil, err
+	}
+
+	if !IsEncrypted(f) {
+		return f, nil
+	}
+
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	r := &Reader{
+		R:    f,
+		Size: st.Size(),
+		Keys: keys,
+	}
+	err = r.Initialize()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &decryptedFile{Reader: r, f: f}, nil
+}
+
+// Truncate truncates an encrypted segment to a size of its decrypted content,
+// then closes the segment with the final chunk.
+// When the size falls inside a chunk, the chunk is encrypted again.
+// It returns the new size of the segment.
+func Truncate(fpath string, keys *Keys, plainSize int64) (int64, error) {
+	f, err := os.OpenFile(fpath, os.O_RDWR, 0o644)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	st, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	r := &Reader{
+		R:    f,
+		Size: st.Size(),
+		Keys: keys,
+	}
+	err = r.Initialize()
+	if err != nil {
+		return 0, err
+	}
+
+	if plainSize > r.plainSize {
+		return 0, fmt.Errorf("size exceeds the content of the segment")
+	}
+
+	newSize := r.ValidSize()
+
+	// a complete segment is kept as is, without what follows the final chunk
+	if plainSize < r.plainSize || r.final == nil {
+		count := len(r.chunks)
+		newSize = headerSize
+		if count != 0 {
+			newSize = r.chunks[count-1].offset + chunkHeaderSize + r.chunks[count-1].cipherSize
+		}
+
+		if plainSize < r.plainSize {
+			i := r.findChunk(plainSize)
+			c := r.chunks[i]
+			newSize = c.offset
+			count = i
+
+			if plainSize != c.plainOffset {
+				plain, err := r.decryptChunk(i)
+				if err != nil {
+					return 0, err
+				}
+
+				buf, err := sealChunk(r.key, r.header, uint64(i), false, bytes.Clone(plain[:plainSize-c.plainOffset]))
+				if err != nil {
+					return 0, err
+				}
+
+				_, err = f.WriteAt(buf, c.offset)
+				if err != nil {
+					return 0, err
+				}
+
+				newSize += int64(len(buf))
+				count++
+			}
+		}
+
+		buf, err := sealChunk(r.key, r.header, uint64(count), true, nil)
+		if err != nil {
+			return 0, err
+		}
+
+		_, err = f.WriteAt(buf, newSize)
+		if err != nil {
+			return 0, err
+		}
+
+		newSize += int64(len(buf))
+	}
+
+	err = f.Truncate(newSize)
+	if err != nil {
+		return 0, err
+	}
+
+	err = f.Sync()
+	if err != nil {
+		return 0, err
+	}
+
+	return newSize, nil
+}
+
+// DecryptFile decrypts an encrypted segment into a new file.
+func DecryptFile(keys *Keys, inPath string, outPath string) error {
+	in, err := Open(inPath, keys)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	if _, ok := in.(*decryptedFile); !ok {
+		return fmt.Errorf("%s is not encrypted", inPath)
+	}
+
+	out, err := os.Create(outPath)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(out, in)
+	if err != nil {
+		out.Close()
+		os.Remove(outPath)
+		return err
+	}
+
+	return out.Close()
+}
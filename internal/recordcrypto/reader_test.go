@@ -0,0 +1,285 @@
+package recordcrypto
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testKey1 = "000102030405060708090a0b0c0d0e0f"
+	testKey2 = "f0e0d0c0b0a090807060504030201000f0e0d0c0b0a090807060504030201000"
+)
+
+func loadTestKeys(t *testing.T, keys ...string) *Keys {
+	fpath := filepath.Join(t.TempDir(), "keys")
+	err := os.WriteFile(fpath, []byte("# keys\n"+strings.Join(keys, "\n")+"\n"), 0o644)
+	require.NoError(t, err)
+
+	ks, err := LoadKeys(fpath)
+	require.NoError(t, err)
+	return ks
+}
+
+// writeTestSegment encrypts every chunk with a separate call to Write().
+func writeTestSegment(t *testing.T, keys *Keys, chunks [][]byte, closed bool) []byte {
+	var buf bytes.Buffer
+
+	w := &Writer{W: &buf, Keys: keys}
+	err := w.Initialize()
+	require.NoError(t, err)
+
+	for _, c := range chunks {
+		_, err = w.Write(c)
+		require.NoError(t, err)
+	}
+
+	if closed {
+		err = w.Close()
+		require.NoError(t, err)
+	}
+
+	return buf.Bytes()
+}
+
+func newTestReader(t *testing.T, keys *Keys, byts []byte) *Reader {
+	r := &Reader{
+		R:    bytes.NewReader(byts),
+		Size: int64(len(byts)),
+		Keys: keys,
+	}
+	err := r.Initialize()
+	require.NoError(t, err)
+	return r
+}
+
+var testChunks = [][]byte{
+	bytes.Repeat([]byte{1}, 100),
+	bytes.Repeat([]byte{2}, 50),
+	bytes.Repeat([]byte{3}, 200),
+}
+
+func TestReaderRoundTrip(t *testing.T) {
+	keys := loadTestKeys(t, testKey1)
+	byts := writeTestSegment(t, keys, testChunks, true)
+
+	require.True(t, IsEncrypted(bytes.NewReader(byts)))
+
+	r := newTestReader(t, keys, byts)
+	require.True(t, r.Complete())
+	require.Equal(t, int64(len(byts)), r.ValidSize())
+	require.Equal(t, int64(350), r.PlainSize())
+
+	plain, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, bytes.Join(testChunks, nil), plain)
+
+	// reads across chunks, from multiple goroutines
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for off := int64(0); off < 340; off += 17 {
+				buf := make([]byte, 10)
+				n, err := r.ReadAt(buf, off)
+				if !(err == nil && n == 10 && bytes.Equal(plain[off:off+10], buf)) {
+					t.Errorf("wrong read at %d", off)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	_, err = r.Seek(-10, io.SeekEnd)
+	require.NoError(t, err)
+	tail, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, plain[340:], tail)
+}
+
+func TestReaderKeyRotation(t *testing.T) {
+	oldKeys := loadTestKeys(t, testKey1)
+	rotatedKeys := loadTestKeys(t, testKey2, testKey1)
+
+	oldSegment := writeTestSegment(t, oldKeys, testChunks, true)
+	newSegment := writeTestSegment(t, rotatedKeys, testChunks, true)
+
+	// new segments are encrypted with the first key
+	require.Equal(t, rotatedKeys.keys[0].id[:], newSegment[5:headerSize])
+
+	// all keys decrypt existing segments
+	for _, byts := range [][]byte{oldSegment, newSegment} {
+		plain, err := io.ReadAll(newTestReader(t, rotatedKeys, byts))
+		require.NoError(t, err)
+		require.Equal(t, bytes.Join(testChunks, nil), plain)
+	}
+}
+
+func TestReaderWrongKey(t *testing.T) {
+	byts := writeTestSegment(t, loadTestKeys(t, testKey2), testChunks, true)
+
+	for _, ca := range []struct {
+		name string
+		keys *Keys
+		err  string
+	}{
+		{
+			"unknown key",
+			loadTestKeys(t, testKey1),
+			"segment is encrypted with an unknown key",
+		},
+		{
+			"no keys",
+			nil,
+			"segment is encrypted, but no encryption key is set",
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			r := &Reader{
+				R:    bytes.NewReader(byts),
+				Size: int64(len(byts)),
+				Keys: ca.keys,
+			}
+			err := r.Initialize()
+			require.EqualError(t, err, ca.err)
+		})
+	}
+}
+
+func TestReaderTamperedChunk(t *testing.T) {
+	keys := loadTestKeys(t, testKey1)
+	byts := writeTestSegment(t, keys, testChunks, true)
+
+	chunk0 := headerSize
+	chunk1 := chunk0 + chunkHeaderSize + len(testChunks[0]) + 16
+	chunk2 := chunk1 + chunkHeaderSize + len(testChunks[1]) + 16
+
+	for _, ca := range []struct {
+		name   string
+		tamper func(byts []byte) []byte
+		err    string
+	}{
+		{
+			"modified ciphertext",
+			func(byts []byte) []byte {
+				byts[chunk1+chunkHeaderSize+10] ^= 0xff
+				return byts
+			},
+			"chunk 1 is corrupted: cipher: message authentication failed",
+		},
+		{
+			"reordered chunks",
+			func(byts []byte) []byte {
+				out := append([]byte(nil), byts[:chunk0]...)
+				out = append(out, byts[chunk1:chunk2]...)
+				out = append(out, byts[chunk0:chunk1]...)
+				return append(out, byts[chunk2:]...)
+			},
+			"chunk 0 is corrupted: cipher: message authentication failed",
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			tampered := ca.tamper(append([]byte(nil), byts...))
+
+			_, err := io.ReadAll(newTestReader(t, keys, tampered))
+			require.EqualError(t, err, ca.err)
+		})
+	}
+}
+
+func TestReaderTruncated(t *testing.T) {
+	keys := loadTestKeys(t, testKey1)
+	closed := writeTestSegment(t, keys, testChunks, true)
+	interrupted := writeTestSegment(t, keys, testChunks, false)
+
+	for _, ca := range []struct {
+		name      string
+		byts      []byte
+		plain     []byte
+		validSize int
+		complete  bool
+	}{
+		{
+			"truncated mid-chunk",
+			interrupted[:len(interrupted)-10],
+			bytes.Join(testChunks[:2], nil),
+			len(interrupted) - (chunkHeaderSize + len(testChunks[2]) + 16),
+			false,
+		},
+		{
+			"without final chunk",
+			interrupted,
+			bytes.Join(testChunks, nil),
+			len(interrupted),
+			false,
+		},
+		{
+			"truncated final chunk",
+			closed[:len(closed)-1],
+			bytes.Join(testChunks, nil),
+			len(interrupted),
+			false,
+		},
+		{
+			"data after final chunk",
+			append(append([]byte(nil), closed...), 1, 2, 3),
+			bytes.Join(testChunks, nil),
+			len(closed),
+			true,
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			r := newTestReader(t, keys, ca.byts)
+			require.Equal(t, ca.complete, r.Complete())
+			require.Equal(t, int64(ca.validSize), r.ValidSize())
+
+			plain, err := io.ReadAll(r)
+			require.NoError(t, err)
+			require.Equal(t, ca.plain, plain)
+		})
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	keys := loadTestKeys(t, testKey1)
+
+	for _, ca := range []struct {
+		name      string
+		closed    bool
+		plainSize int64
+	}{
+		{"interrupted, inside chunk", false, 120},
+		{"interrupted, at chunk boundary", false, 100},
+		{"interrupted, whole content", false, 350},
+		{"closed, inside chunk", true, 120},
+		{"closed, whole content", true, 350},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			fpath := filepath.Join(t.TempDir(), "segment.mp4")
+			err := os.WriteFile(fpath, writeTestSegment(t, keys, testChunks, ca.closed), 0o644)
+			require.NoError(t, err)
+
+			newSize, err := Truncate(fpath, keys, ca.plainSize)
+			require.NoError(t, err)
+
+			byts, err := os.ReadFile(fpath)
+			require.NoError(t, err)
+			require.Equal(t, newSize, int64(len(byts)))
+
+			r := newTestReader(t, keys, byts)
+			require.True(t, r.Complete())
+			require.Equal(t, newSize, r.ValidSize())
+
+			plain, err := io.ReadAll(r)
+			require.NoError(t, err)
+			require.Equal(t, bytes.Join(testChunks, nil)[:ca.plainSize], plain)
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package recordcrypto
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+/*
+Encrypted segments have the following layout:
+
+	header: magic "XMEC" | version (1 byte) | key ID (8 bytes)
+	chunk:  ciphertext size (4 bytes) | nonce (12 bytes) | ciphertext (AES-GCM, tag included)
+	chunk:  ...
+	final chunk: a chunk without plaintext
+
+Every chunk is authenticated together with the header, its index and a flag
+that marks the final chunk, so that chunks can't be reordered or moved into
+other segments, and segments that have been truncated after they were closed
+can be told apart from segments whose writing was interrupted.
+A truncated chunk at the end of a segment, left by a crash, is ignored.
+*/
+
+const (
+	magic           = "XMEC"
+	version         = 1
+	headerSize      = 4 + 1 + 8
+	nonceSize       = 12
+	chunkHeaderSize = 4 + nonceSize
+	// maximum size of a chunk, in order to detect corrupted sizes.
+	maxChunkSize = 64 * 1024 * 1024
+)
+
+func marshalHeader(id [8]byte) []byte {
+	buf := make([]byte, headerSize)
+	copy(buf, magic)
+	buf[4] = version
+	copy(buf[5:], id[:])
+	return buf
+}
+
+func chunkAAD(header []byte, index uint64, final bool) []byte {
+	aad := make([]byte, len(header)+8+1)
+	copy(aad, header)
+	binary.BigEndian.PutUint64(aad[len(header):], index)
+	if final {
+		aad[len(header)+8] = 1
+	}
+	return aad
+}
+
+// sealChunk encrypts a chunk, header of the chunk included.
+func sealChunk(k *key, header []byte, index uint64, final bool, plain []byte) ([]byte, error) {
+	buf := make([]byte, chunkHeaderSize, chunkHeaderSize+len(plain)+k.aead.Overhead())
+
+	_, err := rand.Read(buf[4:chunkHeaderSize])
+	if err != nil {
+		return nil, err
+	}
+
+	buf = k.aead.Seal(buf, buf[4:chunkHeaderSize], plain, chunkAAD(header, index, final))
+	binary.BigEndian.PutUint32(buf[:4], uint32(len(buf)-chunkHeaderSize))
+
+	return buf, nil
+}
+
+// Writer encrypts what is written into it. Every call to Write() produces a chunk,
+// therefore writes should be buffered. Close() must be called when the segment is complete.
+type Writer struct {
+	W    io.Writer
+	Keys *Keys
+
+	key    *key
+	header []byte
+	index  uint64
+	closed bool
+}
+
+// Initialize initializes Writer and writes the header.
+func (w *Writer) Initialize() error {
+	w.key = w.Keys.encryptionKey()
+	w.header = marshalHeader(w.key.id)
+
+	_, err := w.W.Write(w.header)
+	return err
+}
+
+// Write implements io.Writer.
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, fmt.Errorf("writer is closed")
+	}
+
+	n := 0
+
+	for n < len(p) {
+		size := min(len(p)-n, maxChunkSize-w.key.aead.Overhead())
+
+		buf, err := sealChunk(w.key, w.header, w.index, false, p[n:n+size])
+		if err != nil {
+			return n, err
+		}
+
+		_, err = w.W.Write(buf)
+		if err != nil {
+			return n, err
+		}
+
+		w.index++
+		n += size
+	}
+
+	return n, nil
+}
+
+// Close writes the final chunk, that marks the segment as complete. It doesn't close W.
+func (w *Writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	buf, err := sealChunk(w.key, w.header, w.index, true, nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.W.Write(buf)
+	return err
+}
@@ -0,0 +1,355 @@
+package recorder
+
+import (
+	"XMedia/internal/logger"
+	"XMedia/internal/unit"
+	"bytes"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/bluenviron/mediacommon/v2/pkg/codecs/g711"
+	mch264 "github.com/bluenviron/mediacommon/v2/pkg/codecs/h264"
+	mch265 "github.com/bluenviron/mediacommon/v2/pkg/codecs/h265"
+	"github.com/bluenviron/mediacommon/v2/pkg/codecs/mpeg4audio"
+	"github.com/bluenviron/mediacommon/v2/pkg/codecs/opus"
+	"github.com/bluenviron/mediacommon/v2/pkg/formats/fmp4"
+	"github.com/bluenviron/mediacommon/v2/pkg/formats/mp4"
+)
+
+type formatFMP4Sample struct {
+	*fmp4.Sample
+	dts int64
+	ntp time.Time
+}
+
+// formatFMP4 writes fragmented MP4 segments.
+// Every segment starts with a keyframe of the video track (if any),
+// and is made of an initialization section followed by parts.
+type formatFMP4 struct {
+	ri *recorderInstance
+
+	tracks             []*formatFMP4Track
+	hasVideo           bool
+	currentSegment     *formatFMP4Segment
+	nextSequenceNumber uint32
+}
+
+func (f *formatFMP4) initialize() bool {
+	nextID := 1
+
+	addTrack := func(forma format.Format, codec mp4.Codec) *formatFMP4Track {
+		track := &formatFMP4Track{
+			f: f,
+			initTrack: &fmp4.InitTrack{
+				ID:        nextID,
+				TimeScale: uint32(forma.ClockRate()),
+				Codec:     codec,
+			},
+		}
+		nextID++
+		f.tracks = append(f.tracks, track)
+		return track
+	}
+
+	for _, medi := range f.ri.stream.Desc.Medias {
+		for _, forma := range medi.Formats {
+			switch forma := forma.(type) {
+			case *format.H264:
+				sps, pps := forma.SafeParams()
+				track := addTrack(forma, &mp4.CodecH264{SPS: sps, PPS: pps})
+				f.hasVideo = true
+				started := false
+
+				f.ri.stream.AddReader(f.ri.writer, medi, forma, func(u unit.Unit) error {
+					tunit := u.(*unit.H264)
+					if tunit.AU == nil {
+						return nil
+					}
+
+					// parameters can be sent in band, and can change at any time
+					changed := false
+					for _, nalu := range tunit.AU {
+						switch mch264.NALUType(nalu[0] & 0x1F) {
+						case mch264.NALUTypeSPS:
+							if !bytes.Equal(nalu, sps) {
+								sps = nalu
+								changed = true
+							}
+
+						case mch264.NALUTypePPS:
+							if !bytes.Equal(nalu, pps) {
+								pps = nalu
+								changed = true
+							}
+						}
+					}
+					if changed {
+						track.newCodec = &mp4.CodecH264{SPS: sps, PPS: pps}
+					}
+
+					if sps == nil || pps == nil {
+						return nil
+					}
+
+					if !started {
+						if !tunit.RandomAccess {
+							return nil
+						}
+						started = true
+					}
+
+					sample := &fmp4.Sample{
+						IsNonSyncSample: !tunit.RandomAccess,
+					}
+					err := sample.FillH264(int32(tunit.PTS-tunit.DTS), tunit.AU)
+					if err != nil {
+						return err
+					}
+
+					return track.write(&formatFMP4Sample{
+						Sample: sample,
+						dts:    tunit.DTS,
+						ntp:    tunit.NTP,
+					})
+				})
+
+			case *format.H265:
+				vps, sps, pps := forma.SafeParams()
+				track := addTrack(forma, &mp4.CodecH265{VPS: vps, SPS: sps, PPS: pps})
+				f.hasVideo = true
+				var dtsExtractor *mch265.DTSExtractor
+
+				f.ri.stream.AddReader(f.ri.writer, medi, forma, func(u unit.Unit) error {
+					tunit := u.(*unit.H265)
+					if tunit.AU == nil {
+						return nil
+					}
+
+					// parameters can be sent in band, and can change at any time
+					changed := false
+					for _, nalu := range tunit.AU {
+						switch mch265.NALUType((nalu[0] >> 1) & 0b111111) {
+						case mch265.NALUType_VPS_NUT:
+							if !bytes.Equal(nalu, vps) {
+								vps = nalu
+								changed = true
+							}
+
+						case mch265.NALUType_SPS_NUT:
+							if !bytes.Equal(nalu, sps) {
+								sps = nalu
+								changed = true
+							}
+
+						case mch265.NALUType_PPS_NUT:
+							if !bytes.Equal(nalu, pps) {
+								pps = nalu
+								changed = true
+							}
+						}
+					}
+					if changed {
+						track.newCodec = &mp4.CodecH265{VPS: vps, SPS: sps, PPS: pps}
+					}
+
+					if vps == nil || sps == nil || pps == nil {
+						return nil
+					}
+
+					randomAccess := mch265.IsRandomAccess(tunit.AU)
+
+					if dtsExtractor == nil {
+						if !randomAccess {
+							return nil
+						}
+						dtsExtractor = &mch265.DTSExtractor{}
+						dtsExtractor.Initialize()
+					}
+
+					dts, err := dtsExtractor.Extract(tunit.AU, tunit.PTS)
+					if err != nil {
+						return err
+					}
+
+					sample := &fmp4.Sample{
+						IsNonSyncSample: !randomAccess,
+					}
+					err = sample.FillH265(int32(tunit.PTS-dts), tunit.AU)
+					if err != nil {
+						return err
+					}
+
+					return track.write(&formatFMP4Sample{
+						Sample: sample,
+						dts:    dts,
+						ntp:    tunit.NTP,
+					})
+				})
+
+			case *format.MPEG4Audio:
+				if forma.Config == nil {
+					f.ri.Log(logger.Warn, "skipping MPEG-4 Audio track without configuration")
+					continue
+				}
+
+				track := addTrack(forma, &mp4.CodecMPEG4Audio{Config: *forma.Config})
+
+				f.ri.stream.AddReader(f.ri.writer, medi, forma, func(u unit.Unit) error {
+					tunit := u.(*unit.MPEG4Audio)
+
+					for i, au := range tunit.AUs {
+						offset := int64(i) * mpeg4audio.SamplesPerAccessUnit
+
+						err := track.write(&formatFMP4Sample{
+							Sample: &fmp4.Sample{Payload: au},
+							dts:    tunit.PTS + offset,
+							ntp:    tunit.NTP.Add(timestampToDuration(offset, forma.ClockRate())),
+						})
+						if err != nil {
+							return err
+						}
+					}
+
+					return nil
+				})
+
+			case *format.Opus:
+				track := addTrack(forma, &mp4.CodecOpus{ChannelCount: forma.ChannelCount})
+
+				f.ri.stream.AddReader(f.ri.writer, medi, forma, func(u unit.Unit) error {
+					tunit := u.(*unit.Opus)
+					offset := int64(0)
+
+					for _, pkt := range tunit.Packets {
+						err := track.write(&formatFMP4Sample{
+							Sample: &fmp4.Sample{Payload: pkt},
+							dts:    tunit.PTS + offset,
+							ntp:    tunit.NTP.Add(timestampToDuration(offset, forma.ClockRate())),
+						})
+						if err != nil {
+							return err
+						}
+
+						offset += opus.PacketDuration2(pkt)
+					}
+
+					return nil
+				})
+
+			case *format.G711:
+				// G711 is not supported by MP4, samples are stored as LPCM
+				track := addTrack(forma, &mp4.CodecLPCM{
+					LittleEndian: false,
+					BitDepth:     16,
+					SampleRate:   forma.SampleRate,
+					ChannelCount: forma.ChannelCount,
+				})
+
+				f.ri.stream.AddReader(f.ri.writer, medi, forma, func(u unit.Unit) error {
+					tunit := u.(*unit.G711)
+					if tunit.Samples == nil {
+						return nil
+					}
+
+					var lpcm []byte
+					if forma.MULaw {
+						lpcm = g711.DecodeMulaw(tunit.Samples)
+					} else {
+						lpcm = g711.DecodeAlaw(tunit.Samples)
+					}
+
+					return track.write(&formatFMP4Sample{
+						Sample: &fmp4.Sample{Payload: lpcm},
+						dts:    tunit.PTS,
+						ntp:    tunit.NTP,
+					})
+				})
+
+			default:
+				f.ri.Log(logger.Warn, "skipping track with codec %s, that is not supported by fMP4", forma.Codec())
+			}
+		}
+	}
+
+	return len(f.tracks) != 0
+}
+
+func (f *formatFMP4) close() {
+	if f.currentSegment != nil {
+		// the duration of the last sample of every track is unknown,
+		// use the one of the previous sample.
+		for _, track := range f.tracks {
+			if track.nextSample != nil {
+				track.nextSample.Duration = track.lastDuration
+				err := f.currentSegment.write(track, track.nextSample)
+				if err != nil {
+					f.ri.Log(logger.Error, "%v", err)
+				}
+				track.nextSample = nil
+			}
+		}
+
+		err := f.currentSegment.close()
+		if err != nil {
+			f.ri.Log(logger.Error, "%v", err)
+		}
+		f.currentSegment = nil
+	}
+}
+
+// apply parameters received in band, before starting a segment.
+func (f *formatFMP4) applyCodecs() {
+	for _, track := range f.tracks {
+		if track.newCodec != nil {
+			track.initTrack.Codec = track.newCodec
+			track.newCodec = nil
+		}
+	}
+}
+
+// write a sample, whose duration is known, into the current segment.
+// next is the following sample of the same track.
+func (f *formatFMP4) write(track *formatFMP4Track, sample *formatFMP4Sample, next *formatFMP4Sample) error {
+	if f.currentSegment == nil {
+		// when there's a video track, segments start with a keyframe
+		if f.hasVideo && (!track.isVideo() || sample.IsNonSyncSample) {
+			return nil
+		}
+
+		f.applyCodecs()
+
+		f.currentSegment = &formatFMP4Segment{
+			f:        f,
+			startDTS: timestampToDuration(sample.dts, track.clockRate()),
+			startNTP: sample.ntp,
+		}
+		f.currentSegment.initialize()
+	}
+
+	err := f.currentSegment.write(track, sample)
+	if err != nil {
+		return err
+	}
+
+	// switch segment when the next sample is a keyframe and either
+	// the segment is long enough or codec parameters have changed.
+	if (!f.hasVideo || track.isVideo()) && !next.IsNonSyncSample {
+		nextDTS := timestampToDuration(next.dts, track.clockRate())
+
+		switch {
+		case track.newCodec != nil:
+			f.ri.Log(logger.Info, "codec parameters changed, starting a new segment")
+
+		case (nextDTS - f.currentSegment.startDTS) >= f.ri.segmentDuration:
+
+		default:
+			return nil
+		}
+
+		err = f.currentSegment.close()
+		f.currentSegment = nil
+		return err
+	}
+
+	return nil
+}
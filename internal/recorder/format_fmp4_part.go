@@ -0,0 +1,76 @@
+package recorder
+
+import (
+	"time"
+
+	"github.com/bluenviron/mediacommon/v2/pkg/formats/fmp4"
+	"github.com/bluenviron/mediacommon/v2/pkg/formats/fmp4/seekablebuffer"
+)
+
+type formatFMP4Part struct {
+	s              *formatFMP4Segment
+	sequenceNumber uint32
+	startDTS       time.Duration
+
+	partTracks map[*formatFMP4Track]*fmp4.PartTrack
+}
+
+func (p *formatFMP4Part) initialize() {
+	p.partTracks = make(map[*formatFMP4Track]*fmp4.PartTrack)
+}
+
+func (p *formatFMP4Part) write(track *formatFMP4Track, sample *formatFMP4Sample) {
+	partTrack, ok := p.partTracks[track]
+	if !ok {
+		// samples of other tracks that precede the segment start are dropped,
+		// since they can't be represented without shifting the track.
+		baseTime := sample.dts - durationToTimestamp(p.s.startDTS, track.clockRate())
+		if baseTime < 0 {
+			return
+		}
+
+		partTrack = &fmp4.PartTrack{
+			ID:       track.initTrack.ID,
+			BaseTime: uint64(baseTime),
+		}
+		p.partTracks[track] = partTrack
+	}
+
+	partTrack.Samples = append(partTrack.Samples, sample.Sample)
+}
+
+// close writes the part into the segment file.
+func (p *formatFMP4Part) close() error {
+	if p.s.fi == nil {
+		err := p.s.open()
+		if err != nil {
+			return err
+		}
+	}
+
+	part := fmp4.Part{
+		SequenceNumber: p.sequenceNumber,
+	}
+	for _, track := range p.s.f.tracks {
+		if partTrack, ok := p.partTracks[track]; ok {
+			part.Tracks = append(part.Tracks, partTrack)
+		}
+	}
+
+	var buf seekablebuffer.Buffer
+	err := part.Marshal(&buf)
+	if err != nil {
+		return err
+	}
+
+	_, err = p.s.w.Write(buf.Bytes())
+	if err != nil {
+		return err
+	}
+
+	if p.s.f.ri.fsync == FsyncPart {
+		return p.s.fi.Sync()
+	}
+
+	return nil
+}
@@ -0,0 +1,166 @@
+package recorder
+
+import (
+	"XMedia/internal/logger"
+	"XMedia/internal/recordstore"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bluenviron/mediacommon/v2/pkg/formats/fmp4"
+	"github.com/bluenviron/mediacommon/v2/pkg/formats/fmp4/seekablebuffer"
+)
+
+type formatFMP4Segment struct {
+	f        *formatFMP4
+	startDTS time.Duration
+	startNTP time.Time
+
+	path        string
+	fi          *os.File
+	w           io.Writer
+	currentPart *formatFMP4Part
+	timeline    *segmentTimeline
+	endDTS      time.Duration
+}
+
+func (s *formatFMP4Segment) initialize() {
+	s.path = recordstore.Path{
+		Path:  s.f.ri.pathName,
+		Start: s.startNTP,
+	}.Encode(s.f.ri.pathFormat) + ".mp4"
+}
+
+func (s *formatFMP4Segment) close() error {
+	var err error
+
+	if s.currentPart != nil {
+		err = s.currentPart.close()
+		s.currentPart = nil
+	}
+
+	if s.fi != nil {
+		s.f.ri.Log(logger.Info, "closing segment %s", s.path)
+
+		if err == nil {
+			err = closeSegmentWriter(s.w)
+		}
+
+		if err == nil {
+			s.timeline.update(s.endNTP())
+		}
+		s.timeline.save()
+
+		if s.f.ri.fsync != FsyncNever {
+			err2 := s.fi.Sync()
+			if err == nil {
+				err = err2
+			}
+		}
+
+		err2 := s.fi.Close()
+		if err == nil {
+			err = err2
+		}
+	}
+
+	return err
+}
+
+// open creates the segment file and writes the initialization section.
+func (s *formatFMP4Segment) open() error {
+	err := os.MkdirAll(filepath.Dir(s.path), 0o755)
+	if err != nil {
+		return err
+	}
+
+	s.f.ri.Log(logger.Info, "creating segment %s", s.path)
+
+	fi, err := os.Create(s.path)
+	if err != nil {
+		return err
+	}
+
+	init := fmp4.Init{}
+	for _, track := range s.f.tracks {
+		init.Tracks = append(init.Tracks, track.initTrack)
+	}
+
+	w, err := newSegmentWriter(fi, s.f.ri.encryptionKeys)
+	if err != nil {
+		fi.Close()
+		os.Remove(s.path)
+		return err
+	}
+
+	var buf seekablebuffer.Buffer
+	err = init.Marshal(&buf)
+	if err == nil {
+		_, err = w.Write(buf.Bytes())
+	}
+	if err != nil {
+		fi.Close()
+		os.Remove(s.path)
+		return err
+	}
+
+	if s.f.ri.fsync != FsyncNever {
+		err = syncDir(filepath.Dir(s.path))
+		if err != nil {
+			fi.Close()
+			os.Remove(s.path)
+			return err
+		}
+	}
+
+	s.fi = fi
+	s.w = w
+
+	s.timeline = &segmentTimeline{
+		ri:    s.f.ri,
+		start: s.startNTP,
+	}
+	s.timeline.initialize()
+
+	return nil
+}
+
+// endNTP returns the end of the samples written into the segment.
+func (s *formatFMP4Segment) endNTP() time.Time {
+	return s.startNTP.Add(s.endDTS - s.startDTS)
+}
+
+func (s *formatFMP4Segment) write(track *formatFMP4Track, sample *formatFMP4Sample) error {
+	dts := timestampToDuration(sample.dts, track.clockRate())
+
+	if s.currentPart != nil && (!s.f.hasVideo || track.isVideo()) &&
+		(dts-s.currentPart.startDTS) >= s.f.ri.partDuration {
+		err := s.currentPart.close()
+		s.currentPart = nil
+		if err != nil {
+			return err
+		}
+
+		s.timeline.update(s.endNTP())
+	}
+
+	if s.currentPart == nil {
+		s.currentPart = &formatFMP4Part{
+			s:              s,
+			sequenceNumber: s.f.nextSequenceNumber,
+			startDTS:       dts,
+		}
+		s.currentPart.initialize()
+		s.f.nextSequenceNumber++
+	}
+
+	s.currentPart.write(track, sample)
+	s.f.ri.written(sample.ntp)
+
+	if end := timestampToDuration(sample.dts+int64(sample.Duration), track.clockRate()); end > s.endDTS {
+		s.endDTS = end
+	}
+
+	return nil
+}
@@ -0,0 +1,44 @@
+package recorder
+
+import (
+	"github.com/bluenviron/mediacommon/v2/pkg/formats/fmp4"
+	"github.com/bluenviron/mediacommon/v2/pkg/formats/mp4"
+)
+
+type formatFMP4Track struct {
+	f         *formatFMP4
+	initTrack *fmp4.InitTrack
+
+	// codec with parameters received in band, applied when the next segment starts.
+	newCodec mp4.Codec
+	// sample whose duration is still unknown.
+	nextSample *formatFMP4Sample
+	// duration of the last written sample.
+	lastDuration uint32
+}
+
+func (t *formatFMP4Track) isVideo() bool {
+	return t.initTrack.Codec.IsVideo()
+}
+
+func (t *formatFMP4Track) clockRate() int {
+	return int(t.initTrack.TimeScale)
+}
+
+// write buffers a sample and writes the previous one,
+// since the duration of a sample is known only when the next one is received.
+func (t *formatFMP4Track) write(sample *formatFMP4Sample) error {
+	prev := t.nextSample
+	t.nextSample = sample
+
+	if prev == nil {
+		return nil
+	}
+
+	if sample.dts > prev.dts {
+		prev.Duration = uint32(sample.dts - prev.dts)
+		t.lastDuration = prev.Duration
+	}
+
+	return t.f.write(t, prev, sample)
+}
@@ -0,0 +1,369 @@
+package recorder
+
+import (
+	"XMedia/internal/logger"
+	"XMedia/internal/unit"
+	"bufio"
+	"bytes"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	mch264 "github.com/bluenviron/mediacommon/v2/pkg/codecs/h264"
+	mch265 "github.com/bluenviron/mediacommon/v2/pkg/codecs/h265"
+	"github.com/bluenviron/mediacommon/v2/pkg/formats/mpegts"
+)
+
+const (
+	mpegtsBufferSize = 64 * 1024
+	mpegtsClockRate  = 90000
+)
+
+func multiplyAndDivide(v, m, d int64) int64 {
+	secs := v / d
+	dec := v % d
+	return (secs*m + dec*m/d)
+}
+
+// dynamicWriter redirects the output of the MPEG-TS writer to the current segment.
+type dynamicWriter struct {
+	f *formatMPEGTS
+}
+
+func (d *dynamicWriter) Write(p []byte) (int, error) {
+	return d.f.currentSegment.w.Write(p)
+}
+
+// formatMPEGTS writes MPEG-TS segments.
+// Every segment starts with a keyframe of the video track (if any).
+// Data is flushed to disk every part duration.
+type formatMPEGTS struct {
+	ri *recorderInstance
+
+	tracks         []*mpegts.Track
+	hasVideo       bool
+	bw             *bufio.Writer
+	mw             *mpegts.Writer
+	currentSegment *formatMPEGTSSegment
+	lastFlush      time.Duration
+	// whether codec parameters have changed since the segment start.
+	paramsChanged bool
+}
+
+func (f *formatMPEGTS) initialize() bool {
+	addTrack := func(codec mpegts.Codec) *mpegts.Track {
+		track := &mpegts.Track{
+			Codec: codec,
+		}
+		f.tracks = append(f.tracks, track)
+		return track
+	}
+
+	for _, medi := range f.ri.stream.Desc.Medias {
+		for _, forma := range medi.Formats {
+			switch forma := forma.(type) {
+			case *format.H264:
+				track := addTrack(&mpegts.CodecH264{})
+				f.hasVideo = true
+				sps, pps := forma.SafeParams()
+				started := false
+
+				f.ri.stream.AddReader(f.ri.writer, medi, forma, func(u unit.Unit) error {
+					tunit := u.(*unit.H264)
+					if tunit.AU == nil {
+						return nil
+					}
+
+					// parameters are sent in band, but segments are switched anyway
+					// in order to allow players to be configured once per file.
+					for _, nalu := range tunit.AU {
+						switch mch264.NALUType(nalu[0] & 0x1F) {
+						case mch264.NALUTypeSPS:
+							if !bytes.Equal(nalu, sps) {
+								f.paramsChanged = f.paramsChanged || sps != nil
+								sps = nalu
+							}
+
+						case mch264.NALUTypePPS:
+							if !bytes.Equal(nalu, pps) {
+								f.paramsChanged = f.paramsChanged || pps != nil
+								pps = nalu
+							}
+						}
+					}
+
+					if !started {
+						if !tunit.RandomAccess {
+							return nil
+						}
+						started = true
+					}
+
+					return f.write(
+						timestampToDuration(tunit.DTS, forma.ClockRate()),
+						tunit.NTP,
+						true,
+						tunit.RandomAccess,
+						func() error {
+							return f.mw.WriteH264(
+								track,
+								multiplyAndDivide(tunit.PTS, mpegtsClockRate, int64(forma.ClockRate())),
+								multiplyAndDivide(tunit.DTS, mpegtsClockRate, int64(forma.ClockRate())),
+								tunit.AU)
+						})
+				})
+
+			case *format.H265:
+				track := addTrack(&mpegts.CodecH265{})
+				f.hasVideo = true
+				vps, sps, pps := forma.SafeParams()
+				var dtsExtractor *mch265.DTSExtractor
+
+				f.ri.stream.AddReader(f.ri.writer, medi, forma, func(u unit.Unit) error {
+					tunit := u.(*unit.H265)
+					if tunit.AU == nil {
+						return nil
+					}
+
+					for _, nalu := range tunit.AU {
+						var cur *[]byte
+
+						switch mch265.NALUType((nalu[0] >> 1) & 0b111111) {
+						case mch265.NALUType_VPS_NUT:
+							cur = &vps
+						case mch265.NALUType_SPS_NUT:
+							cur = &sps
+						case mch265.NALUType_PPS_NUT:
+							cur = &pps
+						default:
+							continue
+						}
+
+						if !bytes.Equal(nalu, *cur) {
+							f.paramsChanged = f.paramsChanged || *cur != nil
+							*cur = nalu
+						}
+					}
+
+					randomAccess := mch265.IsRandomAccess(tunit.AU)
+
+					if dtsExtractor == nil {
+						if !randomAccess {
+							return nil
+						}
+						dtsExtractor = &mch265.DTSExtractor{}
+						dtsExtractor.Initialize()
+					}
+
+					dts, err := dtsExtractor.Extract(tunit.AU, tunit.PTS)
+					if err != nil {
+						return err
+					}
+
+					return f.write(
+						timestampToDuration(dts, forma.ClockRate()),
+						tunit.NTP,
+						true,
+						randomAccess,
+						func() error {
+							return f.mw.WriteH265(
+								track,
+								multiplyAndDivide(tunit.PTS, mpegtsClockRate, int64(forma.ClockRate())),
+								multiplyAndDivide(dts, mpegtsClockRate, int64(forma.ClockRate())),
+								tunit.AU)
+						})
+				})
+
+			case *format.MPEG4Audio:
+				if forma.Config == nil {
+					f.ri.Log(logger.Warn, "skipping MPEG-4 Audio track without configuration")
+					continue
+				}
+
+				track := addTrack(&mpegts.CodecMPEG4Audio{Config: *forma.Config})
+
+				f.ri.stream.AddReader(f.ri.writer, medi, forma, func(u unit.Unit) error {
+					tunit := u.(*unit.MPEG4Audio)
+					if tunit.AUs == nil {
+						return nil
+					}
+
+					return f.write(
+						timestampToDuration(tunit.PTS, forma.ClockRate()),
+						tunit.NTP,
+						false,
+						true,
+						func() error {
+							return f.mw.WriteMPEG4Audio(
+								track,
+								multiplyAndDivide(tunit.PTS, mpegtsClockRate, int64(forma.ClockRate())),
+								tunit.AUs)
+						})
+				})
+
+			case *format.Opus:
+				track := addTrack(&mpegts.CodecOpus{ChannelCount: forma.ChannelCount})
+
+				f.ri.stream.AddReader(f.ri.writer, medi, forma, func(u unit.Unit) error {
+					tunit := u.(*unit.Opus)
+					if tunit.Packets == nil {
+						return nil
+					}
+
+					return f.write(
+						timestampToDuration(tunit.PTS, forma.ClockRate()),
+						tunit.NTP,
+						false,
+						true,
+						func() error {
+							return f.mw.WriteOpus(
+								track,
+								multiplyAndDivide(tunit.PTS, mpegtsClockRate, int64(forma.ClockRate())),
+								tunit.Packets)
+						})
+				})
+
+			case *format.KLV:
+				track := addTrack(&mpegts.CodecKLV{Synchronous: true})
+
+				f.ri.stream.AddReader(f.ri.writer, medi, forma, func(u unit.Unit) error {
+					tunit := u.(*unit.KLV)
+					if tunit.Unit == nil {
+						return nil
+					}
+
+					return f.write(
+						timestampToDuration(tunit.PTS, forma.ClockRate()),
+						tunit.NTP,
+						false,
+						false,
+						func() error {
+							return f.mw.WriteKLV(
+								track,
+								multiplyAndDivide(tunit.PTS, mpegtsClockRate, int64(forma.ClockRate())),
+								tunit.Unit)
+						})
+				})
+
+			default:
+				f.ri.Log(logger.Warn, "skipping track with codec %s, that is not supported by MPEG-TS", forma.Codec())
+			}
+		}
+	}
+
+	if len(f.tracks) == 0 {
+		return false
+	}
+
+	f.bw = bufio.NewWriterSize(&dynamicWriter{f: f}, mpegtsBufferSize)
+
+	f.mw = &mpegts.Writer{
+		W:      f.bw,
+		Tracks: f.tracks,
+	}
+	err := f.mw.Initialize()
+	if err != nil {
+		f.ri.Log(logger.Error, "%v", err)
+		f.tracks = nil
+		return false
+	}
+
+	return true
+}
+
+func (f *formatMPEGTS) close() {
+	if f.currentSegment != nil {
+		err := f.currentSegment.close()
+		if err != nil {
+			f.ri.Log(logger.Error, "%v", err)
+		}
+		f.currentSegment = nil
+	}
+}
+
+// write writes a unit, after switching segment or flushing buffered data when needed.
+// Units that don't belong to the leading track (the video track, if any) can't trigger switches.
+func (f *formatMPEGTS) write(
+	dts time.Duration,
+	ntp time.Time,
+	isVideo bool,
+	randomAccess bool,
+	writeCB func() error,
+) error {
+	isLeading := !f.hasVideo || isVideo
+
+	switch {
+	case f.currentSegment == nil:
+		// when there's a video track, segments start with a keyframe
+		if f.hasVideo && (!isVideo || !randomAccess) {
+			return nil
+		}
+
+		err := f.startSegment(dts, ntp)
+		if err != nil {
+			return err
+		}
+
+	case isLeading && randomAccess &&
+		(f.paramsChanged || (dts-f.currentSegment.startDTS) >= f.ri.segmentDuration):
+		if f.paramsChanged {
+			f.ri.Log(logger.Info, "codec parameters changed, starting a new segment")
+		}
+
+		err := f.currentSegment.close()
+		f.currentSegment = nil
+		if err != nil {
+			return err
+		}
+
+		err = f.startSegment(dts, ntp)
+		if err != nil {
+			return err
+		}
+
+	case isLeading && (dts-f.lastFlush) >= f.ri.partDuration:
+		err := f.bw.Flush()
+		if err != nil {
+			return err
+		}
+
+		if f.ri.fsync == FsyncPart {
+			err = f.currentSegment.fi.Sync()
+			if err != nil {
+				return err
+			}
+		}
+
+		f.lastFlush = dts
+		f.currentSegment.timeline.update(f.currentSegment.endNTP())
+	}
+
+	err := writeCB()
+	if err != nil {
+		return err
+	}
+
+	f.ri.written(ntp)
+
+	if dts > f.currentSegment.endDTS {
+		f.currentSegment.endDTS = dts
+	}
+
+	return nil
+}
+
+func (f *formatMPEGTS) startSegment(dts time.Duration, ntp time.Time) error {
+	seg := &formatMPEGTSSegment{
+		f:        f,
+		startDTS: dts,
+		startNTP: ntp,
+	}
+	err := seg.initialize()
+	if err != nil {
+		return err
+	}
+
+	f.currentSegment = seg
+	f.lastFlush = dts
+	f.paramsChanged = false
+	return nil
+}
@@ -0,0 +1,93 @@
+package recorder
+
+import (
+	"XMedia/internal/logger"
+	"XMedia/internal/recordstore"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type formatMPEGTSSegment struct {
+	f        *formatMPEGTS
+	startDTS time.Duration
+	startNTP time.Time
+
+	path     string
+	fi       *os.File
+	w        io.Writer
+	timeline *segmentTimeline
+	endDTS   time.Duration
+}
+
+func (s *formatMPEGTSSegment) initialize() error {
+	s.path = recordstore.Path{
+		Path:  s.f.ri.pathName,
+		Start: s.startNTP,
+	}.Encode(s.f.ri.pathFormat) + ".ts"
+
+	err := os.MkdirAll(filepath.Dir(s.path), 0o755)
+	if err != nil {
+		return err
+	}
+
+	s.f.ri.Log(logger.Info, "creating segment %s", s.path)
+
+	s.fi, err = os.Create(s.path)
+	if err != nil {
+		return err
+	}
+
+	s.w, err = newSegmentWriter(s.fi, s.f.ri.encryptionKeys)
+	if err != nil {
+		s.fi.Close()
+		return err
+	}
+
+	if s.f.ri.fsync != FsyncNever {
+		err = syncDir(filepath.Dir(s.path))
+		if err != nil {
+			s.fi.Close()
+			return err
+		}
+	}
+
+	s.timeline = &segmentTimeline{
+		ri:    s.f.ri,
+		start: s.startNTP,
+	}
+	s.timeline.initialize()
+
+	return nil
+}
+
+// endNTP returns the timestamp of the last unit written into the segment.
+func (s *formatMPEGTSSegment) endNTP() time.Time {
+	return s.startNTP.Add(s.endDTS - s.startDTS)
+}
+
+func (s *formatMPEGTSSegment) close() error {
+	err := s.f.bw.Flush()
+	if err == nil {
+		err = closeSegmentWriter(s.w)
+	}
+
+	s.f.ri.Log(logger.Info, "closing segment %s", s.path)
+
+	if err == nil {
+		s.timeline.update(s.endNTP())
+	}
+	s.timeline.save()
+
+	if err == nil && s.f.ri.fsync != FsyncNever {
+		err = s.fi.Sync()
+	}
+
+	err2 := s.fi.Close()
+	if err == nil {
+		err = err2
+	}
+
+	return err
+}
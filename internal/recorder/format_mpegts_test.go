@@ -0,0 +1,264 @@
+package recorder
+
+import (
+	"XMedia/internal/recordstore"
+	"XMedia/internal/stream"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/description"
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/bluenviron/mediacommon/v2/pkg/codecs/mpeg4audio"
+	"github.com/bluenviron/mediacommon/v2/pkg/formats/mpegts"
+	"github.com/stretchr/testify/require"
+)
+
+// testMultiTrackSource is a source with a video, an audio and a KLV track.
+type testMultiTrackSource struct {
+	t         *testing.T
+	videoMedi *description.Media
+	videoForm *format.H264
+	audioMedi *description.Media
+	audioForm *format.MPEG4Audio
+	klvMedi   *description.Media
+	klvForm   *format.KLV
+	strm      *stream.Stream
+	start     time.Time
+	frames    int
+}
+
+func newTestMultiTrackSource(t *testing.T) *testMultiTrackSource {
+	s := &testMultiTrackSource{
+		t: t,
+		videoForm: &format.H264{
+			PayloadTyp:        96,
+			SPS:               testSPS,
+			PPS:               testPPS,
+			PacketizationMode: 1,
+		},
+		audioForm: &format.MPEG4Audio{
+			PayloadTyp: 97,
+			Config: &mpeg4audio.AudioSpecificConfig{
+				Type:         mpeg4audio.ObjectTypeAACLC,
+				SampleRate:   48000,
+				ChannelCount: 2,
+			},
+			SizeLength:       13,
+			IndexLength:      3,
+			IndexDeltaLength: 3,
+		},
+		klvForm: &format.KLV{PayloadTyp: 98},
+		start:   time.Date(2008, 5, 20, 22, 15, 25, 0, time.UTC),
+	}
+
+	s.videoMedi = &description.Media{Type: description.MediaTypeVideo, Formats: []format.Format{s.videoForm}}
+	s.audioMedi = &description.Media{Type: description.MediaTypeAudio, Formats: []format.Format{s.audioForm}}
+	s.klvMedi = &description.Media{Type: description.MediaTypeApplication, Formats: []format.Format{s.klvForm}}
+
+	s.strm = &stream.Stream{
+		WriteQueueSize:    512,
+		UDPMaxPayloadSize: 1472,
+		Desc: &description.Session{Medias: []*description.Media{
+			s.videoMedi,
+			s.audioMedi,
+			s.klvMedi,
+		}},
+		Parent: nilLogger{},
+	}
+	err := s.strm.Initialize()
+	require.NoError(t, err)
+
+	return s
+}
+
+// writeFrames writes video frames at 10 fps, with a keyframe every 10 frames,
+// an audio access unit after every frame and a KLV unit every 5 frames.
+func (s *testMultiTrackSource) writeFrames(n int) {
+	videoEnc, err := s.videoForm.CreateEncoder()
+	require.NoError(s.t, err)
+
+	audioEnc, err := s.audioForm.CreateEncoder()
+	require.NoError(s.t, err)
+
+	klvEnc, err := s.klvForm.CreateEncoder()
+	require.NoError(s.t, err)
+
+	for i := 0; i < n; i++ {
+		ntp := s.start.Add(time.Duration(s.frames) * 100 * time.Millisecond)
+
+		var au [][]byte
+		if s.frames%10 == 0 {
+			au = [][]byte{testSPS, testPPS, {0x65, 0x88, 0x84, 0x00, byte(s.frames)}}
+		} else {
+			au = [][]byte{{0x41, 0x9a, 0x24, 0x6c, byte(s.frames)}}
+		}
+
+		pkts, err := videoEnc.Encode(au)
+		require.NoError(s.t, err)
+
+		pts := int64(s.frames) * 9000
+		for _, pkt := range pkts {
+			pkt.Timestamp = uint32(pts)
+			s.strm.WriteRTPPacket(s.videoMedi, s.videoForm, pkt, ntp, pts)
+		}
+
+		pkts, err = audioEnc.Encode([][]byte{{0x01, 0x02, 0x03, byte(s.frames)}})
+		require.NoError(s.t, err)
+
+		pts = int64(s.frames) * 4800
+		for _, pkt := range pkts {
+			pkt.Timestamp = uint32(pts)
+			s.strm.WriteRTPPacket(s.audioMedi, s.audioForm, pkt, ntp, pts)
+		}
+
+		if s.frames%5 == 0 {
+			pkts, err = klvEnc.Encode(testKLVUnit(byte(s.frames)))
+			require.NoError(s.t, err)
+
+			pts = int64(s.frames) * 9000
+			for _, pkt := range pkts {
+				pkt.Timestamp = uint32(pts)
+				s.strm.WriteRTPPacket(s.klvMedi, s.klvForm, pkt, ntp, pts)
+			}
+		}
+
+		s.frames++
+	}
+}
+
+// KLV unit with a universal key and a single byte value.
+func testKLVUnit(v byte) []byte {
+	return []byte{
+		0x06, 0x0e, 0x2b, 0x34, 0x02, 0x0b, 0x01, 0x01,
+		0x0e, 0x01, 0x03, 0x01, 0x01, 0x00, 0x00, 0x00,
+		0x01, v,
+	}
+}
+
+func findTSSegments(t *testing.T, dir string) []string {
+	var ret []string
+	err := filepath.WalkDir(dir, func(fpath string, _ os.DirEntry, err error) error {
+		if err == nil && filepath.Ext(fpath) == ".ts" {
+			ret = append(ret, fpath)
+		}
+		return err
+	})
+	require.NoError(t, err)
+	sort.Strings(ret)
+	return ret
+}
+
+type tsContent struct {
+	codecs []string
+	video  [][][]byte
+	audio  [][]byte
+	klv    [][]byte
+}
+
+func readTSSegment(t *testing.T, fpath string) *tsContent {
+	f, err := os.Open(fpath)
+	require.NoError(t, err)
+	defer f.Close()
+
+	r := &mpegts.Reader{R: f}
+	err = r.Initialize()
+	require.NoError(t, err)
+
+	c := &tsContent{}
+
+	for _, track := range r.Tracks() {
+		switch track.Codec.(type) {
+		case *mpegts.CodecH264:
+			c.codecs = append(c.codecs, "H264")
+			r.OnDataH264(track, func(_ int64, _ int64, au [][]byte) error {
+				c.video = append(c.video, au)
+				return nil
+			})
+
+		case *mpegts.CodecMPEG4Audio:
+			c.codecs = append(c.codecs, "MPEG-4 Audio")
+			r.OnDataMPEG4Audio(track, func(_ int64, aus [][]byte) error {
+				c.audio = append(c.audio, aus...)
+				return nil
+			})
+
+		case *mpegts.CodecKLV:
+			c.codecs = append(c.codecs, "KLV")
+			r.OnDataKLV(track, func(_ int64, data []byte) error {
+				c.klv = append(c.klv, append([]byte(nil), data...))
+				return nil
+			})
+		}
+	}
+
+	for {
+		err = r.Read()
+		if err != nil {
+			break
+		}
+	}
+
+	return c
+}
+
+func TestRecorderMPEGTS(t *testing.T) {
+	dir := t.TempDir()
+
+	src := newTestMultiTrackSource(t)
+	defer src.strm.Close()
+
+	r := &Recorder{
+		Format:          FormatMPEGTS,
+		PathFormat:      filepath.Join(dir, "%path", "%Y-%m-%d_%H-%M-%S-%f"),
+		PartDuration:    100 * time.Millisecond,
+		SegmentDuration: 1 * time.Second,
+		Fsync:           FsyncSegment,
+		PathName:        "mypath",
+		Stream:          src.strm,
+		WriteQueueSize:  512,
+		Parent:          nilLogger{},
+	}
+	r.Initialize()
+
+	src.writeFrames(25)
+	r.Close()
+
+	// segments are rotated on the keyframes of the video track
+	segments := findTSSegments(t, dir)
+	require.Len(t, segments, 3)
+
+	totalVideo := 0
+	totalAudio := 0
+	var klv [][]byte
+
+	for i, fpath := range segments {
+		var pa recordstore.Path
+		ok := pa.Decode(r.PathFormat, fpath[:len(fpath)-len(".ts")])
+		require.True(t, ok)
+		require.Equal(t, "mypath", pa.Path)
+		require.Equal(t, src.start.Add(time.Duration(i)*time.Second), pa.Start.UTC())
+
+		c := readTSSegment(t, fpath)
+		require.Equal(t, []string{"H264", "MPEG-4 Audio", "KLV"}, c.codecs)
+
+		// every segment starts with a keyframe
+		require.Equal(t, [][]byte{testSPS, testPPS, {0x65, 0x88, 0x84, 0x00, byte(i * 10)}}, c.video[0])
+
+		totalVideo += len(c.video)
+		totalAudio += len(c.audio)
+		klv = append(klv, c.klv...)
+	}
+
+	require.Equal(t, 25, totalVideo)
+	require.Equal(t, 25, totalAudio)
+	require.Equal(t, [][]byte{
+		testKLVUnit(0),
+		testKLVUnit(5),
+		testKLVUnit(10),
+		testKLVUnit(15),
+		testKLVUnit(20),
+	}, klv)
+}
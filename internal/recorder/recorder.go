@@ -0,0 +1,139 @@
+// Package recorder contains the recorder.
+package recorder
+
+import (
+	"XMedia/internal/logger"
+	"XMedia/internal/recordcrypto"
+	"XMedia/internal/stream"
+	"time"
+)
+
+// formats of recordings.
+const (
+	FormatFMP4   = "fmp4"
+	FormatMPEGTS = "mpegts"
+)
+
+// policies of synchronization of segments to disk.
+const (
+	// leave synchronization to the operating system.
+	FsyncNever = "never"
+	// synchronize segments when they are closed.
+	FsyncSegment = "segment"
+	// synchronize segments every time a part is written.
+	FsyncPart = "part"
+)
+
+// time after which a failed recording is restarted.
+const restartPause = 2 * time.Second
+
+// Recorder writes the content of a stream to disk.
+type Recorder struct {
+	// FormatFMP4 or FormatMPEGTS.
+	Format string
+	// template of the path of segments, without extension.
+	PathFormat      string
+	PartDuration    time.Duration
+	SegmentDuration time.Duration
+	// FsyncNever, FsyncSegment or FsyncPart.
+	Fsync string
+	// keys used to encrypt segments. nil disables encryption.
+	EncryptionKeys *recordcrypto.Keys
+	PathName       string
+	Stream         *stream.Stream
+	WriteQueueSize int
+	// start from the pre-roll buffer of the stream.
+	PreRoll bool
+	// when PreRoll is true, replay only units that are newer than this,
+	// that is the last unit recorded by a previous recorder of the same stream.
+	PreRollAfter time.Time
+	Parent       logger.Writer
+
+	terminate chan struct{}
+	done      chan struct{}
+	lastNTP   time.Time
+}
+
+// Initialize initializes Recorder.
+func (r *Recorder) Initialize() {
+	r.terminate = make(chan struct{})
+	r.done = make(chan struct{})
+
+	r.Log(logger.Info, "recording to %s", r.PathFormat)
+
+	// the pre-roll buffer is used by the first instance only,
+	// since the following ones would write it again.
+	// The first instance is created here, in order not to miss units that follow.
+	ri := r.newInstance(r.PreRoll)
+
+	go r.run(ri)
+}
+
+// Close closes the Recorder.
+func (r *Recorder) Close() {
+	r.Log(logger.Info, "recording stopped")
+	close(r.terminate)
+	<-r.done
+}
+
+// LastNTP returns the NTP of the last unit that has been recorded.
+// It must be called after Close().
+func (r *Recorder) LastNTP() time.Time {
+	return r.lastNTP
+}
+
+// Log implements logger.Writer.
+func (r *Recorder) Log(level logger.Level, format string, args ...interface{}) {
+	r.Parent.Log(level, "[recorder] "+format, args...)
+}
+
+func (r *Recorder) newInstance(preRoll bool) *recorderInstance {
+	ri := &recorderInstance{
+		recordFormat:    r.Format,
+		pathFormat:      r.PathFormat,
+		partDuration:    r.PartDuration,
+		segmentDuration: r.SegmentDuration,
+		fsync:           r.Fsync,
+		encryptionKeys:  r.EncryptionKeys,
+		pathName:        r.PathName,
+		stream:          r.Stream,
+		writeQueueSize:  r.WriteQueueSize,
+		preRoll:         preRoll,
+		preRollAfter:    r.PreRollAfter,
+		parent:          r,
+	}
+	ri.initialize()
+	return ri
+}
+
+func (r *Recorder) run(ri *recorderInstance) {
+	defer close(r.done)
+
+	for {
+		select {
+		case err := <-ri.writer.Error():
+			ri.close()
+			r.updateLastNTP(ri)
+			r.Log(logger.Error, "%v", err)
+
+		case <-r.terminate:
+			ri.close()
+			r.updateLastNTP(ri)
+			return
+		}
+
+		select {
+		case <-time.After(restartPause):
+		case <-r.terminate:
+			return
+		}
+
+		ri = r.newInstance(false)
+	}
+}
+
+func (r *Recorder) updateLastNTP(ri *recorderInstance) {
+	if ri.lastNTP.After(r.lastNTP) {
+		r.lastNTP = ri.lastNTP
+	}
+}
@@ -0,0 +1,138 @@
+package recorder
+
+import (
+	"XMedia/internal/asyncwriter"
+	"XMedia/internal/logger"
+	"XMedia/internal/recordcrypto"
+	"XMedia/internal/stream"
+	"io"
+	"os"
+	"time"
+)
+
+func timestampToDuration(d int64, clockRate int) time.Duration {
+	cr := int64(clockRate)
+	return time.Duration(d/cr)*time.Second + time.Duration(d%cr)*time.Second/time.Duration(cr)
+}
+
+func durationToTimestamp(d time.Duration, clockRate int) int64 {
+	cr := int64(clockRate)
+	return int64(d/time.Second)*cr + int64(d%time.Second)*cr/int64(time.Second)
+}
+
+// syncDir synchronizes a directory to disk,
+// in order to make sure that files created inside it survive a power loss.
+func syncDir(dir string) error {
+	fi, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer fi.Close()
+
+	return fi.Sync()
+}
+
+// newSegmentWriter returns the writer of a segment file, that encrypts data when keys are set.
+func newSegmentWriter(fi *os.File, keys *recordcrypto.Keys) (io.Writer, error) {
+	if keys == nil {
+		return fi, nil
+	}
+
+	w := &recordcrypto.Writer{
+		W:    fi,
+		Keys: keys,
+	}
+	err := w.Initialize()
+	if err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// closeSegmentWriter marks the segment as complete, when it is encrypted.
+func closeSegmentWriter(w io.Writer) error {
+	if cw, ok := w.(*recordcrypto.Writer); ok {
+		return cw.Close()
+	}
+	return nil
+}
+
+// recordFormat is a container format of recordings.
+type recordFormat interface {
+	// adds readers of the supported tracks, returns false when there are none.
+	initialize() bool
+	// writes pending data and closes the current segment.
+	close()
+}
+
+type recorderInstance struct {
+	recordFormat    string
+	pathFormat      string
+	partDuration    time.Duration
+	segmentDuration time.Duration
+	fsync           string
+	encryptionKeys  *recordcrypto.Keys
+	pathName        string
+	stream          *stream.Stream
+	writeQueueSize  int
+	preRoll         bool
+	preRollAfter    time.Time
+	parent          logger.Writer
+
+	writer *asyncwriter.Writer
+	format recordFormat
+	// NTP of the last unit written into a segment.
+	lastNTP time.Time
+}
+
+func (ri *recorderInstance) initialize() {
+	ri.writer = &asyncwriter.Writer{
+		QueueSize: ri.writeQueueSize,
+		Parent:    ri,
+	}
+	ri.writer.Initialize()
+
+	if ri.recordFormat == FormatMPEGTS {
+		ri.format = &formatMPEGTS{
+			ri: ri,
+		}
+		if !ri.format.initialize() {
+			ri.Log(logger.Warn, "the stream doesn't contain any supported codec, "+
+				"which are currently H264, H265, MPEG-4 Audio, Opus, KLV")
+		}
+	} else {
+		ri.format = &formatFMP4{
+			ri: ri,
+		}
+		if !ri.format.initialize() {
+			ri.Log(logger.Warn, "the stream doesn't contain any supported codec, "+
+				"which are currently H264, H265, MPEG-4 Audio, Opus, G711")
+		}
+	}
+
+	if ri.preRoll {
+		ri.stream.StartReaderFromPreRoll(ri.writer, ri.preRollAfter)
+	} else {
+		ri.stream.StartReader(ri.writer)
+	}
+	ri.writer.Start()
+}
+
+func (ri *recorderInstance) close() {
+	ri.stream.RemoveReader(ri.writer)
+	ri.writer.Stop()
+	ri.format.close()
+}
+
+// written is called when a unit has been written into a segment.
+func (ri *recorderInstance) written(ntp time.Time) {
+	if ntp.After(ri.lastNTP) {
+		ri.lastNTP = ntp
+	}
+}
+
+// Log implements logger.Writer.
+func (ri *recorderInstance) Log(level logger.Level, format string, args ...interface{}) {
+	ri.parent.Log(level, format, args...)
+}
@@ -0,0 +1,346 @@
+package recorder
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"XMedia/internal/logger"
+	"XMedia/internal/recordcrypto"
+	"XMedia/internal/recordstore"
+	"XMedia/internal/stream"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/description"
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/bluenviron/mediacommon/v2/pkg/formats/fmp4"
+	"github.com/bluenviron/mediacommon/v2/pkg/formats/mp4"
+	"github.com/stretchr/testify/require"
+)
+
+var testSPS = []byte{
+	0x67, 0x42, 0xc0, 0x28, 0xd9, 0x00, 0x78, 0x02,
+	0x27, 0xe5, 0x84, 0x00, 0x00, 0x03, 0x00, 0x04,
+	0x00, 0x00, 0x03, 0x00, 0xf0, 0x3c, 0x60, 0xc9,
+	0x20,
+}
+
+var testSPS2 = []byte{
+	0x67, 0x64, 0x00, 0x0c, 0xac, 0x3b, 0x50, 0xb0,
+	0x4b, 0x42, 0x00, 0x00, 0x03, 0x00, 0x02, 0x00,
+	0x00, 0x03, 0x00, 0x3d, 0x08,
+}
+
+var testPPS = []byte{0x68, 0xee, 0x3c, 0x80}
+
+type nilLogger struct{}
+
+func (nilLogger) Log(logger.Level, string, ...interface{}) {}
+
+type testSource struct {
+	t      *testing.T
+	medi   *description.Media
+	forma  *format.H264
+	strm   *stream.Stream
+	start  time.Time
+	frames int
+}
+
+func newTestSource(t *testing.T, preRoll time.Duration) *testSource {
+	forma := &format.H264{
+		PayloadTyp:        96,
+		SPS:               testSPS,
+		PPS:               testPPS,
+		PacketizationMode: 1,
+	}
+	medi := &description.Media{
+		Type:    description.MediaTypeVideo,
+		Formats: []format.Format{forma},
+	}
+
+	strm := &stream.Stream{
+		WriteQueueSize:    512,
+		UDPMaxPayloadSize: 1472,
+		Desc:              &description.Session{Medias: []*description.Media{medi}},
+		PreRollDuration:   preRoll,
+		Parent:            nilLogger{},
+	}
+	err := strm.Initialize()
+	require.NoError(t, err)
+
+	return &testSource{
+		t:     t,
+		medi:  medi,
+		forma: forma,
+		strm:  strm,
+		start: time.Date(2008, 5, 20, 22, 15, 25, 0, time.UTC),
+	}
+}
+
+// writeFrames writes frames at 10 fps, with a keyframe every 10 frames.
+func (s *testSource) writeFrames(n int, sps []byte) {
+	enc, err := s.forma.CreateEncoder()
+	require.NoError(s.t, err)
+
+	for i := 0; i < n; i++ {
+		var au [][]byte
+		if s.frames%10 == 0 {
+			au = [][]byte{sps, testPPS, {0x65, 0x88, 0x84, 0x00, byte(s.frames)}}
+		} else {
+			au = [][]byte{{0x41, 0x9a, 0x24, 0x6c, byte(s.frames)}}
+		}
+
+		pkts, err := enc.Encode(au)
+		require.NoError(s.t, err)
+
+		pts := int64(s.frames) * 9000
+		ntp := s.start.Add(time.Duration(s.frames) * 100 * time.Millisecond)
+
+		for _, pkt := range pkts {
+			pkt.Timestamp = uint32(pts)
+			s.strm.WriteRTPPacket(s.medi, s.forma, pkt, ntp, pts)
+		}
+
+		s.frames++
+	}
+}
+
+func readInit(t *testing.T, fpath string) *fmp4.Init {
+	buf, err := os.ReadFile(fpath)
+	require.NoError(t, err)
+
+	var init fmp4.Init
+	err = init.Unmarshal(bytes.NewReader(buf))
+	require.NoError(t, err)
+
+	return &init
+}
+
+func readParts(t *testing.T, fpath string) fmp4.Parts {
+	buf, err := os.ReadFile(fpath)
+	require.NoError(t, err)
+
+	// skip ftyp and moov
+	offset := 0
+	for i := 0; i < 2; i++ {
+		offset += int(uint32(buf[offset])<<24 | uint32(buf[offset+1])<<16 | uint32(buf[offset+2])<<8 | uint32(buf[offset+3]))
+	}
+
+	var parts fmp4.Parts
+	err = parts.Unmarshal(buf[offset:])
+	require.NoError(t, err)
+
+	return parts
+}
+
+func findSegments(t *testing.T, dir string) []string {
+	var ret []string
+	err := filepath.WalkDir(dir, func(fpath string, _ os.DirEntry, err error) error {
+		if err == nil && filepath.Ext(fpath) == ".mp4" {
+			ret = append(ret, fpath)
+		}
+		return err
+	})
+	require.NoError(t, err)
+	sort.Strings(ret)
+	return ret
+}
+
+func countSamples(parts fmp4.Parts) int {
+	n := 0
+	for _, part := range parts {
+		for _, track := range part.Tracks {
+			n += len(track.Samples)
+		}
+	}
+	return n
+}
+
+func TestRecorderSegmentRotation(t *testing.T) {
+	dir := t.TempDir()
+
+	src := newTestSource(t, 0)
+	defer src.strm.Close()
+
+	r := &Recorder{
+		Format:          FormatFMP4,
+		PathFormat:      filepath.Join(dir, "%path", "%Y-%m-%d_%H-%M-%S-%f"),
+		PartDuration:    100 * time.Millisecond,
+		SegmentDuration: 1 * time.Second,
+		Fsync:           FsyncSegment,
+		PathName:        "mypath",
+		Stream:          src.strm,
+		WriteQueueSize:  512,
+		Parent:          nilLogger{},
+	}
+	r.Initialize()
+
+	src.writeFrames(35, testSPS)
+
+	// segments are closed, and frames in queue are written
+	r.Close()
+
+	segments := findSegments(t, dir)
+	require.Len(t, segments, 4)
+
+	total := 0
+	for i, fpath := range segments {
+		var pa recordstore.Path
+		ok := pa.Decode(r.PathFormat, fpath[:len(fpath)-len(".mp4")])
+		require.True(t, ok)
+		require.Equal(t, "mypath", pa.Path)
+		require.Equal(t, src.start.Add(time.Duration(i)*time.Second), pa.Start.UTC())
+
+		parts := readParts(t, fpath)
+		require.False(t, parts[0].Tracks[0].Samples[0].IsNonSyncSample)
+		total += countSamples(parts)
+	}
+
+	// the last frame, whose duration is unknown, is written too
+	require.Equal(t, 35, total)
+}
+
+func TestRecorderParametersChange(t *testing.T) {
+	dir := t.TempDir()
+
+	src := newTestSource(t, 0)
+	defer src.strm.Close()
+
+	r := &Recorder{
+		Format:          FormatFMP4,
+		PathFormat:      filepath.Join(dir, "%path", "%Y-%m-%d_%H-%M-%S-%f"),
+		PartDuration:    100 * time.Millisecond,
+		SegmentDuration: 1 * time.Hour,
+		Fsync:           FsyncNever,
+		PathName:        "mypath",
+		Stream:          src.strm,
+		WriteQueueSize:  512,
+		Parent:          nilLogger{},
+	}
+	r.Initialize()
+
+	src.writeFrames(20, testSPS)
+	src.writeFrames(20, testSPS2)
+	r.Close()
+
+	segments := findSegments(t, dir)
+	require.Len(t, segments, 2)
+
+	init1 := readInit(t, segments[0])
+	require.Equal(t, testSPS, init1.Tracks[0].Codec.(*mp4.CodecH264).SPS)
+
+	init2 := readInit(t, segments[1])
+	require.Equal(t, testSPS2, init2.Tracks[0].Codec.(*mp4.CodecH264).SPS)
+
+	require.Equal(t, 20, countSamples(readParts(t, segments[0])))
+	require.Equal(t, 20, countSamples(readParts(t, segments[1])))
+}
+
+func TestRecorderPreRollAfterPreviousEvent(t *testing.T) {
+	dir := t.TempDir()
+
+	src := newTestSource(t, 1*time.Hour)
+	defer src.strm.Close()
+
+	newRecorder := func(after time.Time) *Recorder {
+		r := &Recorder{
+			Format:          FormatFMP4,
+			PathFormat:      filepath.Join(dir, "%path", "%Y-%m-%d_%H-%M-%S-%f"),
+			PartDuration:    100 * time.Millisecond,
+			SegmentDuration: 1 * time.Hour,
+			Fsync:           FsyncNever,
+			PathName:        "mypath",
+			Stream:          src.strm,
+			WriteQueueSize:  512,
+			PreRoll:         true,
+			PreRollAfter:    after,
+			Parent:          nilLogger{},
+		}
+		r.Initialize()
+		return r
+	}
+
+	// first event
+	src.writeFrames(20, testSPS)
+	r := newRecorder(time.Time{})
+	src.writeFrames(5, testSPS)
+	r.Close()
+	require.Equal(t, src.start.Add(24*100*time.Millisecond), r.LastNTP().UTC())
+
+	// frames between events
+	src.writeFrames(15, testSPS)
+
+	// second event, the pre-roll buffer contains the first event too
+	r = newRecorder(r.LastNTP())
+	src.writeFrames(10, testSPS)
+	r.Close()
+
+	segments := findSegments(t, dir)
+	require.Len(t, segments, 2)
+
+	require.Equal(t, 25, countSamples(readParts(t, segments[0])))
+
+	// units already recorded are skipped, the segment starts with the following keyframe
+	var pa recordstore.Path
+	ok := pa.Decode(r.PathFormat, segments[1][:len(segments[1])-len(".mp4")])
+	require.True(t, ok)
+	require.Equal(t, src.start.Add(30*100*time.Millisecond), pa.Start.UTC())
+	require.Equal(t, 20, countSamples(readParts(t, segments[1])))
+}
+
+func TestRecorderEncryption(t *testing.T) {
+	dir := t.TempDir()
+
+	keyPath := filepath.Join(dir, "keys")
+	err := os.WriteFile(keyPath, []byte("000102030405060708090a0b0c0d0e0f\n"), 0o644)
+	require.NoError(t, err)
+	keys, err := recordcrypto.LoadKeys(keyPath)
+	require.NoError(t, err)
+
+	src := newTestSource(t, 0)
+	defer src.strm.Close()
+
+	r := &Recorder{
+		Format:          FormatFMP4,
+		PathFormat:      filepath.Join(dir, "%path", "%Y-%m-%d_%H-%M-%S-%f"),
+		PartDuration:    100 * time.Millisecond,
+		SegmentDuration: 1 * time.Second,
+		Fsync:           FsyncNever,
+		PathName:        "mypath",
+		Stream:          src.strm,
+		WriteQueueSize:  512,
+		EncryptionKeys:  keys,
+		Parent:          nilLogger{},
+	}
+	r.Initialize()
+
+	src.writeFrames(25, testSPS)
+	r.Close()
+
+	segments := findSegments(t, dir)
+	require.Len(t, segments, 3)
+
+	for _, fpath := range segments {
+		f, err := os.Open(fpath)
+		require.NoError(t, err)
+		defer f.Close()
+
+		st, err := f.Stat()
+		require.NoError(t, err)
+
+		cr := &recordcrypto.Reader{R: f, Size: st.Size(), Keys: keys}
+		err = cr.Initialize()
+		require.NoError(t, err)
+
+		// segments are closed with the final chunk
+		require.True(t, cr.Complete())
+		require.Equal(t, st.Size(), cr.ValidSize())
+
+		var init fmp4.Init
+		err = init.Unmarshal(cr)
+		require.NoError(t, err)
+		require.Equal(t, testSPS, init.Tracks[0].Codec.(*mp4.CodecH264).SPS)
+	}
+}
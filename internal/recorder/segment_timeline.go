@@ -0,0 +1,43 @@
+package recorder
+
+import (
+	"XMedia/internal/logger"
+	"XMedia/internal/recordstore"
+	"time"
+)
+
+// interval between two updates of the timeline while a segment is being written.
+const timelineUpdatePeriod = 10 * time.Second
+
+// segmentTimeline keeps the timeline of the path updated with the time range of a segment.
+type segmentTimeline struct {
+	ri    *recorderInstance
+	start time.Time
+
+	end   time.Time
+	saved time.Time
+}
+
+func (t *segmentTimeline) initialize() {
+	t.end = t.start
+	t.save()
+}
+
+// update sets the end of the segment, that is saved periodically.
+func (t *segmentTimeline) update(end time.Time) {
+	if end.After(t.end) {
+		t.end = end
+	}
+
+	if t.end.Sub(t.saved) >= timelineUpdatePeriod {
+		t.save()
+	}
+}
+
+func (t *segmentTimeline) save() {
+	err := recordstore.UpdateTimeline(t.ri.pathFormat, t.ri.pathName, t.start, t.end)
+	if err != nil {
+		t.ri.Log(logger.Warn, "unable to update timeline: %v", err)
+	}
+	t.saved = t.end
+}
@@ -0,0 +1,187 @@
+package recordrecovery
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/bluenviron/mediacommon/v2/pkg/formats/fmp4"
+)
+
+// boxHeader is the header of a top-level MP4 box.
+type boxHeader struct {
+	typ  string
+	size int64
+}
+
+// readBoxHeaderAt reads the header of a box and checks that the box is entirely contained in the file.
+// Boxes that extend to the end of file are not produced by the recorder, and are considered invalid,
+// since they are what a zero-filled tail looks like.
+func readBoxHeaderAt(r io.ReaderAt, offset int64, fileSize int64) (*boxHeader, bool) {
+	buf := make([]byte, 16)
+	_, err := r.ReadAt(buf[:8], offset)
+	if err != nil {
+		return nil, false
+	}
+
+	h := &boxHeader{
+		typ:  string(buf[4:8]),
+		size: int64(binary.BigEndian.Uint32(buf[:4])),
+	}
+
+	for _, c := range []byte(h.typ) {
+		if c < 0x20 || c > 0x7E {
+			return nil, false
+		}
+	}
+
+	if h.size == 1 {
+		_, err = r.ReadAt(buf[8:], offset+8)
+		if err != nil {
+			return nil, false
+		}
+		h.size = int64(binary.BigEndian.Uint64(buf[8:]))
+	}
+
+	if h.size < 8 || offset+h.size > fileSize {
+		return nil, false
+	}
+
+	return h, true
+}
+
+// fmp4Layout is the position of the sections of a fMP4 segment.
+type fmp4Layout struct {
+	initSize int64
+	// position of the last complete part, -1 when there are no complete parts.
+	lastPartOffset int64
+	lastPartSize   int64
+}
+
+// readFMP4Layout finds the initialization section and the last complete part of a fMP4 segment.
+// It returns false when the initialization section is not complete.
+func readFMP4Layout(r io.ReaderAt, fileSize int64) (*fmp4Layout, bool) {
+	offset := int64(0)
+
+	// initialization section
+	for {
+		h, ok := readBoxHeaderAt(r, offset, fileSize)
+		if !ok || (h.typ != "ftyp" && h.typ != "moov") {
+			return nil, false
+		}
+
+		offset += h.size
+
+		if h.typ == "moov" {
+			break
+		}
+	}
+
+	l := &fmp4Layout{
+		initSize:       offset,
+		lastPartOffset: -1,
+	}
+
+	for offset < fileSize {
+		h, ok := readBoxHeaderAt(r, offset, fileSize)
+		if !ok {
+			break
+		}
+
+		// a part is a moof box followed by a mdat box
+		if h.typ == "moof" {
+			mdat, ok := readBoxHeaderAt(r, offset+h.size, fileSize)
+			if !ok || mdat.typ != "mdat" {
+				break
+			}
+
+			l.lastPartOffset = offset
+			l.lastPartSize = h.size + mdat.size
+			offset += l.lastPartSize
+			continue
+		}
+
+		offset += h.size
+	}
+
+	return l, true
+}
+
+// fmp4ValidSize returns the size of the valid section of a fMP4 segment,
+// that is made of the initialization section followed by complete parts.
+// It returns zero when the segment doesn't contain any complete part.
+func fmp4ValidSize(r io.ReaderAt, fileSize int64) int64 {
+	l, ok := readFMP4Layout(r, fileSize)
+	if !ok || l.lastPartOffset < 0 {
+		return 0
+	}
+
+	return l.lastPartOffset + l.lastPartSize
+}
+
+// fmp4Duration returns the duration of a fMP4 segment, that is read from its last complete part.
+func fmp4Duration(r io.ReaderAt, fileSize int64) (time.Duration, error) {
+	l, ok := readFMP4Layout(r, fileSize)
+	if !ok {
+		return 0, fmt.Errorf("initialization section is not complete")
+	}
+
+	if l.lastPartOffset < 0 {
+		return 0, nil
+	}
+
+	buf := make([]byte, l.initSize)
+	_, err := r.ReadAt(buf, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	var init fmp4.Init
+	err = init.Unmarshal(bytes.NewReader(buf))
+	if err != nil {
+		return 0, err
+	}
+
+	buf = make([]byte, l.lastPartSize)
+	_, err = r.ReadAt(buf, l.lastPartOffset)
+	if err != nil {
+		return 0, err
+	}
+
+	var parts fmp4.Parts
+	err = parts.Unmarshal(buf)
+	if err != nil {
+		return 0, err
+	}
+
+	timeScales := make(map[int]uint32)
+	for _, track := range init.Tracks {
+		timeScales[track.ID] = track.TimeScale
+	}
+
+	var duration time.Duration
+
+	for _, part := range parts {
+		for _, track := range part.Tracks {
+			timeScale, ok := timeScales[track.ID]
+			if !ok || timeScale == 0 {
+				continue
+			}
+
+			end := track.BaseTime
+			for _, sample := range track.Samples {
+				end += uint64(sample.Duration)
+			}
+
+			d := time.Duration(end/uint64(timeScale))*time.Second +
+				time.Duration(end%uint64(timeScale))*time.Second/time.Duration(timeScale)
+			if d > duration {
+				duration = d
+			}
+		}
+	}
+
+	return duration, nil
+}
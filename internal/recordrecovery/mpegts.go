@@ -0,0 +1,116 @@
+package recordrecovery
+
+import (
+	"bufio"
+	"io"
+	"time"
+)
+
+const (
+	mpegtsPacketSize = 188
+	mpegtsSyncByte   = 0x47
+)
+
+// mpegtsValidSize returns the size of the valid section of a MPEG-TS segment,
+// that ends with the last packet that is complete and starts with a sync byte.
+func mpegtsValidSize(r io.ReaderAt, fileSize int64) int64 {
+	validSize := fileSize - fileSize%mpegtsPacketSize
+	buf := make([]byte, 1)
+
+	for validSize > 0 {
+		_, err := r.ReadAt(buf, validSize-mpegtsPacketSize)
+		if err == nil && buf[0] == mpegtsSyncByte {
+			break
+		}
+		validSize -= mpegtsPacketSize
+	}
+
+	return validSize
+}
+
+// mpegtsDuration returns the duration of a MPEG-TS segment, that is the maximum
+// difference between the timestamps of the PES packets of every stream.
+func mpegtsDuration(r io.ReaderAt, fileSize int64) (time.Duration, error) {
+	br := bufio.NewReaderSize(io.NewSectionReader(r, 0, fileSize), 64*1024)
+	buf := make([]byte, mpegtsPacketSize)
+
+	first := make(map[uint16]int64)
+	maxDiff := int64(0)
+
+	for {
+		_, err := io.ReadFull(br, buf)
+		if err != nil {
+			break
+		}
+
+		ts, pid, ok := mpegtsPacketTimestamp(buf)
+		if !ok {
+			continue
+		}
+
+		f, ok := first[pid]
+		if !ok {
+			first[pid] = ts
+			continue
+		}
+
+		// timestamps have 33 bits and wrap around.
+		// Timestamps that precede the first one, like the ones of B-frames, are ignored.
+		diff := (ts - f) & (1<<33 - 1)
+		if diff < 1<<32 && diff > maxDiff {
+			maxDiff = diff
+		}
+	}
+
+	return time.Duration(maxDiff) * time.Second / 90000, nil
+}
+
+// mpegtsPacketTimestamp returns the DTS, or the PTS when the DTS is missing,
+// of the PES packet that starts in a MPEG-TS packet.
+func mpegtsPacketTimestamp(buf []byte) (int64, uint16, bool) {
+	if buf[0] != mpegtsSyncByte || buf[1]&0x40 == 0 { // payload_unit_start_indicator
+		return 0, 0, false
+	}
+
+	pid := uint16(buf[1]&0x1F)<<8 | uint16(buf[2])
+
+	payload := buf[4:]
+
+	switch buf[3] >> 4 & 0x03 { // adaptation_field_control
+	case 0x02: // adaptation field only
+		return 0, 0, false
+
+	case 0x03: // adaptation field followed by payload
+		n := 1 + int(buf[4])
+		if n > len(payload) {
+			return 0, 0, false
+		}
+		payload = payload[n:]
+	}
+
+	// PES start code, stream ID, length, flags, header length, timestamps
+	if len(payload) < 14 || payload[0] != 0 || payload[1] != 0 || payload[2] != 1 {
+		return 0, 0, false
+	}
+
+	switch payload[7] >> 6 { // PTS_DTS_flags
+	case 0x02:
+		return mpegtsReadTimestamp(payload[9:14]), pid, true
+
+	case 0x03:
+		if len(payload) < 19 {
+			return 0, 0, false
+		}
+		return mpegtsReadTimestamp(payload[14:19]), pid, true
+	}
+
+	return 0, 0, false
+}
+
+func mpegtsReadTimestamp(buf []byte) int64 {
+	return int64(buf[0]>>1&0x07)<<30 |
+		int64(buf[1])<<22 |
+		int64(buf[2]>>1)<<15 |
+		int64(buf[3])<<7 |
+		int64(buf[4]>>1)
+}
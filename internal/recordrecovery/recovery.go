@@ -0,0 +1,295 @@
+// Package recordrecovery contains the recovery of recording segments.
+package recordrecovery
+
+import (
+	"XMedia/internal/conf"
+	"XMedia/internal/logger"
+	"XMedia/internal/recordcrypto"
+	"XMedia/internal/recordstore"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Recovery repairs the segments that were being written when the server was stopped abruptly.
+//
+// Segments are written in parts, therefore a crash or a power loss can only damage
+// the end of the last segment of every path. Recovery truncates it to its last complete part,
+// and removes it when no part is complete. Then, timelines are reconciled with segments.
+// It must be run before recorders are started.
+//
+// Encrypted segments end with a final chunk when they are closed. The last segment
+// of every path, when it lacks the final chunk, is considered interrupted and is closed
+// by the recovery. Other segments that lack the final chunk have been truncated,
+// and are reported.
+type Recovery struct {
+	PathConfs    map[string]*conf.Path
+	PathDefaults *conf.Path
+	Parent       logger.Writer
+}
+
+// Log implements logger.Writer.
+func (r *Recovery) Log(level logger.Level, format string, args ...interface{}) {
+	r.Parent.Log(level, "[record recovery] "+format, args...)
+}
+
+// Run runs the recovery.
+func (r *Recovery) Run() {
+	pathConfs := []*conf.Path{r.PathDefaults}
+	for _, pconf := range r.PathConfs {
+		pathConfs = append(pathConfs, pconf)
+	}
+
+	// different paths can share the same record path
+	done := make(map[string]struct{})
+
+	for _, pconf := range pathConfs {
+		if _, ok := done[pconf.RecordPath]; ok || pconf.RecordPath == "" {
+			continue
+		}
+		done[pconf.RecordPath] = struct{}{}
+
+		r.processPath(pconf.RecordPath)
+	}
+}
+
+func (r *Recovery) processPath(recordPath string) {
+	// segments of every path
+	segments := make(map[string][]*recordstore.Segment)
+
+	// paths that have a timeline
+	timelines := make(map[string]struct{})
+
+	filepath.WalkDir(recordstore.CommonPath(recordPath), func(fpath string, info fs.DirEntry, err error) error { //nolint:errcheck
+		if err != nil || info.IsDir() {
+			return nil
+		}
+
+		if pathName, ok := recordstore.TimelinePathName(recordPath, fpath); ok {
+			timelines[pathName] = struct{}{}
+			return nil
+		}
+
+		ext := filepath.Ext(fpath)
+		if ext != ".mp4" && ext != ".ts" {
+			return nil
+		}
+
+		var pa recordstore.Path
+		if !pa.Decode(recordPath, strings.TrimSuffix(fpath, ext)) {
+			return nil
+		}
+
+		pconf := conf.FindPathConf(r.PathConfs, r.PathDefaults, pa.Path)
+		if pconf.RecordPath != recordPath {
+			return nil
+		}
+
+		segments[pa.Path] = append(segments[pa.Path], &recordstore.Segment{
+			Fpath: fpath,
+			Path:  pa,
+		})
+
+		return nil
+	})
+
+	for pathName, pathSegments := range segments {
+		sort.Slice(pathSegments, func(i, j int) bool {
+			return pathSegments[i].Start.Before(pathSegments[j].Start)
+		})
+
+		pconf := conf.FindPathConf(r.PathConfs, r.PathDefaults, pathName)
+
+		for _, seg := range pathSegments[:len(pathSegments)-1] {
+			r.checkSegment(seg.Fpath, pconf.RecordEncryptionKeys)
+		}
+
+		last := pathSegments[len(pathSegments)-1]
+		if r.recoverSegment(last.Fpath, pconf.RecordEncryptionKeys) {
+			pathSegments = pathSegments[:len(pathSegments)-1]
+		}
+
+		r.reconcileTimeline(recordPath, pathName, pathSegments, pconf.RecordEncryptionKeys)
+		delete(timelines, pathName)
+	}
+
+	// timelines of paths without segments
+	for pathName := range timelines {
+		r.reconcileTimeline(recordPath, pathName, nil, nil)
+	}
+}
+
+// recoverSegment recovers a segment and returns whether it has been removed.
+func (r *Recovery) recoverSegment(fpath string, keys *recordcrypto.Keys) bool {
+	validSize := mpegtsValidSize
+	if filepath.Ext(fpath) == ".mp4" {
+		validSize = fmp4ValidSize
+	}
+
+	res, err := recoverFile(fpath, keys, validSize)
+
+	switch {
+	case err != nil:
+		r.Log(logger.Error, "unable to recover %s: %v", fpath, err)
+
+	case res.removed:
+		r.Log(logger.Warn, "removed %s, that doesn't contain any complete data", fpath)
+		return true
+
+	case res.interrupted:
+		r.Log(logger.Warn, "recovered %s, whose writing was interrupted: removed %d bytes of incomplete data, "+
+			"salvaged %d bytes", fpath, res.truncated, res.size)
+
+	case res.truncated > 0:
+		r.Log(logger.Warn, "recovered %s: removed %d bytes of incomplete data, salvaged %d bytes",
+			fpath, res.truncated, res.size)
+	}
+
+	return false
+}
+
+// result is the outcome of the recovery of a segment.
+type result struct {
+	// size of the segment after the recovery.
+	size int64
+	// amount of bytes that have been removed from the end.
+	truncated int64
+	// whether the segment has been removed.
+	removed bool
+	// whether the segment is encrypted and was not closed.
+	interrupted bool
+}
+
+// checkSegment reports encrypted segments that have been truncated after they were closed.
+func (r *Recovery) checkSegment(fpath string, keys *recordcrypto.Keys) {
+	if keys == nil {
+		return
+	}
+
+	fi, err := os.Open(fpath)
+	if err != nil {
+		return
+	}
+	defer fi.Close()
+
+	if !recordcrypto.IsEncrypted(fi) {
+		return
+	}
+
+	st, err := fi.Stat()
+	if err != nil {
+		return
+	}
+
+	cr := &recordcrypto.Reader{
+		R:    fi,
+		Size: st.Size(),
+		Keys: keys,
+	}
+	err = cr.Initialize()
+	if err != nil {
+		r.Log(logger.Error, "unable to read %s: %v", fpath, err)
+		return
+	}
+
+	if !cr.Complete() {
+		r.Log(logger.Error, "%s has been truncated after it was closed, %d bytes are left",
+			fpath, cr.PlainSize())
+	}
+}
+
+// recoverFile truncates a segment to the size of its valid section,
+// that is computed on the decrypted content when the segment is encrypted.
+func recoverFile(
+	fpath string,
+	keys *recordcrypto.Keys,
+	validSize func(r io.ReaderAt, size int64) int64,
+) (*result, error) {
+	fi, err := os.Open(fpath)
+	if err != nil {
+		return nil, err
+	}
+
+	st, err := fi.Stat()
+	if err != nil {
+		fi.Close()
+		return nil, err
+	}
+
+	if !recordcrypto.IsEncrypted(fi) {
+		size := validSize(fi, st.Size())
+		fi.Close()
+		return apply(fpath, st.Size(), size)
+	}
+
+	cr := &recordcrypto.Reader{
+		R:    fi,
+		Size: st.Size(),
+		Keys: keys,
+	}
+	err = cr.Initialize()
+	if err != nil {
+		fi.Close()
+		return nil, err
+	}
+
+	size := validSize(cr, cr.PlainSize())
+	fi.Close()
+
+	switch {
+	case cr.Complete() && size == cr.PlainSize() && size != 0 && cr.ValidSize() == st.Size():
+		return &result{size: st.Size()}, nil
+
+	case size == 0:
+		return apply(fpath, st.Size(), 0)
+	}
+
+	// the segment is truncated and closed with the final chunk
+	newSize, err := recordcrypto.Truncate(fpath, keys, size)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result{
+		size:        newSize,
+		truncated:   cr.PlainSize() - size + st.Size() - cr.ValidSize(),
+		interrupted: !cr.Complete(),
+	}, nil
+}
+
+// apply truncates or removes a segment, then synchronizes it to disk.
+func apply(fpath string, fileSize int64, validSize int64) (*result, error) {
+	if validSize == fileSize && validSize != 0 {
+		return &result{size: fileSize}, nil
+	}
+
+	if validSize == 0 {
+		err := os.Remove(fpath)
+		if err != nil {
+			return nil, err
+		}
+
+		return &result{truncated: fileSize, removed: true}, nil
+	}
+
+	fi, err := os.OpenFile(fpath, os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	defer fi.Close()
+
+	err = fi.Truncate(validSize)
+	if err != nil {
+		return nil, err
+	}
+
+	err = fi.Sync()
+	if err != nil {
+		return nil, err
+	}
+
+	return &result{size: validSize, truncated: fileSize - validSize}, nil
+}
@@ -0,0 +1,462 @@
+package recordrecovery
+
+import (
+	"XMedia/internal/conf"
+	"XMedia/internal/logger"
+	"XMedia/internal/recordcrypto"
+	"XMedia/internal/recordstore"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bluenviron/mediacommon/v2/pkg/formats/fmp4"
+	"github.com/bluenviron/mediacommon/v2/pkg/formats/fmp4/seekablebuffer"
+	"github.com/bluenviron/mediacommon/v2/pkg/formats/mp4"
+	"github.com/stretchr/testify/require"
+)
+
+type nilLogger struct{}
+
+func (nilLogger) Log(logger.Level, string, ...interface{}) {}
+
+// writeSegment writes a fMP4 segment with a part for every second of duration.
+func writeSegment(t *testing.T, fpath string, seconds int) []byte {
+	var buf seekablebuffer.Buffer
+
+	init := &fmp4.Init{
+		Tracks: []*fmp4.InitTrack{{
+			ID:        1,
+			TimeScale: 90000,
+			Codec: &mp4.CodecH264{
+				SPS: []byte{
+					0x67, 0x42, 0xc0, 0x28, 0xd9, 0x00, 0x78, 0x02,
+					0x27, 0xe5, 0x84, 0x00, 0x00, 0x03, 0x00, 0x04,
+					0x00, 0x00, 0x03, 0x00, 0xf0, 0x3c, 0x60, 0xc9,
+					0x20,
+				},
+				PPS: []byte{0x68, 0xee, 0x3c, 0x80},
+			},
+		}},
+	}
+	require.NoError(t, init.Marshal(&buf))
+
+	for i := 0; i < seconds; i++ {
+		part := &fmp4.Part{
+			SequenceNumber: uint32(i),
+			Tracks: []*fmp4.PartTrack{{
+				ID:       1,
+				BaseTime: uint64(i) * 90000,
+				Samples: []*fmp4.Sample{
+					{Duration: 45000, Payload: []byte{5, 1}},
+					{Duration: 45000, IsNonSyncSample: true, Payload: []byte{1, 2}},
+				},
+			}},
+		}
+		require.NoError(t, part.Marshal(&buf))
+	}
+
+	require.NoError(t, os.MkdirAll(filepath.Dir(fpath), 0o755))
+	require.NoError(t, os.WriteFile(fpath, buf.Bytes(), 0o644))
+
+	return buf.Bytes()
+}
+
+// writeMPEGTSSegment writes a MPEG-TS segment made of the given amount of packets.
+func writeMPEGTSSegment(t *testing.T, fpath string, count int) []byte {
+	pkt := make([]byte, mpegtsPacketSize)
+	pkt[0] = mpegtsSyncByte
+	byts := bytes.Repeat(pkt, count)
+
+	require.NoError(t, os.MkdirAll(filepath.Dir(fpath), 0o755))
+	require.NoError(t, os.WriteFile(fpath, byts, 0o644))
+
+	return byts
+}
+
+func TestRecovery(t *testing.T) {
+	for _, ca := range []struct {
+		name string
+		ext  string
+		// returns the content of the segment and its expected size after the recovery,
+		// that is -1 when the segment is expected to be removed.
+		write func(t *testing.T, fpath string) ([]byte, int)
+	}{
+		{
+			"fmp4, complete",
+			".mp4",
+			func(t *testing.T, fpath string) ([]byte, int) {
+				byts := writeSegment(t, fpath, 3)
+				return byts, len(byts)
+			},
+		},
+		{
+			"fmp4, incomplete part",
+			".mp4",
+			func(t *testing.T, fpath string) ([]byte, int) {
+				complete := writeSegment(t, fpath, 2)
+				byts := writeSegment(t, fpath, 3)
+				byts = byts[:len(byts)-10]
+				return byts, len(complete)
+			},
+		},
+		{
+			"fmp4, zero-filled tail",
+			".mp4",
+			func(t *testing.T, fpath string) ([]byte, int) {
+				complete := writeSegment(t, fpath, 2)
+				byts := append(append([]byte(nil), complete...), make([]byte, 4096)...)
+				return byts, len(complete)
+			},
+		},
+		{
+			"fmp4, no complete part",
+			".mp4",
+			func(t *testing.T, fpath string) ([]byte, int) {
+				byts := writeSegment(t, fpath, 1)
+				return byts[:len(byts)-1], -1
+			},
+		},
+		{
+			"fmp4, incomplete initialization section",
+			".mp4",
+			func(t *testing.T, fpath string) ([]byte, int) {
+				byts := writeSegment(t, fpath, 1)
+				return byts[:20], -1
+			},
+		},
+		{
+			"mpegts, complete",
+			".ts",
+			func(t *testing.T, fpath string) ([]byte, int) {
+				byts := writeMPEGTSSegment(t, fpath, 10)
+				return byts, len(byts)
+			},
+		},
+		{
+			"mpegts, incomplete packet",
+			".ts",
+			func(t *testing.T, fpath string) ([]byte, int) {
+				byts := writeMPEGTSSegment(t, fpath, 10)
+				return byts[:len(byts)-100], 9 * mpegtsPacketSize
+			},
+		},
+		{
+			"mpegts, zero-filled tail",
+			".ts",
+			func(t *testing.T, fpath string) ([]byte, int) {
+				byts := writeMPEGTSSegment(t, fpath, 10)
+				byts = append(byts, make([]byte, 3*mpegtsPacketSize)...)
+				return byts, 10 * mpegtsPacketSize
+			},
+		},
+		{
+			"mpegts, no complete packet",
+			".ts",
+			func(t *testing.T, fpath string) ([]byte, int) {
+				byts := writeMPEGTSSegment(t, fpath, 1)
+				return byts[:100], -1
+			},
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			recordPath := filepath.Join(t.TempDir(), "%path/%Y-%m-%d_%H-%M-%S-%f")
+			start := time.Date(2008, 5, 20, 22, 15, 25, 0, time.Local)
+
+			segmentPath := func(start time.Time) string {
+				return recordstore.Path{Path: "mypath", Start: start}.Encode(recordPath) + ca.ext
+			}
+
+			// previous segments are not touched
+			previous := segmentPath(start.Add(-time.Minute))
+			require.NoError(t, os.MkdirAll(filepath.Dir(previous), 0o755))
+			require.NoError(t, os.WriteFile(previous, []byte{1, 2, 3}, 0o644))
+
+			last := segmentPath(start)
+			byts, size := ca.write(t, last)
+			require.NoError(t, os.WriteFile(last, byts, 0o644))
+
+			r := &Recovery{
+				PathDefaults: &conf.Path{RecordPath: recordPath},
+				Parent:       nilLogger{},
+			}
+			r.Run()
+
+			buf, err := os.ReadFile(previous)
+			require.NoError(t, err)
+			require.Equal(t, []byte{1, 2, 3}, buf)
+
+			buf, err = os.ReadFile(last)
+			if size < 0 {
+				require.ErrorIs(t, err, os.ErrNotExist)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, byts[:size], buf)
+		})
+	}
+}
+
+func TestRecoveryTimeline(t *testing.T) {
+	recordPath := filepath.Join(t.TempDir(), "%path/%Y-%m-%d_%H-%M-%S-%f")
+
+	pathDefaults := &conf.Path{
+		RecordPath:   recordPath,
+		RecordFormat: "fmp4",
+	}
+
+	start := time.Date(2008, 5, 20, 22, 15, 25, 0, time.Local)
+
+	segmentPath := func(pathName string, start time.Time) string {
+		return recordstore.Path{Path: pathName, Start: start}.Encode(recordPath) + ".mp4"
+	}
+
+	// indexed segment
+	writeSegment(t, segmentPath("mypath", start), 3)
+	require.NoError(t, recordstore.UpdateTimeline(recordPath, "mypath", start, start.Add(3*time.Second)))
+
+	// segment that is missing from the timeline
+	writeSegment(t, segmentPath("mypath", start.Add(time.Minute)), 4)
+
+	// segment that doesn't exist anymore
+	require.NoError(t, recordstore.UpdateTimeline(recordPath, "mypath",
+		start.Add(-time.Minute), start.Add(-time.Minute+time.Second)))
+
+	// last segment, whose end has been saved before the crash and whose last part is incomplete
+	last := start.Add(2 * time.Minute)
+	byts := writeSegment(t, segmentPath("mypath", last), 5)
+	require.NoError(t, recordstore.UpdateTimeline(recordPath, "mypath", last, last.Add(2*time.Second)))
+	require.NoError(t, os.WriteFile(segmentPath("mypath", last), byts[:len(byts)-3], 0o644))
+
+	// path without timeline
+	writeSegment(t, segmentPath("other", start), 2)
+
+	// timeline of a path without segments
+	require.NoError(t, recordstore.UpdateTimeline(recordPath, "removed", start, start.Add(time.Second)))
+
+	r := &Recovery{
+		PathDefaults: pathDefaults,
+		Parent:       nilLogger{},
+	}
+	r.Run()
+
+	for _, ca := range []struct {
+		pathName string
+		entries  []*recordstore.TimelineEntry
+	}{
+		{
+			"mypath",
+			[]*recordstore.TimelineEntry{
+				{Start: start, End: start.Add(3 * time.Second)},
+				{Start: start.Add(time.Minute), End: start.Add(time.Minute + 4*time.Second)},
+				{Start: last, End: last.Add(4 * time.Second)},
+			},
+		},
+		{
+			"other",
+			[]*recordstore.TimelineEntry{
+				{Start: start, End: start.Add(2 * time.Second)},
+			},
+		},
+		{
+			"removed",
+			nil,
+		},
+	} {
+		t.Run(ca.pathName, func(t *testing.T) {
+			entries, err := recordstore.ReadTimeline(recordPath, ca.pathName)
+			require.NoError(t, err)
+			require.Len(t, entries, len(ca.entries))
+
+			for i, entry := range entries {
+				require.True(t, ca.entries[i].Start.Equal(entry.Start), "%v != %v", ca.entries[i].Start, entry.Start)
+				require.True(t, ca.entries[i].End.Equal(entry.End), "%v != %v", ca.entries[i].End, entry.End)
+			}
+		})
+	}
+}
+
+type testLogger struct {
+	messages []string
+}
+
+func (l *testLogger) Log(_ logger.Level, format string, args ...interface{}) {
+	l.messages = append(l.messages, fmt.Sprintf(format, args...))
+}
+
+// encryptSegment encrypts a segment in chunks of 64 bytes, and closes it when asked.
+func encryptSegment(t *testing.T, keys *recordcrypto.Keys, plain []byte, closed bool) []byte {
+	var buf bytes.Buffer
+
+	w := &recordcrypto.Writer{W: &buf, Keys: keys}
+	require.NoError(t, w.Initialize())
+
+	for len(plain) != 0 {
+		n := min(len(plain), 64)
+		_, err := w.Write(plain[:n])
+		require.NoError(t, err)
+		plain = plain[n:]
+	}
+
+	if closed {
+		require.NoError(t, w.Close())
+	}
+
+	return buf.Bytes()
+}
+
+func readEncryptedSegment(t *testing.T, keys *recordcrypto.Keys, fpath string) ([]byte, bool) {
+	f, err := os.Open(fpath)
+	require.NoError(t, err)
+	defer f.Close()
+
+	st, err := f.Stat()
+	require.NoError(t, err)
+
+	r := &recordcrypto.Reader{R: f, Size: st.Size(), Keys: keys}
+	require.NoError(t, r.Initialize())
+
+	plain, err := io.ReadAll(r)
+	require.NoError(t, err)
+
+	return plain, r.Complete() && r.ValidSize() == st.Size()
+}
+
+func TestRecoveryEncrypted(t *testing.T) {
+	dir := t.TempDir()
+	recordPath := filepath.Join(dir, "%path/%Y-%m-%d_%H-%M-%S-%f")
+
+	keyPath := filepath.Join(dir, "keys")
+	require.NoError(t, os.WriteFile(keyPath, []byte("000102030405060708090a0b0c0d0e0f\n"), 0o644))
+	keys, err := recordcrypto.LoadKeys(keyPath)
+	require.NoError(t, err)
+
+	start := time.Date(2008, 5, 20, 22, 15, 25, 0, time.Local)
+
+	segmentPath := func(start time.Time) string {
+		return recordstore.Path{Path: "mypath", Start: start}.Encode(recordPath) + ".mp4"
+	}
+
+	complete := writeSegment(t, segmentPath(start), 2)
+	plain := writeSegment(t, segmentPath(start), 3)
+
+	// segment that has been closed
+	closedPath := segmentPath(start)
+	closed := encryptSegment(t, keys, plain, true)
+	require.NoError(t, os.WriteFile(closedPath, closed, 0o644))
+
+	// segment that has been truncated after it was closed, at a chunk boundary
+	truncatedPath := segmentPath(start.Add(time.Minute))
+	truncated := encryptSegment(t, keys, plain, false)
+	require.NoError(t, os.WriteFile(truncatedPath, truncated, 0o644))
+
+	// last segment, whose writing has been interrupted inside the last part
+	lastPath := segmentPath(start.Add(2 * time.Minute))
+	last := encryptSegment(t, keys, plain, false)
+	require.NoError(t, os.WriteFile(lastPath, last[:len(last)-10], 0o644))
+
+	l := &testLogger{}
+
+	r := &Recovery{
+		PathDefaults: &conf.Path{
+			RecordPath:           recordPath,
+			RecordEncryptionKeys: keys,
+		},
+		Parent: l,
+	}
+	r.Run()
+
+	// segments that are not the last one are left untouched
+	buf, err := os.ReadFile(closedPath)
+	require.NoError(t, err)
+	require.Equal(t, closed, buf)
+
+	buf, err = os.ReadFile(truncatedPath)
+	require.NoError(t, err)
+	require.Equal(t, truncated, buf)
+
+	// the last segment is truncated to its last complete part, then closed
+	dec, ok := readEncryptedSegment(t, keys, lastPath)
+	require.True(t, ok)
+	require.Equal(t, complete, dec)
+
+	// truncations are told apart from interrupted writes
+	require.Contains(t, l.messages, fmt.Sprintf("[record recovery] %s has been truncated after it was closed, %d bytes are left",
+		truncatedPath, len(plain)))
+
+	interrupted := false
+	for _, msg := range l.messages {
+		if strings.HasPrefix(msg, "[record recovery] recovered "+lastPath+", whose writing was interrupted") {
+			interrupted = true
+		}
+		require.NotContains(t, msg, closedPath)
+	}
+	require.True(t, interrupted)
+}
+
+func TestMPEGTSPacketTimestamp(t *testing.T) {
+	for _, ca := range []struct {
+		name string
+		pkt  []byte
+		ts   int64
+		ok   bool
+	}{
+		{
+			"pts",
+			[]byte{
+				0x47, 0x41, 0x00, 0x10,
+				0x00, 0x00, 0x01, 0xe0, 0x00, 0x00, 0x80, 0x80, 0x05,
+				0x21, 0x00, 0x07, 0xd8, 0x61,
+			},
+			126000,
+			true,
+		},
+		{
+			"pts and dts",
+			[]byte{
+				0x47, 0x41, 0x00, 0x10,
+				0x00, 0x00, 0x01, 0xe0, 0x00, 0x00, 0x80, 0xc0, 0x0a,
+				0x31, 0x00, 0x07, 0xd8, 0x61,
+				0x11, 0x00, 0x05, 0xbf, 0x21,
+			},
+			90000,
+			true,
+		},
+		{
+			"adaptation field",
+			[]byte{
+				0x47, 0x41, 0x00, 0x30, 0x01, 0x00,
+				0x00, 0x00, 0x01, 0xe0, 0x00, 0x00, 0x80, 0x80, 0x05,
+				0x21, 0x00, 0x07, 0xd8, 0x61,
+			},
+			126000,
+			true,
+		},
+		{
+			"continuation",
+			[]byte{
+				0x47, 0x01, 0x00, 0x10,
+				0x00, 0x00, 0x01, 0xe0, 0x00, 0x00, 0x80, 0x80, 0x05,
+				0x21, 0x00, 0x07, 0xd8, 0x61,
+			},
+			0,
+			false,
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			pkt := make([]byte, mpegtsPacketSize)
+			copy(pkt, ca.pkt)
+
+			ts, pid, ok := mpegtsPacketTimestamp(pkt)
+			require.Equal(t, ca.ok, ok)
+			if ok {
+				require.Equal(t, ca.ts, ts)
+				require.Equal(t, uint16(0x100), pid)
+			}
+		})
+	}
+}
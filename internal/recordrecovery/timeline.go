@@ -0,0 +1,116 @@
+package recordrecovery
+
+import (
+	"XMedia/internal/logger"
+	"XMedia/internal/recordcrypto"
+	"XMedia/internal/recordstore"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// segmentDuration reads the duration of a segment.
+func segmentDuration(fpath string, keys *recordcrypto.Keys) (time.Duration, error) {
+	f, err := recordcrypto.Open(fpath, keys)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+
+	if filepath.Ext(fpath) == ".mp4" {
+		return fmp4Duration(f, size)
+	}
+	return mpegtsDuration(f, size)
+}
+
+// reconcileTimeline makes the timeline of a path consistent with its segments,
+// that must be sorted by start.
+// Entries of segments that don't exist anymore are removed, and segments that are missing
+// from the timeline, because the timeline is missing or stale, are added.
+// The end of the last segment, that is saved periodically and may have been truncated
+// by the recovery, is read from the segment.
+func (r *Recovery) reconcileTimeline(
+	recordPath string,
+	pathName string,
+	segments []*recordstore.Segment,
+	keys *recordcrypto.Keys,
+) {
+	entries, err := recordstore.ReadTimeline(recordPath, pathName)
+	if err != nil {
+		r.Log(logger.Warn, "unable to read timeline of path '%s', rebuilding it: %v", pathName, err)
+		entries = nil
+	}
+
+	// entries are matched with segments through the segment path,
+	// since the start encoded in the segment path may be less precise.
+	byPath := make(map[string]*recordstore.TimelineEntry, len(entries))
+	for _, entry := range entries {
+		byPath[filepath.Clean(recordstore.Path{Path: pathName, Start: entry.Start}.Encode(recordPath))] = entry
+	}
+
+	out := make([]*recordstore.TimelineEntry, 0, len(segments))
+	added := 0
+	changed := false
+
+	for i, seg := range segments {
+		entry, ok := byPath[filepath.Clean(strings.TrimSuffix(seg.Fpath, filepath.Ext(seg.Fpath)))]
+		if ok && i != len(segments)-1 {
+			out = append(out, entry)
+			continue
+		}
+
+		duration, err := segmentDuration(seg.Fpath, keys)
+		if err != nil {
+			r.Log(logger.Warn, "unable to read duration of %s: %v", seg.Fpath, err)
+			if ok {
+				out = append(out, entry)
+			}
+			continue
+		}
+
+		start := seg.Start
+		if ok {
+			start = entry.Start
+		}
+
+		newEntry := &recordstore.TimelineEntry{
+			Start: start,
+			End:   start.Add(duration),
+		}
+
+		if !ok {
+			added++
+			changed = true
+		} else if !newEntry.End.Equal(entry.End) {
+			changed = true
+		}
+
+		out = append(out, newEntry)
+	}
+
+	removed := len(entries) - (len(out) - added)
+	if removed != 0 {
+		changed = true
+	}
+
+	if !changed {
+		return
+	}
+
+	err = recordstore.ReplaceTimeline(recordPath, pathName, out)
+	if err != nil {
+		r.Log(logger.Error, "unable to write timeline of path '%s': %v", pathName, err)
+		return
+	}
+
+	if added != 0 || removed != 0 {
+		r.Log(logger.Info, "timeline of path '%s' reconciled: %d segments added, %d removed",
+			pathName, added, removed)
+	}
+}
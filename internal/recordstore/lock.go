@@ -0,0 +1,34 @@
+package recordstore
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+)
+
+// LockExt is the extension of lock files.
+// A lock file is placed next to a segment and prevents its deletion.
+const LockExt = ".lock"
+
+// IsLocked returns whether a segment is locked.
+func IsLocked(fpath string) bool {
+	_, err := os.Stat(fpath + LockExt)
+	return err == nil
+}
+
+// SetLocked locks or unlocks a segment.
+func SetLocked(fpath string, locked bool) error {
+	if !locked {
+		err := os.Remove(fpath + LockExt)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	f, err := os.OpenFile(fpath+LockExt, os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
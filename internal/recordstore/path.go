@@ -0,0 +1,167 @@
+// Package recordstore contains utilities to store and find recordings.
+package recordstore
+
+import (
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// tokens that can be used inside the record path.
+var pathTokens = []string{"%path", "%Y", "%m", "%d", "%H", "%M", "%S", "%f", "%s"}
+
+func leadingZeros(v int, size int) string {
+	out := strconv.FormatInt(int64(v), 10)
+	if len(out) >= size {
+		return out
+	}
+	return strings.Repeat("0", size-len(out)) + out
+}
+
+// CommonPath returns the directory that contains all the recordings of a record path,
+// that is, the part of the path that precedes the first token.
+func CommonPath(format string) string {
+	if i := strings.Index(format, "%"); i >= 0 {
+		format = format[:i]
+	}
+	return filepath.Dir(format + "_")
+}
+
+// HasTimeTokens checks whether a record path contains enough tokens to encode the start time.
+func HasTimeTokens(format string) bool {
+	if strings.Contains(format, "%s") {
+		return true
+	}
+
+	for _, tok := range []string{"%Y", "%m", "%d", "%H", "%M", "%S"} {
+		if !strings.Contains(format, tok) {
+			return false
+		}
+	}
+	return true
+}
+
+// Path is the path of a recording segment.
+type Path struct {
+	// name of the path that has been recorded.
+	Path string
+	// start time of the segment.
+	Start time.Time
+}
+
+// Encode encodes a Path into a file path, by replacing the tokens of format.
+func (p Path) Encode(format string) string {
+	start := p.Start.Local()
+
+	format = strings.ReplaceAll(format, "%Y", leadingZeros(start.Year(), 4))
+	format = strings.ReplaceAll(format, "%m", leadingZeros(int(start.Month()), 2))
+	format = strings.ReplaceAll(format, "%d", leadingZeros(start.Day(), 2))
+	format = strings.ReplaceAll(format, "%H", leadingZeros(start.Hour(), 2))
+	format = strings.ReplaceAll(format, "%M", leadingZeros(start.Minute(), 2))
+	format = strings.ReplaceAll(format, "%S", leadingZeros(start.Second(), 2))
+	format = strings.ReplaceAll(format, "%f", leadingZeros(start.Nanosecond()/1000, 6))
+	format = strings.ReplaceAll(format, "%s", strconv.FormatInt(start.Unix(), 10))
+	format = strings.ReplaceAll(format, "%path", p.Path)
+
+	return format
+}
+
+func pathRegexp(format string) (*regexp.Regexp, []string) {
+	var tokens []string
+	re := "^"
+
+	for len(format) != 0 {
+		found := ""
+		for _, tok := range pathTokens {
+			if strings.HasPrefix(format, tok) {
+				found = tok
+				break
+			}
+		}
+
+		if found == "" {
+			re += regexp.QuoteMeta(format[:1])
+			format = format[1:]
+			continue
+		}
+
+		tokens = append(tokens, found)
+		format = format[len(found):]
+
+		switch found {
+		case "%path":
+			re += "(.+?)"
+		case "%Y":
+			re += "([0-9]{4})"
+		case "%f":
+			re += "([0-9]{6})"
+		case "%s":
+			re += "([0-9]{1,20})"
+		default:
+			re += "([0-9]{2})"
+		}
+	}
+
+	return regexp.MustCompile(re + "$"), tokens
+}
+
+// Decode decodes a file path produced by Encode with the same format.
+// It returns false when the file path doesn't match the format.
+func (p *Path) Decode(format string, v string) bool {
+	re, tokens := pathRegexp(filepath.ToSlash(filepath.Clean(format)))
+
+	m := re.FindStringSubmatch(filepath.ToSlash(filepath.Clean(v)))
+	if m == nil {
+		return false
+	}
+
+	p.Path = ""
+	year, month, day, hour, minute, second, micro := 0, 1, 1, 0, 0, 0, 0
+	var unix *int64
+
+	for i, tok := range tokens {
+		val := m[i+1]
+
+		if tok == "%path" {
+			if p.Path != "" && p.Path != val {
+				return false
+			}
+			p.Path = val
+			continue
+		}
+
+		n, err := strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			return false
+		}
+
+		switch tok {
+		case "%Y":
+			year = int(n)
+		case "%m":
+			month = int(n)
+		case "%d":
+			day = int(n)
+		case "%H":
+			hour = int(n)
+		case "%M":
+			minute = int(n)
+		case "%S":
+			second = int(n)
+		case "%f":
+			micro = int(n)
+		case "%s":
+			unix = &n
+		}
+	}
+
+	if unix != nil {
+		p.Start = time.Unix(*unix, int64(micro)*1000)
+	} else {
+		p.Start = time.Date(year, time.Month(month), day, hour, minute, second, micro*1000, time.Local)
+	}
+
+	return true
+}
@@ -0,0 +1,89 @@
+package recordstore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPathEncodeDecode(t *testing.T) {
+	start := time.Date(2008, 5, 20, 22, 15, 25, 427000000, time.Local)
+
+	for _, ca := range []struct {
+		name     string
+		format   string
+		pathName string
+		start    time.Time
+	}{
+		{
+			"default",
+			"./recordings/%path/%Y-%m-%d_%H-%M-%S-%f",
+			"mypath",
+			start,
+		},
+		{
+			"nested path",
+			"./recordings/%path/%Y-%m-%d_%H-%M-%S-%f",
+			"my/nested/path",
+			start,
+		},
+		{
+			"path after time",
+			"/data/%Y/%m/%d/%path/%H-%M-%S-%f",
+			"cam1",
+			start,
+		},
+		{
+			"unix time",
+			"recordings/%path/%s",
+			"mypath",
+			start.Truncate(time.Second),
+		},
+		{
+			"repeated path",
+			"recordings/%path/%path_%Y%m%d%H%M%S%f",
+			"cam",
+			start,
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			enc := Path{Path: ca.pathName, Start: ca.start}.Encode(ca.format)
+
+			var dec Path
+			ok := dec.Decode(ca.format, enc)
+			require.True(t, ok)
+			require.Equal(t, ca.pathName, dec.Path)
+			require.True(t, ca.start.Equal(dec.Start), "%v != %v", ca.start, dec.Start)
+		})
+	}
+}
+
+func TestPathDecodeMismatch(t *testing.T) {
+	for _, ca := range []struct {
+		name   string
+		format string
+		v      string
+	}{
+		{
+			"wrong directory",
+			"./recordings/%path/%Y-%m-%d_%H-%M-%S-%f",
+			"./other/mypath/2008-05-20_22-15-25-427000",
+		},
+		{
+			"missing time",
+			"./recordings/%path/%Y-%m-%d_%H-%M-%S-%f",
+			"./recordings/mypath/2008-05-20",
+		},
+		{
+			"different repeated path",
+			"recordings/%path/%path_%Y%m%d%H%M%S%f",
+			"recordings/cam1/cam2_20080520221525427000",
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			var dec Path
+			require.False(t, dec.Decode(ca.format, ca.v))
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package recordstore
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrNoSegmentsFound is returned when a path has no recording segments.
+var ErrNoSegmentsFound = errors.New("recording segments not found")
+
+// ErrSegmentNotFound is returned when a segment doesn't exist.
+var ErrSegmentNotFound = errors.New("recording segment not found")
+
+// Segment is a recording segment.
+type Segment struct {
+	Fpath string
+	Path
+}
+
+// FindSegments returns the segments of a path with the given extension, sorted by start time.
+func FindSegments(recordPath string, pathName string, ext string) ([]*Segment, error) {
+	recordPath = strings.ReplaceAll(recordPath, "%path", pathName)
+	commonPath := CommonPath(recordPath)
+
+	var segments []*Segment
+
+	err := filepath.WalkDir(commonPath, func(fpath string, info fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() || filepath.Ext(fpath) != ext {
+			return nil
+		}
+
+		var pa Path
+		if pa.Decode(recordPath, strings.TrimSuffix(fpath, ext)) {
+			pa.Path = pathName
+			segments = append(segments, &Segment{
+				Fpath: fpath,
+				Path:  pa,
+			})
+		}
+
+		return nil
+	})
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	if segments == nil {
+		return nil, ErrNoSegmentsFound
+	}
+
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].Start.Before(segments[j].Start)
+	})
+
+	return segments, nil
+}
+
+// FindSegment returns the segment of a path that starts at the given time.
+func FindSegment(recordPath string, pathName string, ext string, start time.Time) (*Segment, error) {
+	segments, err := FindSegments(recordPath, pathName, ext)
+	if err != nil {
+		if errors.Is(err, ErrNoSegmentsFound) {
+			return nil, ErrSegmentNotFound
+		}
+		return nil, err
+	}
+
+	for _, seg := range segments {
+		if seg.Start.Equal(start) {
+			return seg, nil
+		}
+	}
+
+	return nil, ErrSegmentNotFound
+}
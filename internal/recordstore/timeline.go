@@ -0,0 +1,244 @@
+package recordstore
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+The timeline of a path is an index of the time ranges of its segments,
+that allows to know what has been recorded without reading segments.
+It is stored in a file inside the directory of recordings, that contains
+a line for every update of a segment:
+
+	start end
+
+where start and end are Unix timestamps in nanoseconds.
+A line replaces previous lines with the same start.
+The file is compacted when segments are removed.
+*/
+
+const timelinePrefix = ".timeline-"
+
+// the timeline is written by recorders and the cleaner, that run in the same process.
+var timelineMutex sync.Mutex
+
+// TimelineEntry is the time range of a segment.
+type TimelineEntry struct {
+	Start time.Time
+	End   time.Time
+}
+
+// timelinePath returns the path of the timeline of a path.
+// The path name is escaped, in order to obtain a distinct file name for every path.
+func timelinePath(recordPath string, pathName string) string {
+	recordPath = strings.ReplaceAll(recordPath, "%path", pathName)
+	return filepath.Join(CommonPath(recordPath), timelinePrefix+url.PathEscape(pathName))
+}
+
+// TimelinePathName returns the name of the path of a timeline file,
+// after checking that the file belongs to a record path.
+func TimelinePathName(recordPath string, fpath string) (string, bool) {
+	name := filepath.Base(fpath)
+	if !strings.HasPrefix(name, timelinePrefix) {
+		return "", false
+	}
+
+	pathName, err := url.PathUnescape(name[len(timelinePrefix):])
+	if err != nil || pathName == "" {
+		return "", false
+	}
+
+	if filepath.Clean(timelinePath(recordPath, pathName)) != filepath.Clean(fpath) {
+		return "", false
+	}
+
+	return pathName, true
+}
+
+func readTimelineFile(fpath string) (map[int64]int64, int, error) {
+	f, err := os.Open(fpath)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	entries := make(map[int64]int64)
+	lines := 0
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue // line truncated by a crash
+		}
+
+		start, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		end, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil || end < start {
+			continue
+		}
+
+		entries[start] = end
+		lines++
+	}
+
+	return entries, lines, scanner.Err()
+}
+
+func writeTimelineFile(fpath string, entries map[int64]int64) error {
+	starts := make([]int64, 0, len(entries))
+	for start := range entries {
+		starts = append(starts, start)
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i] < starts[j] })
+
+	var b strings.Builder
+	for _, start := range starts {
+		fmt.Fprintf(&b, "%d %d\n", start, entries[start])
+	}
+
+	tmpPath := fpath + ".tmp"
+
+	err := os.WriteFile(tmpPath, []byte(b.String()), 0o644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, fpath)
+}
+
+// ReadTimeline returns the timeline of a path, sorted by start.
+// It returns an empty timeline when the path has never been recorded.
+func ReadTimeline(recordPath string, pathName string) ([]*TimelineEntry, error) {
+	timelineMutex.Lock()
+	entries, _, err := readTimelineFile(timelinePath(recordPath, pathName))
+	timelineMutex.Unlock()
+
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	out := make([]*TimelineEntry, 0, len(entries))
+	for start, end := range entries {
+		out = append(out, &TimelineEntry{
+			Start: time.Unix(0, start),
+			End:   time.Unix(0, end),
+		})
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Start.Before(out[j].Start)
+	})
+
+	return out, nil
+}
+
+// UpdateTimeline sets the time range of a segment in the timeline of a path.
+func UpdateTimeline(recordPath string, pathName string, start time.Time, end time.Time) error {
+	fpath := timelinePath(recordPath, pathName)
+
+	timelineMutex.Lock()
+	defer timelineMutex.Unlock()
+
+	err := os.MkdirAll(filepath.Dir(fpath), 0o755)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(fpath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(f, "%d %d\n", start.UnixNano(), end.UnixNano())
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// ReplaceTimeline replaces the timeline of a path.
+// The timeline is removed when there are no entries.
+func ReplaceTimeline(recordPath string, pathName string, entries []*TimelineEntry) error {
+	fpath := timelinePath(recordPath, pathName)
+
+	timelineMutex.Lock()
+	defer timelineMutex.Unlock()
+
+	if len(entries) == 0 {
+		err := os.Remove(fpath)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	m := make(map[int64]int64, len(entries))
+	for _, entry := range entries {
+		m[entry.Start.UnixNano()] = entry.End.UnixNano()
+	}
+
+	err := os.MkdirAll(filepath.Dir(fpath), 0o755)
+	if err != nil {
+		return err
+	}
+
+	return writeTimelineFile(fpath, m)
+}
+
+// PruneTimeline removes from the timeline of a path the segments that started before a given time
+// and don't exist anymore, then compacts the timeline.
+func PruneTimeline(recordPath string, pathName string, before time.Time, exists func(start time.Time) bool) error {
+	fpath := timelinePath(recordPath, pathName)
+
+	timelineMutex.Lock()
+	defer timelineMutex.Unlock()
+
+	entries, lines, err := readTimelineFile(fpath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	changed := lines != len(entries)
+
+	for start := range entries {
+		t := time.Unix(0, start)
+		if t.Before(before) && !exists(t) {
+			delete(entries, start)
+			changed = true
+		}
+	}
+
+	if !changed {
+		return nil
+	}
+
+	if len(entries) == 0 {
+		return os.Remove(fpath)
+	}
+
+	return writeTimelineFile(fpath, entries)
+}
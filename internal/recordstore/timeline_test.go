@@ -0,0 +1,55 @@
+package recordstore
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimelinePathDistinct(t *testing.T) {
+	recordPath := "/recordings/%Y-%m-%d_%H-%M-%S-%f_%path"
+
+	seen := make(map[string]string)
+
+	for _, pathName := range []string{"a/b", "a_b", "a%2Fb", "a-b", "a.b"} {
+		fpath := timelinePath(recordPath, pathName)
+		require.Equal(t, "/recordings", filepath.Dir(fpath))
+
+		other, ok := seen[fpath]
+		require.False(t, ok, "paths '%s' and '%s' share the same timeline", pathName, other)
+		seen[fpath] = pathName
+	}
+}
+
+func TestTimelineUpdatePrune(t *testing.T) {
+	recordPath := filepath.Join(t.TempDir(), "%path/%Y-%m-%d_%H-%M-%S-%f")
+	start := time.Date(2008, 5, 20, 22, 15, 25, 0, time.UTC)
+
+	for _, pathName := range []string{"a/b", "a_b"} {
+		require.NoError(t, UpdateTimeline(recordPath, pathName, start, start.Add(time.Second)))
+		require.NoError(t, UpdateTimeline(recordPath, pathName, start, start.Add(2*time.Second)))
+	}
+	require.NoError(t, UpdateTimeline(recordPath, "a/b", start.Add(time.Minute), start.Add(2*time.Minute)))
+
+	entries, err := ReadTimeline(recordPath, "a/b")
+	require.NoError(t, err)
+	require.Equal(t, []*TimelineEntry{
+		{Start: time.Unix(0, start.UnixNano()), End: time.Unix(0, start.Add(2*time.Second).UnixNano())},
+		{Start: time.Unix(0, start.Add(time.Minute).UnixNano()), End: time.Unix(0, start.Add(2*time.Minute).UnixNano())},
+	}, entries)
+
+	err = PruneTimeline(recordPath, "a/b", start.Add(time.Hour), func(s time.Time) bool {
+		return !s.Equal(start)
+	})
+	require.NoError(t, err)
+
+	entries, err = ReadTimeline(recordPath, "a/b")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	entries, err = ReadTimeline(recordPath, "a_b")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+}
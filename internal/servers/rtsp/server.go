@@ -213,3 +213,15 @@ func (s *Server) OnRecord(ctx *gortsplib.ServerHandlerOnRecordCtx) (*base.Respon
 	se := ctx.Session.UserData().(*session)
 	return se.onRecord(ctx)
 }
+
+// OnPacketsLost implements gortsplib.ServerHandlerOnPacketsLost.
+func (s *Server) OnPacketsLost(ctx *gortsplib.ServerHandlerOnPacketsLostCtx) {
+	se := ctx.Session.UserData().(*session)
+	se.onPacketsLost(ctx)
+}
+
+// OnDecodeError implements gortsplib.ServerHandlerOnDecodeError.
+func (s *Server) OnDecodeError(ctx *gortsplib.ServerHandlerOnDecodeErrorCtx) {
+	se := ctx.Session.UserData().(*session)
+	se.onDecodeError(ctx)
+}
@@ -14,7 +14,10 @@ import (
 
 	"github.com/bluenviron/gortsplib/v4"
 	"github.com/bluenviron/gortsplib/v4/pkg/base"
+	"github.com/bluenviron/gortsplib/v4/pkg/description"
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
 	"github.com/google/uuid"
+	"github.com/pion/rtcp"
 )
 
 type session struct {
@@ -37,6 +40,10 @@ type session struct {
 	transport *gortsplib.Transport
 	pathName  string
 	query     string
+
+	announcedSDP  []byte
+	packetsLost   map[format.Format]uint64
+	toStreamClose func()
 }
 
 func (s *session) initialize() {
@@ -58,16 +65,21 @@ func (s *session) onClose(err error) {
 	// 	s.onUnreadHook()
 	// }
 
-	// switch s.rsession.State() {
+	switch s.rsession.State() {
 	// case gortsplib.ServerSessionStatePrePlay, gortsplib.ServerSessionStatePlay:
 	// 	s.path.RemoveReader(defs.PathRemoveReaderReq{Author: s})
 
-	// case gortsplib.ServerSessionStatePreRecord, gortsplib.ServerSessionStateRecord:
-	// 	s.path.RemovePublisher(defs.PathRemovePublisherReq{Author: s})
-	// }
+	case gortsplib.ServerSessionStatePreRecord, gortsplib.ServerSessionStateRecord:
+		if s.toStreamClose != nil {
+			s.toStreamClose()
+		}
+		s.path.RemovePublisher(defs.PathRemovePublisherReq{Author: s})
+	}
 
-	// s.path = nil
-	// s.stream = nil
+	s.mutex.Lock()
+	s.path = nil
+	s.stream = nil
+	s.mutex.Unlock()
 
 	s.Log(logger.Info, "destroyed: %v", err)
 }
@@ -105,9 +117,10 @@ func (s *session) onAnnounce(c *conn, ctx *gortsplib.ServerHandlerOnAnnounceCtx)
 		}, err
 	}
 
-	s.path = path
+	s.announcedSDP = ctx.Request.Body
 
 	s.mutex.Lock()
+	s.path = path
 	s.state = gortsplib.ServerSessionStatePreRecord
 	s.pathName = ctx.Path
 	s.query = ctx.Query
@@ -164,12 +177,15 @@ func (s *session) onRecord(ctx *gortsplib.ServerHandlerOnRecordCtx) (*base.Respo
 			StatusCode: base.StatusBadRequest,
 		}, err
 	}
+	s.mutex.Lock()
 	s.stream = stream
+	s.mutex.Unlock()
 
-	rtsp.ToStream(
+	s.toStreamClose = rtsp.ToStream(
 		s.rsession,
 		s.rsession.AnnouncedDescription().Medias,
-		&conf.Path{},
+		rtsp.AbsCaptureTimeIDs(s.announcedSDP, s.rsession.AnnouncedDescription()),
+		s.path.SafeConf(),
 		stream,
 		s)
 
@@ -183,6 +199,76 @@ func (s *session) onRecord(ctx *gortsplib.ServerHandlerOnRecordCtx) (*base.Respo
 	}, nil
 }
 
+// onPacketsLost is called by rtspServer.
+func (s *session) onPacketsLost(ctx *gortsplib.ServerHandlerOnPacketsLostCtx) {
+	// stream and path are set and cleared by other callbacks.
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.stream == nil {
+		return
+	}
+
+	// losses are already accounted by the reorder buffer, that sees the same gaps.
+	if s.path.SafeConf().RtpReorderBufferSize != 0 {
+		return
+	}
+
+	// the callback doesn't tell the track, find it by comparing statistics.
+	stats := ctx.Session.Stats()
+
+	if s.packetsLost == nil {
+		s.packetsLost = make(map[format.Format]uint64)
+	}
+
+	for medi, ms := range stats.Medias {
+		for forma, fs := range ms.Formats {
+			if fs.RTPPacketsLost > s.packetsLost[forma] {
+				s.stream.AddPacketsLost(medi, forma, fs.RTPPacketsLost-s.packetsLost[forma])
+				s.packetsLost[forma] = fs.RTPPacketsLost
+			}
+		}
+	}
+}
+
+// onDecodeError is called by rtspServer.
+func (s *session) onDecodeError(_ *gortsplib.ServerHandlerOnDecodeErrorCtx) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.stream == nil {
+		return
+	}
+
+	s.stream.AddDecodeError()
+}
+
+// RequestKeyframe implements defs.KeyframeRequester.
+func (s *session) RequestKeyframe(medi *description.Media) {
+	ms, ok := s.rsession.Stats().Medias[medi]
+	if !ok {
+		return
+	}
+
+	for _, fs := range ms.Formats {
+		// SSRC of the publisher is not known until the first packet has been received
+		if fs.RemoteSSRC == 0 {
+			continue
+		}
+
+		err := s.rsession.WritePacketRTCP(medi, &rtcp.PictureLossIndication{
+			SenderSSRC: fs.LocalSSRC,
+			MediaSSRC:  fs.RemoteSSRC,
+		})
+		if err != nil {
+			s.Log(logger.Warn, "unable to send keyframe request: %v", err)
+			return
+		}
+	}
+
+	s.Log(logger.Info, "keyframe request sent (%s)", medi.Type)
+}
+
 // APIReaderDescribe implements reader.
 func (s *session) APIReaderDescribe() defs.APIPathSourceOrReader {
 	return defs.APIPathSourceOrReader{
@@ -202,4 +288,6 @@ func (s *session) APISourceDescribe() defs.APIPathSourceOrReader {
 }
 
 // Close closes a Session.
-func (s *session) Close() {}
+func (s *session) Close() {
+	s.rsession.Close()
+}
@@ -0,0 +1,167 @@
+package stream
+
+import (
+	"XMedia/internal/logger"
+	"XMedia/internal/unit"
+	"sync"
+	"time"
+
+	mch265 "github.com/bluenviron/mediacommon/v2/pkg/codecs/h265"
+)
+
+// whether a unit can be decoded without previous ones.
+// The second return value is false when the unit doesn't belong to a video codec with keyframes.
+func isRandomAccess(u unit.Unit) (bool, bool) {
+	switch u := u.(type) {
+	case *unit.H264:
+		return u.RandomAccess, true
+
+	case *unit.H265:
+		return u.AU != nil && mch265.IsRandomAccess(u.AU), true
+
+	default:
+		return false, false
+	}
+}
+
+type gopCacheEntry struct {
+	sf   *streamFormat
+	u    unit.Unit
+	size uint64
+}
+
+// shift the timestamps of a unit back by d, expressed in the clock rate of its track.
+// Units are shared among readers, therefore a copy is returned.
+// RTP packets are not modified, since they are routed to RTSP readers only.
+func shiftUnit(u unit.Unit, d int64) unit.Unit {
+	switch u := u.(type) {
+	case *unit.H264:
+		c := *u
+		c.PTS -= d
+		c.DTS -= d
+		return &c
+
+	case *unit.H265:
+		c := *u
+		c.PTS -= d
+		return &c
+
+	case *unit.MJPEG:
+		c := *u
+		c.PTS -= d
+		return &c
+
+	case *unit.MPEG4Video:
+		c := *u
+		c.PTS -= d
+		return &c
+
+	case *unit.MPEG4Audio:
+		c := *u
+		c.PTS -= d
+		return &c
+
+	case *unit.MPEG4AudioLATM:
+		c := *u
+		c.PTS -= d
+		return &c
+
+	case *unit.Opus:
+		c := *u
+		c.PTS -= d
+		return &c
+
+	case *unit.G711:
+		c := *u
+		c.PTS -= d
+		return &c
+
+	case *unit.KLV:
+		c := *u
+		c.PTS -= d
+		return &c
+
+	default:
+		return u
+	}
+}
+
+// returns a ReadFunc that shifts the timestamps of units back by d.
+func shiftedReadFunc(cb ReadFunc, d int64) ReadFunc {
+	return func(u unit.Unit) error {
+		return cb(shiftUnit(u, d))
+	}
+}
+
+// gopCache keeps the units received since the last keyframe,
+// in order to replay them to readers that join the stream.
+// Timestamps of readers that receive the cache are rewritten, in order to start
+// from zero at the cached keyframe. The same offset is applied to the live units
+// that follow, in all tracks, therefore timestamps stay continuous and consistent.
+// NTP timestamps are not rewritten, since they are absolute.
+// The cache is replayed to non-RTSP readers only: RTSP readers are not served
+// by the stream (rtspStream is never set).
+type gopCache struct {
+	maxBytes    uint64
+	maxDuration time.Duration
+	parent      logger.Writer
+
+	mutex     sync.Mutex
+	keyFormat *streamFormat
+	active    bool
+	start     time.Time
+	bytes     uint64
+	entries   []gopCacheEntry
+	overflown bool
+}
+
+func (c *gopCache) push(sf *streamFormat, u unit.Unit, size uint64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	ra, isVideo := isRandomAccess(u)
+
+	// the first video track with keyframes drives the cache
+	if c.keyFormat == nil && isVideo {
+		c.keyFormat = sf
+	}
+
+	if sf == c.keyFormat && ra {
+		c.active = true
+		c.start = time.Now()
+		c.bytes = 0
+
+		// release units of the previous GOP, that would be retained by the backing array
+		clear(c.entries)
+		c.entries = c.entries[:0]
+	}
+
+	if !c.active {
+		return
+	}
+
+	if (c.maxBytes != 0 && (c.bytes+size) > c.maxBytes) ||
+		(c.maxDuration != 0 && time.Since(c.start) > c.maxDuration) {
+		if !c.overflown {
+			c.overflown = true
+			c.parent.Log(logger.Warn, "GOP exceeds the limits of the GOP cache, "+
+				"new readers will wait for the next keyframe")
+		}
+
+		c.active = false
+		c.bytes = 0
+		c.entries = nil
+		return
+	}
+
+	c.bytes += size
+	c.entries = append(c.entries, gopCacheEntry{sf: sf, u: u, size: size})
+}
+
+// returns the cached units, starting from the last keyframe.
+func (c *gopCache) units() []gopCacheEntry {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return append([]gopCacheEntry(nil), c.entries...)
+}
@@ -0,0 +1,114 @@
+package stream
+
+import (
+	"XMedia/internal/logger"
+	"XMedia/internal/unit"
+	"sync"
+	"time"
+)
+
+type preRollEntry struct {
+	sf       *streamFormat
+	u        unit.Unit
+	size     uint64
+	received time.Time
+	// whether the unit is a keyframe of the track that drives the buffer.
+	keyframe bool
+}
+
+// preRollBuffer keeps the units received in the last part of the stream,
+// in order to replay them to readers that need what happened before they joined, like event recorders.
+// When there's a video track, the buffer starts with a keyframe and covers at least the given duration.
+type preRollBuffer struct {
+	duration time.Duration
+	parent   logger.Writer
+
+	mutex     sync.Mutex
+	keyFormat *streamFormat
+	entries   []preRollEntry
+	overflown bool
+}
+
+func (b *preRollBuffer) push(sf *streamFormat, u unit.Unit, size uint64) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	ra, isVideo := isRandomAccess(u)
+
+	// the first video track with keyframes drives the buffer
+	if b.keyFormat == nil && isVideo {
+		b.keyFormat = sf
+	}
+
+	now := time.Now()
+
+	// video units can't be decoded before the first keyframe
+	if b.keyFormat != nil && len(b.entries) == 0 && (sf != b.keyFormat || !ra) {
+		return
+	}
+
+	b.entries = append(b.entries, preRollEntry{
+		sf:       sf,
+		u:        u,
+		size:     size,
+		received: now,
+		keyframe: sf == b.keyFormat && ra,
+	})
+
+	b.trim(now)
+}
+
+// trim removes the units that are not needed to cover the duration of the buffer.
+func (b *preRollBuffer) trim(now time.Time) {
+	limit := now.Add(-b.duration)
+
+	if b.keyFormat == nil {
+		i := 0
+		for i < len(b.entries) && b.entries[i].received.Before(limit) {
+			i++
+		}
+		b.entries = b.entries[i:]
+		return
+	}
+
+	// start from the most recent keyframe that is older than the duration
+	start := 0
+	for i, e := range b.entries {
+		if e.received.After(limit) {
+			break
+		}
+		if e.keyframe {
+			start = i
+		}
+	}
+
+	// keyframes are too far apart, drop units regardless of them
+	// in order to bound memory usage.
+	if b.entries[start].received.Before(now.Add(-2 * b.duration)) {
+		if !b.overflown {
+			b.overflown = true
+			b.parent.Log(logger.Warn, "the keyframe interval is longer than the pre-roll duration, "+
+				"pre-roll will be shorter than configured")
+		}
+
+		for start < len(b.entries) && (b.entries[start].received.Before(limit) || !b.entries[start].keyframe) {
+			start++
+		}
+	}
+
+	if start != 0 {
+		n := copy(b.entries, b.entries[start:])
+
+		// release removed units, that would be retained by the backing array
+		clear(b.entries[n:])
+		b.entries = b.entries[:n]
+	}
+}
+
+// returns the buffered units.
+func (b *preRollBuffer) units() []preRollEntry {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return append([]preRollEntry(nil), b.entries...)
+}
@@ -1,8 +1,16 @@
 package stream
 
 import (
+	"XMedia/internal/asyncwriter"
+	"XMedia/internal/counterdumper"
+	"XMedia/internal/formatprocessor"
 	"XMedia/internal/logger"
+	"XMedia/internal/unit"
+	"bytes"
+	"errors"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/bluenviron/gortsplib/v4"
@@ -11,6 +19,15 @@ import (
 	"github.com/pion/rtp"
 )
 
+// ErrSEIInjectionNotSupported is returned when a stream has no track that supports SEI injection.
+var ErrSEIInjectionNotSupported = errors.New("stream has no H264 or H265 track")
+
+// ErrNoTelemetry is returned when a stream has no KLV track or no telemetry has been received yet.
+var ErrNoTelemetry = errors.New("no KLV telemetry received")
+
+// ReadFunc is the callback passed to AddReader().
+type ReadFunc func(unit.Unit) error
+
 // Stream is a media stream.
 // It stores tracks, readers and allows to write data to readers, converting it when needed.
 type Stream struct {
@@ -18,15 +35,37 @@ type Stream struct {
 	UDPMaxPayloadSize  int
 	Desc               *description.Session
 	GenerateRTPPackets bool
-	Parent             logger.Writer
+	WaitForParameters  bool
+	// keep the stream not ready until every video track has produced a keyframe
+	// with known parameters and every other track has produced a frame.
+	WaitForKeyframe  bool
+	ProcessorOptions formatprocessor.Options
+	// maximum size and duration of the GOP cache. Both zero disable the cache.
+	GOPCacheMaxBytes    uint64
+	GOPCacheMaxDuration time.Duration
+	// duration of the pre-roll buffer, that is replayed by StartReaderFromPreRoll(). Zero disables the buffer.
+	PreRollDuration time.Duration
+	// called when a keyframe of a media is needed, at most once every KeyframeRequestInterval.
+	// It is called without holding the lock of the stream.
+	OnKeyframeRequest       func(medi *description.Media)
+	KeyframeRequestInterval time.Duration
+	Parent                  logger.Writer
 
 	bytesReceived *uint64
 	bytesSent     *uint64
 	streamMedias  map[*description.Media]*streamMedia
 	mutex         sync.RWMutex
 	rtspStream    *gortsplib.ServerStream
+	decodeErrors  *counterdumper.CounterDumper
+	gopCache      *gopCache
+	preRoll       *preRollBuffer
+
+	keyframeRequestMutex sync.Mutex
+	lastKeyframeRequest  map[*description.Media]time.Time
 
 	readerRunning chan struct{}
+	ready         chan struct{}
+	isReady       uint32
 }
 
 func (s *Stream) Initialize() error {
@@ -34,12 +73,44 @@ func (s *Stream) Initialize() error {
 	s.bytesSent = new(uint64)
 	s.streamMedias = make(map[*description.Media]*streamMedia)
 	s.readerRunning = make(chan struct{})
+	s.ready = make(chan struct{})
+	s.lastKeyframeRequest = make(map[*description.Media]time.Time)
+
+	s.decodeErrors = &counterdumper.CounterDumper{
+		OnReport: func(val uint64) {
+			s.Parent.Log(logger.Warn, "%d decode %s",
+				val,
+				func() string {
+					if val == 1 {
+						return "error"
+					}
+					return "errors"
+				}())
+		},
+	}
+	s.decodeErrors.Start()
+
+	if s.GOPCacheMaxBytes != 0 || s.GOPCacheMaxDuration != 0 {
+		s.gopCache = &gopCache{
+			maxBytes:    s.GOPCacheMaxBytes,
+			maxDuration: s.GOPCacheMaxDuration,
+			parent:      s.Parent,
+		}
+	}
+
+	if s.PreRollDuration != 0 {
+		s.preRoll = &preRollBuffer{
+			duration: s.PreRollDuration,
+			parent:   s.Parent,
+		}
+	}
 
 	for _, media := range s.Desc.Medias {
 		s.streamMedias[media] = &streamMedia{
 			udpMaxPayloadSize:  s.UDPMaxPayloadSize,
 			media:              media,
 			generateRTPPackets: s.GenerateRTPPackets,
+			options:            s.ProcessorOptions,
 			parent:             s.Parent,
 		}
 		err := s.streamMedias[media].initialize()
@@ -48,9 +119,48 @@ func (s *Stream) Initialize() error {
 		}
 	}
 
+	s.checkReady()
+
 	return nil
 }
 
+// Ready returns a channel that is closed when the stream can be read.
+// If WaitForParameters is true, this happens when parameters of all tracks are known.
+// If WaitForKeyframe is true, this happens when all tracks have produced a decodable frame.
+func (s *Stream) Ready() <-chan struct{} {
+	return s.ready
+}
+
+func (s *Stream) checkReady() {
+	if atomic.LoadUint32(&s.isReady) == 1 {
+		return
+	}
+
+	if s.WaitForParameters || s.WaitForKeyframe {
+		for _, sm := range s.streamMedias {
+			for _, sf := range sm.formats {
+				if p, ok := sf.proc.(formatprocessor.ParametersChecker); ok && !p.HasParameters() {
+					return
+				}
+			}
+		}
+	}
+
+	if s.WaitForKeyframe {
+		for _, sm := range s.streamMedias {
+			for _, sf := range sm.formats {
+				if atomic.LoadUint32(&sf.started) == 0 {
+					return
+				}
+			}
+		}
+	}
+
+	if atomic.CompareAndSwapUint32(&s.isReady, 0, 1) {
+		close(s.ready)
+	}
+}
+
 // WriteRTPPacket writes a RTP packet.
 func (s *Stream) WriteRTPPacket(
 	medi *description.Media,
@@ -64,7 +174,275 @@ func (s *Stream) WriteRTPPacket(
 	sf := sm.formats[forma]
 
 	s.mutex.RLock()
-	defer s.mutex.RUnlock()
+	ok := sf.writeRTPPacket(s, medi, pkt, ntp, pts)
+	s.mutex.RUnlock()
+
+	// a frame has been lost, readers would have to wait for the next keyframe
+	if !ok && medi.Type == description.MediaTypeVideo {
+		s.RequestKeyframe(medi)
+	}
+
+	s.checkReady()
+}
+
+// Close closes all resources of the stream.
+func (s *Stream) Close() {
+	for _, sm := range s.streamMedias {
+		sm.close()
+	}
+	s.decodeErrors.Stop()
+}
+
+// AddReader adds a non-RTSP reader of a track.
+// Units are passed to cb through the queue of the reader, after StartReader() has been called.
+func (s *Stream) AddReader(r *asyncwriter.Writer, medi *description.Media, forma format.Format, cb ReadFunc) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.streamMedias[medi].formats[forma].addReader(r, cb)
+}
+
+// StartReader starts delivering units to a reader.
+// When the GOP cache is enabled, units received since the last keyframe are delivered first,
+// and timestamps of all units of the reader are shifted in order to start from zero at the keyframe.
+func (s *Stream) StartReader(r *asyncwriter.Writer) {
+	// keyframes are requested after the lock is released, since requests are sent to the publisher
+	for _, medi := range s.startReader(r) {
+		s.RequestKeyframe(medi)
+	}
+}
+
+// startReader starts delivering units to a reader and returns the medias whose keyframe is needed.
+func (s *Stream) startReader(r *asyncwriter.Writer) []*description.Media {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	replayed := false
+	var keyframeMedias []*description.Media
+
+	if s.gopCache != nil {
+		entries := s.gopCache.units()
+
+		for _, e := range entries {
+			if _, ok := e.sf.pausedReaders[r]; ok {
+				replayed = true
+				break
+			}
+		}
+
+		if replayed {
+			// the first entry is the keyframe, whose timestamp is converted into the clock rate of every track
+			keyPTS := entries[0].u.GetPTS()
+			keyClockRate := int64(entries[0].sf.format.ClockRate())
+
+			for _, sm := range s.streamMedias {
+				for _, sf := range sm.formats {
+					if cb, ok := sf.pausedReaders[r]; ok {
+						sf.pausedReaders[r] = shiftedReadFunc(cb, keyPTS*int64(sf.format.ClockRate())/keyClockRate)
+					}
+				}
+			}
+
+			for _, e := range entries {
+				if cb, ok := e.sf.pausedReaders[r]; ok {
+					e.sf.pushUnit(s, r, cb, e.u, e.size)
+				}
+			}
+		}
+	}
+
+	for medi, sm := range s.streamMedias {
+		for _, sf := range sm.formats {
+			if _, ok := sf.pausedReaders[r]; ok && !replayed && medi.Type == description.MediaTypeVideo {
+				// the reader would have to wait for the next keyframe
+				keyframeMedias = append(keyframeMedias, medi)
+			}
+
+			sf.startReader(r)
+		}
+	}
+
+	return keyframeMedias
+}
+
+// StartReaderFromPreRoll starts delivering units to a reader,
+// starting from the units in the pre-roll buffer, with their original timestamps.
+// Units whose NTP is not after the given time are skipped, in order not to deliver units
+// that have already been read by another reader, like the recorder of a previous event.
+// Video tracks may then start without a keyframe.
+// When the pre-roll buffer is disabled, it behaves like StartReader().
+func (s *Stream) StartReaderFromPreRoll(r *asyncwriter.Writer, after time.Time) {
+	if s.preRoll == nil {
+		s.StartReader(r)
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, e := range s.preRoll.units() {
+		if !e.u.GetNTP().After(after) {
+			continue
+		}
+
+		if cb, ok := e.sf.pausedReaders[r]; ok {
+			e.sf.pushUnit(s, r, cb, e.u, e.size)
+		}
+	}
+
+	for _, sm := range s.streamMedias {
+		for _, sf := range sm.formats {
+			sf.startReader(r)
+		}
+	}
+}
+
+// RequestKeyframe asks the publisher to send a keyframe of a media.
+// Requests are rate-limited with KeyframeRequestInterval.
+func (s *Stream) RequestKeyframe(medi *description.Media) {
+	if s.OnKeyframeRequest == nil {
+		return
+	}
+
+	s.keyframeRequestMutex.Lock()
+	now := time.Now()
+	if last, ok := s.lastKeyframeRequest[medi]; ok && now.Sub(last) < s.KeyframeRequestInterval {
+		s.keyframeRequestMutex.Unlock()
+		return
+	}
+	s.lastKeyframeRequest[medi] = now
+	s.keyframeRequestMutex.Unlock()
+
+	s.OnKeyframeRequest(medi)
+}
+
+// RemoveReader removes a reader from all tracks.
+func (s *Stream) RemoveReader(r *asyncwriter.Writer) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, sm := range s.streamMedias {
+		for _, sf := range sm.formats {
+			sf.removeReader(r)
+		}
+	}
+}
+
+// AddPacketsLost increases the number of RTP packets of a track that have been lost.
+// Losses of video tracks trigger a keyframe request.
+func (s *Stream) AddPacketsLost(medi *description.Media, forma format.Format, n uint64) {
+	s.streamMedias[medi].formats[forma].packetsLost.Add(n)
+
+	if n != 0 && medi.Type == description.MediaTypeVideo {
+		s.RequestKeyframe(medi)
+	}
+}
+
+// AddPacketsDuplicated increases the number of RTP packets of a track that have been received twice.
+func (s *Stream) AddPacketsDuplicated(medi *description.Media, forma format.Format, n uint64) {
+	s.streamMedias[medi].formats[forma].packetsDuplicated.Add(n)
+}
+
+// AddPacketsLate increases the number of RTP packets of a track that have been received
+// after being declared lost.
+func (s *Stream) AddPacketsLate(medi *description.Media, forma format.Format, n uint64) {
+	s.streamMedias[medi].formats[forma].packetsLate.Add(n)
+}
+
+// AddDecodeError increases the number of RTP and RTCP packets that could not be decoded.
+func (s *Stream) AddDecodeError() {
+	s.decodeErrors.Increase()
+}
+
+// FormatStats are the statistics of a track.
+type FormatStats struct {
+	PacketsLost       uint64
+	PacketsDuplicated uint64
+	PacketsLate       uint64
+	ProcessingErrors  uint64
+}
+
+// FormatStats returns the statistics of a track.
+func (s *Stream) FormatStats(medi *description.Media, forma format.Format) FormatStats {
+	sf := s.streamMedias[medi].formats[forma]
+	return FormatStats{
+		PacketsLost:       sf.packetsLost.Total(),
+		PacketsDuplicated: sf.packetsDuplicated.Total(),
+		PacketsLate:       sf.packetsLate.Total(),
+		ProcessingErrors:  sf.processingErrors.Total(),
+	}
+}
+
+// TrackInfo returns properties of a track that have been read from the bitstream.
+func (s *Stream) TrackInfo(medi *description.Media, forma format.Format) (formatprocessor.TrackInfo, bool) {
+	sf := s.streamMedias[medi].formats[forma]
+
+	if p, ok := sf.proc.(formatprocessor.TrackInfoProvider); ok {
+		return p.TrackInfo()
+	}
+	return formatprocessor.TrackInfo{}, false
+}
+
+// LatestUserData returns the latest SEI user data payload of every UUID, of all tracks.
+func (s *Stream) LatestUserData() []formatprocessor.UserDataSample {
+	var ret []formatprocessor.UserDataSample
+
+	for _, sm := range s.streamMedias {
+		for _, sf := range sm.formats {
+			if p, ok := sf.proc.(formatprocessor.UserDataProvider); ok {
+				ret = append(ret, p.LatestUserData()...)
+			}
+		}
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return bytes.Compare(ret[i].UUID[:], ret[j].UUID[:]) < 0
+	})
+
+	return ret
+}
+
+// LatestTimecode returns the latest timecode of the first track that has one.
+func (s *Stream) LatestTimecode() (formatprocessor.TimecodeSample, bool) {
+	for _, medi := range s.Desc.Medias {
+		for _, forma := range medi.Formats {
+			if p, ok := s.streamMedias[medi].formats[forma].proc.(formatprocessor.TimecodeProvider); ok {
+				if tc, ok := p.LatestTimecode(); ok {
+					return tc, true
+				}
+			}
+		}
+	}
+
+	return formatprocessor.TimecodeSample{}, false
+}
+
+// InjectSEIUserData queues a user_data_unregistered SEI payload,
+// that is inserted into the next access unit of the first H264 or H265 track.
+func (s *Stream) InjectSEIUserData(uuid [16]byte, payload []byte) error {
+	for _, medi := range s.Desc.Medias {
+		for _, forma := range medi.Formats {
+			if p, ok := s.streamMedias[medi].formats[forma].proc.(formatprocessor.SEIInjector); ok {
+				p.InjectSEIUserData(uuid, payload)
+				return nil
+			}
+		}
+	}
+
+	return ErrSEIInjectionNotSupported
+}
+
+// LatestTelemetry returns the latest telemetry decoded from the KLV tracks.
+func (s *Stream) LatestTelemetry() (formatprocessor.KLVTelemetry, error) {
+	for _, medi := range s.Desc.Medias {
+		for _, forma := range medi.Formats {
+			if p, ok := s.streamMedias[medi].formats[forma].proc.(formatprocessor.TelemetryProvider); ok {
+				if t, ok := p.LatestTelemetry(); ok {
+					return t, nil
+				}
+			}
+		}
+	}
 
-	sf.writeRTPPacket(s, medi, pkt, ntp, pts)
+	return formatprocessor.KLVTelemetry{}, ErrNoTelemetry
 }
@@ -1,10 +1,11 @@
 package stream
 
 import (
+	"XMedia/internal/asyncwriter"
+	"XMedia/internal/counterdumper"
 	"XMedia/internal/formatprocessor"
 	"XMedia/internal/logger"
 	"XMedia/internal/unit"
-	"fmt"
 	"sync/atomic"
 	"time"
 
@@ -25,44 +26,82 @@ type streamFormat struct {
 	udpMaxPayloadSize  int
 	format             format.Format
 	generateRTPPackets bool
-	//processingErrors   *counterdumper.CounterDumper
-	parent logger.Writer
-
-	proc formatprocessor.Processor
-	//pausedReaders  map[*streamReader]ReadFunc
-	//runningReaders map[*streamReader]ReadFunc
+	options            formatprocessor.Options
+	processingErrors   *counterdumper.CounterDumper
+	packetsLost        *counterdumper.CounterDumper
+	packetsDuplicated  *counterdumper.CounterDumper
+	packetsLate        *counterdumper.CounterDumper
+	parent             logger.Writer
+
+	proc           formatprocessor.Processor
+	pausedReaders  map[*asyncwriter.Writer]ReadFunc
+	runningReaders map[*asyncwriter.Writer]ReadFunc
+
+	// whether the track has produced a keyframe (video) or a frame (other tracks).
+	started uint32
 }
 
 func (sf *streamFormat) initialize() error {
-	//sf.pausedReaders = make(map[*streamReader]ReadFunc)
-	//sf.runningReaders = make(map[*streamReader]ReadFunc)
+	sf.pausedReaders = make(map[*asyncwriter.Writer]ReadFunc)
+	sf.runningReaders = make(map[*asyncwriter.Writer]ReadFunc)
 
 	var err error
-	sf.proc, err = formatprocessor.New(sf.udpMaxPayloadSize, sf.format, sf.generateRTPPackets, sf.parent)
+	sf.proc, err = formatprocessor.New(sf.udpMaxPayloadSize, sf.format, sf.generateRTPPackets, sf.options, sf.parent)
 	if err != nil {
 		return err
 	}
 
+	sf.processingErrors = sf.newCounter("processing %s", "error", "errors")
+	sf.packetsLost = sf.newCounter("RTP %s lost", "packet", "packets")
+	sf.packetsDuplicated = sf.newCounter("duplicate RTP %s discarded", "packet", "packets")
+	sf.packetsLate = sf.newCounter("RTP %s arrived too late", "packet", "packets")
+
 	return nil
 }
 
+func (sf *streamFormat) newCounter(format string, singular string, plural string) *counterdumper.CounterDumper {
+	c := &counterdumper.CounterDumper{
+		OnReport: func(val uint64) {
+			noun := plural
+			if val == 1 {
+				noun = singular
+			}
+			sf.parent.Log(logger.Warn, "%d "+format+" (%s)", val, noun, sf.format.Codec())
+		},
+	}
+	c.Start()
+	return c
+}
+
+func (sf *streamFormat) close() {
+	sf.processingErrors.Stop()
+	sf.packetsLost.Stop()
+	sf.packetsDuplicated.Stop()
+	sf.packetsLate.Stop()
+}
+
+// writeRTPPacket processes a RTP packet and writes the resulting unit.
+// It returns false when the packet can't be processed.
 func (sf *streamFormat) writeRTPPacket(
 	s *Stream,
 	medi *description.Media,
 	pkt *rtp.Packet,
 	ntp time.Time,
 	pts int64,
-) {
-	//hasNonRTSPReaders := len(sf.pausedReaders) > 0 || len(sf.runningReaders) > 0
+) bool {
 	//存在非RTSP的拉流者 RTSP拉流不走Reader
-	hasNonRTSPReaders := false
+	// units must be decoded also to detect keyframes for the GOP cache, the pre-roll buffer and for readiness
+	hasNonRTSPReaders := len(sf.pausedReaders) > 0 || len(sf.runningReaders) > 0 ||
+		s.gopCache != nil || s.preRoll != nil ||
+		(s.WaitForKeyframe && atomic.LoadUint32(&sf.started) == 0)
 	u, err := sf.proc.ProcessRTPPacket(pkt, ntp, pts, hasNonRTSPReaders)
 	if err != nil {
-		//sf.processingErrors.Increase()
-		return
+		sf.processingErrors.Increase()
+		return false
 	}
 
 	sf.writeUnitInner(s, medi, u)
+	return true
 }
 
 func (sf *streamFormat) writeUnitInner(s *Stream, medi *description.Media, u unit.Unit) {
@@ -70,8 +109,6 @@ func (sf *streamFormat) writeUnitInner(s *Stream, medi *description.Media, u uni
 
 	atomic.AddUint64(s.bytesReceived, size)
 
-	fmt.Printf("发送RTP数据 %s\n", medi.Type)
-
 	if s.rtspStream != nil {
 		for _, pkt := range u.GetRTPPackets() {
 			s.rtspStream.WritePacketRTPWithNTP(medi, pkt, u.GetNTP()) //nolint:errcheck
@@ -84,11 +121,56 @@ func (sf *streamFormat) writeUnitInner(s *Stream, medi *description.Media, u uni
 	// 	}
 	// }
 
-	// for sr, cb := range sf.runningReaders {
-	// 	ccb := cb
-	// 	sr.push(func() error {
-	// 		atomic.AddUint64(s.bytesSent, size)
-	// 		return ccb(u)
-	// 	})
-	// }
+	if atomic.LoadUint32(&sf.started) == 0 {
+		sf.checkStarted(u)
+	}
+
+	if s.gopCache != nil {
+		s.gopCache.push(sf, u, size)
+	}
+
+	if s.preRoll != nil {
+		s.preRoll.push(sf, u, size)
+	}
+
+	for r, cb := range sf.runningReaders {
+		sf.pushUnit(s, r, cb, u, size)
+	}
+}
+
+func (sf *streamFormat) checkStarted(u unit.Unit) {
+	ra, isVideo := isRandomAccess(u)
+
+	if isVideo {
+		if !ra {
+			return
+		}
+	} else if len(u.GetRTPPackets()) == 0 {
+		return
+	}
+
+	atomic.StoreUint32(&sf.started, 1)
+}
+
+func (sf *streamFormat) pushUnit(s *Stream, r *asyncwriter.Writer, cb ReadFunc, u unit.Unit, size uint64) {
+	r.Push(func() error {
+		atomic.AddUint64(s.bytesSent, size)
+		return cb(u)
+	})
+}
+
+func (sf *streamFormat) addReader(r *asyncwriter.Writer, cb ReadFunc) {
+	sf.pausedReaders[r] = cb
+}
+
+func (sf *streamFormat) startReader(r *asyncwriter.Writer) {
+	if cb, ok := sf.pausedReaders[r]; ok {
+		delete(sf.pausedReaders, r)
+		sf.runningReaders[r] = cb
+	}
+}
+
+func (sf *streamFormat) removeReader(r *asyncwriter.Writer) {
+	delete(sf.pausedReaders, r)
+	delete(sf.runningReaders, r)
 }
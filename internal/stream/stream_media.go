@@ -1,6 +1,7 @@
 package stream
 
 import (
+	"XMedia/internal/formatprocessor"
 	"XMedia/internal/logger"
 
 	"github.com/bluenviron/gortsplib/v4/pkg/description"
@@ -11,6 +12,7 @@ type streamMedia struct {
 	udpMaxPayloadSize  int
 	media              *description.Media
 	generateRTPPackets bool
+	options            formatprocessor.Options
 	parent             logger.Writer
 
 	formats map[format.Format]*streamFormat
@@ -24,6 +26,7 @@ func (sm *streamMedia) initialize() error {
 			udpMaxPayloadSize:  sm.udpMaxPayloadSize,
 			format:             forma,
 			generateRTPPackets: sm.generateRTPPackets,
+			options:            sm.options,
 			parent:             sm.parent,
 		}
 		err := sf.initialize()
@@ -35,3 +38,9 @@ func (sm *streamMedia) initialize() error {
 
 	return nil
 }
+
+func (sm *streamMedia) close() {
+	for _, sf := range sm.formats {
+		sf.close()
+	}
+}
@@ -0,0 +1,339 @@
+package stream
+
+import (
+	"XMedia/internal/asyncwriter"
+	"XMedia/internal/formatprocessor"
+	"XMedia/internal/logger"
+	"XMedia/internal/unit"
+	"testing"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/description"
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+)
+
+type nilLogger struct{}
+
+func (nilLogger) Log(logger.Level, string, ...interface{}) {}
+
+var testSPS = []byte{
+	0x67, 0x42, 0xc0, 0x28, 0xd9, 0x00, 0x78, 0x02,
+	0x27, 0xe5, 0x84, 0x00, 0x00, 0x03, 0x00, 0x04,
+	0x00, 0x00, 0x03, 0x00, 0xf0, 0x3c, 0x60, 0xc9,
+	0x20,
+}
+
+var testPPS = []byte{0x68, 0xee, 0x3c, 0x80}
+
+var testUUID = [16]byte{
+	0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88,
+	0x99, 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff, 0x10,
+}
+
+type testSource struct {
+	t     *testing.T
+	medi  *description.Media
+	forma *format.H264
+	strm  *Stream
+}
+
+func newTestSource(t *testing.T, options formatprocessor.Options) *testSource {
+	forma := &format.H264{
+		PayloadTyp:        96,
+		SPS:               testSPS,
+		PPS:               testPPS,
+		PacketizationMode: 1,
+	}
+	medi := &description.Media{
+		Type:    description.MediaTypeVideo,
+		Formats: []format.Format{forma},
+	}
+
+	strm := &Stream{
+		WriteQueueSize:    512,
+		UDPMaxPayloadSize: 1472,
+		Desc:              &description.Session{Medias: []*description.Media{medi}},
+		ProcessorOptions:  options,
+		Parent:            nilLogger{},
+	}
+	err := strm.Initialize()
+	require.NoError(t, err)
+
+	return &testSource{
+		t:     t,
+		medi:  medi,
+		forma: forma,
+		strm:  strm,
+	}
+}
+
+func (s *testSource) writeAU(au [][]byte, pts int64) {
+	enc, err := s.forma.CreateEncoder()
+	require.NoError(s.t, err)
+
+	pkts, err := enc.Encode(au)
+	require.NoError(s.t, err)
+
+	ntp := time.Date(2008, 5, 20, 22, 15, 25, 0, time.UTC).Add(time.Duration(pts) * time.Second / 90000)
+
+	for _, pkt := range pkts {
+		pkt.Timestamp = uint32(pts)
+		s.strm.WriteRTPPacket(s.medi, s.forma, pkt, ntp, pts)
+	}
+}
+
+// user_data_unregistered SEI NALU.
+func testUserDataSEI(payload []byte) []byte {
+	nalu := []byte{0x06, 0x05, byte(16 + len(payload))}
+	nalu = append(nalu, testUUID[:]...)
+	nalu = append(nalu, payload...)
+	return append(nalu, 0x80)
+}
+
+func newTestReader() *asyncwriter.Writer {
+	r := &asyncwriter.Writer{
+		QueueSize: 64,
+		Parent:    nilLogger{},
+	}
+	r.Initialize()
+	return r
+}
+
+func TestStreamUserData(t *testing.T) {
+	src := newTestSource(t, formatprocessor.Options{
+		H264: formatprocessor.H264Options{
+			ExtractUserData: true,
+		},
+	})
+	defer src.strm.Close()
+
+	r := newTestReader()
+
+	received := make(chan *unit.H264, 10)
+
+	src.strm.AddReader(r, src.medi, src.forma, func(u unit.Unit) error {
+		received <- u.(*unit.H264)
+		return nil
+	})
+	src.strm.StartReader(r)
+	r.Start()
+
+	src.writeAU([][]byte{testSPS, testPPS, testUserDataSEI([]byte("first")), {0x65, 0x88, 0x84, 0x00}}, 0)
+	src.writeAU([][]byte{{0x41, 0x9a, 0x24, 0x6c}}, 3000)
+	src.writeAU([][]byte{testUserDataSEI([]byte("second")), {0x41, 0x9a, 0x24, 0x6d}}, 6000)
+
+	r.Stop()
+	close(received)
+
+	var units []*unit.H264
+	for u := range received {
+		units = append(units, u)
+	}
+
+	// user data is attached to the access units that carry it
+	require.Len(t, units, 3)
+
+	require.Equal(t, int64(0), units[0].PTS)
+	require.Equal(t, []unit.SEIUserData{{UUID: testUUID, Payload: []byte("first")}}, units[0].UserData)
+
+	require.Equal(t, int64(3000), units[1].PTS)
+	require.Nil(t, units[1].UserData)
+
+	require.Equal(t, int64(6000), units[2].PTS)
+	require.Equal(t, []unit.SEIUserData{{UUID: testUUID, Payload: []byte("second")}}, units[2].UserData)
+
+	// the latest payloads are exposed through the API
+	latest := src.strm.LatestUserData()
+	require.NotEmpty(t, latest)
+	require.Equal(t, []byte("second"), latest[len(latest)-1].Payload)
+}
+
+// user_data_registered SEI NALU with a CEA-608 byte pair.
+func testCaptionSEI(data [2]byte) []byte {
+	return []byte{
+		0x06, 0x04, 0x0e,
+		0xb5, 0x00, 0x31, 'G', 'A', '9', '4', 0x03,
+		0x41, 0xff, 0xfc, data[0], data[1], 0xff,
+		0x80,
+	}
+}
+
+func TestStreamCaptions(t *testing.T) {
+	src := newTestSource(t, formatprocessor.Options{
+		H264: formatprocessor.H264Options{
+			ExtractCaptions: true,
+		},
+	})
+	defer src.strm.Close()
+
+	r := newTestReader()
+
+	received := make(chan *unit.H264, 10)
+
+	src.strm.AddReader(r, src.medi, src.forma, func(u unit.Unit) error {
+		received <- u.(*unit.H264)
+		return nil
+	})
+	src.strm.StartReader(r)
+	r.Start()
+
+	src.writeAU([][]byte{testSPS, testPPS, testCaptionSEI([2]byte{0x94, 0x2c}), {0x65, 0x88, 0x84, 0x00}}, 0)
+	src.writeAU([][]byte{{0x41, 0x9a, 0x24, 0x6c}}, 3000)
+	src.writeAU([][]byte{testCaptionSEI([2]byte{0xc8, 0xe5}), {0x41, 0x9a, 0x24, 0x6d}}, 6000)
+
+	r.Stop()
+	close(received)
+
+	var units []*unit.H264
+	for u := range received {
+		units = append(units, u)
+	}
+
+	// captions are attached to the access units that carry them
+	require.Len(t, units, 3)
+
+	require.Equal(t, int64(0), units[0].PTS)
+	require.Equal(t, []unit.CaptionPacket{
+		{Type: unit.CaptionTypeCEA608Field1, Data: [2]byte{0x94, 0x2c}},
+	}, units[0].Captions)
+
+	require.Equal(t, int64(3000), units[1].PTS)
+	require.Nil(t, units[1].Captions)
+
+	require.Equal(t, int64(6000), units[2].PTS)
+	require.Equal(t, []unit.CaptionPacket{
+		{Type: unit.CaptionTypeCEA608Field1, Data: [2]byte{0xc8, 0xe5}},
+	}, units[2].Captions)
+}
+
+func TestStreamKeyframeRequests(t *testing.T) {
+	src := newTestSource(t, formatprocessor.Options{})
+	defer src.strm.Close()
+
+	var requests []*description.Media
+
+	src.strm.OnKeyframeRequest = func(medi *description.Media) {
+		// requests are sent without holding the lock of the stream
+		require.True(t, src.strm.mutex.TryLock())
+		src.strm.mutex.Unlock()
+
+		requests = append(requests, medi)
+	}
+
+	r := newTestReader()
+	src.strm.AddReader(r, src.medi, src.forma, func(unit.Unit) error {
+		return nil
+	})
+
+	// a reader joins the stream
+	src.strm.StartReader(r)
+	require.Equal(t, []*description.Media{src.medi}, requests)
+
+	// a frame can't be decoded
+	src.strm.WriteRTPPacket(src.medi, src.forma, &rtp.Packet{
+		Header: rtp.Header{
+			Version:        2,
+			PayloadType:    96,
+			SequenceNumber: 123,
+		},
+		Payload: []byte{0x18, 0x00, 0x10},
+	}, time.Now(), 0)
+	require.Len(t, requests, 2)
+
+	// RTP packets are lost
+	src.strm.AddPacketsLost(src.medi, src.forma, 3)
+	require.Len(t, requests, 3)
+
+	// requests are rate-limited
+	src.strm.KeyframeRequestInterval = time.Hour
+	src.strm.AddPacketsLost(src.medi, src.forma, 1)
+	require.Len(t, requests, 3)
+}
+
+func TestStreamGOPCache(t *testing.T) {
+	src := newTestSource(t, formatprocessor.Options{})
+	src.strm.Close()
+
+	src.strm = &Stream{
+		WriteQueueSize:      512,
+		UDPMaxPayloadSize:   1472,
+		Desc:                src.strm.Desc,
+		GOPCacheMaxBytes:    1000000,
+		GOPCacheMaxDuration: time.Hour,
+		Parent:              nilLogger{},
+	}
+	require.NoError(t, src.strm.Initialize())
+	defer src.strm.Close()
+
+	src.writeAU([][]byte{testSPS, testPPS, {0x65, 0x88, 0x84, 0x00}}, 0)
+	src.writeAU([][]byte{{0x41, 0x9a, 0x24, 0x6c}}, 3000)
+	src.writeAU([][]byte{{0x41, 0x9a, 0x24, 0x6d}}, 6000)
+
+	// a new GOP replaces the previous one, whose units are released
+	src.writeAU([][]byte{testSPS, testPPS, {0x65, 0x88, 0x84, 0x01}}, 9000)
+	src.writeAU([][]byte{{0x41, 0x9a, 0x24, 0x6e}}, 12000)
+
+	entries := src.strm.gopCache.entries
+	require.Len(t, entries, 2)
+	for _, e := range entries[len(entries):cap(entries)] {
+		require.Nil(t, e.u)
+	}
+
+	r := newTestReader()
+
+	var received []*unit.H264
+	src.strm.AddReader(r, src.medi, src.forma, func(u unit.Unit) error {
+		received = append(received, u.(*unit.H264))
+		return nil
+	})
+	src.strm.StartReader(r)
+	r.Start()
+
+	src.writeAU([][]byte{{0x41, 0x9a, 0x24, 0x6f}}, 15000)
+
+	r.Stop()
+
+	// timestamps start from zero at the cached keyframe and are continuous with the live ones,
+	// while NTP timestamps are left untouched
+	require.Len(t, received, 3)
+	start := time.Date(2008, 5, 20, 22, 15, 25, 0, time.UTC)
+	for i, u := range received {
+		pts := int64(i * 3000)
+		require.Equal(t, pts, u.PTS)
+		require.Equal(t, pts, u.DTS)
+		require.Equal(t, start.Add(time.Duration(9000+pts)*time.Second/90000), u.NTP)
+	}
+	require.True(t, received[0].RandomAccess)
+
+	// cached units, that are shared among readers, are not modified
+	require.Equal(t, int64(9000), src.strm.gopCache.entries[0].u.GetPTS())
+}
+
+func TestShiftUnit(t *testing.T) {
+	for _, ca := range []struct {
+		name string
+		u    unit.Unit
+	}{
+		{"h264", &unit.H264{Base: unit.Base{PTS: 1000}, DTS: 900}},
+		{"h265", &unit.H265{Base: unit.Base{PTS: 1000}}},
+		{"mjpeg", &unit.MJPEG{Base: unit.Base{PTS: 1000}}},
+		{"mpeg-4 video", &unit.MPEG4Video{Base: unit.Base{PTS: 1000}}},
+		{"mpeg-4 audio", &unit.MPEG4Audio{Base: unit.Base{PTS: 1000}}},
+		{"mpeg-4 audio latm", &unit.MPEG4AudioLATM{Base: unit.Base{PTS: 1000}}},
+		{"opus", &unit.Opus{Base: unit.Base{PTS: 1000}}},
+		{"g711", &unit.G711{Base: unit.Base{PTS: 1000}}},
+		{"klv", &unit.KLV{Base: unit.Base{PTS: 1000}}},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			s := shiftUnit(ca.u, 400)
+			require.Equal(t, int64(600), s.GetPTS())
+			require.Equal(t, int64(1000), ca.u.GetPTS())
+
+			if h, ok := s.(*unit.H264); ok {
+				require.Equal(t, int64(500), h.DTS)
+			}
+		})
+	}
+}
@@ -0,0 +1,18 @@
+package unit
+
+// CaptionType is the type of a closed caption packet (cc_type).
+type CaptionType int
+
+// caption types.
+const (
+	CaptionTypeCEA608Field1 CaptionType = 0
+	CaptionTypeCEA608Field2 CaptionType = 1
+	CaptionTypeDTVCCData    CaptionType = 2
+	CaptionTypeDTVCCStart   CaptionType = 3
+)
+
+// CaptionPacket is a CEA-608 byte pair or a fragment of a CEA-708 (DTVCC) packet.
+type CaptionPacket struct {
+	Type CaptionType
+	Data [2]byte
+}
@@ -0,0 +1,7 @@
+package unit
+
+// G711 is a G711 data unit.
+type G711 struct {
+	Base
+	Samples []byte
+}
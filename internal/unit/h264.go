@@ -4,4 +4,23 @@ package unit
 type H264 struct {
 	Base
 	AU [][]byte
+
+	// decoding timestamp, in the same time base of PTS.
+	// It is equal to PTS until the first random access point has been received.
+	DTS int64
+
+	// whether the access unit can be decoded without previous ones (IDR).
+	RandomAccess bool
+
+	// closed captions carried by the access unit, in decoding order.
+	// They are filled only when caption extraction is enabled.
+	Captions []CaptionPacket
+
+	// user_data_unregistered SEI payloads carried by the access unit.
+	// They are filled only when user data extraction is enabled.
+	UserData []SEIUserData
+
+	// timecode of the access unit, read from a pic_timing SEI message.
+	// It is filled only when timecode extraction is enabled.
+	Timecode *Timecode
 }
@@ -0,0 +1,7 @@
+package unit
+
+// KLV is a KLV data unit.
+type KLV struct {
+	Base
+	Unit []byte
+}
@@ -0,0 +1,7 @@
+package unit
+
+// MPEG4AudioLATM is a MPEG-4 Audio LATM data unit.
+type MPEG4AudioLATM struct {
+	Base
+	Element []byte
+}
@@ -0,0 +1,7 @@
+package unit
+
+// MPEG4Video is a MPEG-4 Video data unit.
+type MPEG4Video struct {
+	Base
+	Frame []byte
+}
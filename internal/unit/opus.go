@@ -0,0 +1,7 @@
+package unit
+
+// Opus is a Opus data unit.
+type Opus struct {
+	Base
+	Packets [][]byte
+}
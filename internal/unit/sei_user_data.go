@@ -0,0 +1,7 @@
+package unit
+
+// SEIUserData is a user_data_unregistered SEI payload.
+type SEIUserData struct {
+	UUID    [16]byte
+	Payload []byte
+}
@@ -0,0 +1,25 @@
+package unit
+
+import (
+	"fmt"
+)
+
+// Timecode is a SMPTE timecode, read from a clock timestamp of a pic_timing SEI message.
+type Timecode struct {
+	Hours   int
+	Minutes int
+	Seconds int
+	Frames  int
+	// whether frame numbers are dropped to compensate 29.97 and 59.94 fps.
+	DropFrame bool
+}
+
+// String returns the timecode in the HH:MM:SS:FF format,
+// or HH:MM:SS;FF when frames are dropped.
+func (t Timecode) String() string {
+	sep := ":"
+	if t.DropFrame {
+		sep = ";"
+	}
+	return fmt.Sprintf("%02d:%02d:%02d%s%02d", t.Hours, t.Minutes, t.Seconds, sep, t.Frames)
+}
@@ -7,6 +7,7 @@ import (
 	"strings"
 
 	"XMedia/internal/core"
+	"XMedia/internal/recordcrypto"
 	"XMedia/internal/utils"
 
 	"github.com/common-nighthawk/go-figure"
@@ -39,6 +40,14 @@ func (p *program) Stop(s service.Service) (err error) {
 	return nil
 }
 
+func decrypt(keyFile string, inPath string, outPath string) error {
+	keys, err := recordcrypto.LoadKeys(keyFile)
+	if err != nil {
+		return err
+	}
+	return recordcrypto.DecryptFile(keys, inPath, outPath)
+}
+
 func main() {
 	productName = utils.EXEName()
 	serviceName = fmt.Sprintf("%s_Service", productName)
@@ -58,6 +67,15 @@ func main() {
 	}
 	if len(os.Args) > 1 {
 		switch os.Args[1] {
+		case "decrypt":
+			if len(os.Args) != 5 {
+				fmt.Printf("usage: %s decrypt KEYFILE INPUT OUTPUT\n", productName)
+				os.Exit(1)
+			}
+			if err = decrypt(os.Args[2], os.Args[3], os.Args[4]); err != nil {
+				fmt.Printf("error:%v\n", err)
+				os.Exit(1)
+			}
 		case "install", "stop":
 			if strings.EqualFold(utils.EXEName(), productName) || runtime.GOOS == "windows" {
 				figure.NewFigure(productName, "", false).Print()